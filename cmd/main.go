@@ -16,6 +16,9 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to initialize a logger, err: %v", err)
 	}
+	if err := cnf.Validate(); err != nil {
+		lg.Fatalf("invalid configuration, error: %v", err)
+	}
 	dbConn, err := db.Open(context.Background(), cnf)
 	if err != nil {
 		lg.Fatalf("failed to open db connection")
@@ -24,6 +27,9 @@ func main() {
 	if err != nil {
 		lg.Fatalf("failed to execute statements, error: %v", err)
 	}
+	if err := db.Migrate(dbConn, db.Migrations, lg); err != nil {
+		lg.Fatalf("failed to apply migrations, error: %v", err)
+	}
 	// Log providers
 	db.Providers(dbConn, lg)
 
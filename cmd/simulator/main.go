@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"payment-api/internal/integrations/payment/simulator"
+	"payment-api/internal/logger"
+)
+
+// Local stand-in of the payment provider for end-to-end testing
+func main() {
+	addr := flag.String("addr", "localhost:8090", "address the simulator listens on")
+	apiKey := flag.String("api-key", "", "secret key clients must use, any key is accepted if empty")
+	webhookUrl := flag.String("webhook-url", "", "url the events are delivered to")
+	webhookSecret := flag.String("webhook-secret", "whsec_simulator", "secret the events are signed with")
+	logLevel := flag.String("log-level", "info", "log level")
+	flag.Parse()
+
+	lg, err := logger.New("development", *logLevel)
+	if err != nil {
+		log.Fatalf("failed to initialize a logger, err: %v", err)
+	}
+	sim := simulator.NewServer(lg,
+		simulator.WithApiKey(*apiKey),
+		simulator.WithWebhook(*webhookUrl, *webhookSecret),
+	)
+	lg.Infof("provider simulator is listening on %v", *addr)
+	if err := http.ListenAndServe(*addr, sim); err != nil {
+		lg.Fatalf("provider simulator unexpected error: %s", err)
+	}
+}
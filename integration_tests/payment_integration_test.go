@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
@@ -51,6 +52,9 @@ func (s *PaymentTestSuite) SetupSuite() {
 	if err != nil {
 		lg.Fatalf("failed to execute statements, error: %v", err)
 	}
+	if err := db.Migrate(dbConn, db.Migrations, lg); err != nil {
+		lg.Fatalf("failed to apply migrations, error: %v", err)
+	}
 	// Launching the server
 	go func() {
 		server.Run(lg, cnf, dbConn)
@@ -123,7 +127,7 @@ func (s *PaymentTestSuite) TestApp() {
 					"AppleStore": "https://apps.apple.com/us/app/headway-daily-book-summaries/id1457185832",
 				},
 				{
-					"PlayMarket": "https://play.google.com/store/apps/details?id=com.headway.books&hl=pl&pli=1",
+					"PlayMarket": "https://play.google.com/store/apps/details?id=com.headway.books&hl=en",
 				},
 			},
 			code: http.StatusOK,
@@ -147,6 +151,12 @@ func (s *PaymentTestSuite) TestApp() {
 		},
 	}
 
+	// Payment links must not be followed, their redirect target is checked instead
+	client := http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
 	for _, tc := range testCases {
 		s.Run(tc.name, func() {
 			resp, err := http.Get(
@@ -166,13 +176,32 @@ func (s *PaymentTestSuite) TestApp() {
 			var respData respMsg
 			_ = json.NewDecoder(resp.Body).Decode(&respData)
 			s.Equal(tc.code, respData.Code)
-			s.Equal(tc.data, respData.Data)
 			s.Equal(tc.msg, respData.Message)
+			if tc.data == "" {
+				s.Empty(respData.Data)
+			} else {
+				s.True(strings.HasPrefix(respData.Data, s.cnf.Links.BaseUrl+"/pay/"))
+				redirect, err := client.Get(respData.Data)
+				if err != nil {
+					s.FailNow("failed to open the payment link")
+				}
+				redirect.Body.Close()
+				s.Equal(http.StatusFound, redirect.StatusCode)
+				s.Equal(tc.data, redirect.Header.Get("Location"))
+			}
 
-			// If stores are returned
+			// If stores are returned, their tracking urls must lead to the stores
 			if tc.urls != nil {
-				s.Equal(tc.urls[0]["AppleStore"], respData.Urls[0]["AppleStore"])
-				s.Equal(tc.urls[1]["PlayMarket"], respData.Urls[1]["PlayMarket"])
+				for i, name := range []string{"AppleStore", "PlayMarket"} {
+					trackingUrl := respData.Urls[i][name]
+					s.True(strings.HasPrefix(trackingUrl, s.cnf.Links.BaseUrl+"/r/store/"+name))
+					redirect, err := client.Get(trackingUrl)
+					if err != nil {
+						s.FailNow("failed to open the store tracking link")
+					}
+					redirect.Body.Close()
+					s.Equal(tc.urls[i][name], redirect.Header.Get("Location"))
+				}
 			}
 		})
 	}
@@ -1,7 +1,11 @@
 package config
 
 import (
+	"errors"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -16,8 +20,53 @@ const (
 	envName          = "ENVIRONMENT"
 	providerFilePath = "PROVIDER_FILE_PATH"
 	storesFilePath   = "STORES_FILE_PATH"
+	pricesFilePath   = "PRICES_FILE_PATH"
+	fxRatesFilePath  = "FX_RATES_FILE_PATH"
+	taxRulesFilePath = "TAX_RULES_FILE_PATH"
+	entitiesFilePath = "LEGAL_ENTITIES_FILE_PATH"
+	riskRulesPath    = "RISK_RULES_FILE_PATH"
+	publicBaseUrl    = "PUBLIC_BASE_URL"
+	urlSigningSecret = "URL_SIGNING_SECRET"
+	paymentUrlTTL    = "PAYMENT_URL_TTL"
+	adminToken       = "ADMIN_TOKEN"
+	userTokenSecret  = "USER_TOKEN_SECRET"
+	countryHeader    = "COUNTRY_HEADER"
+	clientIPHeader   = "CLIENT_IP_HEADER"
+	checkoutProduct  = "CHECKOUT_PRODUCT_NAME"
+	checkoutAmount   = "CHECKOUT_AMOUNT"
+	checkoutCurrency = "CHECKOUT_CURRENCY"
+	checkoutSuccess  = "CHECKOUT_SUCCESS_URL"
+	checkoutCancel   = "CHECKOUT_CANCEL_URL"
+	checkoutAccess   = "CHECKOUT_ACCESS_DURATION"
+	stripeBaseUrl    = "STRIPE_BASE_URL"
+	payPalBaseUrl    = "PAYPAL_BASE_URL"
+	appStoreBaseUrl  = "APPSTORE_BASE_URL"
+	appStoreBundleID = "APPSTORE_BUNDLE_ID"
+	appStoreIssuerID = "APPSTORE_ISSUER_ID"
+	appStoreKeyID    = "APPSTORE_KEY_ID"
+	appStoreKeyPath  = "APPSTORE_PRIVATE_KEY_PATH"
+	appStoreRootPath = "APPSTORE_ROOT_CERT_PATH"
+	appStoreEnv      = "APPSTORE_ENVIRONMENT"
+	playBaseUrl      = "GOOGLE_PLAY_BASE_URL"
+	playPackageName  = "GOOGLE_PLAY_PACKAGE_NAME"
+	playAccountPath  = "GOOGLE_PLAY_SERVICE_ACCOUNT_PATH"
+	playPushToken    = "GOOGLE_PLAY_PUSH_TOKEN"
+	ledgerInterval   = "LEDGER_CHECK_INTERVAL"
+	settlementDir    = "SETTLEMENT_DIR"
+	settlementPeriod = "SETTLEMENT_IMPORT_INTERVAL"
+	plansFilePath    = "PLANS_FILE_PATH"
+	promoRelease     = "PROMO_RELEASE_INTERVAL"
+	flagsRefresh     = "FLAGS_REFRESH_INTERVAL"
+	disputesRevoke   = "DISPUTES_REVOKE_REASONS"
+	listsRefresh     = "LISTS_REFRESH_INTERVAL"
 )
 
+// developmentEnv is the only environment payment links may be signed with the development secret in
+const developmentEnv = "development"
+
+// ErrSigningSecretMissing is returned when links would be signed with the public development secret
+var ErrSigningSecretMissing = errors.New(urlSigningSecret + " must be set outside of " + developmentEnv)
+
 type ConfigDB struct {
 	Host     string
 	Port     string
@@ -31,6 +80,95 @@ type ConfigService struct {
 	Port string
 }
 
+type ConfigLinks struct {
+	BaseUrl string
+	Secret  string
+	TTL     time.Duration
+}
+
+// ConfigCheckout describes what the customer pays for on the provider checkout
+type ConfigCheckout struct {
+	ProductName string
+	// Amount is in minor units of the currency
+	Amount     int64
+	Currency   string
+	SuccessUrl string
+	CancelUrl  string
+	// AccessDuration is how long access bought with the checkout lasts
+	AccessDuration time.Duration
+}
+
+// ConfigStripe enables real Stripe adapter when BaseUrl is set,
+// otherwise Stripe is mocked with the providers file
+type ConfigStripe struct {
+	BaseUrl string
+}
+
+// ConfigPayPal enables real PayPal adapter when BaseUrl is set,
+// otherwise PayPal is mocked with the providers file
+type ConfigPayPal struct {
+	BaseUrl string
+}
+
+// ConfigAppStore enables App Store transactions verification when RootCertPath is set,
+// private key is needed only to fetch transactions from the App Store Server API
+type ConfigAppStore struct {
+	BaseUrl        string
+	BundleID       string
+	IssuerID       string
+	KeyID          string
+	PrivateKeyPath string
+	RootCertPath   string
+	// Environment transactions are accepted from, it is Production outside of development
+	Environment string
+}
+
+// ConfigGooglePlay enables Google Play purchases verification when ServiceAccountPath is set,
+// notifications are accepted only when PushToken is set
+type ConfigGooglePlay struct {
+	BaseUrl            string
+	PackageName        string
+	ServiceAccountPath string
+	PushToken          string
+}
+
+// ConfigLedger sets how often the ledger invariant is checked
+type ConfigLedger struct {
+	CheckInterval time.Duration
+}
+
+// ConfigSettlement enables reconciliation of provider settlement files dropped to Dir when it is set
+type ConfigSettlement struct {
+	Dir            string
+	ImportInterval time.Duration
+}
+
+// ConfigSubscriptions sets where plans are kept
+type ConfigSubscriptions struct {
+	PlansFilePath string
+}
+
+// ConfigPromotions sets how often redemptions of expired unpaid sessions are released
+type ConfigPromotions struct {
+	ReleaseInterval time.Duration
+}
+
+// ConfigFlags sets how often feature flags are reloaded, flags changed on another instance take effect after it
+type ConfigFlags struct {
+	RefreshInterval time.Duration
+}
+
+// ConfigDisputes sets reasons of disputes which revoke access as soon as they are opened,
+// access is revoked on any lost dispute anyway
+type ConfigDisputes struct {
+	RevokeReasons []string
+}
+
+// ConfigLists sets how often block and allow lists are reloaded, entries changed on another instance take effect after it
+type ConfigLists struct {
+	RefreshInterval time.Duration
+}
+
 type Config struct {
 	Database         ConfigDB
 	Service          ConfigService
@@ -38,18 +176,78 @@ type Config struct {
 	Environment      string
 	ProviderFilePath string
 	StoresFilePath   string
+	PricesFilePath   string
+	// FxRatesFilePath holds exchange rates loaded on start, empty when rates are managed with the API only
+	FxRatesFilePath string
+	// TaxRulesFilePath enables taxes of the checkout amounts when it is set
+	TaxRulesFilePath string
+	// LegalEntitiesFilePath enables invoices of the captured payments when it is set
+	LegalEntitiesFilePath string
+	// RiskRulesFilePath enables screening of the payment links when it is set
+	RiskRulesFilePath string
+	Links             ConfigLinks
+	AdminToken        string
+	// UserTokenSecret verifies user tokens issued by the Headway backend, users aren't authenticated when it is empty
+	UserTokenSecret string
+	// CountryHeader is set by the CDN in front of the service with country of the client
+	CountryHeader string
+	// ClientIPHeader is set by the CDN in front of the service with ip of the client
+	ClientIPHeader string
+	Checkout       ConfigCheckout
+	Stripe         ConfigStripe
+	PayPal         ConfigPayPal
+	AppStore       ConfigAppStore
+	GooglePlay     ConfigGooglePlay
+	Ledger         ConfigLedger
+	Settlement     ConfigSettlement
+	Subscriptions  ConfigSubscriptions
+	Promotions     ConfigPromotions
+	Flags          ConfigFlags
+	Disputes       ConfigDisputes
+	Lists          ConfigLists
 }
 
 // Load loads env variables
 func Load() *Config {
+	env := environment()
 	return &Config{
-		Database:         database(),
-		Service:          service(),
-		LogLevel:         logger(),
-		Environment:      environment(),
-		ProviderFilePath: provider(),
-		StoresFilePath:   stores(),
+		Database:              database(),
+		Service:               service(),
+		LogLevel:              logger(),
+		Environment:           env,
+		ProviderFilePath:      provider(),
+		StoresFilePath:        stores(),
+		PricesFilePath:        prices(),
+		FxRatesFilePath:       os.Getenv(fxRatesFilePath),
+		TaxRulesFilePath:      os.Getenv(taxRulesFilePath),
+		LegalEntitiesFilePath: os.Getenv(entitiesFilePath),
+		RiskRulesFilePath:     os.Getenv(riskRulesPath),
+		Links:                 links(env),
+		AdminToken:            os.Getenv(adminToken),
+		UserTokenSecret:       os.Getenv(userTokenSecret),
+		CountryHeader:         country(),
+		ClientIPHeader:        clientIP(),
+		Checkout:              checkout(),
+		Stripe:                ConfigStripe{BaseUrl: os.Getenv(stripeBaseUrl)},
+		PayPal:                ConfigPayPal{BaseUrl: os.Getenv(payPalBaseUrl)},
+		AppStore:              appStore(env),
+		GooglePlay:            googlePlay(),
+		Ledger:                ledger(),
+		Settlement:            settlement(),
+		Subscriptions:         subscriptions(),
+		Promotions:            promotions(),
+		Flags:                 flags(),
+		Disputes:              disputes(),
+		Lists:                 lists(),
+	}
+}
+
+// Validate reports settings the service must not be started with
+func (c *Config) Validate() error {
+	if c.Links.Secret == "" {
+		return ErrSigningSecretMissing
 	}
+	return nil
 }
 
 func database() ConfigDB {
@@ -101,7 +299,7 @@ func logger() string {
 func environment() string {
 	env := os.Getenv(envName)
 	if len(env) == 0 {
-		env = "development"
+		env = developmentEnv
 	}
 	return env
 }
@@ -121,3 +319,179 @@ func stores() string {
 	}
 	return env
 }
+
+func prices() string {
+	env := os.Getenv(pricesFilePath)
+	if len(env) == 0 {
+		env = "./assets/prices.json"
+	}
+	return env
+}
+
+// links falls back to the public development secret only in development, links signed with it can be forged
+func links(env string) ConfigLinks {
+	conf := ConfigLinks{}
+	conf.BaseUrl = os.Getenv(publicBaseUrl)
+	if len(conf.BaseUrl) == 0 {
+		conf.BaseUrl = "http://localhost:8080"
+	}
+	conf.Secret = os.Getenv(urlSigningSecret)
+	if len(conf.Secret) == 0 && env == developmentEnv {
+		conf.Secret = "development-signing-secret"
+	}
+	ttl, err := time.ParseDuration(os.Getenv(paymentUrlTTL))
+	if err != nil || ttl <= 0 {
+		ttl = 15 * time.Minute
+	}
+	conf.TTL = ttl
+	return conf
+}
+
+func country() string {
+	env := os.Getenv(countryHeader)
+	if len(env) == 0 {
+		env = "CF-IPCountry"
+	}
+	return env
+}
+
+func clientIP() string {
+	env := os.Getenv(clientIPHeader)
+	if len(env) == 0 {
+		env = "CF-Connecting-IP"
+	}
+	return env
+}
+
+func checkout() ConfigCheckout {
+	conf := ConfigCheckout{}
+	conf.ProductName = os.Getenv(checkoutProduct)
+	if len(conf.ProductName) == 0 {
+		conf.ProductName = "Headway Premium"
+	}
+	amount, err := strconv.ParseInt(os.Getenv(checkoutAmount), 10, 64)
+	if err != nil || amount <= 0 {
+		amount = 1299
+	}
+	conf.Amount = amount
+	conf.Currency = os.Getenv(checkoutCurrency)
+	if len(conf.Currency) == 0 {
+		conf.Currency = "usd"
+	}
+	conf.SuccessUrl = os.Getenv(checkoutSuccess)
+	if len(conf.SuccessUrl) == 0 {
+		conf.SuccessUrl = "https://makeheadway.com/payment/success?session_id={CHECKOUT_SESSION_ID}"
+	}
+	conf.CancelUrl = os.Getenv(checkoutCancel)
+	if len(conf.CancelUrl) == 0 {
+		conf.CancelUrl = "https://makeheadway.com/payment/cancel"
+	}
+	duration, err := time.ParseDuration(os.Getenv(checkoutAccess))
+	if err != nil || duration <= 0 {
+		duration = 365 * 24 * time.Hour
+	}
+	conf.AccessDuration = duration
+	return conf
+}
+
+func appStore(env string) ConfigAppStore {
+	conf := ConfigAppStore{}
+	conf.BaseUrl = os.Getenv(appStoreBaseUrl)
+	if len(conf.BaseUrl) == 0 {
+		conf.BaseUrl = "https://api.storekit.itunes.apple.com"
+	}
+	conf.BundleID = os.Getenv(appStoreBundleID)
+	if len(conf.BundleID) == 0 {
+		conf.BundleID = "com.headway.books"
+	}
+	conf.IssuerID = os.Getenv(appStoreIssuerID)
+	conf.KeyID = os.Getenv(appStoreKeyID)
+	conf.PrivateKeyPath = os.Getenv(appStoreKeyPath)
+	conf.RootCertPath = os.Getenv(appStoreRootPath)
+	// sandbox purchases are free, so they are accepted in development only
+	conf.Environment = "Production"
+	if env == developmentEnv {
+		conf.Environment = os.Getenv(appStoreEnv)
+		if len(conf.Environment) == 0 {
+			conf.Environment = "Sandbox"
+		}
+	}
+	return conf
+}
+
+func googlePlay() ConfigGooglePlay {
+	conf := ConfigGooglePlay{}
+	conf.BaseUrl = os.Getenv(playBaseUrl)
+	if len(conf.BaseUrl) == 0 {
+		conf.BaseUrl = "https://androidpublisher.googleapis.com"
+	}
+	conf.PackageName = os.Getenv(playPackageName)
+	if len(conf.PackageName) == 0 {
+		conf.PackageName = "com.headway.books"
+	}
+	conf.ServiceAccountPath = os.Getenv(playAccountPath)
+	conf.PushToken = os.Getenv(playPushToken)
+	return conf
+}
+
+func ledger() ConfigLedger {
+	interval, err := time.ParseDuration(os.Getenv(ledgerInterval))
+	if err != nil || interval <= 0 {
+		interval = time.Hour
+	}
+	return ConfigLedger{CheckInterval: interval}
+}
+
+func settlement() ConfigSettlement {
+	interval, err := time.ParseDuration(os.Getenv(settlementPeriod))
+	if err != nil || interval <= 0 {
+		interval = time.Hour
+	}
+	return ConfigSettlement{Dir: os.Getenv(settlementDir), ImportInterval: interval}
+}
+
+func subscriptions() ConfigSubscriptions {
+	conf := ConfigSubscriptions{PlansFilePath: os.Getenv(plansFilePath)}
+	if len(conf.PlansFilePath) == 0 {
+		conf.PlansFilePath = "./assets/plans.json"
+	}
+	return conf
+}
+
+func promotions() ConfigPromotions {
+	interval, err := time.ParseDuration(os.Getenv(promoRelease))
+	if err != nil || interval <= 0 {
+		interval = time.Hour
+	}
+	return ConfigPromotions{ReleaseInterval: interval}
+}
+
+func flags() ConfigFlags {
+	interval, err := time.ParseDuration(os.Getenv(flagsRefresh))
+	if err != nil || interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return ConfigFlags{RefreshInterval: interval}
+}
+
+func lists() ConfigLists {
+	interval, err := time.ParseDuration(os.Getenv(listsRefresh))
+	if err != nil || interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return ConfigLists{RefreshInterval: interval}
+}
+
+func disputes() ConfigDisputes {
+	raw, ok := os.LookupEnv(disputesRevoke)
+	if !ok {
+		raw = "fraudulent,unrecognized"
+	}
+	conf := ConfigDisputes{RevokeReasons: []string{}}
+	for _, reason := range strings.Split(raw, ",") {
+		if reason = strings.TrimSpace(reason); reason != "" {
+			conf.RevokeReasons = append(conf.RevokeReasons, reason)
+		}
+	}
+	return conf
+}
@@ -35,6 +35,17 @@ func InitialSeed(conn *sql.DB, stmnts []string, log *zap.SugaredLogger) error {
 	return nil
 }
 
+// Migrate applies schema statements, unlike InitialSeed it runs on every start
+func Migrate(conn *sql.DB, stmnts []string, log *zap.SugaredLogger) error {
+	for _, stm := range stmnts {
+		if _, err := conn.Exec(stm); err != nil {
+			return fmt.Errorf("failed to apply migration: %v, error: %v", stm, err)
+		}
+	}
+	log.Infof("applied %d migrations", len(stmnts))
+	return nil
+}
+
 func Providers(conn *sql.DB, log *zap.SugaredLogger) {
 	res, err := conn.Query("SELECT id, name FROM providers")
 	if err != nil {
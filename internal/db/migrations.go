@@ -0,0 +1,432 @@
+package db
+
+var (
+	CreatePaymentSessions = `
+	CREATE TABLE IF NOT EXISTS payment_sessions(
+		id UUID PRIMARY KEY,
+		provider_id UUID NOT NULL,
+		provider_url TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP,
+		visits INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	CreateEntitlements = `
+	CREATE TABLE IF NOT EXISTS entitlements(
+		id UUID PRIMARY KEY,
+		user_id VARCHAR(64),
+		source VARCHAR(32) NOT NULL,
+		product_id VARCHAR(255) NOT NULL,
+		external_id VARCHAR(255) NOT NULL,
+		transaction_id VARCHAR(255),
+		purchased_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (source, external_id)
+	);
+	`
+	AddPaymentSessionsProviderSessionID = `
+	ALTER TABLE payment_sessions ADD COLUMN IF NOT EXISTS provider_session_id VARCHAR(255);
+	`
+	AddPaymentSessionsUserID = `
+	ALTER TABLE payment_sessions
+		ADD COLUMN IF NOT EXISTS user_id VARCHAR(64),
+		ADD COLUMN IF NOT EXISTS paid_at TIMESTAMP;
+	`
+	CreateEntitlementsUserIDIndex = `
+	CREATE INDEX IF NOT EXISTS entitlements_user_id_idx ON entitlements (user_id);
+	`
+	CreateCustomers = `
+	CREATE TABLE IF NOT EXISTS customers(
+		id UUID PRIMARY KEY,
+		user_id VARCHAR(64) NOT NULL UNIQUE,
+		email VARCHAR(255),
+		country VARCHAR(2),
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	CreateCustomerProviderIDs = `
+	CREATE TABLE IF NOT EXISTS customer_provider_ids(
+		customer_id UUID NOT NULL REFERENCES customers(id),
+		provider_name VARCHAR(64) NOT NULL,
+		external_id VARCHAR(255) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (customer_id, provider_name)
+	);
+	`
+	AddPaymentSessionsAmount = `
+	ALTER TABLE payment_sessions
+		ADD COLUMN IF NOT EXISTS amount BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT '';
+	`
+	CreateLedgerAccounts = `
+	CREATE TABLE IF NOT EXISTS ledger_accounts(
+		code VARCHAR(128) PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	CreateLedgerEntries = `
+	CREATE TABLE IF NOT EXISTS ledger_entries(
+		id UUID PRIMARY KEY,
+		kind VARCHAR(32) NOT NULL,
+		reference VARCHAR(255) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (kind, reference)
+	);
+	`
+	CreateLedgerPostings = `
+	CREATE TABLE IF NOT EXISTS ledger_postings(
+		id BIGSERIAL PRIMARY KEY,
+		entry_id UUID NOT NULL REFERENCES ledger_entries(id),
+		account VARCHAR(128) NOT NULL REFERENCES ledger_accounts(code),
+		currency VARCHAR(3) NOT NULL,
+		amount BIGINT NOT NULL CHECK (amount <> 0)
+	);
+	`
+	CreateLedgerPostingsAccountIndex = `
+	CREATE INDEX IF NOT EXISTS ledger_postings_account_idx ON ledger_postings (account, currency);
+	`
+	CreatePaymentSessionsPaidAtIndex = `
+	CREATE INDEX IF NOT EXISTS payment_sessions_paid_at_idx ON payment_sessions (paid_at);
+	`
+	CreateReconciliationReports = `
+	CREATE TABLE IF NOT EXISTS reconciliation_reports(
+		id UUID PRIMARY KEY,
+		file_name VARCHAR(255) NOT NULL UNIQUE,
+		provider VARCHAR(64) NOT NULL,
+		period_start TIMESTAMP NOT NULL,
+		period_end TIMESTAMP NOT NULL,
+		settled_count INTEGER NOT NULL,
+		matched_count INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	CreateReconciliationDiscrepancies = `
+	CREATE TABLE IF NOT EXISTS reconciliation_discrepancies(
+		id BIGSERIAL PRIMARY KEY,
+		report_id UUID NOT NULL REFERENCES reconciliation_reports(id),
+		kind VARCHAR(32) NOT NULL,
+		payment_session_id VARCHAR(64),
+		transaction_id VARCHAR(255),
+		expected_amount BIGINT NOT NULL DEFAULT 0,
+		expected_currency VARCHAR(3) NOT NULL DEFAULT '',
+		settled_amount BIGINT NOT NULL DEFAULT 0,
+		settled_currency VARCHAR(3) NOT NULL DEFAULT ''
+	);
+	`
+	CreateFxRates = `
+	CREATE TABLE IF NOT EXISTS fx_rates(
+		base VARCHAR(3) NOT NULL,
+		quote VARCHAR(3) NOT NULL,
+		rate NUMERIC(24, 12) NOT NULL CHECK (rate > 0),
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (base, quote)
+	);
+	`
+	CreatePromotions = `
+	CREATE TABLE IF NOT EXISTS promotions(
+		id UUID PRIMARY KEY,
+		code VARCHAR(64) NOT NULL UNIQUE,
+		kind VARCHAR(16) NOT NULL,
+		percent_off INTEGER NOT NULL DEFAULT 0,
+		amount_off BIGINT NOT NULL DEFAULT 0,
+		currency VARCHAR(3) NOT NULL DEFAULT '',
+		product_ids TEXT[] NOT NULL DEFAULT '{}',
+		starts_at TIMESTAMP,
+		ends_at TIMESTAMP,
+		max_redemptions INTEGER NOT NULL DEFAULT 0,
+		per_customer_limit INTEGER NOT NULL DEFAULT 0,
+		redemptions INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	CreatePromoRedemptions = `
+	CREATE TABLE IF NOT EXISTS promo_redemptions(
+		session_id UUID PRIMARY KEY,
+		promotion_id UUID NOT NULL REFERENCES promotions(id),
+		user_id VARCHAR(64),
+		discount BIGINT NOT NULL,
+		currency VARCHAR(3) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	CreatePromoRedemptionsUserIndex = `
+	CREATE INDEX IF NOT EXISTS promo_redemptions_user_idx ON promo_redemptions (promotion_id, user_id);
+	`
+	AddPaymentSessionsPlan = `
+	ALTER TABLE payment_sessions
+		ADD COLUMN IF NOT EXISTS plan_id VARCHAR(64),
+		ADD COLUMN IF NOT EXISTS trial_days INTEGER NOT NULL DEFAULT 0;
+	`
+	CreateSubscriptions = `
+	CREATE TABLE IF NOT EXISTS subscriptions(
+		id UUID PRIMARY KEY,
+		user_id VARCHAR(64) NOT NULL,
+		plan_id VARCHAR(64) NOT NULL,
+		session_id UUID NOT NULL UNIQUE,
+		status VARCHAR(16) NOT NULL,
+		trial_ends_at TIMESTAMP,
+		current_period_end TIMESTAMP NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	CreateSubscriptionsTrialIndex = `
+	CREATE UNIQUE INDEX IF NOT EXISTS subscriptions_trial_user_idx ON subscriptions (user_id) WHERE trial_ends_at IS NOT NULL;
+	`
+	CreateSubscriptionsStatusIndex = `
+	CREATE INDEX IF NOT EXISTS subscriptions_status_trial_idx ON subscriptions (status, trial_ends_at);
+	`
+	CreateExperiments = `
+	CREATE TABLE IF NOT EXISTS experiments(
+		id UUID PRIMARY KEY,
+		key VARCHAR(64) NOT NULL UNIQUE,
+		status VARCHAR(16) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	CreateExperimentVariants = `
+	CREATE TABLE IF NOT EXISTS experiment_variants(
+		experiment_id UUID NOT NULL REFERENCES experiments(id),
+		key VARCHAR(64) NOT NULL,
+		position INTEGER NOT NULL,
+		weight INTEGER NOT NULL,
+		provider_id UUID,
+		plan_id VARCHAR(64),
+		amount BIGINT NOT NULL DEFAULT 0,
+		currency VARCHAR(3) NOT NULL DEFAULT '',
+		PRIMARY KEY (experiment_id, key)
+	);
+	`
+	CreateExperimentExposures = `
+	CREATE TABLE IF NOT EXISTS experiment_exposures(
+		experiment_id UUID NOT NULL REFERENCES experiments(id),
+		variant_key VARCHAR(64) NOT NULL,
+		user_id VARCHAR(64) NOT NULL,
+		session_id UUID NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (experiment_id, session_id)
+	);
+	`
+	CreateFeatureFlags = `
+	CREATE TABLE IF NOT EXISTS feature_flags(
+		key VARCHAR(128) PRIMARY KEY,
+		enabled BOOLEAN NOT NULL,
+		percentage INTEGER NOT NULL,
+		countries TEXT[] NOT NULL DEFAULT '{}',
+		platforms TEXT[] NOT NULL DEFAULT '{}',
+		clients TEXT[] NOT NULL DEFAULT '{}',
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	AddPaymentSessionsTax = `
+	ALTER TABLE payment_sessions ADD COLUMN IF NOT EXISTS tax_amount BIGINT NOT NULL DEFAULT 0;
+	`
+	CreatePaymentSessionTaxes = `
+	CREATE TABLE IF NOT EXISTS payment_session_taxes(
+		session_id UUID NOT NULL REFERENCES payment_sessions(id),
+		position INTEGER NOT NULL,
+		name VARCHAR(64) NOT NULL,
+		rate VARCHAR(16) NOT NULL,
+		inclusive BOOLEAN NOT NULL,
+		amount BIGINT NOT NULL,
+		currency VARCHAR(3) NOT NULL,
+		PRIMARY KEY (session_id, position)
+	);
+	`
+	AddPaymentSessionsPayer = `
+	ALTER TABLE payment_sessions ADD COLUMN IF NOT EXISTS email VARCHAR(255);
+	ALTER TABLE payment_sessions ADD COLUMN IF NOT EXISTS country VARCHAR(2);
+	`
+	CreateInvoiceSequences = `
+	CREATE TABLE IF NOT EXISTS invoice_sequences(
+		entity_id VARCHAR(64) PRIMARY KEY,
+		last_number BIGINT NOT NULL
+	);
+	`
+	CreateInvoices = `
+	CREATE TABLE IF NOT EXISTS invoices(
+		id UUID PRIMARY KEY,
+		entity_id VARCHAR(64) NOT NULL,
+		sequence BIGINT NOT NULL,
+		number VARCHAR(64) NOT NULL,
+		session_id UUID NOT NULL UNIQUE REFERENCES payment_sessions(id),
+		user_id VARCHAR(64),
+		email VARCHAR(255),
+		country VARCHAR(2),
+		seller_name VARCHAR(255) NOT NULL,
+		seller_address TEXT[] NOT NULL,
+		seller_tax_id VARCHAR(64),
+		description VARCHAR(255) NOT NULL,
+		net_amount BIGINT NOT NULL,
+		tax_amount BIGINT NOT NULL,
+		total_amount BIGINT NOT NULL,
+		currency VARCHAR(3) NOT NULL,
+		issued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (entity_id, sequence)
+	);
+	`
+	CreateInvoiceTaxes = `
+	CREATE TABLE IF NOT EXISTS invoice_taxes(
+		invoice_id UUID NOT NULL REFERENCES invoices(id),
+		position INTEGER NOT NULL,
+		name VARCHAR(64) NOT NULL,
+		rate VARCHAR(16) NOT NULL,
+		inclusive BOOLEAN NOT NULL,
+		amount BIGINT NOT NULL,
+		currency VARCHAR(3) NOT NULL,
+		PRIMARY KEY (invoice_id, position)
+	);
+	`
+	CreateDisputes = `
+	CREATE TABLE IF NOT EXISTS disputes(
+		id UUID PRIMARY KEY,
+		provider_id UUID NOT NULL,
+		external_id VARCHAR(255) NOT NULL,
+		session_id UUID NOT NULL REFERENCES payment_sessions(id),
+		user_id VARCHAR(64),
+		reason VARCHAR(64) NOT NULL,
+		status VARCHAR(32) NOT NULL,
+		amount BIGINT NOT NULL,
+		currency VARCHAR(3) NOT NULL,
+		evidence_due_by TIMESTAMP,
+		access_revoked_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (provider_id, external_id)
+	);
+	`
+	CreateDisputesStatusIndex = `
+	CREATE INDEX IF NOT EXISTS disputes_status_idx ON disputes(status, evidence_due_by);
+	`
+	CreateDisputeEvidence = `
+	CREATE TABLE IF NOT EXISTS dispute_evidence(
+		id UUID PRIMARY KEY,
+		dispute_id UUID NOT NULL REFERENCES disputes(id),
+		filename VARCHAR(255) NOT NULL,
+		content_type VARCHAR(128) NOT NULL,
+		size BIGINT NOT NULL,
+		content BYTEA NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	CreateRiskDecisions = `
+	CREATE TABLE IF NOT EXISTS risk_decisions(
+		id UUID PRIMARY KEY,
+		session_id UUID,
+		user_id VARCHAR(64) NOT NULL DEFAULT '',
+		email VARCHAR(320) NOT NULL DEFAULT '',
+		ip VARCHAR(45) NOT NULL DEFAULT '',
+		device_id VARCHAR(128) NOT NULL DEFAULT '',
+		country VARCHAR(2) NOT NULL DEFAULT '',
+		ip_country VARCHAR(2) NOT NULL DEFAULT '',
+		score INT NOT NULL,
+		action VARCHAR(16) NOT NULL,
+		reasons TEXT[] NOT NULL DEFAULT '{}',
+		review VARCHAR(16) NOT NULL DEFAULT '',
+		reviewed_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL
+	);
+	`
+	CreateRiskDecisionsVelocityIndexes = `
+	CREATE INDEX IF NOT EXISTS risk_decisions_ip_idx ON risk_decisions (ip, created_at) WHERE ip <> '';
+	CREATE INDEX IF NOT EXISTS risk_decisions_user_idx ON risk_decisions (user_id, created_at) WHERE user_id <> '';
+	CREATE INDEX IF NOT EXISTS risk_decisions_email_idx ON risk_decisions (email, created_at) WHERE email <> '';
+	CREATE INDEX IF NOT EXISTS risk_decisions_device_idx ON risk_decisions (device_id, created_at) WHERE device_id <> '';
+	`
+	CreateRiskDecisionsActionIndex = `
+	CREATE INDEX IF NOT EXISTS risk_decisions_action_idx ON risk_decisions (action, created_at);
+	`
+	// renewals of the subscription are invoiced with the same session, so only the checkout invoice is unique per session
+	AddInvoicesProviderInvoiceID = `
+	ALTER TABLE invoices ADD COLUMN IF NOT EXISTS provider_invoice_id VARCHAR(255) UNIQUE;
+	ALTER TABLE invoices DROP CONSTRAINT IF EXISTS invoices_session_id_key;
+	CREATE UNIQUE INDEX IF NOT EXISTS invoices_session_id_idx ON invoices (session_id) WHERE provider_invoice_id IS NULL;
+	`
+	AddPaymentSessionsProviderPaymentID = `
+	ALTER TABLE payment_sessions ADD COLUMN IF NOT EXISTS provider_payment_id VARCHAR(255);
+	CREATE INDEX IF NOT EXISTS payment_sessions_provider_payment_id_idx ON payment_sessions (provider_payment_id);
+	`
+	CreateListEntries = `
+	CREATE TABLE IF NOT EXISTS list_entries(
+		id UUID PRIMARY KEY,
+		list VARCHAR(8) NOT NULL,
+		kind VARCHAR(16) NOT NULL,
+		match_type VARCHAR(8) NOT NULL,
+		value VARCHAR(320) NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		created_by VARCHAR(128) NOT NULL,
+		expires_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (list, kind, match_type, value)
+	);
+	`
+	CreateStoreClicks = `
+	CREATE TABLE IF NOT EXISTS store_clicks(
+		id UUID PRIMARY KEY,
+		store VARCHAR(64) NOT NULL,
+		product_id VARCHAR(64),
+		platform VARCHAR(32),
+		request_id VARCHAR(128),
+		utm_source VARCHAR(255),
+		utm_medium VARCHAR(255),
+		utm_campaign VARCHAR(255),
+		utm_term VARCHAR(255),
+		utm_content VARCHAR(255),
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+)
+
+// Migrations are applied on every start, thus each of them must be idempotent
+var Migrations = []string{
+	CreatePaymentSessions,
+	CreateStoreClicks,
+	AddPaymentSessionsProviderSessionID,
+	CreateEntitlements,
+	AddPaymentSessionsUserID,
+	CreateEntitlementsUserIDIndex,
+	CreateCustomers,
+	CreateCustomerProviderIDs,
+	AddPaymentSessionsAmount,
+	CreateLedgerAccounts,
+	CreateLedgerEntries,
+	CreateLedgerPostings,
+	CreateLedgerPostingsAccountIndex,
+	CreatePaymentSessionsPaidAtIndex,
+	CreateReconciliationReports,
+	CreateReconciliationDiscrepancies,
+	CreateFxRates,
+	CreatePromotions,
+	CreatePromoRedemptions,
+	CreatePromoRedemptionsUserIndex,
+	AddPaymentSessionsPlan,
+	CreateSubscriptions,
+	CreateSubscriptionsTrialIndex,
+	CreateSubscriptionsStatusIndex,
+	CreateExperiments,
+	CreateExperimentVariants,
+	CreateExperimentExposures,
+	CreateFeatureFlags,
+	AddPaymentSessionsTax,
+	CreatePaymentSessionTaxes,
+	AddPaymentSessionsPayer,
+	CreateInvoiceSequences,
+	CreateInvoices,
+	CreateInvoiceTaxes,
+	CreateDisputes,
+	CreateDisputesStatusIndex,
+	CreateDisputeEvidence,
+	CreateRiskDecisions,
+	CreateRiskDecisionsVelocityIndexes,
+	CreateRiskDecisionsActionIndex,
+	CreateListEntries,
+	AddPaymentSessionsProviderPaymentID,
+	AddInvoicesProviderInvoiceID,
+}
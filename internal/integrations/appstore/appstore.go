@@ -0,0 +1,203 @@
+package appstore
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Environments of the App Store transactions, TestFlight purchases are made in the sandbox
+const (
+	EnvironmentProduction = "Production"
+	EnvironmentSandbox    = "Sandbox"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	// lifetime of the token authenticating API requests, Apple accepts up to an hour
+	authTokenTTL = 5 * time.Minute
+)
+
+var (
+	ErrMalformed           = errors.New("signed data is malformed")
+	ErrSignature           = errors.New("signature does not match")
+	ErrUntrustedChain      = errors.New("certificate chain is not trusted")
+	ErrBundleMismatch      = errors.New("transaction belongs to another app")
+	ErrEnvironmentMismatch = errors.New("transaction is made in another environment")
+	ErrTransactionNotFound = errors.New("transaction is not found")
+	ErrUnauthorized        = errors.New("app store rejected credentials")
+	ErrUnavailable         = errors.New("app store is unavailable")
+)
+
+// Config of the App Store Server API integration
+type Config struct {
+	// BaseUrl of the App Store Server API, production, sandbox or a local stand-in
+	BaseUrl  string
+	BundleID string
+	// Environment transactions are accepted from, sandbox purchases are signed by the same chain
+	// but are free, so they are rejected in production. Production is used when it is empty
+	Environment string
+	// IssuerID, KeyID and PrivateKey are the in-app purchase key from App Store Connect
+	IssuerID   string
+	KeyID      string
+	PrivateKey *ecdsa.PrivateKey
+	// Roots are trusted root certificates of the signed data, Apple Root CA - G3 in production
+	Roots   *x509.CertPool
+	Timeout time.Duration
+}
+
+// Transaction is a verified in-app purchase transaction
+type Transaction struct {
+	TransactionID         string
+	OriginalTransactionID string
+	BundleID              string
+	ProductID             string
+	Type                  string
+	Environment           string
+	// AppAccountToken is uuid of our user, set by the app at purchase
+	AppAccountToken string
+	PurchaseDate    time.Time
+	// ExpiresDate is set for subscriptions only
+	ExpiresDate    *time.Time
+	RevocationDate *time.Time
+}
+
+// transactionPayload is JWSTransactionDecodedPayload, dates are in unix milliseconds
+type transactionPayload struct {
+	TransactionID         string `json:"transactionId"`
+	OriginalTransactionID string `json:"originalTransactionId"`
+	BundleID              string `json:"bundleId"`
+	ProductID             string `json:"productId"`
+	Type                  string `json:"type"`
+	Environment           string `json:"environment"`
+	AppAccountToken       string `json:"appAccountToken"`
+	PurchaseDate          int64  `json:"purchaseDate"`
+	ExpiresDate           int64  `json:"expiresDate"`
+	RevocationDate        int64  `json:"revocationDate"`
+}
+
+type AppStore struct {
+	log    *zap.SugaredLogger
+	cnf    Config
+	client *http.Client
+	now    func() time.Time
+}
+
+func NewAppStore(log *zap.SugaredLogger, cnf Config) *AppStore {
+	cnf.BaseUrl = strings.TrimRight(cnf.BaseUrl, "/")
+	if cnf.Timeout <= 0 {
+		cnf.Timeout = defaultTimeout
+	}
+	if cnf.Environment == "" {
+		cnf.Environment = EnvironmentProduction
+	}
+	return &AppStore{log: log, cnf: cnf, client: &http.Client{Timeout: cnf.Timeout}, now: time.Now}
+}
+
+// VerifyTransaction verifies signed transaction info and checks it belongs to our app in the configured environment
+func (a *AppStore) VerifyTransaction(signed string) (*Transaction, error) {
+	var p transactionPayload
+	if err := verifyJWS(signed, a.cnf.Roots, a.now(), &p); err != nil {
+		a.log.Errorf("failed to verify signed transaction, error: %v", err)
+		return nil, err
+	}
+	if p.BundleID != a.cnf.BundleID {
+		a.log.Errorw("transaction belongs to another app",
+			"bundleID", p.BundleID,
+			"transactionID", p.TransactionID)
+		return nil, ErrBundleMismatch
+	}
+	if p.Environment != a.cnf.Environment {
+		a.log.Errorw("transaction is made in another environment",
+			"environment", p.Environment,
+			"transactionID", p.TransactionID)
+		return nil, ErrEnvironmentMismatch
+	}
+	if p.TransactionID == "" || p.OriginalTransactionID == "" || p.ProductID == "" {
+		return nil, ErrMalformed
+	}
+	return &Transaction{
+		TransactionID:         p.TransactionID,
+		OriginalTransactionID: p.OriginalTransactionID,
+		BundleID:              p.BundleID,
+		ProductID:             p.ProductID,
+		Type:                  p.Type,
+		Environment:           p.Environment,
+		AppAccountToken:       p.AppAccountToken,
+		PurchaseDate:          time.UnixMilli(p.PurchaseDate).UTC(),
+		ExpiresDate:           millis(p.ExpiresDate),
+		RevocationDate:        millis(p.RevocationDate),
+	}, nil
+}
+
+// Transaction fetches transaction info by its id from the App Store Server API and verifies it
+func (a *AppStore) Transaction(ctx context.Context, transactionID string) (*Transaction, error) {
+	if a.cnf.PrivateKey == nil {
+		return nil, ErrUnauthorized
+	}
+	token, err := a.authToken()
+	if err != nil {
+		a.log.Errorf("failed to sign app store auth token, error: %v", err)
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.cnf.BaseUrl+"/inApps/v1/transactions/"+url.PathEscape(transactionID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		a.log.Errorf("failed to reach app store, error: %v", err)
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadRequest:
+		return nil, ErrTransactionNotFound
+	case resp.StatusCode == http.StatusUnauthorized:
+		return nil, ErrUnauthorized
+	default:
+		a.log.Errorf("app store failed to return transaction %v with status %v", transactionID, resp.StatusCode)
+		return nil, ErrUnavailable
+	}
+	var body struct {
+		SignedTransactionInfo string `json:"signedTransactionInfo"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return a.VerifyTransaction(body.SignedTransactionInfo)
+}
+
+// authToken signs JWT authenticating requests to the App Store Server API
+func (a *AppStore) authToken() (string, error) {
+	now := a.now()
+	return signES256(a.cnf.PrivateKey,
+		map[string]string{"alg": "ES256", "kid": a.cnf.KeyID, "typ": "JWT"},
+		map[string]any{
+			"iss": a.cnf.IssuerID,
+			"iat": now.Unix(),
+			"exp": now.Add(authTokenTTL).Unix(),
+			"aud": "appstoreconnect-v1",
+			"bid": a.cnf.BundleID,
+		})
+}
+
+func millis(ms int64) *time.Time {
+	if ms == 0 {
+		return nil
+	}
+	t := time.UnixMilli(ms).UTC()
+	return &t
+}
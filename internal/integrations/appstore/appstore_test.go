@@ -0,0 +1,234 @@
+package appstore
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// testCA is a locally generated stand-in of Apple Root CA with an intermediate and a signing leaf
+type testCA struct {
+	roots *x509.CertPool
+	key   *ecdsa.PrivateKey
+	x5c   []string
+}
+
+// newCert issues the certificate with the parent, the marker extension is added unless it is nil
+func newCert(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, isCA bool, marker asn1.ObjectIdentifier) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	if marker != nil {
+		// Apple markers hold ASN.1 NULL
+		tmpl.ExtraExtensions = []pkix.Extension{{Id: marker, Value: []byte{0x05, 0x00}}}
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return cert, key
+}
+
+func newTestCA(t *testing.T) *testCA {
+	return newTestChain(t, oidIntermediateMarker, oidLeafMarker)
+}
+
+// newTestChain issues the chain with the provided markers of the intermediate and the leaf
+func newTestChain(t *testing.T, intermediateMarker, leafMarker asn1.ObjectIdentifier) *testCA {
+	root, rootKey := newCert(t, "Test Root CA", nil, nil, true, nil)
+	intermediate, intermediateKey := newCert(t, "Test WWDR CA", root, rootKey, true, intermediateMarker)
+	leaf, leafKey := newCert(t, "Test Signing", intermediate, intermediateKey, false, leafMarker)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	return &testCA{
+		roots: roots,
+		key:   leafKey,
+		x5c: []string{
+			base64.StdEncoding.EncodeToString(leaf.Raw),
+			base64.StdEncoding.EncodeToString(intermediate.Raw),
+			base64.StdEncoding.EncodeToString(root.Raw),
+		},
+	}
+}
+
+func (ca *testCA) sign(t *testing.T, payload map[string]any) string {
+	signed, err := signES256(ca.key, map[string]any{"alg": "ES256", "x5c": ca.x5c}, payload)
+	assert.NoError(t, err)
+	return signed
+}
+
+func signedPayload(bundleID string) map[string]any {
+	return map[string]any{
+		"transactionId":         "2000000000000002",
+		"originalTransactionId": "2000000000000001",
+		"bundleId":              bundleID,
+		"productId":             "com.headway.books.premium.yearly",
+		"type":                  "Auto-Renewable Subscription",
+		"environment":           "Production",
+		"appAccountToken":       "7e3fb20b-4cdb-47cc-936d-99d65f608138",
+		"purchaseDate":          int64(1700000000000),
+		"expiresDate":           int64(1731536000000),
+	}
+}
+
+func TestAppStoreVerifyTransaction(t *testing.T) {
+	ca := newTestCA(t)
+	appStore := NewAppStore(zap.NewNop().Sugar(), Config{BundleID: "com.headway.books", Roots: ca.roots})
+
+	t.Run("success", func(t *testing.T) {
+		tx, err := appStore.VerifyTransaction(ca.sign(t, signedPayload("com.headway.books")))
+		assert.NoError(t, err)
+		assert.Equal(t, "2000000000000001", tx.OriginalTransactionID)
+		assert.Equal(t, "com.headway.books.premium.yearly", tx.ProductID)
+		assert.Equal(t, "7e3fb20b-4cdb-47cc-936d-99d65f608138", tx.AppAccountToken)
+		assert.Equal(t, time.UnixMilli(1731536000000).UTC(), *tx.ExpiresDate)
+		assert.Nil(t, tx.RevocationDate)
+	})
+
+	t.Run("fail another app", func(t *testing.T) {
+		_, err := appStore.VerifyTransaction(ca.sign(t, signedPayload("com.another.app")))
+		assert.ErrorIs(t, err, ErrBundleMismatch)
+	})
+
+	t.Run("fail sandbox transaction", func(t *testing.T) {
+		payload := signedPayload("com.headway.books")
+		payload["environment"] = EnvironmentSandbox
+		_, err := appStore.VerifyTransaction(ca.sign(t, payload))
+		assert.ErrorIs(t, err, ErrEnvironmentMismatch)
+
+		sandbox := NewAppStore(zap.NewNop().Sugar(), Config{BundleID: "com.headway.books", Environment: EnvironmentSandbox, Roots: ca.roots})
+		_, err = sandbox.VerifyTransaction(ca.sign(t, payload))
+		assert.NoError(t, err)
+		_, err = sandbox.VerifyTransaction(ca.sign(t, signedPayload("com.headway.books")))
+		assert.ErrorIs(t, err, ErrEnvironmentMismatch)
+	})
+
+	t.Run("fail foreign root", func(t *testing.T) {
+		foreign := newTestCA(t)
+		_, err := appStore.VerifyTransaction(foreign.sign(t, signedPayload("com.headway.books")))
+		assert.ErrorIs(t, err, ErrUntrustedChain)
+	})
+
+	t.Run("fail chain without storekit markers", func(t *testing.T) {
+		for _, chain := range []*testCA{
+			newTestChain(t, nil, nil),
+			newTestChain(t, oidIntermediateMarker, nil),
+			newTestChain(t, nil, oidLeafMarker),
+		} {
+			trusted := NewAppStore(zap.NewNop().Sugar(), Config{BundleID: "com.headway.books", Roots: chain.roots})
+			_, err := trusted.VerifyTransaction(chain.sign(t, signedPayload("com.headway.books")))
+			assert.ErrorIs(t, err, ErrUntrustedChain)
+		}
+	})
+
+	t.Run("fail tampered payload", func(t *testing.T) {
+		parts := strings.Split(ca.sign(t, signedPayload("com.headway.books")), ".")
+		tampered := signedPayload("com.headway.books")
+		tampered["expiresDate"] = int64(4102444800000)
+		raw, _ := json.Marshal(tampered)
+		parts[1] = base64.RawURLEncoding.EncodeToString(raw)
+		_, err := appStore.VerifyTransaction(strings.Join(parts, "."))
+		assert.ErrorIs(t, err, ErrSignature)
+	})
+
+	t.Run("fail expired chain", func(t *testing.T) {
+		expired := NewAppStore(zap.NewNop().Sugar(), Config{BundleID: "com.headway.books", Roots: ca.roots})
+		expired.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+		_, err := expired.VerifyTransaction(ca.sign(t, signedPayload("com.headway.books")))
+		assert.ErrorIs(t, err, ErrUntrustedChain)
+	})
+
+	t.Run("fail malformed", func(t *testing.T) {
+		_, err := appStore.VerifyTransaction("not.a.jws")
+		assert.ErrorIs(t, err, ErrMalformed)
+	})
+}
+
+func TestAppStoreTransaction(t *testing.T) {
+	ca := newTestCA(t)
+	apiKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	// fake App Store Server API checks the auth token signed with the in-app purchase key
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		parts := strings.Split(token, ".")
+		if len(parts) != 3 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rawClaims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		var claims map[string]any
+		_ = json.Unmarshal(rawClaims, &claims)
+		assert.Equal(t, "issuer", claims["iss"])
+		assert.Equal(t, "appstoreconnect-v1", claims["aud"])
+		assert.Equal(t, "com.headway.books", claims["bid"])
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		digest := sha256Sum(parts[0] + "." + parts[1])
+		if !ecdsa.Verify(&apiKey.PublicKey, digest, new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/inApps/v1/transactions/2000000000000002":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]string{"signedTransactionInfo": ca.sign(t, signedPayload("com.headway.books"))})
+		case "/inApps/v1/transactions/500":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	appStore := NewAppStore(zap.NewNop().Sugar(), Config{
+		BaseUrl:    srv.URL,
+		BundleID:   "com.headway.books",
+		IssuerID:   "issuer",
+		KeyID:      "key",
+		PrivateKey: apiKey,
+		Roots:      ca.roots,
+	})
+
+	tx, err := appStore.Transaction(context.Background(), "2000000000000002")
+	assert.NoError(t, err)
+	assert.Equal(t, "2000000000000001", tx.OriginalTransactionID)
+
+	_, err = appStore.Transaction(context.Background(), "404")
+	assert.ErrorIs(t, err, ErrTransactionNotFound)
+	_, err = appStore.Transaction(context.Background(), "500")
+	assert.ErrorIs(t, err, ErrUnavailable)
+}
+
+func sha256Sum(s string) []byte {
+	digest := sha256.Sum256([]byte(s))
+	return digest[:]
+}
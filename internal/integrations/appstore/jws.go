@@ -0,0 +1,188 @@
+package appstore
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// Marker extensions Apple puts into certificates of the StoreKit signing chain, any other certificate
+// issued under Apple Root CA is not a StoreKit signer
+var (
+	oidLeafMarker         = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 11, 1}
+	oidIntermediateMarker = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 2, 1}
+)
+
+// jwsHeader is the protected header of the JWS signed by Apple
+type jwsHeader struct {
+	Alg string   `json:"alg"`
+	X5c []string `json:"x5c"`
+}
+
+// verifyJWS checks that the compact JWS is signed by the leaf certificate of its x5c chain,
+// that the chain leads to one of the roots and that the leaf and the intermediate carry the StoreKit markers,
+// payload is decoded into out
+func verifyJWS(signed string, roots *x509.CertPool, now time.Time, out any) error {
+	parts := strings.Split(signed, ".")
+	if len(parts) != 3 {
+		return ErrMalformed
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrMalformed
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return ErrMalformed
+	}
+	if header.Alg != "ES256" || len(header.X5c) == 0 {
+		return ErrMalformed
+	}
+
+	certs := make([]*x509.Certificate, 0, len(header.X5c))
+	for _, i := range header.X5c {
+		// x5c holds standard base64 of DER, not base64url
+		der, err := base64.StdEncoding.DecodeString(i)
+		if err != nil {
+			return ErrMalformed
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return ErrMalformed
+		}
+		certs = append(certs, cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUntrustedChain, err)
+	}
+	if !storeKitChain(chains) {
+		return fmt.Errorf("%w: chain is not issued for StoreKit signing", ErrUntrustedChain)
+	}
+
+	pub, ok := certs[0].PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return ErrUntrustedChain
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return ErrSignature
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return ErrSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ErrMalformed
+	}
+	if err := json.Unmarshal(payload, out); err != nil {
+		return ErrMalformed
+	}
+	return nil
+}
+
+// storeKitChain tells whether any of the verified chains is the leaf with its marker
+// issued by the intermediate with its marker
+func storeKitChain(chains [][]*x509.Certificate) bool {
+	for _, chain := range chains {
+		if len(chain) >= 3 && hasExtension(chain[0], oidLeafMarker) && hasExtension(chain[1], oidIntermediateMarker) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+// signES256 produces compact JWS of the header and claims, it is used
+// to authenticate requests to the App Store Server API
+func signES256(key *ecdsa.PrivateKey, header, claims any) (string, error) {
+	rawHeader, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	rawClaims, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(rawHeader) + "." + base64.RawURLEncoding.EncodeToString(rawClaims)
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	// JWS signature is fixed size r || s, not ASN.1
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// LoadRootCerts reads trusted root certificates from PEM or DER file,
+// Apple distributes its roots as DER encoded .cer files
+func LoadRootCerts(path string) (*x509.CertPool, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if pool.AppendCertsFromPEM(raw) {
+		return pool, nil
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return nil, err
+	}
+	pool.AddCert(cert)
+	return pool, nil
+}
+
+// LoadPrivateKey reads PKCS#8 PEM encoded EC key, the format of .p8 keys issued by App Store Connect
+func LoadPrivateKey(path string) (*ecdsa.PrivateKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not EC key")
+	}
+	return ecKey, nil
+}
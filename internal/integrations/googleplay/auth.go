@@ -0,0 +1,172 @@
+package googleplay
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	scope = "https://www.googleapis.com/auth/androidpublisher"
+	// lifetime of the assertion exchanged for an access token, Google accepts up to an hour
+	assertionTTL = time.Hour
+	// token is refreshed a bit earlier than it expires, so it doesn't expire in flight
+	tokenLeeway = time.Minute
+)
+
+// ServiceAccount is the service account key granted access to the Play Console
+type ServiceAccount struct {
+	ClientEmail  string
+	PrivateKeyID string
+	PrivateKey   *rsa.PrivateKey
+	// TokenUri issues access tokens in exchange for signed assertions
+	TokenUri string
+}
+
+// serviceAccountFile is the JSON key file downloaded from Google Cloud console
+type serviceAccountFile struct {
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenUri     string `json:"token_uri"`
+}
+
+// LoadServiceAccount reads JSON key file of the service account
+func LoadServiceAccount(path string) (*ServiceAccount, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f serviceAccountFile
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(f.PrivateKey))
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not RSA key")
+	}
+	if f.ClientEmail == "" || f.TokenUri == "" {
+		return nil, errors.New("client_email and token_uri are required")
+	}
+	return &ServiceAccount{
+		ClientEmail:  f.ClientEmail,
+		PrivateKeyID: f.PrivateKeyID,
+		PrivateKey:   rsaKey,
+		TokenUri:     f.TokenUri,
+	}, nil
+}
+
+// tokenSource exchanges assertions signed by the service account for access tokens
+// and caches them, concurrent requests wait for a single refresh
+type tokenSource struct {
+	account *ServiceAccount
+	client  *http.Client
+	now     func() time.Time
+
+	mu        sync.Mutex
+	value     string
+	expiresAt time.Time
+}
+
+// token returns cached access token or requests a new one
+func (s *tokenSource) token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.value != "" && s.now().Add(tokenLeeway).Before(s.expiresAt) {
+		return s.value, nil
+	}
+
+	assertion, err := s.assertion()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.account.TokenUri, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return "", fmt.Errorf("%w: token endpoint responded with %v", ErrUnavailable, resp.StatusCode)
+	default:
+		return "", fmt.Errorf("%w: token endpoint responded with %v", ErrUnauthorized, resp.StatusCode)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.AccessToken == "" {
+		return "", fmt.Errorf("%w: malformed access token", ErrUnavailable)
+	}
+	s.value = body.AccessToken
+	s.expiresAt = s.now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	return s.value, nil
+}
+
+// invalidate drops the token unless it was already refreshed by a concurrent call
+func (s *tokenSource) invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.value == token {
+		s.value = ""
+	}
+}
+
+// assertion signs RS256 JWT of the service account requesting Play Developer API scope
+func (s *tokenSource) assertion() (string, error) {
+	now := s.now()
+	rawHeader, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": s.account.PrivateKeyID})
+	if err != nil {
+		return "", err
+	}
+	rawClaims, err := json.Marshal(map[string]any{
+		"iss":   s.account.ClientEmail,
+		"scope": scope,
+		"aud":   s.account.TokenUri,
+		"iat":   now.Unix(),
+		"exp":   now.Add(assertionTTL).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(rawHeader) + "." + base64.RawURLEncoding.EncodeToString(rawClaims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.account.PrivateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
@@ -0,0 +1,195 @@
+package googleplay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// Subscription states of the subscriptionsv2 resource
+const (
+	StatePending       = "SUBSCRIPTION_STATE_PENDING"
+	StateActive        = "SUBSCRIPTION_STATE_ACTIVE"
+	StatePaused        = "SUBSCRIPTION_STATE_PAUSED"
+	StateInGracePeriod = "SUBSCRIPTION_STATE_IN_GRACE_PERIOD"
+	StateOnHold        = "SUBSCRIPTION_STATE_ON_HOLD"
+	StateCanceled      = "SUBSCRIPTION_STATE_CANCELED"
+	StateExpired       = "SUBSCRIPTION_STATE_EXPIRED"
+)
+
+var (
+	ErrPurchaseNotFound = errors.New("purchase is not found")
+	ErrUnauthorized     = errors.New("google play rejected credentials")
+	ErrUnavailable      = errors.New("google play is unavailable")
+)
+
+// Config of the Play Developer API integration
+type Config struct {
+	// BaseUrl of the Play Developer API, production or a local stand-in
+	BaseUrl     string
+	PackageName string
+	Account     *ServiceAccount
+	Timeout     time.Duration
+}
+
+// Subscription is the state of a subscription purchase
+type Subscription struct {
+	PurchaseToken string
+	// LinkedPurchaseToken is the token of the replaced subscription on upgrade or resubscribe
+	LinkedPurchaseToken string
+	ProductID           string
+	OrderID             string
+	State               string
+	Acknowledged        bool
+	// AccountID is obfuscated id of our user, set by the app at purchase
+	AccountID  string
+	StartTime  time.Time
+	ExpiryTime *time.Time
+}
+
+// subscriptionPurchase is SubscriptionPurchaseV2 resource
+type subscriptionPurchase struct {
+	SubscriptionState          string    `json:"subscriptionState"`
+	AcknowledgementState       string    `json:"acknowledgementState"`
+	StartTime                  time.Time `json:"startTime"`
+	LinkedPurchaseToken        string    `json:"linkedPurchaseToken"`
+	LatestOrderID              string    `json:"latestOrderId"`
+	ExternalAccountIdentifiers struct {
+		ObfuscatedExternalAccountID string `json:"obfuscatedExternalAccountId"`
+	} `json:"externalAccountIdentifiers"`
+	LineItems []struct {
+		ProductID  string     `json:"productId"`
+		ExpiryTime *time.Time `json:"expiryTime"`
+	} `json:"lineItems"`
+}
+
+type GooglePlay struct {
+	log    *zap.SugaredLogger
+	cnf    Config
+	client *http.Client
+	tokens *tokenSource
+}
+
+func NewGooglePlay(log *zap.SugaredLogger, cnf Config) *GooglePlay {
+	cnf.BaseUrl = strings.TrimRight(cnf.BaseUrl, "/")
+	if cnf.Timeout <= 0 {
+		cnf.Timeout = defaultTimeout
+	}
+	client := &http.Client{Timeout: cnf.Timeout}
+	return &GooglePlay{
+		log:    log,
+		cnf:    cnf,
+		client: client,
+		tokens: &tokenSource{account: cnf.Account, client: client, now: time.Now},
+	}
+}
+
+// PackageName of the app the purchases belong to
+func (g *GooglePlay) PackageName() string {
+	return g.cnf.PackageName
+}
+
+// Subscription fetches state of the subscription purchase by its token
+func (g *GooglePlay) Subscription(ctx context.Context, purchaseToken string) (*Subscription, error) {
+	var p subscriptionPurchase
+	path := "/androidpublisher/v3/applications/" + url.PathEscape(g.cnf.PackageName) +
+		"/purchases/subscriptionsv2/tokens/" + url.PathEscape(purchaseToken)
+	if err := g.call(ctx, http.MethodGet, path, nil, &p); err != nil {
+		return nil, err
+	}
+	if len(p.LineItems) == 0 {
+		return nil, fmt.Errorf("%w: subscription has no line items", ErrUnavailable)
+	}
+	// line items are several only for subscription bundles, which are not sold
+	item := p.LineItems[0]
+	return &Subscription{
+		PurchaseToken:       purchaseToken,
+		LinkedPurchaseToken: p.LinkedPurchaseToken,
+		ProductID:           item.ProductID,
+		OrderID:             p.LatestOrderID,
+		State:               p.SubscriptionState,
+		Acknowledged:        p.AcknowledgementState == "ACKNOWLEDGEMENT_STATE_ACKNOWLEDGED",
+		AccountID:           p.ExternalAccountIdentifiers.ObfuscatedExternalAccountID,
+		StartTime:           p.StartTime,
+		ExpiryTime:          item.ExpiryTime,
+	}, nil
+}
+
+// Acknowledge acknowledges the subscription purchase, otherwise Google refunds it in three days
+func (g *GooglePlay) Acknowledge(ctx context.Context, productID, purchaseToken string) error {
+	path := "/androidpublisher/v3/applications/" + url.PathEscape(g.cnf.PackageName) +
+		"/purchases/subscriptions/" + url.PathEscape(productID) +
+		"/tokens/" + url.PathEscape(purchaseToken) + ":acknowledge"
+	return g.call(ctx, http.MethodPost, path, map[string]string{}, nil)
+}
+
+// call performs authorized API call, when the cached token is rejected
+// it is refreshed and the call is retried once
+func (g *GooglePlay) call(ctx context.Context, method, path string, body, out any) error {
+	if g.cnf.Account == nil {
+		return ErrUnauthorized
+	}
+	var raw []byte
+	if body != nil {
+		var err error
+		if raw, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		token, err := g.tokens.token(ctx)
+		if err != nil {
+			g.log.Errorf("failed to obtain google play access token, error: %v", err)
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, method, g.cnf.BaseUrl+path, bytes.NewReader(raw))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := g.client.Do(req)
+		if err != nil {
+			g.log.Errorf("failed to reach google play, error: %v", err)
+			return fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			resp.Body.Close()
+			g.tokens.invalidate(token)
+			continue
+		}
+		defer resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent:
+		// invalid tokens are reported as bad request, unknown ones as not found
+		case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusGone:
+			return ErrPurchaseNotFound
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			return ErrUnauthorized
+		default:
+			g.log.Errorf("google play failed to serve %v with status %v", path, resp.StatusCode)
+			return ErrUnavailable
+		}
+		if out == nil {
+			return nil
+		}
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			g.log.Errorf("failed to decode google play response, error: %v", err)
+			return fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+		return nil
+	}
+}
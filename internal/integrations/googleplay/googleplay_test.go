@@ -0,0 +1,174 @@
+package googleplay
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// writeServiceAccount writes JSON key file of the service account as Google Cloud console does
+func writeServiceAccount(t *testing.T, key *rsa.PrivateKey, tokenUri string) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+	raw, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "payments@headway.iam.gserviceaccount.com",
+		"private_key_id": "key-id",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":      tokenUri,
+	})
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "service-account.json")
+	assert.NoError(t, os.WriteFile(path, raw, 0o600))
+	return path
+}
+
+// fakePlay is a stand-in of Google OAuth and Play Developer API
+type fakePlay struct {
+	t             *testing.T
+	key           *rsa.PublicKey
+	tokenRequests int32
+	acknowledged  int32
+	// expired makes the API reject every issued token once
+	expired int32
+}
+
+func (f *fakePlay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		atomic.AddInt32(&f.tokenRequests, 1)
+		assert.Equal(f.t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.FormValue("grant_type"))
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		assert.Len(f.t, parts, 3)
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if rsa.VerifyPKCS1v15(f.key, crypto.SHA256, digest[:], sig) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		rawClaims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		var claims map[string]any
+		_ = json.Unmarshal(rawClaims, &claims)
+		assert.Equal(f.t, scope, claims["scope"])
+		assert.Equal(f.t, "payments@headway.iam.gserviceaccount.com", claims["iss"])
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "access", "expires_in": 3600})
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer access" || atomic.CompareAndSwapInt32(&f.expired, 1, 0) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/androidpublisher/v3/applications/com.headway.books/purchases/subscriptionsv2/tokens/token":
+		_, _ = w.Write([]byte(`{
+			"subscriptionState": "SUBSCRIPTION_STATE_ACTIVE",
+			"acknowledgementState": "ACKNOWLEDGEMENT_STATE_PENDING",
+			"startTime": "2024-01-02T10:00:00.000Z",
+			"latestOrderId": "GPA.1234",
+			"externalAccountIdentifiers": {"obfuscatedExternalAccountId": "user"},
+			"lineItems": [{"productId": "premium_yearly", "expiryTime": "2025-01-02T10:00:00.000Z"}]
+		}`))
+	case "/androidpublisher/v3/applications/com.headway.books/purchases/subscriptions/premium_yearly/tokens/token:acknowledge":
+		assert.Equal(f.t, http.MethodPost, r.Method)
+		atomic.AddInt32(&f.acknowledged, 1)
+	case "/androidpublisher/v3/applications/com.headway.books/purchases/subscriptionsv2/tokens/outage":
+		w.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestGooglePlay(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	fake := &fakePlay{t: t, key: &key.PublicKey}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	account, err := LoadServiceAccount(writeServiceAccount(t, key, srv.URL+"/token"))
+	assert.NoError(t, err)
+	play := NewGooglePlay(zap.NewNop().Sugar(), Config{BaseUrl: srv.URL, PackageName: "com.headway.books", Account: account})
+
+	t.Run("success subscription and acknowledge", func(t *testing.T) {
+		sub, err := play.Subscription(context.Background(), "token")
+		assert.NoError(t, err)
+		assert.Equal(t, "premium_yearly", sub.ProductID)
+		assert.Equal(t, StateActive, sub.State)
+		assert.Equal(t, "user", sub.AccountID)
+		assert.Equal(t, "GPA.1234", sub.OrderID)
+		assert.False(t, sub.Acknowledged)
+		assert.Equal(t, time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC), sub.ExpiryTime.UTC())
+
+		assert.NoError(t, play.Acknowledge(context.Background(), sub.ProductID, sub.PurchaseToken))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&fake.acknowledged))
+		// token is cached between the calls
+		assert.Equal(t, int32(1), atomic.LoadInt32(&fake.tokenRequests))
+	})
+
+	t.Run("rejected token is refreshed", func(t *testing.T) {
+		atomic.StoreInt32(&fake.expired, 1)
+		_, err := play.Subscription(context.Background(), "token")
+		assert.NoError(t, err)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&fake.tokenRequests))
+	})
+
+	t.Run("fail unknown token", func(t *testing.T) {
+		_, err := play.Subscription(context.Background(), "unknown")
+		assert.ErrorIs(t, err, ErrPurchaseNotFound)
+	})
+
+	t.Run("fail outage", func(t *testing.T) {
+		_, err := play.Subscription(context.Background(), "outage")
+		assert.ErrorIs(t, err, ErrUnavailable)
+	})
+
+	t.Run("fail foreign key", func(t *testing.T) {
+		foreign, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.NoError(t, err)
+		account, err := LoadServiceAccount(writeServiceAccount(t, foreign, srv.URL+"/token"))
+		assert.NoError(t, err)
+		play := NewGooglePlay(zap.NewNop().Sugar(), Config{BaseUrl: srv.URL, PackageName: "com.headway.books", Account: account})
+		_, err = play.Subscription(context.Background(), "token")
+		assert.ErrorIs(t, err, ErrUnauthorized)
+	})
+}
+
+func TestParseNotification(t *testing.T) {
+	push := func(data string) []byte {
+		return []byte(`{"message": {"data": "` + base64.StdEncoding.EncodeToString([]byte(data)) + `", "messageId": "1"}, "subscription": "projects/headway/subscriptions/play"}`)
+	}
+
+	n, err := ParseNotification(push(`{"version": "1.0", "packageName": "com.headway.books", "eventTimeMillis": "1700000000000",
+		"subscriptionNotification": {"version": "1.0", "notificationType": 12, "purchaseToken": "token", "subscriptionId": "premium_yearly"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "com.headway.books", n.PackageName)
+	assert.Equal(t, time.UnixMilli(1700000000000).UTC(), n.EventTime)
+	assert.Equal(t, &SubscriptionNotification{Type: NotificationRevoked, PurchaseToken: "token", ProductID: "premium_yearly"}, n.Subscription)
+
+	n, err = ParseNotification(push(`{"version": "1.0", "packageName": "com.headway.books", "testNotification": {"version": "1.0"}}`))
+	assert.NoError(t, err)
+	assert.True(t, n.Test)
+	assert.Nil(t, n.Subscription)
+
+	_, err = ParseNotification([]byte(`{"message": {"data": "not base64"}}`))
+	assert.ErrorIs(t, err, ErrMalformedNotification)
+	_, err = ParseNotification(push(`{"subscriptionNotification": {"notificationType": 4}}`))
+	assert.ErrorIs(t, err, ErrMalformedNotification)
+}
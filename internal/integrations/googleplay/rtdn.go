@@ -0,0 +1,101 @@
+package googleplay
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Subscription notification types of Real-Time Developer Notifications
+const (
+	NotificationRecovered   = 1
+	NotificationRenewed     = 2
+	NotificationCanceled    = 3
+	NotificationPurchased   = 4
+	NotificationOnHold      = 5
+	NotificationGracePeriod = 6
+	NotificationRestarted   = 7
+	NotificationPaused      = 10
+	NotificationRevoked     = 12
+	NotificationExpired     = 13
+)
+
+var ErrMalformedNotification = errors.New("notification is malformed")
+
+// Notification is Real-Time Developer Notification, only one of its kinds is set
+type Notification struct {
+	MessageID   string
+	PackageName string
+	EventTime   time.Time
+	// Subscription is nil for one-time product and test notifications
+	Subscription *SubscriptionNotification
+	Test         bool
+}
+
+type SubscriptionNotification struct {
+	Type          int
+	PurchaseToken string
+	ProductID     string
+}
+
+// pushMessage is the body of Pub/Sub push request
+type pushMessage struct {
+	Message struct {
+		Data      string `json:"data"`
+		MessageID string `json:"messageId"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// developerNotification is the payload published by Google Play
+type developerNotification struct {
+	PackageName              string `json:"packageName"`
+	EventTimeMillis          string `json:"eventTimeMillis"`
+	SubscriptionNotification *struct {
+		NotificationType int    `json:"notificationType"`
+		PurchaseToken    string `json:"purchaseToken"`
+		SubscriptionID   string `json:"subscriptionId"`
+	} `json:"subscriptionNotification"`
+	TestNotification *struct {
+		Version string `json:"version"`
+	} `json:"testNotification"`
+}
+
+// ParseNotification decodes Pub/Sub push request body into the notification
+func ParseNotification(body []byte) (*Notification, error) {
+	var msg pushMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, ErrMalformedNotification
+	}
+	data, err := base64.StdEncoding.DecodeString(msg.Message.Data)
+	if err != nil {
+		return nil, ErrMalformedNotification
+	}
+	var dn developerNotification
+	if err := json.Unmarshal(data, &dn); err != nil {
+		return nil, ErrMalformedNotification
+	}
+
+	n := &Notification{
+		MessageID:   msg.Message.MessageID,
+		PackageName: dn.PackageName,
+		Test:        dn.TestNotification != nil,
+	}
+	// eventTimeMillis is a string of unix milliseconds
+	if millis, err := strconv.ParseInt(dn.EventTimeMillis, 10, 64); err == nil {
+		n.EventTime = time.UnixMilli(millis).UTC()
+	}
+	if sn := dn.SubscriptionNotification; sn != nil {
+		if sn.PurchaseToken == "" {
+			return nil, ErrMalformedNotification
+		}
+		n.Subscription = &SubscriptionNotification{
+			Type:          sn.NotificationType,
+			PurchaseToken: sn.PurchaseToken,
+			ProductID:     sn.SubscriptionID,
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,332 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/money"
+)
+
+const (
+	defaultPayPalTimeout = 10 * time.Second
+	// token is refreshed a bit earlier than it expires, so it doesn't expire in flight
+	payPalTokenLeeway = time.Minute
+)
+
+// PayPalConfig holds everything the PayPal adapter needs besides the credentials,
+// which are stored together with the provider
+type PayPalConfig struct {
+	// BaseUrl of the PayPal API, e.g. sandbox or a local stand-in
+	BaseUrl string
+	// ReturnUrl receives the payer after approval, session_id of the checkout is added to it
+	ReturnUrl string
+	CancelUrl string
+	Items     []LineItem
+	Timeout   time.Duration
+}
+
+// PayPal creates and captures orders via PayPal Orders API
+type PayPal struct {
+	log    *zap.SugaredLogger
+	cnf    PayPalConfig
+	client *http.Client
+	now    func() time.Time
+
+	mu sync.Mutex
+	// tokens keeps access token of every client id
+	tokens map[string]*payPalToken
+}
+
+// payPalToken is a cached access token, its own lock makes concurrent requests
+// of the same client wait for a single refresh
+type payPalToken struct {
+	mu        sync.Mutex
+	value     string
+	expiresAt time.Time
+}
+
+func NewPayPal(log *zap.SugaredLogger, cnf PayPalConfig) *PayPal {
+	cnf.BaseUrl = strings.TrimRight(cnf.BaseUrl, "/")
+	if cnf.Timeout <= 0 {
+		cnf.Timeout = defaultPayPalTimeout
+	}
+	return &PayPal{
+		log:    log,
+		cnf:    cnf,
+		client: &http.Client{Timeout: cnf.Timeout},
+		now:    time.Now,
+		tokens: map[string]*payPalToken{},
+	}
+}
+
+type payPalOrder struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Links  []struct {
+		Href string `json:"href"`
+		Rel  string `json:"rel"`
+	} `json:"links"`
+}
+
+type payPalError struct {
+	Name    string `json:"name"`
+	Message string `json:"message"`
+	Details []struct {
+		Issue string `json:"issue"`
+	} `json:"details"`
+	// OAuth errors come in a different shape
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// CreateCheckout creates an order to be captured and returns its approve link
+func (p *PayPal) CreateCheckout(ctx context.Context, clientID, secret string, checkout Checkout) (*CheckoutSession, error) {
+	// orders are one-time payments, recurring checkouts need PayPal Subscriptions API
+	if checkout.Subscription != nil {
+		return nil, fmt.Errorf("%w: subscriptions are not supported by paypal", ErrNotSupported)
+	}
+	var total int64
+	var currency string
+	items := checkoutItems(p.cnf.Items, checkout)
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		quantity := item.Quantity
+		if quantity <= 0 {
+			quantity = 1
+		}
+		total += item.Amount * quantity
+		currency = strings.ToUpper(item.Currency)
+		names = append(names, item.Name)
+	}
+	unit := map[string]any{
+		"reference_id": checkout.ReferenceID,
+		// invoice id is the only reference listed in settlement reports
+		"invoice_id":  checkout.ReferenceID,
+		"description": strings.Join(names, ", "),
+		"amount": map[string]string{
+			"currency_code": currency,
+			"value":         money.Money{Amount: total, Currency: currency}.Major(),
+		},
+	}
+	// metadata is not supported by PayPal, thus the provider reference is kept in custom_id
+	if id := checkout.Metadata["provider_id"]; id != "" {
+		unit["custom_id"] = id
+	}
+	returnUrl := p.cnf.ReturnUrl
+	if checkout.ReferenceID != "" {
+		returnUrl = withQuery(returnUrl, "session_id", checkout.ReferenceID)
+	}
+	body := map[string]any{
+		"intent":         "CAPTURE",
+		"purchase_units": []any{unit},
+		"application_context": map[string]string{
+			"return_url": returnUrl,
+			"cancel_url": p.cnf.CancelUrl,
+		},
+	}
+	// PayPal keeps no customers of the merchant, the payer is only prefilled on the approval page
+	if c := checkout.Customer; c != nil {
+		payer := map[string]any{}
+		if c.Email != "" {
+			payer["email_address"] = c.Email
+		}
+		if c.Country != "" {
+			payer["address"] = map[string]string{"country_code": c.Country}
+		}
+		if len(payer) > 0 {
+			body["payer"] = payer
+		}
+	}
+
+	var order payPalOrder
+	if err := p.call(ctx, clientID, secret, http.MethodPost, "/v2/checkout/orders", checkout.ReferenceID, body, &order); err != nil {
+		return nil, err
+	}
+	for _, link := range order.Links {
+		// payer-action is returned instead of approve for some payment sources
+		if link.Rel == "approve" || link.Rel == "payer-action" {
+			return &CheckoutSession{ID: order.ID, Url: link.Href}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: order %v has no approve link", ErrProviderUnavailable, order.ID)
+}
+
+// Capture captures payment of the order approved by the payer
+func (p *PayPal) Capture(ctx context.Context, clientID, secret, orderID string) error {
+	var order payPalOrder
+	path := "/v2/checkout/orders/" + url.PathEscape(orderID) + "/capture"
+	// capture is idempotent on the order, so the order id is a natural request id
+	if err := p.call(ctx, clientID, secret, http.MethodPost, path, "capture-"+orderID, nil, &order); err != nil {
+		return err
+	}
+	if order.Status != "COMPLETED" {
+		return fmt.Errorf("%w: order %v is %v after capture", ErrProviderDeclined, orderID, order.Status)
+	}
+	return nil
+}
+
+// call performs authorized API call, when the cached token is rejected
+// it is refreshed and the call is retried once
+func (p *PayPal) call(ctx context.Context, clientID, secret, method, path, requestID string, body, out any) error {
+	var raw []byte
+	if body != nil {
+		var err error
+		if raw, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		token, err := p.token(ctx, clientID, secret)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, method, p.cnf.BaseUrl+path, bytes.NewReader(raw))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		if requestID != "" {
+			req.Header.Set("PayPal-Request-Id", requestID)
+		}
+		resp, err := p.client.Do(req)
+		if err != nil {
+			p.log.Errorf("failed to reach paypal, error: %v", err)
+			return fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			resp.Body.Close()
+			p.invalidate(clientID, token)
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= http.StatusMultipleChoices {
+			var e payPalError
+			_ = json.NewDecoder(resp.Body).Decode(&e)
+			p.log.Errorw("paypal rejected the request",
+				"path", path,
+				"status", resp.StatusCode,
+				"name", e.Name,
+				"message", e.Message)
+			return payPalErr(resp.StatusCode, e)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			p.log.Errorf("failed to decode paypal response, error: %v", err)
+			return fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+		}
+		return nil
+	}
+}
+
+// token returns cached access token of the client or requests a new one
+func (p *PayPal) token(ctx context.Context, clientID, secret string) (string, error) {
+	p.mu.Lock()
+	t, ok := p.tokens[clientID]
+	if !ok {
+		t = &payPalToken{}
+		p.tokens[clientID] = t
+	}
+	p.mu.Unlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.value != "" && p.now().Add(payPalTokenLeeway).Before(t.expiresAt) {
+		return t.value, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cnf.BaseUrl+"/v1/oauth2/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(clientID, secret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.log.Errorf("failed to reach paypal, error: %v", err)
+		return "", fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e payPalError
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		p.log.Errorw("paypal failed to issue access token",
+			"status", resp.StatusCode,
+			"error", e.Error,
+			"description", e.ErrorDescription)
+		return "", payPalErr(resp.StatusCode, e)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.AccessToken == "" {
+		p.log.Errorf("failed to decode paypal access token, error: %v", err)
+		return "", fmt.Errorf("%w: malformed access token", ErrProviderUnavailable)
+	}
+	t.value = body.AccessToken
+	t.expiresAt = p.now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	return t.value, nil
+}
+
+// invalidate drops the token unless it was already refreshed by a concurrent call
+func (p *PayPal) invalidate(clientID, token string) {
+	p.mu.Lock()
+	t := p.tokens[clientID]
+	p.mu.Unlock()
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.value == token {
+		t.value = ""
+	}
+}
+
+// payPalErr maps PayPal error to the domain error
+func payPalErr(status int, e payPalError) error {
+	issue := ""
+	if len(e.Details) > 0 {
+		issue = e.Details[0].Issue
+	}
+	var err error
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden || e.Error == "invalid_client":
+		err = ErrProviderAuth
+	case issue == "INSTRUMENT_DECLINED" || issue == "PAYER_ACTION_REQUIRED" || issue == "PAYER_CANNOT_PAY":
+		err = ErrProviderDeclined
+	case status == http.StatusTooManyRequests:
+		err = ErrProviderRateLimited
+	case status >= http.StatusInternalServerError:
+		err = ErrProviderUnavailable
+	default:
+		err = ErrProviderRequest
+	}
+	message := e.Message
+	if issue != "" {
+		message = issue + " " + message
+	}
+	if message == "" {
+		message = e.ErrorDescription
+	}
+	return fmt.Errorf("%w: %v", err, message)
+}
+
+func withQuery(link, key, value string) string {
+	sep := "?"
+	if strings.Contains(link, "?") {
+		sep = "&"
+	}
+	return link + sep + url.QueryEscape(key) + "=" + url.QueryEscape(value)
+}
@@ -0,0 +1,154 @@
+package payment
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"payment-api/internal/integrations/payment/simulator"
+	"payment-api/internal/models"
+)
+
+// approve follows the approve link as the payer would and returns the redirect target
+func approve(t *testing.T, link string) *url.URL {
+	client := http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(link)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	assert.NoError(t, err)
+	return location
+}
+
+func TestPayPalCheckout(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	sim := simulator.NewServer(mockLogger, simulator.WithApiKey("client"))
+	var tokenRequests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/oauth2/token" {
+			atomic.AddInt32(&tokenRequests, 1)
+		}
+		sim.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	payPal := NewPayPal(mockLogger, PayPalConfig{
+		BaseUrl:   srv.URL,
+		ReturnUrl: "https://pay.test/api/v1/payment/paypal/return",
+		CancelUrl: "https://merchant.test/cancel",
+		Items:     []LineItem{{Name: "Premium", Amount: 1205, Currency: "usd", Quantity: 1}},
+		Timeout:   200 * time.Millisecond,
+	})
+	provider := NewPaymentProvider(mockLogger, "../../../assets/providers.json", WithPayPal(payPal))
+	checkout := Checkout{
+		ReferenceID: "session",
+		Metadata:    map[string]string{"provider_id": "provider"},
+		Customer:    &Customer{ReferenceID: "customer", Email: "reader@headway.test", Country: "PL"},
+	}
+
+	t.Run("success create and capture", func(t *testing.T) {
+		session, err := provider.PaymentUrl(context.Background(), models.ProviderNamePayPal, "client", "secret", checkout)
+		assert.NoError(t, err)
+		assert.Equal(t, srv.URL+"/checkoutnow?token="+session.ID, session.Url)
+
+		// capture is possible only after approval
+		err = provider.Capture(context.Background(), models.ProviderNamePayPal, "client", "secret", session.ID)
+		assert.ErrorIs(t, err, ErrProviderRequest)
+
+		location := approve(t, session.Url)
+		assert.Equal(t, "session", location.Query().Get("session_id"))
+		assert.Equal(t, session.ID, location.Query().Get("token"))
+		assert.NoError(t, provider.Capture(context.Background(), models.ProviderNamePayPal, "client", "secret", session.ID))
+
+		captured := sim.Events()[0].Data.Object.(simulator.Order)
+		assert.Equal(t, "12.05", captured.PurchaseUnits[0].Amount.Value)
+		assert.Equal(t, "USD", captured.PurchaseUnits[0].Amount.CurrencyCode)
+		assert.Equal(t, "session", captured.PurchaseUnits[0].ReferenceID)
+		assert.Equal(t, "session", captured.PurchaseUnits[0].InvoiceID)
+		assert.Equal(t, "provider", captured.PurchaseUnits[0].CustomID)
+		assert.Equal(t, "reader@headway.test", captured.Payer.EmailAddress)
+		assert.Equal(t, "PL", captured.Payer.Address.CountryCode)
+		// PayPal has no customers of the merchant
+		assert.Empty(t, session.CustomerID)
+	})
+
+	t.Run("token is cached across concurrent calls", func(t *testing.T) {
+		atomic.StoreInt32(&tokenRequests, 0)
+		payPal := NewPayPal(mockLogger, PayPalConfig{BaseUrl: srv.URL, Items: []LineItem{{Amount: 100, Currency: "usd"}}})
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := payPal.CreateCheckout(context.Background(), "client", "secret", checkout)
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), atomic.LoadInt32(&tokenRequests))
+
+		// token rejected by the provider is refreshed and the call is retried
+		sim.ExpireTokens()
+		_, err := payPal.CreateCheckout(context.Background(), "client", "secret", checkout)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&tokenRequests))
+
+		// token about to expire is refreshed upfront
+		payPal.now = func() time.Time { return time.Now().Add(9 * time.Hour) }
+		_, err = payPal.CreateCheckout(context.Background(), "client", "secret", checkout)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&tokenRequests))
+	})
+
+	t.Run("fail declined capture", func(t *testing.T) {
+		session, err := payPal.CreateCheckout(context.Background(), "client", "secret", checkout)
+		assert.NoError(t, err)
+		approve(t, session.Url)
+		sim.Script(simulator.RouteCaptureOrder, simulator.Scenario{Decline: true})
+		err = payPal.Capture(context.Background(), "client", "secret", session.ID)
+		assert.ErrorIs(t, err, ErrProviderDeclined)
+	})
+
+	t.Run("fail subscription", func(t *testing.T) {
+		subscription := checkout
+		subscription.Subscription = &Subscription{Name: "Premium monthly", Interval: models.IntervalMonth, TrialDays: 7}
+		session, err := payPal.CreateCheckout(context.Background(), "client", "secret", subscription)
+		assert.ErrorIs(t, err, ErrNotSupported)
+		assert.Nil(t, session)
+	})
+
+	type testCase struct {
+		name        string
+		clientID    string
+		route       simulator.Route
+		scenario    simulator.Scenario
+		expectedErr error
+	}
+	testCases := []testCase{
+		{"fail wrong client", "another", "", simulator.Scenario{}, ErrProviderAuth},
+		{"fail token outage", "client", simulator.RouteToken, simulator.Scenario{Status: http.StatusServiceUnavailable}, ErrProviderUnavailable},
+		{"fail rate limit", "client", simulator.RouteCreateOrder, simulator.Scenario{Status: http.StatusTooManyRequests}, ErrProviderRateLimited},
+		{"fail timeout", "client", simulator.RouteCreateOrder, simulator.Scenario{Timeout: true}, ErrProviderUnavailable},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sim.Reset()
+			sim.ExpireTokens()
+			payPal := NewPayPal(mockLogger, PayPalConfig{BaseUrl: srv.URL, Items: []LineItem{{Amount: 100, Currency: "usd"}}, Timeout: 200 * time.Millisecond})
+			sim.Script(tc.route, tc.scenario)
+			session, err := payPal.CreateCheckout(context.Background(), tc.clientID, "secret", checkout)
+			assert.ErrorIs(t, err, tc.expectedErr)
+			assert.Nil(t, session)
+		})
+	}
+}
@@ -1,17 +1,30 @@
 package payment
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"os"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 
 	"payment-api/internal/models"
+	"payment-api/internal/money"
 )
 
 var (
 	ErrUnknownProviderID = errors.New("unknown provider name")
+	ErrCaptureNotNeeded  = errors.New("provider captures payments on its own")
+	ErrNotSupported      = errors.New("provider adapter does not support the checkout")
+	ErrSignatureInvalid  = errors.New("webhook signature is invalid")
+	// errors of the real provider adapters
+	ErrProviderAuth        = errors.New("provider rejected credentials")
+	ErrProviderDeclined    = errors.New("provider declined the payment")
+	ErrProviderRequest     = errors.New("provider rejected the request")
+	ErrProviderRateLimited = errors.New("provider rate limit is exceeded")
+	ErrProviderUnavailable = errors.New("provider is unavailable")
 )
 
 type configProviders struct {
@@ -21,41 +34,233 @@ type configProviders struct {
 	PayPal    string `json:"pay_pal"`
 }
 
+// Checkout describes the purchase the payment url is requested for
+type Checkout struct {
+	// ReferenceID ties checkout on the provider side to our payment session
+	ReferenceID string
+	Metadata    map[string]string
+	// Customer is the paying user, nil for anonymous checkouts
+	Customer *Customer
+	// Price replaces the configured line items with a single one, set when the price is localized
+	Price *money.Money
+	// Subscription makes the checkout recurring, nil for one-time payments
+	Subscription *Subscription
+	// Challenge asks the provider to verify the payer, e.g. with 3-D Secure, it is set for risky payers
+	Challenge bool
+}
+
+// Subscription describes recurring terms of the checkout, Price of the checkout is charged every period
+type Subscription struct {
+	// Name of the plan, it replaces names of the configured line items
+	Name     string
+	Interval string
+	// TrialDays of free access before the first charge
+	TrialDays int
+	// IntroPrice is charged for the first IntroPeriods periods instead of the checkout price
+	IntroPrice   *money.Money
+	IntroPeriods int
+}
+
+// Customer identifies the paying user, so returning users get their saved payment methods
+type Customer struct {
+	// ID of the customer on the provider side, empty until the provider creates one
+	ID string
+	// ReferenceID ties customer on the provider side to our customer
+	ReferenceID string
+	Email       string
+	Country     string
+}
+
+// checkoutItems returns line items of the checkout, the localized price is charged
+// for a single item named after the configured ones or after the plan
+func checkoutItems(configured []LineItem, checkout Checkout) []LineItem {
+	if checkout.Price == nil {
+		return configured
+	}
+	names := make([]string, 0, len(configured))
+	for _, item := range configured {
+		names = append(names, item.Name)
+	}
+	name := strings.Join(names, ", ")
+	if checkout.Subscription != nil && checkout.Subscription.Name != "" {
+		name = checkout.Subscription.Name
+	}
+	return []LineItem{{Name: name, Amount: checkout.Price.Amount, Currency: checkout.Price.Currency, Quantity: 1}}
+}
+
+// CheckoutSession is a checkout created on the provider side
+type CheckoutSession struct {
+	// ID of the checkout on the provider side, empty for mocked providers
+	ID  string
+	Url string
+	// CustomerID is id of the customer on the provider side the checkout belongs to,
+	// empty when the provider has no notion of customers
+	CustomerID string
+}
+
+// Dispute is the dispute of a payment reported by the provider webhook
+type Dispute struct {
+	// ID of the dispute on the provider side
+	ID string
+	// SessionID is our payment session the disputed payment was made with
+	SessionID string
+	Reason    string
+	// Status is one of models.Dispute* statuses
+	Status        string
+	Amount        int64
+	Currency      string
+	EvidenceDueBy *time.Time
+}
+
+// Completion is the checkout paid on the provider side reported by the provider webhook
+type Completion struct {
+	// CheckoutID is id of the checkout on the provider side
+	CheckoutID string
+	// SessionID is our payment session the checkout was created for
+	SessionID string
+	// PaymentID is id of the payment on the provider side, e.g. payment intent, empty when nothing is charged
+	PaymentID string
+}
+
+// Renewal is the paid renewal of a subscription reported by the provider webhook,
+// the first charge after the trial is a renewal as well
+type Renewal struct {
+	// InvoiceID is id of the paid invoice on the provider side
+	InvoiceID string
+	// SessionID is our payment session the subscription was started with
+	SessionID   string
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+	// Amount is charged with the invoice in minor units of the currency, taxes included.
+	// TaxAmount is the part of the amount the provider has charged as taxes
+	Amount    int64
+	TaxAmount int64
+	Currency  string
+}
+
+// Refund is the refund of a payment reported by the provider webhook
+type Refund struct {
+	// ID of the refund on the provider side
+	ID string
+	// SessionID is our payment session the refunded payment was made with
+	SessionID string
+	// Amount is refunded in minor units of the currency
+	Amount   int64
+	Currency string
+}
+
 type PaymentProvider struct {
 	log      *zap.SugaredLogger
 	filePath string
+	stripe   *Stripe
+	payPal   *PayPal
+}
+
+// Option configures real adapters of the PaymentProvider, providers without
+// an adapter are mocked with the providers file
+type Option func(p *PaymentProvider)
+
+// WithStripe makes Stripe checkouts go through the real adapter
+func WithStripe(s *Stripe) Option {
+	return func(p *PaymentProvider) {
+		p.stripe = s
+	}
+}
+
+// WithPayPal makes PayPal checkouts go through the real adapter
+func WithPayPal(pp *PayPal) Option {
+	return func(p *PaymentProvider) {
+		p.payPal = pp
+	}
 }
 
-func NewPaymentProvider(log *zap.SugaredLogger, filePath string) *PaymentProvider {
-	return &PaymentProvider{log: log, filePath: filePath}
+func NewPaymentProvider(log *zap.SugaredLogger, filePath string, opts ...Option) *PaymentProvider {
+	p := &PaymentProvider{log: log, filePath: filePath}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
-// PaymentUrl mocks process of generating link for the provider which name was passed method
-// since it is a mock which is coupled to business logic, thus is tested within it
-func (p *PaymentProvider) PaymentUrl(name, apiKey, secret string) (string, error) {
+// PaymentUrl creates checkout for the provider which name was passed to the method,
+// providers without a real adapter are mocked with the providers file,
+// since the mock is coupled to business logic, it is tested within it
+func (p *PaymentProvider) PaymentUrl(ctx context.Context, name, apiKey, secret string, checkout Checkout) (*CheckoutSession, error) {
+	switch {
+	case name == models.ProviderNameStripe && p.stripe != nil:
+		return p.stripe.CreateCheckout(ctx, apiKey, checkout)
+	case name == models.ProviderNamePayPal && p.payPal != nil:
+		return p.payPal.CreateCheckout(ctx, apiKey, secret, checkout)
+	}
+
 	raw, err := os.ReadFile(p.filePath)
 	if err != nil {
 		p.log.Errorf("failed to read the file, error: %v", err)
-		return "", err
+		return nil, err
 	}
 
 	var cnf configProviders
 	if err := json.Unmarshal(raw, &cnf); err != nil {
 		p.log.Errorf("failed to unmarshall providers config file, error: %v", err)
-		return "", err
+		return nil, err
 	}
 
 	p.log.Infof("paymentProvider: generating a link for: %v", name)
 	switch name {
 	case models.ProviderNameApplePay:
-		return cnf.ApplePay, nil
+		return &CheckoutSession{Url: cnf.ApplePay}, nil
 	case models.ProviderNameGooglePay:
-		return cnf.GooglePay, nil
+		return &CheckoutSession{Url: cnf.GooglePay}, nil
 	case models.ProviderNamePayPal:
-		return cnf.PayPal, nil
+		return &CheckoutSession{Url: cnf.PayPal}, nil
 	case models.ProviderNameStripe:
-		return cnf.StripPay, nil
+		return &CheckoutSession{Url: cnf.StripPay}, nil
 	default:
-		return "", ErrUnknownProviderID
+		return nil, ErrUnknownProviderID
+	}
+}
+
+// Dispute verifies the webhook event signed with the secret and returns the dispute it reports,
+// nil is returned for events of other kinds. Only providers with a real adapter send webhooks
+func (p *PaymentProvider) Dispute(ctx context.Context, name, apiKey, secret string, payload []byte, signature string) (*Dispute, error) {
+	if name == models.ProviderNameStripe && p.stripe != nil {
+		return p.stripe.Dispute(ctx, apiKey, secret, payload, signature)
+	}
+	return nil, ErrNotSupported
+}
+
+// Completion verifies the webhook event signed with the secret and returns the checkout it reports paid,
+// nil is returned for events of other kinds. Only providers with a real adapter send webhooks
+func (p *PaymentProvider) Completion(ctx context.Context, name, secret string, payload []byte, signature string) (*Completion, error) {
+	if name == models.ProviderNameStripe && p.stripe != nil {
+		return p.stripe.Completion(secret, payload, signature)
+	}
+	return nil, ErrNotSupported
+}
+
+// Renewal verifies the webhook event signed with the secret and returns the paid renewal it reports,
+// nil is returned for events of other kinds. Only providers with a real adapter send webhooks
+func (p *PaymentProvider) Renewal(ctx context.Context, name, secret string, payload []byte, signature string) (*Renewal, error) {
+	if name == models.ProviderNameStripe && p.stripe != nil {
+		return p.stripe.Renewal(secret, payload, signature)
+	}
+	return nil, ErrNotSupported
+}
+
+// Refund verifies the webhook event signed with the secret and returns the succeeded refund it reports,
+// nil is returned for events of other kinds. Only providers with a real adapter send webhooks
+func (p *PaymentProvider) Refund(ctx context.Context, name, apiKey, secret string, payload []byte, signature string) (*Refund, error) {
+	if name == models.ProviderNameStripe && p.stripe != nil {
+		return p.stripe.Refund(ctx, apiKey, secret, payload, signature)
+	}
+	return nil, ErrNotSupported
+}
+
+// Capture completes payment of the checkout approved by the customer,
+// only providers which require explicit capture support it
+func (p *PaymentProvider) Capture(ctx context.Context, name, apiKey, secret, checkoutID string) error {
+	if name == models.ProviderNamePayPal && p.payPal != nil {
+		return p.payPal.Capture(ctx, apiKey, secret, checkoutID)
 	}
+	return ErrCaptureNotNeeded
 }
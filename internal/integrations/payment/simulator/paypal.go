@@ -0,0 +1,258 @@
+package simulator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Order is an order of the simulated PayPal Orders API
+type Order struct {
+	ID            string         `json:"id"`
+	Intent        string         `json:"intent"`
+	Status        string         `json:"status"`
+	PurchaseUnits []PurchaseUnit `json:"purchase_units"`
+	Payer         *Payer         `json:"payer,omitempty"`
+	Links         []Link         `json:"links"`
+	returnUrl     string
+	cancelUrl     string
+}
+
+// PurchaseUnit is what the payer pays for within the order
+type PurchaseUnit struct {
+	ReferenceID string `json:"reference_id,omitempty"`
+	CustomID    string `json:"custom_id,omitempty"`
+	InvoiceID   string `json:"invoice_id,omitempty"`
+	Description string `json:"description,omitempty"`
+	Amount      struct {
+		CurrencyCode string `json:"currency_code"`
+		Value        string `json:"value"`
+	} `json:"amount"`
+}
+
+// Payer is prefilled on the approval page
+type Payer struct {
+	EmailAddress string `json:"email_address,omitempty"`
+	Address      *struct {
+		CountryCode string `json:"country_code"`
+	} `json:"address,omitempty"`
+}
+
+// Link is a HATEOAS link of the order
+type Link struct {
+	Href   string `json:"href"`
+	Rel    string `json:"rel"`
+	Method string `json:"method"`
+}
+
+// ExpireTokens invalidates every issued access token, as if they expired on the provider side
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = map[string]time.Time{}
+}
+
+// issueToken performs client credentials grant, the client id must match
+// configured api key if there is one
+func (s *Server) issueToken(w http.ResponseWriter, r *http.Request) {
+	sc := s.script.next(RouteToken)
+	if !sc.play(w, r) {
+		return
+	}
+	clientID, secret, ok := r.BasicAuth()
+	if !ok || clientID == "" || secret == "" || (s.apiKey != "" && clientID != s.apiKey) {
+		writeJson(w, http.StatusUnauthorized, map[string]string{
+			"error":             "invalid_client",
+			"error_description": "Client Authentication failed",
+		})
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" {
+		writeJson(w, http.StatusBadRequest, map[string]string{
+			"error":             "unsupported_grant_type",
+			"error_description": "Grant Type is NULL or unsupported",
+		})
+		return
+	}
+
+	token := "A21sim" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	s.mu.Lock()
+	s.tokens[token] = time.Now().Add(s.tokenTTL)
+	s.mu.Unlock()
+	writeJson(w, http.StatusOK, map[string]any{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"app_id":       "APP-SIMULATOR",
+		"expires_in":   int(s.tokenTTL.Seconds()),
+	})
+}
+
+// bearer checks the access token and plays scripted scenario before the handler
+func (s *Server) bearer(route Route, next func(w http.ResponseWriter, r *http.Request, sc Scenario)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.mu.Lock()
+		expiresAt, ok := s.tokens[token]
+		s.mu.Unlock()
+		if !ok || !time.Now().Before(expiresAt) {
+			writePayPalError(w, http.StatusUnauthorized, "AUTHENTICATION_FAILURE", "", "Authentication failed due to invalid authentication credentials")
+			return
+		}
+		sc := s.script.next(route)
+		if !sc.play(w, r) {
+			return
+		}
+		next(w, r, sc)
+	}
+}
+
+func (s *Server) createOrder(w http.ResponseWriter, r *http.Request, sc Scenario) {
+	if sc.Decline {
+		writePayPalError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "PAYEE_ACCOUNT_RESTRICTED", "The requested action could not be performed")
+		return
+	}
+	var req struct {
+		Intent             string         `json:"intent"`
+		PurchaseUnits      []PurchaseUnit `json:"purchase_units"`
+		Payer              *Payer         `json:"payer"`
+		ApplicationContext struct {
+			ReturnUrl string `json:"return_url"`
+			CancelUrl string `json:"cancel_url"`
+		} `json:"application_context"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writePayPalError(w, http.StatusBadRequest, "INVALID_REQUEST", "MALFORMED_REQUEST_JSON", "Request is not well-formed")
+		return
+	}
+	if req.Intent != "CAPTURE" && req.Intent != "AUTHORIZE" {
+		writePayPalError(w, http.StatusBadRequest, "INVALID_REQUEST", "INVALID_PARAMETER_VALUE", "Invalid intent")
+		return
+	}
+	if len(req.PurchaseUnits) == 0 || req.PurchaseUnits[0].Amount.Value == "" || req.PurchaseUnits[0].Amount.CurrencyCode == "" {
+		writePayPalError(w, http.StatusBadRequest, "INVALID_REQUEST", "MISSING_REQUIRED_PARAMETER", "Amount of the purchase unit is missing")
+		return
+	}
+
+	id := strings.ToUpper(strings.ReplaceAll(uuid.NewString(), "-", "")[:17])
+	base := baseUrl(r)
+	order := &Order{
+		ID:            id,
+		Intent:        req.Intent,
+		Status:        "CREATED",
+		PurchaseUnits: req.PurchaseUnits,
+		Payer:         req.Payer,
+		Links: []Link{
+			{Href: base + "/v2/checkout/orders/" + id, Rel: "self", Method: http.MethodGet},
+			{Href: base + "/checkoutnow?token=" + id, Rel: "approve", Method: http.MethodGet},
+			{Href: base + "/v2/checkout/orders/" + id + "/capture", Rel: "capture", Method: http.MethodPost},
+		},
+		returnUrl: req.ApplicationContext.ReturnUrl,
+		cancelUrl: req.ApplicationContext.CancelUrl,
+	}
+	s.mu.Lock()
+	s.orders[id] = order
+	resp := *order
+	s.mu.Unlock()
+	writeJson(w, http.StatusCreated, resp)
+}
+
+func (s *Server) getOrder(w http.ResponseWriter, r *http.Request, sc Scenario) {
+	id := strings.TrimPrefix(strings.TrimRight(r.URL.Path, "/"), "/v2/checkout/orders/")
+	s.mu.Lock()
+	order, ok := s.orders[id]
+	var resp Order
+	if ok {
+		resp = *order
+	}
+	s.mu.Unlock()
+	if !ok {
+		writePayPalError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID", "Specified resource ID does not exist")
+		return
+	}
+	writeJson(w, http.StatusOK, resp)
+}
+
+// approveOrder emulates the payer on the PayPal approval page, the payer is
+// redirected to return_url right away, outcome=cancel query parameter or
+// declined scenario send the payer to cancel_url instead
+func (s *Server) approveOrder(w http.ResponseWriter, r *http.Request) {
+	sc := s.script.next(RouteApproveOrder)
+	if !sc.play(w, r) {
+		return
+	}
+	id := r.URL.Query().Get("token")
+	s.mu.Lock()
+	order, ok := s.orders[id]
+	if !ok {
+		s.mu.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	if order.Status != "CREATED" {
+		s.mu.Unlock()
+		http.Error(w, "Order is already approved", http.StatusGone)
+		return
+	}
+	q := url.Values{"token": {id}}
+	redirect := order.cancelUrl
+	if r.URL.Query().Get("outcome") != "cancel" && !sc.Decline {
+		order.Status = "APPROVED"
+		redirect = order.returnUrl
+		q.Set("PayerID", "SIMULATORPAYER")
+	}
+	s.mu.Unlock()
+
+	if redirect == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	sep := "?"
+	if strings.Contains(redirect, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, redirect+sep+q.Encode(), http.StatusFound)
+}
+
+func (s *Server) captureOrder(w http.ResponseWriter, r *http.Request, sc Scenario) {
+	id := strings.TrimSuffix(strings.TrimPrefix(strings.TrimRight(r.URL.Path, "/"), "/v2/checkout/orders/"), "/capture")
+	s.mu.Lock()
+	order, ok := s.orders[id]
+	if !ok {
+		s.mu.Unlock()
+		writePayPalError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID", "Specified resource ID does not exist")
+		return
+	}
+	switch {
+	case order.Status == "COMPLETED":
+		s.mu.Unlock()
+		writePayPalError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "ORDER_ALREADY_CAPTURED", "Order already captured")
+		return
+	case order.Status != "APPROVED":
+		s.mu.Unlock()
+		writePayPalError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "ORDER_NOT_APPROVED", "Payer has not yet approved the Order for payment")
+		return
+	case sc.Decline:
+		s.mu.Unlock()
+		writePayPalError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "INSTRUMENT_DECLINED", "The instrument presented was declined")
+		return
+	}
+	order.Status = "COMPLETED"
+	resp := *order
+	s.mu.Unlock()
+
+	s.emit(*newEvent("PAYMENT.CAPTURE.COMPLETED", resp))
+	writeJson(w, http.StatusCreated, resp)
+}
+
+// writePayPalError answers in the shape of PayPal errors
+func writePayPalError(w http.ResponseWriter, status int, name, issue, message string) {
+	body := map[string]any{"name": name, "message": message}
+	if issue != "" {
+		body["details"] = []map[string]string{{"issue": issue}}
+	}
+	writeJson(w, status, body)
+}
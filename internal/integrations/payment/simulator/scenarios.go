@@ -0,0 +1,103 @@
+package simulator
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Route names an operation of the simulated provider, scenarios are scripted per route
+type Route string
+
+const (
+	RouteCreateSession  Route = "create_session"
+	RouteGetSession     Route = "get_session"
+	RouteCheckout       Route = "checkout"
+	RouteRefund         Route = "refund"
+	RouteCreateCustomer Route = "create_customer"
+	RouteCreateCoupon   Route = "create_coupon"
+	// PayPal routes
+	RouteToken        Route = "token"
+	RouteCreateOrder  Route = "create_order"
+	RouteApproveOrder Route = "approve_order"
+	RouteCaptureOrder Route = "capture_order"
+)
+
+// Scenario describes how the simulator misbehaves while answering a single request
+type Scenario struct {
+	// Latency delays the response
+	Latency time.Duration
+	// Status answers with provider error of the given status, e.g. 429, 500 or 503
+	Status int
+	// Timeout never answers, the request hangs until the client gives up
+	Timeout bool
+	// Decline rejects the card, checkout is failed and other routes answer with card_error
+	Decline bool
+}
+
+// script keeps queues of scenarios, every request consumes the next scenario of its route
+type script struct {
+	mu     sync.Mutex
+	queues map[Route][]Scenario
+}
+
+func newScript() *script {
+	return &script{queues: map[Route][]Scenario{}}
+}
+
+func (s *script) push(route Route, scenarios ...Scenario) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queues[route] = append(s.queues[route], scenarios...)
+}
+
+// next returns upcoming scenario of the route, zero scenario means normal behavior
+func (s *script) next(route Route) Scenario {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := s.queues[route]
+	if len(q) == 0 {
+		return Scenario{}
+	}
+	s.queues[route] = q[1:]
+	return q[0]
+}
+
+func (s *script) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queues = map[Route][]Scenario{}
+}
+
+// play applies latency, timeout and failure status of the scenario,
+// returns false when the response is already written or must never be written
+func (sc Scenario) play(w http.ResponseWriter, r *http.Request) bool {
+	if sc.Timeout || sc.Latency > 0 {
+		// request context is canceled on disconnect only after the body is consumed,
+		// thus it is buffered for the handler
+		raw, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(raw))
+	}
+	if sc.Timeout {
+		<-r.Context().Done()
+		return false
+	}
+	if sc.Latency > 0 {
+		select {
+		case <-time.After(sc.Latency):
+		case <-r.Context().Done():
+			return false
+		}
+	}
+	if sc.Status == http.StatusTooManyRequests {
+		writeError(w, sc.Status, errorTypeRateLimit, "rate_limit", "Too many requests hit the API too quickly")
+		return false
+	}
+	if sc.Status >= http.StatusBadRequest {
+		writeError(w, sc.Status, errorTypeApi, "", "Simulated provider failure")
+		return false
+	}
+	return true
+}
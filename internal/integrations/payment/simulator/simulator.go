@@ -0,0 +1,904 @@
+package simulator
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	errorTypeApi            = "api_error"
+	errorTypeAuthentication = "authentication_error"
+	errorTypeCard           = "card_error"
+	errorTypeInvalidRequest = "invalid_request_error"
+	errorTypeRateLimit      = "rate_limit_error"
+
+	// SignatureHeader carries signature of the webhook payload in the form of t=<unix>,v1=<hex hmac>
+	SignatureHeader = "Stripe-Signature"
+)
+
+// Session is a checkout session of the simulated provider
+type Session struct {
+	ID                string            `json:"id"`
+	Object            string            `json:"object"`
+	Mode              string            `json:"mode"`
+	Url               string            `json:"url"`
+	Status            string            `json:"status"`
+	PaymentStatus     string            `json:"payment_status"`
+	PaymentIntent     string            `json:"payment_intent,omitempty"`
+	AmountTotal       int64             `json:"amount_total"`
+	AmountRefunded    int64             `json:"amount_refunded"`
+	Currency          string            `json:"currency"`
+	SuccessUrl        string            `json:"success_url"`
+	CancelUrl         string            `json:"cancel_url"`
+	ClientReferenceID string            `json:"client_reference_id,omitempty"`
+	Customer          string            `json:"customer,omitempty"`
+	Metadata          map[string]string `json:"metadata"`
+	// Subscription is created once subscription mode session is paid
+	Subscription string `json:"subscription,omitempty"`
+	// TrialPeriodDays of subscription mode sessions, nothing is paid for the trial
+	TrialPeriodDays int `json:"trial_period_days,omitempty"`
+	// PaymentMethodOptions are set when the checkout asks for 3-D Secure
+	PaymentMethodOptions *PaymentMethodOptions `json:"payment_method_options,omitempty"`
+
+	// terms of the subscription the subscription mode session starts
+	interval             string
+	recurringAmount      int64
+	subscriptionMetadata map[string]string
+}
+
+// subscription is started by the paid subscription mode session and renewed by RenewSubscription
+type subscription struct {
+	amount    int64
+	currency  string
+	interval  string
+	periodEnd time.Time
+	metadata  map[string]string
+}
+
+// PaymentMethodOptions configure the payment methods of the checkout session
+type PaymentMethodOptions struct {
+	Card struct {
+		RequestThreeDSecure string `json:"request_three_d_secure"`
+	} `json:"card"`
+}
+
+// Customer is a customer of the simulated provider
+type Customer struct {
+	ID       string            `json:"id"`
+	Object   string            `json:"object"`
+	Email    string            `json:"email,omitempty"`
+	Country  string            `json:"country,omitempty"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// Coupon discounts the first periods of subscriptions
+type Coupon struct {
+	ID               string `json:"id"`
+	Object           string `json:"object"`
+	AmountOff        int64  `json:"amount_off"`
+	Currency         string `json:"currency"`
+	Duration         string `json:"duration"`
+	DurationInMonths int    `json:"duration_in_months,omitempty"`
+}
+
+// Refund is a refund of the paid checkout session
+type Refund struct {
+	ID            string `json:"id"`
+	Object        string `json:"object"`
+	Amount        int64  `json:"amount"`
+	Currency      string `json:"currency"`
+	PaymentIntent string `json:"payment_intent"`
+	Status        string `json:"status"`
+}
+
+// Dispute is a dispute of the paid checkout session opened by the card issuer
+type Dispute struct {
+	ID              string `json:"id"`
+	Object          string `json:"object"`
+	Amount          int64  `json:"amount"`
+	Currency        string `json:"currency"`
+	PaymentIntent   string `json:"payment_intent"`
+	Reason          string `json:"reason"`
+	Status          string `json:"status"`
+	EvidenceDetails struct {
+		DueBy int64 `json:"due_by"`
+	} `json:"evidence_details"`
+	Created int64 `json:"created"`
+}
+
+// Invoice is a paid invoice of the subscription renewal
+type Invoice struct {
+	ID                  string `json:"id"`
+	Object              string `json:"object"`
+	Subscription        string `json:"subscription"`
+	BillingReason       string `json:"billing_reason"`
+	Status              string `json:"status"`
+	AmountPaid          int64  `json:"amount_paid"`
+	Currency            string `json:"currency"`
+	Tax                 int64  `json:"tax"`
+	SubscriptionDetails struct {
+		Metadata map[string]string `json:"metadata"`
+	} `json:"subscription_details"`
+	Lines struct {
+		Data []InvoiceLine `json:"data"`
+	} `json:"lines"`
+}
+
+// InvoiceLine is a line of the invoice, it covers the billing period
+type InvoiceLine struct {
+	Amount int64 `json:"amount"`
+	Period struct {
+		Start int64 `json:"start"`
+		End   int64 `json:"end"`
+	} `json:"period"`
+}
+
+// Event is a webhook callback sent by the simulator
+type Event struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object any `json:"object"`
+	} `json:"data"`
+}
+
+// Server emulates Stripe-style provider API: checkout creation in payment and subscription modes,
+// coupons, status polling, refunds, disputes and webhook callbacks, together with PayPal-style Orders API.
+// It is an http.Handler, so it can be run as a standalone server or embedded
+// into tests via httptest
+type Server struct {
+	log           *zap.SugaredLogger
+	apiKey        string
+	webhookUrl    string
+	webhookSecret string
+	tokenTTL      time.Duration
+	client        *http.Client
+	script        *script
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	// customers are kept by id, idempotency keys map to the customer they created
+	customers   map[string]*Customer
+	idempotency map[string]string
+	coupons     map[string]*Coupon
+	// intents maps payment intent to the session it pays for
+	intents       map[string]string
+	disputes      map[string]*Dispute
+	subscriptions map[string]*subscription
+	events        []Event
+	orders        map[string]*Order
+	// tokens maps issued access token to its expiry
+	tokens map[string]time.Time
+}
+
+// Option configures optional parts of the Server
+type Option func(s *Server)
+
+// WithApiKey makes the simulator accept only the provided secret key,
+// otherwise any non-empty key is accepted
+func WithApiKey(key string) Option {
+	return func(s *Server) {
+		s.apiKey = key
+	}
+}
+
+// WithTokenTTL sets lifetime of the issued OAuth access tokens
+func WithTokenTTL(ttl time.Duration) Option {
+	return func(s *Server) {
+		s.tokenTTL = ttl
+	}
+}
+
+// WithWebhook sets url the events are delivered to and secret they are signed with
+func WithWebhook(url, secret string) Option {
+	return func(s *Server) {
+		s.webhookUrl = url
+		s.webhookSecret = secret
+	}
+}
+
+func NewServer(log *zap.SugaredLogger, opts ...Option) *Server {
+	s := &Server{
+		log:           log,
+		tokenTTL:      9 * time.Hour,
+		client:        &http.Client{Timeout: 5 * time.Second},
+		script:        newScript(),
+		sessions:      map[string]*Session{},
+		customers:     map[string]*Customer{},
+		idempotency:   map[string]string{},
+		coupons:       map[string]*Coupon{},
+		intents:       map[string]string{},
+		disputes:      map[string]*Dispute{},
+		subscriptions: map[string]*subscription{},
+		orders:        map[string]*Order{},
+		tokens:        map[string]time.Time{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Script queues scenarios for the route, each request to the route consumes one of them
+func (s *Server) Script(route Route, scenarios ...Scenario) {
+	s.script.push(route, scenarios...)
+}
+
+// Reset drops every scripted scenario
+func (s *Server) Reset() {
+	s.script.reset()
+}
+
+// DeleteCustomer removes the customer, as if it was deleted on the provider side
+func (s *Server) DeleteCustomer(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.customers, id)
+}
+
+// Customers returns every customer created so far
+func (s *Server) Customers() []Customer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	customers := make([]Customer, 0, len(s.customers))
+	for _, c := range s.customers {
+		customers = append(customers, *c)
+	}
+	return customers
+}
+
+// Coupons returns every coupon created so far
+func (s *Server) Coupons() []Coupon {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	coupons := make([]Coupon, 0, len(s.coupons))
+	for _, c := range s.coupons {
+		coupons = append(coupons, *c)
+	}
+	return coupons
+}
+
+// OpenDispute disputes the payment of the intent as the card issuer would, the evidence is due in a week
+func (s *Server) OpenDispute(paymentIntent, reason string) (*Dispute, error) {
+	s.mu.Lock()
+	session, ok := s.sessions[s.intents[paymentIntent]]
+	if !ok {
+		s.mu.Unlock()
+		return nil, errors.New("no such payment_intent: " + paymentIntent)
+	}
+	now := time.Now()
+	d := &Dispute{
+		ID:            "dp_sim_" + strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Object:        "dispute",
+		Amount:        session.AmountTotal - session.AmountRefunded,
+		Currency:      session.Currency,
+		PaymentIntent: paymentIntent,
+		Reason:        reason,
+		Status:        "needs_response",
+		Created:       now.Unix(),
+	}
+	d.EvidenceDetails.DueBy = now.Add(7 * 24 * time.Hour).Unix()
+	s.disputes[d.ID] = d
+	resp := *d
+	s.mu.Unlock()
+
+	s.emit(*newEvent("charge.dispute.created", resp))
+	return &resp, nil
+}
+
+// UpdateDispute moves the dispute to the status, won and lost statuses close it
+func (s *Server) UpdateDispute(id, status string) (*Dispute, error) {
+	s.mu.Lock()
+	d, ok := s.disputes[id]
+	if !ok {
+		s.mu.Unlock()
+		return nil, errors.New("no such dispute: " + id)
+	}
+	d.Status = status
+	resp := *d
+	s.mu.Unlock()
+
+	typ := "charge.dispute.updated"
+	if status == "won" || status == "lost" {
+		typ = "charge.dispute.closed"
+	}
+	s.emit(*newEvent(typ, resp))
+	return &resp, nil
+}
+
+// RenewSubscription charges the subscription for its next period as the billing cycle would,
+// the first renewal of a subscription started with a trial ends the trial
+func (s *Server) RenewSubscription(id string) (*Invoice, error) {
+	s.mu.Lock()
+	sub, ok := s.subscriptions[id]
+	if !ok {
+		s.mu.Unlock()
+		return nil, errors.New("no such subscription: " + id)
+	}
+	start := sub.periodEnd
+	if sub.interval == "year" {
+		sub.periodEnd = start.AddDate(1, 0, 0)
+	} else {
+		sub.periodEnd = start.AddDate(0, 1, 0)
+	}
+	inv := Invoice{
+		ID:            "in_sim_" + strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Object:        "invoice",
+		Subscription:  id,
+		BillingReason: "subscription_cycle",
+		Status:        "paid",
+		AmountPaid:    sub.amount,
+		Currency:      sub.currency,
+	}
+	inv.SubscriptionDetails.Metadata = sub.metadata
+	line := InvoiceLine{Amount: sub.amount}
+	line.Period.Start = start.Unix()
+	line.Period.End = sub.periodEnd.Unix()
+	inv.Lines.Data = []InvoiceLine{line}
+	s.mu.Unlock()
+
+	s.emit(*newEvent("invoice.paid", inv))
+	return &inv, nil
+}
+
+// Events returns events emitted so far, regardless of their delivery
+func (s *Server) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimRight(r.URL.Path, "/")
+	switch {
+	case path == "/v1/checkout/sessions" && r.Method == http.MethodPost:
+		s.authorized(RouteCreateSession, s.createSession)(w, r)
+	case path == "/v1/customers" && r.Method == http.MethodPost:
+		s.authorized(RouteCreateCustomer, s.createCustomer)(w, r)
+	case path == "/v1/coupons" && r.Method == http.MethodPost:
+		s.authorized(RouteCreateCoupon, s.createCoupon)(w, r)
+	case path == "/v1/checkout/sessions" && r.Method == http.MethodGet:
+		s.authorized(RouteGetSession, s.listSessions)(w, r)
+	case strings.HasPrefix(path, "/v1/checkout/sessions/") && r.Method == http.MethodGet:
+		s.authorized(RouteGetSession, s.getSession)(w, r)
+	case path == "/v1/refunds" && r.Method == http.MethodPost:
+		s.authorized(RouteRefund, s.createRefund)(w, r)
+	case strings.HasPrefix(path, "/checkout/") && r.Method == http.MethodGet:
+		s.checkout(w, r)
+	case path == "/v1/oauth2/token" && r.Method == http.MethodPost:
+		s.issueToken(w, r)
+	case path == "/v2/checkout/orders" && r.Method == http.MethodPost:
+		s.bearer(RouteCreateOrder, s.createOrder)(w, r)
+	case strings.HasPrefix(path, "/v2/checkout/orders/") && strings.HasSuffix(path, "/capture") && r.Method == http.MethodPost:
+		s.bearer(RouteCaptureOrder, s.captureOrder)(w, r)
+	case strings.HasPrefix(path, "/v2/checkout/orders/") && r.Method == http.MethodGet:
+		s.bearer("", s.getOrder)(w, r)
+	case path == "/checkoutnow" && r.Method == http.MethodGet:
+		s.approveOrder(w, r)
+	case path == "/_simulator/scenarios":
+		s.scenarios(w, r)
+	default:
+		writeError(w, http.StatusNotFound, errorTypeInvalidRequest, "resource_missing", "Unrecognized request URL")
+	}
+}
+
+// authorized checks the secret key and plays scripted scenario before the handler
+func (s *Server) authorized(route Route, next func(w http.ResponseWriter, r *http.Request, sc Scenario)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if key == "" || (s.apiKey != "" && key != s.apiKey) {
+			writeError(w, http.StatusUnauthorized, errorTypeAuthentication, "", "Invalid API Key provided")
+			return
+		}
+		sc := s.script.next(route)
+		if !sc.play(w, r) {
+			return
+		}
+		next(w, r, sc)
+	}
+}
+
+func (s *Server) createSession(w http.ResponseWriter, r *http.Request, sc Scenario) {
+	if sc.Decline {
+		writeError(w, http.StatusPaymentRequired, errorTypeCard, "card_declined", "Your card was declined")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, errorTypeInvalidRequest, "", "Invalid request body")
+		return
+	}
+	session := &Session{
+		ID:                "cs_sim_" + strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Object:            "checkout.session",
+		Mode:              r.PostForm.Get("mode"),
+		Status:            "open",
+		PaymentStatus:     "unpaid",
+		SuccessUrl:        r.PostForm.Get("success_url"),
+		CancelUrl:         r.PostForm.Get("cancel_url"),
+		ClientReferenceID: r.PostForm.Get("client_reference_id"),
+		Customer:          r.PostForm.Get("customer"),
+		Metadata:          map[string]string{},
+	}
+	if session.SuccessUrl == "" {
+		writeError(w, http.StatusBadRequest, errorTypeInvalidRequest, "parameter_missing", "Missing required param: success_url")
+		return
+	}
+	for i := 0; ; i++ {
+		prefix := "line_items[" + strconv.Itoa(i) + "]"
+		raw := r.PostForm.Get(prefix + "[price_data][unit_amount]")
+		if raw == "" {
+			break
+		}
+		amount, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || amount <= 0 {
+			writeError(w, http.StatusBadRequest, errorTypeInvalidRequest, "parameter_invalid_integer", "Invalid unit_amount of "+prefix)
+			return
+		}
+		quantity := int64(1)
+		if raw := r.PostForm.Get(prefix + "[quantity]"); raw != "" {
+			quantity, err = strconv.ParseInt(raw, 10, 64)
+			if err != nil || quantity <= 0 {
+				writeError(w, http.StatusBadRequest, errorTypeInvalidRequest, "parameter_invalid_integer", "Invalid quantity of "+prefix)
+				return
+			}
+		}
+		session.AmountTotal += amount * quantity
+		session.Currency = strings.ToLower(r.PostForm.Get(prefix + "[price_data][currency]"))
+	}
+	if session.AmountTotal == 0 {
+		writeError(w, http.StatusBadRequest, errorTypeInvalidRequest, "parameter_missing", "Missing required param: line_items")
+		return
+	}
+	if session.Mode == "" {
+		session.Mode = "payment"
+	}
+	if session.Mode == "subscription" {
+		session.interval = r.PostForm.Get("line_items[0][price_data][recurring][interval]")
+		if session.interval == "" {
+			writeError(w, http.StatusBadRequest, errorTypeInvalidRequest, "parameter_missing", "Recurring price is required in subscription mode")
+			return
+		}
+		session.recurringAmount = session.AmountTotal
+		if raw := r.PostForm.Get("subscription_data[trial_period_days]"); raw != "" {
+			days, err := strconv.Atoi(raw)
+			if err != nil || days <= 0 {
+				writeError(w, http.StatusBadRequest, errorTypeInvalidRequest, "parameter_invalid_integer", "Invalid trial_period_days")
+				return
+			}
+			session.TrialPeriodDays = days
+		}
+	}
+	if raw := r.PostForm.Get("payment_method_options[card][request_three_d_secure]"); raw != "" {
+		if raw != "any" && raw != "automatic" && raw != "challenge" {
+			writeError(w, http.StatusBadRequest, errorTypeInvalidRequest, "parameter_invalid", "Invalid request_three_d_secure")
+			return
+		}
+		session.PaymentMethodOptions = &PaymentMethodOptions{}
+		session.PaymentMethodOptions.Card.RequestThreeDSecure = raw
+	}
+	if r.PostForm.Get("payment_intent_data[setup_future_usage]") != "" && session.Mode != "payment" {
+		writeError(w, http.StatusBadRequest, errorTypeInvalidRequest, "parameter_unknown", "payment_intent_data can only be used in payment mode")
+		return
+	}
+	for k, v := range r.PostForm {
+		if key, ok := strings.CutPrefix(k, "metadata["); ok && len(v) > 0 {
+			session.Metadata[strings.TrimSuffix(key, "]")] = v[0]
+		}
+		if key, ok := strings.CutPrefix(k, "subscription_data[metadata]["); ok && len(v) > 0 {
+			if session.subscriptionMetadata == nil {
+				session.subscriptionMetadata = map[string]string{}
+			}
+			session.subscriptionMetadata[strings.TrimSuffix(key, "]")] = v[0]
+		}
+	}
+	session.Url = baseUrl(r) + "/checkout/" + session.ID
+
+	s.mu.Lock()
+	if _, ok := s.customers[session.Customer]; session.Customer != "" && !ok {
+		s.mu.Unlock()
+		writeErrorParam(w, http.StatusBadRequest, errorTypeInvalidRequest, "resource_missing", "customer", "No such customer: "+session.Customer)
+		return
+	}
+	if id := r.PostForm.Get("discounts[0][coupon]"); id != "" {
+		coupon, ok := s.coupons[id]
+		if !ok {
+			s.mu.Unlock()
+			writeErrorParam(w, http.StatusBadRequest, errorTypeInvalidRequest, "resource_missing", "discounts", "No such coupon: "+id)
+			return
+		}
+		session.AmountTotal -= coupon.AmountOff
+	}
+	// trial checkouts charge nothing until the trial ends
+	if session.TrialPeriodDays > 0 {
+		session.AmountTotal = 0
+	}
+	s.sessions[session.ID] = session
+	resp := *session
+	s.mu.Unlock()
+	writeJson(w, http.StatusOK, resp)
+}
+
+func (s *Server) createCustomer(w http.ResponseWriter, r *http.Request, sc Scenario) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, errorTypeInvalidRequest, "", "Invalid request body")
+		return
+	}
+	key := r.Header.Get("Idempotency-Key")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// retried request returns the customer created by the first one
+	if id, ok := s.idempotency[key]; key != "" && ok {
+		if c, ok := s.customers[id]; ok {
+			writeJson(w, http.StatusOK, *c)
+			return
+		}
+	}
+	c := &Customer{
+		ID:       "cus_sim_" + strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Object:   "customer",
+		Email:    r.PostForm.Get("email"),
+		Country:  r.PostForm.Get("address[country]"),
+		Metadata: map[string]string{},
+	}
+	for k, v := range r.PostForm {
+		if key, ok := strings.CutPrefix(k, "metadata["); ok && len(v) > 0 {
+			c.Metadata[strings.TrimSuffix(key, "]")] = v[0]
+		}
+	}
+	s.customers[c.ID] = c
+	if key != "" {
+		s.idempotency[key] = c.ID
+	}
+	writeJson(w, http.StatusOK, *c)
+}
+
+func (s *Server) createCoupon(w http.ResponseWriter, r *http.Request, sc Scenario) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, errorTypeInvalidRequest, "", "Invalid request body")
+		return
+	}
+	amount, err := strconv.ParseInt(r.PostForm.Get("amount_off"), 10, 64)
+	if err != nil || amount <= 0 || r.PostForm.Get("currency") == "" {
+		writeError(w, http.StatusBadRequest, errorTypeInvalidRequest, "parameter_invalid_integer", "Invalid amount_off")
+		return
+	}
+	c := &Coupon{
+		ID:        r.PostForm.Get("id"),
+		Object:    "coupon",
+		AmountOff: amount,
+		Currency:  strings.ToLower(r.PostForm.Get("currency")),
+		Duration:  r.PostForm.Get("duration"),
+	}
+	if raw := r.PostForm.Get("duration_in_months"); raw != "" {
+		c.DurationInMonths, _ = strconv.Atoi(raw)
+	}
+	if c.ID == "" {
+		c.ID = "coupon_sim_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	}
+	key := r.Header.Get("Idempotency-Key")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// retried request returns the coupon created by the first one
+	if id, ok := s.idempotency[key]; key != "" && ok {
+		if c, ok := s.coupons[id]; ok {
+			writeJson(w, http.StatusOK, *c)
+			return
+		}
+	}
+	if _, ok := s.coupons[c.ID]; ok {
+		writeErrorParam(w, http.StatusBadRequest, errorTypeInvalidRequest, "resource_already_exists", "id", "Coupon already exists")
+		return
+	}
+	s.coupons[c.ID] = c
+	if key != "" {
+		s.idempotency[key] = c.ID
+	}
+	writeJson(w, http.StatusOK, *c)
+}
+
+func (s *Server) getSession(w http.ResponseWriter, r *http.Request, sc Scenario) {
+	if sc.Decline {
+		writeError(w, http.StatusPaymentRequired, errorTypeCard, "card_declined", "Your card was declined")
+		return
+	}
+	id := strings.TrimPrefix(strings.TrimRight(r.URL.Path, "/"), "/v1/checkout/sessions/")
+	s.mu.Lock()
+	session, ok := s.sessions[id]
+	var resp Session
+	if ok {
+		resp = *session
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, errorTypeInvalidRequest, "resource_missing", "No such checkout session: "+id)
+		return
+	}
+	writeJson(w, http.StatusOK, resp)
+}
+
+// listSessions lists checkout sessions, only filtering by payment_intent is supported
+func (s *Server) listSessions(w http.ResponseWriter, r *http.Request, sc Scenario) {
+	intent := r.URL.Query().Get("payment_intent")
+	list := struct {
+		Object string    `json:"object"`
+		Data   []Session `json:"data"`
+	}{Object: "list", Data: []Session{}}
+	s.mu.Lock()
+	for _, session := range s.sessions {
+		if intent == "" || session.PaymentIntent == intent {
+			list.Data = append(list.Data, *session)
+		}
+	}
+	s.mu.Unlock()
+	writeJson(w, http.StatusOK, list)
+}
+
+// checkout emulates the customer on the hosted checkout page, the payment is
+// made right away and the customer is redirected back to the merchant,
+// outcome=cancel query parameter emulates the customer leaving the page and
+// outcome=decline emulates declined card
+func (s *Server) checkout(w http.ResponseWriter, r *http.Request) {
+	sc := s.script.next(RouteCheckout)
+	if !sc.play(w, r) {
+		return
+	}
+	id := strings.TrimPrefix(strings.TrimRight(r.URL.Path, "/"), "/checkout/")
+	outcome := r.URL.Query().Get("outcome")
+	if sc.Decline {
+		outcome = "decline"
+	}
+
+	s.mu.Lock()
+	session, ok := s.sessions[id]
+	if !ok {
+		s.mu.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	if session.Status != "open" {
+		s.mu.Unlock()
+		http.Error(w, "Checkout session is not open", http.StatusGone)
+		return
+	}
+	redirect := session.CancelUrl
+	var event *Event
+	switch outcome {
+	case "cancel":
+	case "decline":
+		event = newEvent("checkout.session.async_payment_failed", *session)
+	default:
+		session.Status = "complete"
+		session.PaymentStatus = "paid"
+		if session.Mode == "subscription" {
+			session.Subscription = "sub_sim_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+			sub := &subscription{
+				amount:   session.recurringAmount,
+				currency: session.Currency,
+				interval: session.interval,
+				metadata: session.subscriptionMetadata,
+			}
+			if session.TrialPeriodDays > 0 {
+				sub.periodEnd = time.Now().AddDate(0, 0, session.TrialPeriodDays)
+			} else if sub.interval == "year" {
+				sub.periodEnd = time.Now().AddDate(1, 0, 0)
+			} else {
+				sub.periodEnd = time.Now().AddDate(0, 1, 0)
+			}
+			s.subscriptions[session.Subscription] = sub
+		}
+		if session.AmountTotal == 0 {
+			session.PaymentStatus = "no_payment_required"
+		} else {
+			session.PaymentIntent = "pi_sim_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+			s.intents[session.PaymentIntent] = session.ID
+		}
+		redirect = strings.ReplaceAll(session.SuccessUrl, "{CHECKOUT_SESSION_ID}", session.ID)
+		event = newEvent("checkout.session.completed", *session)
+	}
+	s.mu.Unlock()
+
+	if event != nil {
+		s.emit(*event)
+	}
+	if redirect == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+func (s *Server) createRefund(w http.ResponseWriter, r *http.Request, sc Scenario) {
+	if sc.Decline {
+		writeError(w, http.StatusPaymentRequired, errorTypeCard, "card_declined", "Your card was declined")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, errorTypeInvalidRequest, "", "Invalid request body")
+		return
+	}
+	intent := r.PostForm.Get("payment_intent")
+	if intent == "" {
+		writeError(w, http.StatusBadRequest, errorTypeInvalidRequest, "parameter_missing", "Missing required param: payment_intent")
+		return
+	}
+
+	s.mu.Lock()
+	session, ok := s.sessions[s.intents[intent]]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, errorTypeInvalidRequest, "resource_missing", "No such payment_intent: "+intent)
+		return
+	}
+	remaining := session.AmountTotal - session.AmountRefunded
+	amount := remaining
+	if raw := r.PostForm.Get("amount"); raw != "" {
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || parsed <= 0 {
+			s.mu.Unlock()
+			writeError(w, http.StatusBadRequest, errorTypeInvalidRequest, "parameter_invalid_integer", "Invalid amount")
+			return
+		}
+		amount = parsed
+	}
+	if remaining == 0 {
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, errorTypeInvalidRequest, "charge_already_refunded", "Charge has already been refunded")
+		return
+	}
+	if amount > remaining {
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, errorTypeInvalidRequest, "amount_too_large", "Refund amount is greater than unrefunded amount")
+		return
+	}
+	session.AmountRefunded += amount
+	refund := Refund{
+		ID:            "re_sim_" + strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Object:        "refund",
+		Amount:        amount,
+		Currency:      session.Currency,
+		PaymentIntent: intent,
+		Status:        "succeeded",
+	}
+	s.mu.Unlock()
+
+	s.emit(*newEvent("charge.refunded", refund))
+	writeJson(w, http.StatusOK, refund)
+}
+
+// scenarios lets scenarios be scripted over HTTP when the simulator runs standalone,
+// POST queues scenarios of the route and DELETE drops all of them
+func (s *Server) scenarios(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			Route     Route `json:"route"`
+			Scenarios []struct {
+				// Latency is a duration string, e.g. "1.5s"
+				Latency string `json:"latency"`
+				Status  int    `json:"status"`
+				Timeout bool   `json:"timeout"`
+				Decline bool   `json:"decline"`
+			} `json:"scenarios"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Route == "" {
+			writeError(w, http.StatusBadRequest, errorTypeInvalidRequest, "", "Invalid scenarios")
+			return
+		}
+		scenarios := make([]Scenario, 0, len(req.Scenarios))
+		for _, i := range req.Scenarios {
+			sc := Scenario{Status: i.Status, Timeout: i.Timeout, Decline: i.Decline}
+			if i.Latency != "" {
+				latency, err := time.ParseDuration(i.Latency)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, errorTypeInvalidRequest, "", "Invalid latency: "+i.Latency)
+					return
+				}
+				sc.Latency = latency
+			}
+			scenarios = append(scenarios, sc)
+		}
+		s.Script(req.Route, scenarios...)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		s.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// emit records the event and delivers it to the webhook url, if it is configured
+func (s *Server) emit(e Event) {
+	s.mu.Lock()
+	s.events = append(s.events, e)
+	s.mu.Unlock()
+	if s.webhookUrl == "" {
+		return
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		s.log.Errorf("failed to marshal %v event, error: %v", e.Type, err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, s.webhookUrl, bytes.NewReader(payload))
+	if err != nil {
+		s.log.Errorf("failed to build webhook request, error: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(s.webhookSecret, payload, time.Now()))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.log.Errorf("failed to deliver %v event, error: %v", e.Type, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		s.log.Errorf("webhook rejected %v event with status %v", e.Type, resp.StatusCode)
+	}
+}
+
+// Sign produces value of the signature header for the webhook payload
+func Sign(secret string, payload []byte, at time.Time) string {
+	ts := strconv.FormatInt(at.Unix(), 10)
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(ts + "."))
+	m.Write(payload)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(m.Sum(nil))
+}
+
+func newEvent(typ string, object any) *Event {
+	e := &Event{
+		ID:      "evt_sim_" + strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Object:  "event",
+		Type:    typ,
+		Created: time.Now().Unix(),
+	}
+	e.Data.Object = object
+	return e
+}
+
+func baseUrl(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func writeJson(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(data)
+}
+
+// writeError answers in the shape of Stripe errors
+func writeError(w http.ResponseWriter, status int, typ, code, message string) {
+	writeErrorParam(w, status, typ, code, "", message)
+}
+
+// writeErrorParam writes Stripe-style error pointing at the invalid parameter
+func writeErrorParam(w http.ResponseWriter, status int, typ, code, param, message string) {
+	e := map[string]string{"type": typ, "code": code, "message": message}
+	if param != "" {
+		e["param"] = param
+	}
+	writeJson(w, status, map[string]any{"error": e})
+}
@@ -0,0 +1,199 @@
+package simulator
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// testClient talks to the simulator as the provider adapter would
+type testClient struct {
+	t       *testing.T
+	baseUrl string
+	http    *http.Client
+}
+
+func (c *testClient) do(method, path string, form url.Values) (int, map[string]any) {
+	req, err := http.NewRequest(method, c.baseUrl+path, strings.NewReader(form.Encode()))
+	assert.NoError(c.t, err)
+	req.Header.Set("Authorization", "Bearer sk_test")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, nil
+	}
+	defer resp.Body.Close()
+	var body map[string]any
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+func (c *testClient) createSession() map[string]any {
+	status, body := c.do(http.MethodPost, "/v1/checkout/sessions", url.Values{
+		"success_url":                            {"https://merchant.test/success?session={CHECKOUT_SESSION_ID}"},
+		"cancel_url":                             {"https://merchant.test/cancel"},
+		"line_items[0][price_data][currency]":    {"USD"},
+		"line_items[0][price_data][unit_amount]": {"1299"},
+		"line_items[0][quantity]":                {"2"},
+		"metadata[product_id]":                   {"product"},
+	})
+	assert.Equal(c.t, http.StatusOK, status)
+	return body
+}
+
+func newTestServer(t *testing.T) (*Server, *testClient, func()) {
+	sim := NewServer(zap.NewNop().Sugar(), WithApiKey("sk_test"))
+	srv := httptest.NewServer(sim)
+	client := &testClient{
+		t:       t,
+		baseUrl: srv.URL,
+		http: &http.Client{
+			Timeout: time.Second,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+	return sim, client, srv.Close
+}
+
+func TestSimulatorCheckoutFlow(t *testing.T) {
+	var received []Event
+	var signature string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ := io.ReadAll(r.Body)
+		var e Event
+		assert.NoError(t, json.Unmarshal(payload, &e))
+		received = append(received, e)
+		signature = r.Header.Get(SignatureHeader)
+		ts, err := strconv.ParseInt(strings.TrimPrefix(strings.Split(signature, ",")[0], "t="), 10, 64)
+		assert.NoError(t, err)
+		assert.Equal(t, Sign("whsec", payload, time.Unix(ts, 0)), signature)
+	}))
+	defer webhook.Close()
+
+	sim := NewServer(zap.NewNop().Sugar(), WithWebhook(webhook.URL, "whsec"))
+	srv := httptest.NewServer(sim)
+	defer srv.Close()
+	client := &testClient{t: t, baseUrl: srv.URL, http: &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+
+	session := client.createSession()
+	id := session["id"].(string)
+	assert.Equal(t, srv.URL+"/checkout/"+id, session["url"])
+	assert.Equal(t, float64(2598), session["amount_total"])
+	assert.Equal(t, "usd", session["currency"])
+	assert.Equal(t, map[string]any{"product_id": "product"}, session["metadata"])
+
+	// customer pays on the hosted page
+	resp, err := client.http.Get(session["url"].(string))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "https://merchant.test/success?session="+id, resp.Header.Get("Location"))
+
+	status, polled := client.do(http.MethodGet, "/v1/checkout/sessions/"+id, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "complete", polled["status"])
+	assert.Equal(t, "paid", polled["payment_status"])
+
+	intent := polled["payment_intent"].(string)
+	status, refund := client.do(http.MethodPost, "/v1/refunds", url.Values{"payment_intent": {intent}, "amount": {"598"}})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, float64(598), refund["amount"])
+	status, _ = client.do(http.MethodPost, "/v1/refunds", url.Values{"payment_intent": {intent}, "amount": {"2001"}})
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = client.do(http.MethodPost, "/v1/refunds", url.Values{"payment_intent": {intent}})
+	assert.Equal(t, http.StatusOK, status)
+	status, body := client.do(http.MethodPost, "/v1/refunds", url.Values{"payment_intent": {intent}})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "charge_already_refunded", body["error"].(map[string]any)["code"])
+
+	// webhooks are delivered synchronously and signed with the secret
+	assert.Len(t, received, 3)
+	assert.Equal(t, "checkout.session.completed", received[0].Type)
+	assert.Equal(t, "charge.refunded", received[1].Type)
+	assert.Equal(t, sim.Events()[2].ID, received[2].ID)
+	assert.Contains(t, signature, ",v1=")
+}
+
+func TestSimulatorScenarios(t *testing.T) {
+	t.Run("unauthorized", func(t *testing.T) {
+		_, client, stop := newTestServer(t)
+		defer stop()
+		req, _ := http.NewRequest(http.MethodPost, client.baseUrl+"/v1/checkout/sessions", nil)
+		req.Header.Set("Authorization", "Bearer sk_wrong")
+		resp, err := client.http.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("failure status is consumed once", func(t *testing.T) {
+		sim, client, stop := newTestServer(t)
+		defer stop()
+		sim.Script(RouteCreateSession, Scenario{Status: http.StatusServiceUnavailable})
+		status, body := client.do(http.MethodPost, "/v1/checkout/sessions", nil)
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, "api_error", body["error"].(map[string]any)["type"])
+		client.createSession()
+	})
+
+	t.Run("declined card", func(t *testing.T) {
+		sim, client, stop := newTestServer(t)
+		defer stop()
+		sim.Script(RouteCreateSession, Scenario{Decline: true})
+		status, body := client.do(http.MethodPost, "/v1/checkout/sessions", nil)
+		assert.Equal(t, http.StatusPaymentRequired, status)
+		assert.Equal(t, "card_declined", body["error"].(map[string]any)["code"])
+
+		session := client.createSession()
+		sim.Script(RouteCheckout, Scenario{Decline: true})
+		resp, err := client.http.Get(session["url"].(string))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "https://merchant.test/cancel", resp.Header.Get("Location"))
+		_, polled := client.do(http.MethodGet, "/v1/checkout/sessions/"+session["id"].(string), nil)
+		assert.Equal(t, "unpaid", polled["payment_status"])
+		assert.Equal(t, "checkout.session.async_payment_failed", sim.Events()[0].Type)
+	})
+
+	t.Run("latency and timeout", func(t *testing.T) {
+		sim, client, stop := newTestServer(t)
+		defer stop()
+		sim.Script(RouteGetSession, Scenario{Latency: 50 * time.Millisecond}, Scenario{Timeout: true})
+
+		start := time.Now()
+		status, _ := client.do(http.MethodGet, "/v1/checkout/sessions/unknown", nil)
+		assert.Equal(t, http.StatusNotFound, status)
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+		client.http.Timeout = 100 * time.Millisecond
+		status, _ = client.do(http.MethodGet, "/v1/checkout/sessions/unknown", nil)
+		assert.Equal(t, 0, status)
+	})
+
+	t.Run("scripted over http", func(t *testing.T) {
+		_, client, stop := newTestServer(t)
+		defer stop()
+		resp, err := client.http.Post(client.baseUrl+"/_simulator/scenarios", "application/json",
+			strings.NewReader(`{"route": "create_session", "scenarios": [{"status": 500}]}`))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		status, _ := client.do(http.MethodPost, "/v1/checkout/sessions", nil)
+		assert.Equal(t, http.StatusInternalServerError, status)
+	})
+}
@@ -0,0 +1,575 @@
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/money"
+)
+
+const (
+	defaultStripeTimeout = 10 * time.Second
+	// stripeSignatureTolerance limits age of the webhook events, so captured events can't be replayed later
+	stripeSignatureTolerance = 5 * time.Minute
+)
+
+// LineItem is a single position of the checkout
+type LineItem struct {
+	Name string
+	// Amount is a unit price in minor units of the currency, e.g. cents
+	Amount   int64
+	Currency string
+	Quantity int64
+}
+
+// StripeConfig holds everything the Stripe adapter needs besides the api key,
+// which is stored together with the provider
+type StripeConfig struct {
+	// BaseUrl of the Stripe API, may point to a local stand-in
+	BaseUrl string
+	// SuccessUrl may contain {CHECKOUT_SESSION_ID} placeholder
+	SuccessUrl string
+	CancelUrl  string
+	Items      []LineItem
+	Timeout    time.Duration
+}
+
+// Stripe creates Checkout Sessions via Stripe API
+type Stripe struct {
+	log    *zap.SugaredLogger
+	cnf    StripeConfig
+	client *http.Client
+}
+
+func NewStripe(log *zap.SugaredLogger, cnf StripeConfig) *Stripe {
+	cnf.BaseUrl = strings.TrimRight(cnf.BaseUrl, "/")
+	if cnf.Timeout <= 0 {
+		cnf.Timeout = defaultStripeTimeout
+	}
+	return &Stripe{log: log, cnf: cnf, client: &http.Client{Timeout: cnf.Timeout}}
+}
+
+type stripeSession struct {
+	ID                string `json:"id"`
+	Url               string `json:"url"`
+	Customer          string `json:"customer"`
+	ClientReferenceID string `json:"client_reference_id"`
+	PaymentStatus     string `json:"payment_status"`
+	PaymentIntent     string `json:"payment_intent"`
+}
+
+type stripeCustomer struct {
+	ID string `json:"id"`
+}
+
+type stripeCoupon struct {
+	ID string `json:"id"`
+}
+
+type stripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+type stripeDispute struct {
+	ID              string `json:"id"`
+	Amount          int64  `json:"amount"`
+	Currency        string `json:"currency"`
+	PaymentIntent   string `json:"payment_intent"`
+	Reason          string `json:"reason"`
+	Status          string `json:"status"`
+	EvidenceDetails struct {
+		DueBy int64 `json:"due_by"`
+	} `json:"evidence_details"`
+}
+
+type stripeRefund struct {
+	ID            string `json:"id"`
+	Object        string `json:"object"`
+	Amount        int64  `json:"amount"`
+	Currency      string `json:"currency"`
+	PaymentIntent string `json:"payment_intent"`
+	Status        string `json:"status"`
+}
+
+type stripeInvoice struct {
+	ID                  string `json:"id"`
+	Subscription        string `json:"subscription"`
+	BillingReason       string `json:"billing_reason"`
+	Status              string `json:"status"`
+	AmountPaid          int64  `json:"amount_paid"`
+	Currency            string `json:"currency"`
+	Tax                 int64  `json:"tax"`
+	SubscriptionDetails struct {
+		Metadata map[string]string `json:"metadata"`
+	} `json:"subscription_details"`
+	Lines struct {
+		Data []struct {
+			Period struct {
+				Start int64 `json:"start"`
+				End   int64 `json:"end"`
+			} `json:"period"`
+		} `json:"data"`
+	} `json:"lines"`
+}
+
+type stripeSessionList struct {
+	Data []struct {
+		ClientReferenceID string `json:"client_reference_id"`
+	} `json:"data"`
+}
+
+type stripeError struct {
+	Error struct {
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+		Param   string `json:"param"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// CreateCheckout creates Checkout Session authenticated with the provided key, it is in payment mode
+// unless the checkout is recurring. The checkout of a known user belongs to their Stripe customer,
+// which is created on the first checkout
+func (s *Stripe) CreateCheckout(ctx context.Context, apiKey string, checkout Checkout) (*CheckoutSession, error) {
+	sub := checkout.Subscription
+	form := url.Values{}
+	form.Set("mode", "payment")
+	if sub != nil {
+		form.Set("mode", "subscription")
+	}
+	form.Set("success_url", s.cnf.SuccessUrl)
+	if s.cnf.CancelUrl != "" {
+		form.Set("cancel_url", s.cnf.CancelUrl)
+	}
+	if checkout.ReferenceID != "" {
+		form.Set("client_reference_id", checkout.ReferenceID)
+	}
+	for i, item := range checkoutItems(s.cnf.Items, checkout) {
+		prefix := "line_items[" + strconv.Itoa(i) + "]"
+		quantity := item.Quantity
+		if quantity <= 0 {
+			quantity = 1
+		}
+		form.Set(prefix+"[price_data][currency]", strings.ToLower(item.Currency))
+		form.Set(prefix+"[price_data][unit_amount]", strconv.FormatInt(item.Amount, 10))
+		form.Set(prefix+"[price_data][product_data][name]", item.Name)
+		if sub != nil {
+			form.Set(prefix+"[price_data][recurring][interval]", sub.Interval)
+		}
+		form.Set(prefix+"[quantity]", strconv.FormatInt(quantity, 10))
+	}
+	// metadata is copied to the payment or the subscription as well, so it shows up in settlement reports
+	for k, v := range checkout.Metadata {
+		form.Set("metadata["+k+"]", v)
+		if sub != nil {
+			form.Set("subscription_data[metadata]["+k+"]", v)
+		} else {
+			form.Set("payment_intent_data[metadata]["+k+"]", v)
+		}
+	}
+	if checkout.Challenge {
+		form.Set("payment_method_options[card][request_three_d_secure]", "any")
+	}
+	if sub != nil && sub.TrialDays > 0 {
+		form.Set("subscription_data[trial_period_days]", strconv.Itoa(sub.TrialDays))
+	}
+	if sub != nil && sub.IntroPrice != nil && checkout.Price != nil {
+		coupon, err := s.introCoupon(ctx, apiKey, sub, *checkout.Price)
+		if err != nil {
+			return nil, err
+		}
+		if coupon != "" {
+			form.Set("discounts[0][coupon]", coupon)
+		}
+	}
+
+	customer := checkout.Customer
+	if customer != nil && customer.ID == "" {
+		id, err := s.createCustomer(ctx, apiKey, customer)
+		if err != nil {
+			return nil, err
+		}
+		customer = &Customer{ID: id, ReferenceID: customer.ReferenceID, Email: customer.Email, Country: customer.Country}
+	}
+	if customer != nil {
+		form.Set("customer", customer.ID)
+		// card is saved to the customer, so it is offered on the next checkout,
+		// subscriptions save it on their own to charge the next periods
+		if sub == nil {
+			form.Set("payment_intent_data[setup_future_usage]", "on_session")
+		}
+	}
+
+	var session stripeSession
+	e, err := s.post(ctx, apiKey, "/v1/checkout/sessions", checkout.ReferenceID, form, &session)
+	// customer deleted on the Stripe side is created anew
+	if err != nil && checkout.Customer != nil && checkout.Customer.ID != "" && e.Error.Code == "resource_missing" && e.Error.Param == "customer" {
+		s.log.Infof("stripe customer %v is missing, creating a new one", checkout.Customer.ID)
+		retry := checkout
+		retry.Customer = &Customer{ReferenceID: customer.ReferenceID, Email: customer.Email, Country: customer.Country}
+		return s.CreateCheckout(ctx, apiKey, retry)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.ID == "" || session.Url == "" {
+		return nil, fmt.Errorf("%w: checkout session has no url", ErrProviderUnavailable)
+	}
+	return &CheckoutSession{ID: session.ID, Url: session.Url, CustomerID: session.Customer}, nil
+}
+
+// Dispute verifies signature of the webhook event with the endpoint secret and returns the dispute
+// of charge.dispute.* events. The dispute is tied to the payment session through the checkout session
+// of its payment intent, which references our session
+func (s *Stripe) Dispute(ctx context.Context, apiKey, secret string, payload []byte, signature string) (*Dispute, error) {
+	if err := verifyStripeSignature(secret, payload, signature, time.Now()); err != nil {
+		return nil, err
+	}
+	var event stripeEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderRequest, err)
+	}
+	if !strings.HasPrefix(event.Type, "charge.dispute.") {
+		return nil, nil
+	}
+	var d stripeDispute
+	if err := json.Unmarshal(event.Data.Object, &d); err != nil || d.ID == "" || d.PaymentIntent == "" {
+		return nil, fmt.Errorf("%w: %v event has no dispute", ErrProviderRequest, event.Type)
+	}
+
+	var sessions stripeSessionList
+	if _, err := s.get(ctx, apiKey, "/v1/checkout/sessions", url.Values{"payment_intent": {d.PaymentIntent}}, &sessions); err != nil {
+		return nil, err
+	}
+	if len(sessions.Data) == 0 || sessions.Data[0].ClientReferenceID == "" {
+		return nil, fmt.Errorf("%w: payment intent %v has no checkout session", ErrProviderRequest, d.PaymentIntent)
+	}
+	dispute := &Dispute{
+		ID:        d.ID,
+		SessionID: sessions.Data[0].ClientReferenceID,
+		Reason:    d.Reason,
+		Status:    stripeDisputeStatus(d.Status),
+		Amount:    d.Amount,
+		Currency:  strings.ToLower(d.Currency),
+	}
+	if d.EvidenceDetails.DueBy > 0 {
+		dueBy := time.Unix(d.EvidenceDetails.DueBy, 0).UTC()
+		dispute.EvidenceDueBy = &dueBy
+	}
+	return dispute, nil
+}
+
+// Completion verifies signature of the webhook event with the endpoint secret and returns the checkout
+// session paid with it. Sessions completed with delayed payment methods are reported once their
+// checkout.session.async_payment_succeeded event comes, subscriptions starting with a trial require no payment
+func (s *Stripe) Completion(secret string, payload []byte, signature string) (*Completion, error) {
+	if err := verifyStripeSignature(secret, payload, signature, time.Now()); err != nil {
+		return nil, err
+	}
+	var event stripeEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderRequest, err)
+	}
+	if event.Type != "checkout.session.completed" && event.Type != "checkout.session.async_payment_succeeded" {
+		return nil, nil
+	}
+	var session stripeSession
+	if err := json.Unmarshal(event.Data.Object, &session); err != nil || session.ID == "" || session.ClientReferenceID == "" {
+		return nil, fmt.Errorf("%w: %v event has no checkout session", ErrProviderRequest, event.Type)
+	}
+	if session.PaymentStatus != "paid" && session.PaymentStatus != "no_payment_required" {
+		return nil, nil
+	}
+	return &Completion{CheckoutID: session.ID, SessionID: session.ClientReferenceID, PaymentID: session.PaymentIntent}, nil
+}
+
+// Renewal verifies signature of the webhook event with the endpoint secret and returns the renewal paid
+// with the invoice.paid event. Only invoices of billing cycles are renewals, the first invoice of the subscription
+// is paid with the checkout. The invoice is tied to our payment session through the subscription metadata,
+// which is copied from the checkout
+func (s *Stripe) Renewal(secret string, payload []byte, signature string) (*Renewal, error) {
+	if err := verifyStripeSignature(secret, payload, signature, time.Now()); err != nil {
+		return nil, err
+	}
+	var event stripeEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderRequest, err)
+	}
+	if event.Type != "invoice.paid" {
+		return nil, nil
+	}
+	var inv stripeInvoice
+	if err := json.Unmarshal(event.Data.Object, &inv); err != nil || inv.ID == "" {
+		return nil, fmt.Errorf("%w: %v event has no invoice", ErrProviderRequest, event.Type)
+	}
+	if inv.BillingReason != "subscription_cycle" || inv.Status != "paid" {
+		return nil, nil
+	}
+	sessionID := inv.SubscriptionDetails.Metadata["payment_session_id"]
+	if sessionID == "" || len(inv.Lines.Data) == 0 {
+		return nil, fmt.Errorf("%w: invoice %v of subscription %v has no payment session", ErrProviderRequest, inv.ID, inv.Subscription)
+	}
+	period := inv.Lines.Data[0].Period
+	if period.End <= period.Start {
+		return nil, fmt.Errorf("%w: invoice %v has no period", ErrProviderRequest, inv.ID)
+	}
+	return &Renewal{
+		InvoiceID:   inv.ID,
+		SessionID:   sessionID,
+		PeriodStart: time.Unix(period.Start, 0).UTC(),
+		PeriodEnd:   time.Unix(period.End, 0).UTC(),
+		Amount:      inv.AmountPaid,
+		TaxAmount:   inv.Tax,
+		Currency:    inv.Currency,
+	}, nil
+}
+
+// Refund verifies signature of the webhook event with the endpoint secret and returns the succeeded refund
+// it carries together with our payment session, which is looked up by the payment intent of the refund.
+// Refunds are reported by refund.* events, charge.refunded events are read when they carry the refund itself
+func (s *Stripe) Refund(ctx context.Context, apiKey, secret string, payload []byte, signature string) (*Refund, error) {
+	if err := verifyStripeSignature(secret, payload, signature, time.Now()); err != nil {
+		return nil, err
+	}
+	var event stripeEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderRequest, err)
+	}
+	if event.Type != "charge.refunded" && event.Type != "refund.created" && event.Type != "refund.updated" {
+		return nil, nil
+	}
+	var r stripeRefund
+	if err := json.Unmarshal(event.Data.Object, &r); err != nil {
+		return nil, fmt.Errorf("%w: %v event has no refund", ErrProviderRequest, event.Type)
+	}
+	// pending refunds are reported again once they succeed
+	if r.Object != "refund" || r.Status != "succeeded" {
+		return nil, nil
+	}
+	if r.ID == "" || r.PaymentIntent == "" {
+		return nil, fmt.Errorf("%w: %v event has no refund", ErrProviderRequest, event.Type)
+	}
+
+	var sessions stripeSessionList
+	if _, err := s.get(ctx, apiKey, "/v1/checkout/sessions", url.Values{"payment_intent": {r.PaymentIntent}}, &sessions); err != nil {
+		return nil, err
+	}
+	if len(sessions.Data) == 0 || sessions.Data[0].ClientReferenceID == "" {
+		return nil, fmt.Errorf("%w: payment intent %v has no checkout session", ErrProviderRequest, r.PaymentIntent)
+	}
+	return &Refund{
+		ID:        r.ID,
+		SessionID: sessions.Data[0].ClientReferenceID,
+		Amount:    r.Amount,
+		Currency:  strings.ToLower(r.Currency),
+	}, nil
+}
+
+// stripeDisputeStatus maps Stripe dispute status, inquiries are reported with the warning_ prefix
+func stripeDisputeStatus(status string) string {
+	switch status {
+	case "warning_needs_response", "needs_response":
+		return models.DisputeNeedsResponse
+	case "warning_under_review", "under_review":
+		return models.DisputeUnderReview
+	case "won":
+		return models.DisputeWon
+	case "lost":
+		return models.DisputeLost
+	default:
+		return models.DisputeClosed
+	}
+}
+
+// verifyStripeSignature checks the t=<unix>,v1=<hex hmac> header, any of v1 signatures may match
+// since Stripe signs with every active secret while it is rolled
+func verifyStripeSignature(secret string, payload []byte, header string, now time.Time) error {
+	var ts string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			signatures = append(signatures, v)
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(signatures) == 0 || secret == "" {
+		return ErrSignatureInvalid
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > stripeSignatureTolerance || age < -stripeSignatureTolerance {
+		return ErrSignatureInvalid
+	}
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(ts + "."))
+	m.Write(payload)
+	expected := m.Sum(nil)
+	for _, sig := range signatures {
+		if raw, err := hex.DecodeString(sig); err == nil && hmac.Equal(raw, expected) {
+			return nil
+		}
+	}
+	return ErrSignatureInvalid
+}
+
+// introCoupon returns coupon which takes the intro price off the regular one for the intro periods,
+// coupons are shared by the checkouts of equal terms. Empty id is returned when the intro price is not lower
+func (s *Stripe) introCoupon(ctx context.Context, apiKey string, sub *Subscription, price money.Money) (string, error) {
+	off, err := price.Sub(*sub.IntroPrice)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrProviderRequest, err)
+	}
+	if off.Amount <= 0 {
+		return "", nil
+	}
+	// coupons are limited in months
+	months := sub.IntroPeriods
+	if sub.Interval == models.IntervalYear {
+		months *= 12
+	}
+	id := fmt.Sprintf("intro_%v_%v_%v", off.Currency, off.Amount, months)
+	form := url.Values{}
+	form.Set("id", id)
+	form.Set("amount_off", strconv.FormatInt(off.Amount, 10))
+	form.Set("currency", off.Currency)
+	form.Set("name", "Intro price")
+	if sub.IntroPeriods <= 1 {
+		form.Set("duration", "once")
+	} else {
+		form.Set("duration", "repeating")
+		form.Set("duration_in_months", strconv.Itoa(months))
+	}
+	var coupon stripeCoupon
+	e, err := s.post(ctx, apiKey, "/v1/coupons", "coupon-"+id, form, &coupon)
+	// coupon created by one of the earlier checkouts is reused
+	if err != nil && e.Error.Code == "resource_already_exists" {
+		return id, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return coupon.ID, nil
+}
+
+// createCustomer creates Stripe customer of our customer and returns its id
+func (s *Stripe) createCustomer(ctx context.Context, apiKey string, c *Customer) (string, error) {
+	form := url.Values{}
+	if c.Email != "" {
+		form.Set("email", c.Email)
+	}
+	if c.Country != "" {
+		form.Set("address[country]", c.Country)
+	}
+	idempotencyKey := ""
+	if c.ReferenceID != "" {
+		form.Set("metadata[customer_id]", c.ReferenceID)
+		idempotencyKey = "customer-" + c.ReferenceID
+	}
+	var customer stripeCustomer
+	if _, err := s.post(ctx, apiKey, "/v1/customers", idempotencyKey, form, &customer); err != nil {
+		return "", err
+	}
+	if customer.ID == "" {
+		return "", fmt.Errorf("%w: customer has no id", ErrProviderUnavailable)
+	}
+	return customer.ID, nil
+}
+
+// post sends form-encoded request, the error body is returned together with the mapped error
+func (s *Stripe) post(ctx context.Context, apiKey, path, idempotencyKey string, form url.Values, out any) (stripeError, error) {
+	var body stripeError
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cnf.BaseUrl+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return body, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// retried request with the same key won't create a second object
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	return s.send(req, apiKey, path, out)
+}
+
+// get sends request with the query, the error body is returned together with the mapped error
+func (s *Stripe) get(ctx context.Context, apiKey, path string, query url.Values, out any) (stripeError, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.cnf.BaseUrl+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return stripeError{}, err
+	}
+	return s.send(req, apiKey, path, out)
+}
+
+func (s *Stripe) send(req *http.Request, apiKey, path string, out any) (stripeError, error) {
+	var body stripeError
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.log.Errorf("failed to reach stripe, error: %v", err)
+		return body, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		err := stripeErr(resp.StatusCode, body.Error.Type)
+		s.log.Errorw("stripe rejected the request",
+			"path", path,
+			"status", resp.StatusCode,
+			"type", body.Error.Type,
+			"code", body.Error.Code,
+			"message", body.Error.Message)
+		return body, fmt.Errorf("%w: %v", err, body.Error.Message)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		s.log.Errorf("failed to decode stripe response, error: %v", err)
+		return body, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	return body, nil
+}
+
+// stripeErr maps Stripe error type to the domain error, status is used
+// when the type is missing, e.g. when the error comes from a proxy
+func stripeErr(status int, typ string) error {
+	switch typ {
+	case "authentication_error":
+		return ErrProviderAuth
+	case "card_error":
+		return ErrProviderDeclined
+	case "rate_limit_error":
+		return ErrProviderRateLimited
+	case "invalid_request_error", "idempotency_error":
+		return ErrProviderRequest
+	case "api_error":
+		return ErrProviderUnavailable
+	}
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrProviderAuth
+	case status == http.StatusPaymentRequired:
+		return ErrProviderDeclined
+	case status == http.StatusTooManyRequests:
+		return ErrProviderRateLimited
+	case status >= http.StatusInternalServerError:
+		return ErrProviderUnavailable
+	default:
+		return ErrProviderRequest
+	}
+}
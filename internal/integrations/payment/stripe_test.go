@@ -0,0 +1,511 @@
+package payment
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"payment-api/internal/integrations/payment/simulator"
+	"payment-api/internal/models"
+	"payment-api/internal/money"
+)
+
+func TestStripeCreateCheckout(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	sim := simulator.NewServer(mockLogger, simulator.WithApiKey("sk_test"))
+	srv := httptest.NewServer(sim)
+	defer srv.Close()
+
+	stripe := NewStripe(mockLogger, StripeConfig{
+		BaseUrl:    srv.URL + "/",
+		SuccessUrl: "https://merchant.test/success?session_id={CHECKOUT_SESSION_ID}",
+		CancelUrl:  "https://merchant.test/cancel",
+		Items:      []LineItem{{Name: "Premium", Amount: 1299, Currency: "USD", Quantity: 1}},
+		Timeout:    200 * time.Millisecond,
+	})
+	provider := NewPaymentProvider(mockLogger, "../../../assets/providers.json", WithStripe(stripe))
+	checkout := Checkout{ReferenceID: "session", Metadata: map[string]string{"provider_id": "provider"}}
+
+	t.Run("success", func(t *testing.T) {
+		session, err := provider.PaymentUrl(context.Background(), models.ProviderNameStripe, "sk_test", "", checkout)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, session.ID)
+		assert.Equal(t, srv.URL+"/checkout/"+session.ID, session.Url)
+
+		// checkout must hold line items, redirect urls and metadata of the request
+		client := http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		resp, err := client.Get(session.Url)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "https://merchant.test/success?session_id="+session.ID, resp.Header.Get("Location"))
+		created := sim.Events()[0].Data.Object.(simulator.Session)
+		assert.Equal(t, int64(1299), created.AmountTotal)
+		assert.Equal(t, "usd", created.Currency)
+		assert.Equal(t, "session", created.ClientReferenceID)
+		assert.Equal(t, map[string]string{"provider_id": "provider"}, created.Metadata)
+	})
+
+	t.Run("success localized price", func(t *testing.T) {
+		localized := checkout
+		localized.ReferenceID = "localized-session"
+		localized.Price = &money.Money{Amount: 4999, Currency: "pln"}
+		session, err := stripe.CreateCheckout(context.Background(), "sk_test", localized)
+		assert.NoError(t, err)
+		client := http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		resp, err := client.Get(session.Url)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		events := sim.Events()
+		created := events[len(events)-1].Data.Object.(simulator.Session)
+		assert.Equal(t, int64(4999), created.AmountTotal)
+		assert.Equal(t, "pln", created.Currency)
+		assert.Nil(t, created.PaymentMethodOptions)
+	})
+
+	t.Run("success challenge asks for 3-D Secure", func(t *testing.T) {
+		challenged := checkout
+		challenged.ReferenceID = "challenged-session"
+		challenged.Challenge = true
+		session, err := stripe.CreateCheckout(context.Background(), "sk_test", challenged)
+		assert.NoError(t, err)
+		client := http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		resp, err := client.Get(session.Url)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		events := sim.Events()
+		created := events[len(events)-1].Data.Object.(simulator.Session)
+		assert.Equal(t, "any", created.PaymentMethodOptions.Card.RequestThreeDSecure)
+	})
+
+	t.Run("success customer is created once and reused", func(t *testing.T) {
+		withCustomer := checkout
+		withCustomer.Customer = &Customer{ReferenceID: "customer", Email: "reader@headway.test", Country: "PL"}
+		first, err := stripe.CreateCheckout(context.Background(), "sk_test", withCustomer)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, first.CustomerID)
+		customers := sim.Customers()
+		assert.Len(t, customers, 1)
+		assert.Equal(t, "reader@headway.test", customers[0].Email)
+		assert.Equal(t, "PL", customers[0].Country)
+		assert.Equal(t, map[string]string{"customer_id": "customer"}, customers[0].Metadata)
+
+		withCustomer.Customer = &Customer{ID: first.CustomerID, ReferenceID: "customer"}
+		second, err := stripe.CreateCheckout(context.Background(), "sk_test", withCustomer)
+		assert.NoError(t, err)
+		assert.Equal(t, first.CustomerID, second.CustomerID)
+		assert.Len(t, sim.Customers(), 1)
+
+		// customer deleted on the Stripe side is created anew
+		sim.DeleteCustomer(first.CustomerID)
+		withCustomer.ReferenceID = "another-session"
+		withCustomer.Customer = &Customer{ID: first.CustomerID, ReferenceID: "another-customer"}
+		third, err := stripe.CreateCheckout(context.Background(), "sk_test", withCustomer)
+		assert.NoError(t, err)
+		assert.NotEqual(t, first.CustomerID, third.CustomerID)
+		assert.Len(t, sim.Customers(), 1)
+	})
+
+	t.Run("success subscription with trial and intro price", func(t *testing.T) {
+		client := http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		subscription := checkout
+		subscription.ReferenceID = "subscription-session"
+		subscription.Customer = &Customer{ReferenceID: "subscriber"}
+		subscription.Price = &money.Money{Amount: 8999, Currency: "usd"}
+		subscription.Subscription = &Subscription{
+			Name:         "Premium yearly",
+			Interval:     models.IntervalYear,
+			IntroPrice:   &money.Money{Amount: 5999, Currency: "usd"},
+			IntroPeriods: 1,
+		}
+		session, err := stripe.CreateCheckout(context.Background(), "sk_test", subscription)
+		assert.NoError(t, err)
+		resp, err := client.Get(session.Url)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		events := sim.Events()
+		created := events[len(events)-1].Data.Object.(simulator.Session)
+		assert.Equal(t, "subscription", created.Mode)
+		assert.NotEmpty(t, created.Subscription)
+		// the intro price is charged for the first period
+		assert.Equal(t, int64(5999), created.AmountTotal)
+		assert.Equal(t, []simulator.Coupon{{ID: "intro_usd_3000_12", Object: "coupon", AmountOff: 3000, Currency: "usd", Duration: "once"}}, sim.Coupons())
+
+		// the coupon is shared by the checkouts of equal terms, trial defers the charge
+		subscription.ReferenceID = "trial-session"
+		subscription.Subscription.TrialDays = 7
+		session, err = stripe.CreateCheckout(context.Background(), "sk_test", subscription)
+		assert.NoError(t, err)
+		resp, err = client.Get(session.Url)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		events = sim.Events()
+		created = events[len(events)-1].Data.Object.(simulator.Session)
+		assert.Equal(t, 7, created.TrialPeriodDays)
+		assert.Equal(t, int64(0), created.AmountTotal)
+		assert.Equal(t, "no_payment_required", created.PaymentStatus)
+		assert.Len(t, sim.Coupons(), 1)
+	})
+
+	t.Run("other providers are mocked", func(t *testing.T) {
+		session, err := provider.PaymentUrl(context.Background(), models.ProviderNamePayPal, "sk_test", "", checkout)
+		assert.NoError(t, err)
+		assert.Equal(t, &CheckoutSession{Url: "https://www.paypal.com/pay"}, session)
+	})
+
+	type testCase struct {
+		name        string
+		apiKey      string
+		scenario    simulator.Scenario
+		expectedErr error
+	}
+	testCases := []testCase{
+		{"fail wrong key", "sk_wrong", simulator.Scenario{}, ErrProviderAuth},
+		{"fail declined", "sk_test", simulator.Scenario{Decline: true}, ErrProviderDeclined},
+		{"fail rate limit", "sk_test", simulator.Scenario{Status: http.StatusTooManyRequests}, ErrProviderRateLimited},
+		{"fail provider outage", "sk_test", simulator.Scenario{Status: http.StatusServiceUnavailable}, ErrProviderUnavailable},
+		{"fail timeout", "sk_test", simulator.Scenario{Timeout: true}, ErrProviderUnavailable},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sim.Reset()
+			sim.Script(simulator.RouteCreateSession, tc.scenario)
+			session, err := stripe.CreateCheckout(context.Background(), tc.apiKey, checkout)
+			assert.ErrorIs(t, err, tc.expectedErr)
+			assert.Nil(t, session)
+		})
+	}
+
+	t.Run("fail invalid request", func(t *testing.T) {
+		invalid := NewStripe(mockLogger, StripeConfig{BaseUrl: srv.URL})
+		_, err := invalid.CreateCheckout(context.Background(), "sk_test", checkout)
+		assert.ErrorIs(t, err, ErrProviderRequest)
+	})
+}
+
+func TestStripeCompletion(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	var payload []byte
+	var signature string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(simulator.SignatureHeader)
+	}))
+	defer webhook.Close()
+	sim := simulator.NewServer(mockLogger, simulator.WithApiKey("sk_test"), simulator.WithWebhook(webhook.URL, "whsec_test"))
+	srv := httptest.NewServer(sim)
+	defer srv.Close()
+
+	stripe := NewStripe(mockLogger, StripeConfig{
+		BaseUrl:    srv.URL,
+		SuccessUrl: "https://merchant.test/success",
+		Items:      []LineItem{{Name: "Premium", Amount: 1299, Currency: "usd"}},
+	})
+	provider := NewPaymentProvider(mockLogger, "../../../assets/providers.json", WithStripe(stripe))
+	session, err := stripe.CreateCheckout(context.Background(), "sk_test", Checkout{ReferenceID: "payment-session"})
+	assert.NoError(t, err)
+	client := http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(session.Url)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	t.Run("success paid", func(t *testing.T) {
+		completion, err := provider.Completion(context.Background(), models.ProviderNameStripe, "whsec_test", payload, signature)
+		assert.NoError(t, err)
+		assert.Equal(t, session.ID, completion.CheckoutID)
+		assert.Equal(t, "payment-session", completion.SessionID)
+		assert.Equal(t, sim.Events()[0].Data.Object.(simulator.Session).PaymentIntent, completion.PaymentID)
+	})
+
+	type testCase struct {
+		name  string
+		event string
+	}
+	testCases := []testCase{
+		{"success other event", `{"id": "evt_1", "type": "charge.refunded", "data": {"object": {}}}`},
+		{"success awaiting delayed payment", `{"id": "evt_2", "type": "checkout.session.completed",
+			"data": {"object": {"id": "cs_1", "client_reference_id": "payment-session", "payment_status": "unpaid"}}}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			completion, err := stripe.Completion("whsec_test", []byte(tc.event), simulator.Sign("whsec_test", []byte(tc.event), time.Now()))
+			assert.NoError(t, err)
+			assert.Nil(t, completion)
+		})
+	}
+
+	t.Run("fail wrong secret", func(t *testing.T) {
+		_, err := stripe.Completion("whsec_wrong", payload, signature)
+		assert.ErrorIs(t, err, ErrSignatureInvalid)
+	})
+
+	t.Run("fail mocked provider", func(t *testing.T) {
+		_, err := provider.Completion(context.Background(), models.ProviderNamePayPal, "", payload, signature)
+		assert.ErrorIs(t, err, ErrNotSupported)
+	})
+}
+
+func TestStripeDispute(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	var payload []byte
+	var signature string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(simulator.SignatureHeader)
+	}))
+	defer webhook.Close()
+	sim := simulator.NewServer(mockLogger, simulator.WithApiKey("sk_test"), simulator.WithWebhook(webhook.URL, "whsec_test"))
+	srv := httptest.NewServer(sim)
+	defer srv.Close()
+
+	stripe := NewStripe(mockLogger, StripeConfig{
+		BaseUrl:    srv.URL,
+		SuccessUrl: "https://merchant.test/success",
+		Items:      []LineItem{{Name: "Premium", Amount: 1299, Currency: "usd"}},
+	})
+	provider := NewPaymentProvider(mockLogger, "../../../assets/providers.json", WithStripe(stripe))
+	session, err := stripe.CreateCheckout(context.Background(), "sk_test", Checkout{ReferenceID: "payment-session"})
+	assert.NoError(t, err)
+	client := http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(session.Url)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	intent := sim.Events()[0].Data.Object.(simulator.Session).PaymentIntent
+
+	t.Run("success created", func(t *testing.T) {
+		opened, err := sim.OpenDispute(intent, "fraudulent")
+		assert.NoError(t, err)
+		dispute, err := provider.Dispute(context.Background(), models.ProviderNameStripe, "sk_test", "whsec_test", payload, signature)
+		assert.NoError(t, err)
+		assert.Equal(t, opened.ID, dispute.ID)
+		assert.Equal(t, "payment-session", dispute.SessionID)
+		assert.Equal(t, "fraudulent", dispute.Reason)
+		assert.Equal(t, models.DisputeNeedsResponse, dispute.Status)
+		assert.Equal(t, int64(1299), dispute.Amount)
+		assert.Equal(t, "usd", dispute.Currency)
+		assert.Equal(t, opened.EvidenceDetails.DueBy, dispute.EvidenceDueBy.Unix())
+
+		_, err = sim.UpdateDispute(opened.ID, "lost")
+		assert.NoError(t, err)
+		dispute, err = provider.Dispute(context.Background(), models.ProviderNameStripe, "sk_test", "whsec_test", payload, signature)
+		assert.NoError(t, err)
+		assert.Equal(t, models.DisputeLost, dispute.Status)
+	})
+
+	t.Run("success other event", func(t *testing.T) {
+		other := []byte(`{"id": "evt_1", "type": "checkout.session.completed", "data": {"object": {}}}`)
+		dispute, err := stripe.Dispute(context.Background(), "sk_test", "whsec_test", other, simulator.Sign("whsec_test", other, time.Now()))
+		assert.NoError(t, err)
+		assert.Nil(t, dispute)
+	})
+
+	type testCase struct {
+		name      string
+		secret    string
+		signature string
+	}
+	testCases := []testCase{
+		{name: "fail wrong secret", secret: "whsec_wrong", signature: simulator.Sign("whsec_test", payload, time.Now())},
+		{name: "fail stale", secret: "whsec_test", signature: simulator.Sign("whsec_test", payload, time.Now().Add(-time.Hour))},
+		{name: "fail malformed", secret: "whsec_test", signature: "v1=deadbeef"},
+		{name: "fail empty secret", signature: simulator.Sign("", payload, time.Now())},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := stripe.Dispute(context.Background(), "sk_test", tc.secret, payload, tc.signature)
+			assert.ErrorIs(t, err, ErrSignatureInvalid)
+		})
+	}
+
+	t.Run("fail unknown payment intent", func(t *testing.T) {
+		unknown := []byte(`{"id": "evt_2", "type": "charge.dispute.created", "data": {"object": {"id": "dp_1", "payment_intent": "pi_unknown"}}}`)
+		_, err := stripe.Dispute(context.Background(), "sk_test", "whsec_test", unknown, simulator.Sign("whsec_test", unknown, time.Now()))
+		assert.ErrorIs(t, err, ErrProviderRequest)
+	})
+
+	t.Run("fail mocked provider", func(t *testing.T) {
+		_, err := provider.Dispute(context.Background(), models.ProviderNamePayPal, "", "", payload, signature)
+		assert.ErrorIs(t, err, ErrNotSupported)
+	})
+}
+
+func TestStripeRefund(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	var payload []byte
+	var signature string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(simulator.SignatureHeader)
+	}))
+	defer webhook.Close()
+	sim := simulator.NewServer(mockLogger, simulator.WithApiKey("sk_test"), simulator.WithWebhook(webhook.URL, "whsec_test"))
+	srv := httptest.NewServer(sim)
+	defer srv.Close()
+
+	stripe := NewStripe(mockLogger, StripeConfig{
+		BaseUrl:    srv.URL,
+		SuccessUrl: "https://merchant.test/success",
+		Items:      []LineItem{{Name: "Premium", Amount: 1299, Currency: "usd"}},
+	})
+	provider := NewPaymentProvider(mockLogger, "../../../assets/providers.json", WithStripe(stripe))
+	session, err := stripe.CreateCheckout(context.Background(), "sk_test", Checkout{ReferenceID: "payment-session"})
+	assert.NoError(t, err)
+	client := http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(session.Url)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	intent := sim.Events()[0].Data.Object.(simulator.Session).PaymentIntent
+
+	t.Run("success refunded", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/v1/refunds", strings.NewReader(url.Values{"payment_intent": {intent}, "amount": {"500"}}.Encode()))
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer sk_test")
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		refund, err := provider.Refund(context.Background(), models.ProviderNameStripe, "sk_test", "whsec_test", payload, signature)
+		assert.NoError(t, err)
+		assert.Equal(t, sim.Events()[1].Data.Object.(simulator.Refund).ID, refund.ID)
+		assert.Equal(t, "payment-session", refund.SessionID)
+		assert.Equal(t, int64(500), refund.Amount)
+		assert.Equal(t, "usd", refund.Currency)
+	})
+
+	type testCase struct {
+		name  string
+		event string
+	}
+	testCases := []testCase{
+		{"success other event", `{"id": "evt_1", "type": "checkout.session.completed", "data": {"object": {}}}`},
+		{"success charge object", `{"id": "evt_2", "type": "charge.refunded", "data": {"object": {"id": "ch_1", "object": "charge"}}}`},
+		{"success pending refund", `{"id": "evt_3", "type": "refund.created",
+			"data": {"object": {"id": "re_1", "object": "refund", "payment_intent": "` + intent + `", "status": "pending"}}}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			refund, err := stripe.Refund(context.Background(), "sk_test", "whsec_test", []byte(tc.event), simulator.Sign("whsec_test", []byte(tc.event), time.Now()))
+			assert.NoError(t, err)
+			assert.Nil(t, refund)
+		})
+	}
+
+	t.Run("fail wrong secret", func(t *testing.T) {
+		_, err := stripe.Refund(context.Background(), "sk_test", "whsec_wrong", payload, signature)
+		assert.ErrorIs(t, err, ErrSignatureInvalid)
+	})
+
+	t.Run("fail unknown payment intent", func(t *testing.T) {
+		unknown := []byte(`{"id": "evt_4", "type": "refund.updated",
+			"data": {"object": {"id": "re_2", "object": "refund", "payment_intent": "pi_unknown", "status": "succeeded"}}}`)
+		_, err := stripe.Refund(context.Background(), "sk_test", "whsec_test", unknown, simulator.Sign("whsec_test", unknown, time.Now()))
+		assert.ErrorIs(t, err, ErrProviderRequest)
+	})
+
+	t.Run("fail mocked provider", func(t *testing.T) {
+		_, err := provider.Refund(context.Background(), models.ProviderNamePayPal, "", "", payload, signature)
+		assert.ErrorIs(t, err, ErrNotSupported)
+	})
+}
+
+func TestStripeRenewal(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	var payload []byte
+	var signature string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(simulator.SignatureHeader)
+	}))
+	defer webhook.Close()
+	sim := simulator.NewServer(mockLogger, simulator.WithApiKey("sk_test"), simulator.WithWebhook(webhook.URL, "whsec_test"))
+	srv := httptest.NewServer(sim)
+	defer srv.Close()
+
+	stripe := NewStripe(mockLogger, StripeConfig{
+		BaseUrl:    srv.URL,
+		SuccessUrl: "https://merchant.test/success",
+		Items:      []LineItem{{Name: "Premium", Amount: 1299, Currency: "usd"}},
+	})
+	provider := NewPaymentProvider(mockLogger, "../../../assets/providers.json", WithStripe(stripe))
+	session, err := stripe.CreateCheckout(context.Background(), "sk_test", Checkout{
+		ReferenceID:  "payment-session",
+		Metadata:     map[string]string{"payment_session_id": "payment-session"},
+		Subscription: &Subscription{Name: "Monthly", Interval: "month", TrialDays: 7},
+	})
+	assert.NoError(t, err)
+	client := http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(session.Url)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	subscription := sim.Events()[0].Data.Object.(simulator.Session).Subscription
+
+	t.Run("success trial converted", func(t *testing.T) {
+		invoice, err := sim.RenewSubscription(subscription)
+		assert.NoError(t, err)
+		renewal, err := provider.Renewal(context.Background(), models.ProviderNameStripe, "whsec_test", payload, signature)
+		assert.NoError(t, err)
+		assert.Equal(t, invoice.ID, renewal.InvoiceID)
+		assert.Equal(t, "payment-session", renewal.SessionID)
+		assert.Equal(t, time.Unix(invoice.Lines.Data[0].Period.Start, 0).UTC(), renewal.PeriodStart)
+		assert.Equal(t, renewal.PeriodStart.AddDate(0, 1, 0), renewal.PeriodEnd)
+	})
+
+	type testCase struct {
+		name  string
+		event string
+	}
+	testCases := []testCase{
+		{"success other event", `{"id": "evt_1", "type": "checkout.session.completed", "data": {"object": {}}}`},
+		{"success first invoice", `{"id": "evt_2", "type": "invoice.paid",
+			"data": {"object": {"id": "in_1", "billing_reason": "subscription_create", "status": "paid"}}}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			renewal, err := stripe.Renewal("whsec_test", []byte(tc.event), simulator.Sign("whsec_test", []byte(tc.event), time.Now()))
+			assert.NoError(t, err)
+			assert.Nil(t, renewal)
+		})
+	}
+
+	t.Run("fail wrong secret", func(t *testing.T) {
+		_, err := stripe.Renewal("whsec_wrong", payload, signature)
+		assert.ErrorIs(t, err, ErrSignatureInvalid)
+	})
+
+	t.Run("fail no payment session", func(t *testing.T) {
+		unknown := []byte(`{"id": "evt_3", "type": "invoice.paid",
+			"data": {"object": {"id": "in_2", "billing_reason": "subscription_cycle", "status": "paid", "subscription": "sub_1"}}}`)
+		_, err := stripe.Renewal("whsec_test", unknown, simulator.Sign("whsec_test", unknown, time.Now()))
+		assert.ErrorIs(t, err, ErrProviderRequest)
+	})
+
+	t.Run("fail mocked provider", func(t *testing.T) {
+		_, err := provider.Renewal(context.Background(), models.ProviderNamePayPal, "", payload, signature)
+		assert.ErrorIs(t, err, ErrNotSupported)
+	})
+}
@@ -0,0 +1,67 @@
+package settlement
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parsePayPal parses settlement report (STL), its section rows are told apart by the first column
+// and amounts are already in minor units. Transaction ids of captures are not kept with our payments,
+// so the rows are matched by the invoice id holding our payment session
+func parsePayPal(r io.Reader) (*Report, error) {
+	cr := csv.NewReader(r)
+	// rows of different sections have different number of columns
+	cr.FieldsPerRecord = -1
+
+	report := &Report{Provider: ProviderPayPal, Rows: []Row{}}
+	var idx map[string]int
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		switch record[0] {
+		case "CH":
+			idx = columns(record)
+			for _, name := range []string{"Transaction ID", "Invoice ID", "Transaction Event Code", "Transaction Initiation Date",
+				"Transaction Debit or Credit", "Gross Transaction Amount", "Gross Transaction Currency"} {
+				if _, ok := idx[name]; !ok {
+					return nil, fmt.Errorf("%w: missing column %v", ErrMalformed, name)
+				}
+			}
+		case "SB":
+			if idx == nil || len(record) < len(idx) {
+				return nil, fmt.Errorf("%w: body row is out of its section", ErrMalformed)
+			}
+			// T00 event codes are payments received, everything else is refunds, holds and transfers
+			if !strings.HasPrefix(record[idx["Transaction Event Code"]], "T00") || record[idx["Transaction Debit or Credit"]] != "CR" {
+				continue
+			}
+			id := record[idx["Transaction ID"]]
+			amount, err := strconv.ParseInt(record[idx["Gross Transaction Amount"]], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: gross amount of %v", ErrMalformed, id)
+			}
+			createdAt, err := time.Parse("2006/01/02 15:04:05 -0700", record[idx["Transaction Initiation Date"]])
+			if err != nil {
+				return nil, fmt.Errorf("%w: initiation date of %v", ErrMalformed, id)
+			}
+			report.Rows = append(report.Rows, Row{
+				TransactionID: id,
+				ReferenceID:   record[idx["Invoice ID"]],
+				Amount:        amount,
+				Currency:      strings.ToLower(record[idx["Gross Transaction Currency"]]),
+				CreatedAt:     createdAt.UTC(),
+			})
+		}
+	}
+	return report, nil
+}
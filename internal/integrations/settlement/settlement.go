@@ -0,0 +1,90 @@
+package settlement
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// Providers the settlement reports are parsed for
+const (
+	ProviderStripe = "stripe"
+	ProviderPayPal = "paypal"
+)
+
+var (
+	ErrUnknownFormat = errors.New("settlement report format is unknown")
+	ErrMalformed     = errors.New("settlement report is malformed")
+)
+
+// Report is the list of payments the provider settled
+type Report struct {
+	Provider string
+	Rows     []Row
+	// PeriodStart and PeriodEnd are bounds of the settled transactions
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+}
+
+// Row is a single settled payment
+type Row struct {
+	// TransactionID is id of the transaction on the provider side
+	TransactionID string
+	// PaymentID is id of the payment on the provider side, e.g. Stripe payment intent, empty when it is not reported
+	PaymentID string
+	// ReferenceID is id of our payment session passed to the provider at checkout, empty when it was not passed
+	ReferenceID string
+	// Amount is gross amount in minor units of the currency
+	Amount    int64
+	Currency  string
+	CreatedAt time.Time
+}
+
+// Parse detects format of the settlement report by its header and parses its payments,
+// refunds, fees and payouts are skipped
+func Parse(r io.Reader) (*Report, error) {
+	br := bufio.NewReader(r)
+	// reports exported on Windows start with byte order mark
+	if bom, err := br.Peek(3); err == nil && bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		_, _ = br.Discard(3)
+	}
+	head, err := br.Peek(64)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	first := strings.Trim(strings.SplitN(string(head), ",", 2)[0], "\"")
+
+	var report *Report
+	switch first {
+	case "balance_transaction_id":
+		report, err = parseStripe(br)
+	case "RH":
+		report, err = parsePayPal(br)
+	default:
+		return nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, row := range report.Rows {
+		if i == 0 || row.CreatedAt.Before(report.PeriodStart) {
+			report.PeriodStart = row.CreatedAt
+		}
+		if i == 0 || row.CreatedAt.After(report.PeriodEnd) {
+			report.PeriodEnd = row.CreatedAt
+		}
+	}
+	return report, nil
+}
+
+// columns maps header names to their positions
+func columns(header []string) map[string]int {
+	idx := make(map[string]int, len(header))
+	for i, name := range header {
+		idx[strings.TrimSpace(name)] = i
+	}
+	return idx
+}
@@ -0,0 +1,62 @@
+package settlement
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStripe(t *testing.T) {
+	report, err := Parse(strings.NewReader("\xef\xbb\xbf" + `balance_transaction_id,created_utc,available_on_utc,currency,gross,fee,net,reporting_category,description,payment_intent_id,payment_metadata[payment_session_id]
+txn_1,2024-01-02 10:00:00,2024-01-04 00:00:00,usd,12.99,-0.68,12.31,charge,Headway Premium,pi_1,session-1
+txn_2,2024-01-02 12:30:00,2024-01-04 00:00:00,jpy,1300,-69,1231,charge,Headway Premium,pi_2,session-2
+txn_3,2024-01-03 09:00:00,2024-01-05 00:00:00,usd,-12.99,0.00,-12.99,refund,REFUND FOR CHARGE,pi_1,session-1
+txn_4,2024-01-01 08:00:00,2024-01-03 00:00:00,eur,9.5,-0.40,9.10,charge,Headway Premium,,
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, ProviderStripe, report.Provider)
+	assert.Equal(t, []Row{
+		{TransactionID: "txn_1", PaymentID: "pi_1", ReferenceID: "session-1", Amount: 1299, Currency: "usd", CreatedAt: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)},
+		{TransactionID: "txn_2", PaymentID: "pi_2", ReferenceID: "session-2", Amount: 1300, Currency: "jpy", CreatedAt: time.Date(2024, 1, 2, 12, 30, 0, 0, time.UTC)},
+		{TransactionID: "txn_4", Amount: 950, Currency: "eur", CreatedAt: time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)},
+	}, report.Rows)
+	assert.Equal(t, time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC), report.PeriodStart)
+	assert.Equal(t, time.Date(2024, 1, 2, 12, 30, 0, 0, time.UTC), report.PeriodEnd)
+
+	_, err = Parse(strings.NewReader("balance_transaction_id,created_utc,currency,gross,reporting_category\ntxn_1,2024-01-02 10:00:00,usd,12.999,charge\n"))
+	assert.ErrorIs(t, err, ErrMalformed)
+	_, err = Parse(strings.NewReader("balance_transaction_id,currency,gross\n"))
+	assert.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestParsePayPal(t *testing.T) {
+	report, err := Parse(strings.NewReader(`"RH","2024/01/03 04:00:00 -0800","A","MERCHANT","1"
+"FH","1"
+"SH","2024/01/02 00:00:00 -0800","2024/01/02 23:59:59 -0800","MERCHANT",""
+"CH","Transaction ID","Invoice ID","PayPal Reference ID","PayPal Reference ID Type","Transaction Event Code","Transaction Initiation Date","Transaction Completion Date","Transaction Debit or Credit","Gross Transaction Amount","Gross Transaction Currency","Fee Debit or Credit","Fee Amount","Fee Currency","Custom Field"
+"SB","5TY05013RG002845M","session-1","","","T0006","2024/01/02 10:00:00 -0800","2024/01/02 10:00:05 -0800","CR","1299","USD","DR","68","USD","provider"
+"SB","8MC585209K746392H","session-1","5TY05013RG002845M","TXN","T1107","2024/01/02 11:00:00 -0800","2024/01/02 11:00:05 -0800","DR","1299","USD","CR","0","USD","provider"
+"SF","1"
+"SC","2"
+"RF","2"
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, ProviderPayPal, report.Provider)
+	assert.Equal(t, []Row{
+		{TransactionID: "5TY05013RG002845M", ReferenceID: "session-1", Amount: 1299, Currency: "usd", CreatedAt: time.Date(2024, 1, 2, 18, 0, 0, 0, time.UTC)},
+	}, report.Rows)
+
+	_, err = Parse(strings.NewReader(`"RH","2024/01/03 04:00:00 -0800","A","MERCHANT","1"
+"SB","5TY05013RG002845M","session-1"
+`))
+	assert.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestParseUnknown(t *testing.T) {
+	_, err := Parse(strings.NewReader("id,amount\n1,100\n"))
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+	_, err = Parse(strings.NewReader(""))
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
@@ -0,0 +1,70 @@
+package settlement
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"payment-api/internal/money"
+)
+
+// stripeReference is the column of the payment metadata holding our payment session id
+const stripeReference = "payment_metadata[payment_session_id]"
+
+// stripePayment is the column holding payment intent of the charge
+const stripePayment = "payment_intent_id"
+
+// parseStripe parses itemized balance change report, amounts of which are in major units
+func parseStripe(r io.Reader) (*Report, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	idx := columns(header)
+	for _, name := range []string{"balance_transaction_id", "created_utc", "currency", "gross", "reporting_category"} {
+		if _, ok := idx[name]; !ok {
+			return nil, fmt.Errorf("%w: missing column %v", ErrMalformed, name)
+		}
+	}
+
+	report := &Report{Provider: ProviderStripe, Rows: []Row{}}
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		if record[idx["reporting_category"]] != "charge" {
+			continue
+		}
+		currency := strings.ToLower(record[idx["currency"]])
+		gross, err := money.ParseMajor(record[idx["gross"]], currency)
+		if err != nil {
+			return nil, fmt.Errorf("%w: gross of %v", ErrMalformed, record[idx["balance_transaction_id"]])
+		}
+		createdAt, err := time.Parse("2006-01-02 15:04:05", record[idx["created_utc"]])
+		if err != nil {
+			return nil, fmt.Errorf("%w: created_utc of %v", ErrMalformed, record[idx["balance_transaction_id"]])
+		}
+		row := Row{
+			TransactionID: record[idx["balance_transaction_id"]],
+			Amount:        gross.Amount,
+			Currency:      currency,
+			CreatedAt:     createdAt,
+		}
+		if i, ok := idx[stripePayment]; ok {
+			row.PaymentID = record[i]
+		}
+		if i, ok := idx[stripeReference]; ok {
+			row.ReferenceID = record[i]
+		}
+		report.Rows = append(report.Rows, row)
+	}
+	return report, nil
+}
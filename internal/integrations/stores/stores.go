@@ -6,20 +6,41 @@ import (
 	"os"
 
 	"go.uber.org/zap"
-)
 
-const (
-	StoreAppleStore = "AppleStore"
-	StorePlayMarket = "PlayMarket"
+	"payment-api/internal/locale"
+	"payment-api/internal/platform"
 )
 
-var ErrUnknownStoreName = errors.New("unknown store name")
+var (
+	ErrUnknownStoreName = errors.New("unknown store name")
+	ErrNoDefaultUrl     = errors.New("store has no default url")
+)
 
 type StoreName string
 
+// regionUrls holds urls of the store for a single region
+type regionUrls struct {
+	Default   string            `json:"default"`
+	Languages map[string]string `json:"languages"`
+}
+
+// storeUrls holds localized urls of the store, keyed by region and language
+type storeUrls struct {
+	Default   string                `json:"default"`
+	Languages map[string]string     `json:"languages"`
+	Regions   map[string]regionUrls `json:"regions"`
+}
+
+// configStore describes a single store together with platforms it serves
+type configStore struct {
+	Name      StoreName           `json:"name"`
+	Platforms []platform.Platform `json:"platforms"`
+	Urls      storeUrls           `json:"urls"`
+}
+
+// configStores keeps stores in the order they are offered to the client
 type configStores struct {
-	PlayMarket string `json:"play_market"`
-	AppleStore string `json:"apple_store"`
+	Stores []configStore `json:"stores"`
 }
 
 type Stores struct {
@@ -31,26 +52,85 @@ func NewStore(log *zap.SugaredLogger, filePath string) *Stores {
 	return &Stores{log: log, filePath: filePath}
 }
 
-// AppUrl fetches url to the Headway app from the provided store name
+// AppUrl fetches url to the Headway app from the provided store name localized for the client
 // This method is coupled to business logic, thus is tested within business logic scope
-func (s *Stores) AppUrl(name StoreName) (string, error) {
+func (s *Stores) AppUrl(name StoreName, loc locale.Locale) (string, error) {
+	cnf, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	for _, store := range cnf.Stores {
+		if store.Name == name {
+			return store.Urls.localized(loc), nil
+		}
+	}
+	return "", ErrUnknownStoreName
+}
+
+// Names returns configured stores serving the provided platform in the configured order,
+// empty platform stands for every store. Every returned store is checked to have a default url
+// within the same read of the config, so links to it won't lead nowhere
+func (s *Stores) Names(p platform.Platform) ([]StoreName, error) {
+	cnf, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]StoreName, 0, len(cnf.Stores))
+	for _, store := range cnf.Stores {
+		if p != "" && !store.serves(p) {
+			continue
+		}
+		if store.Urls.Default == "" {
+			s.log.Errorw("store has no default url",
+				"store", store.Name)
+			return nil, ErrNoDefaultUrl
+		}
+		names = append(names, store.Name)
+	}
+	return names, nil
+}
+
+func (s *Stores) load() (*configStores, error) {
 	raw, err := os.ReadFile(s.filePath)
 	if err != nil {
 		s.log.Errorf("failed to read the file, error: %v", err)
-		return "", err
+		return nil, err
 	}
 
 	var cnf configStores
 	if err := json.Unmarshal(raw, &cnf); err != nil {
 		s.log.Errorf("failed to unmarshall stores config file, error: %v", err)
-		return "", err
+		return nil, err
+	}
+	return &cnf, nil
+}
+
+func (c configStore) serves(p platform.Platform) bool {
+	for _, i := range c.Platforms {
+		if i == p {
+			return true
+		}
+	}
+	return false
+}
+
+// localized walks the fallback chain: region and language, region default,
+// language of any region and finally the store default
+func (u storeUrls) localized(loc locale.Locale) string {
+	if region, ok := u.Regions[loc.Region]; ok {
+		for _, lang := range loc.Languages {
+			if url, ok := region.Languages[lang]; ok {
+				return url
+			}
+		}
+		if region.Default != "" {
+			return region.Default
+		}
 	}
-	switch name {
-	case StoreAppleStore:
-		return cnf.AppleStore, nil
-	case StorePlayMarket:
-		return cnf.PlayMarket, nil
-	default:
-		return "", ErrUnknownStoreName
+	for _, lang := range loc.Languages {
+		if url, ok := u.Languages[lang]; ok {
+			return url
+		}
 	}
+	return u.Default
 }
@@ -0,0 +1,96 @@
+package locale
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Locale is a language preference of the client together with its region
+type Locale struct {
+	// Languages are lowercase language tags ordered by preference, e.g. ["pl-pl", "pl", "en"]
+	Languages []string
+	// Region is uppercase ISO 3166-1 alpha-2 country code, e.g. "PL"
+	Region string
+}
+
+// FromRequest resolves locale from Accept-Language and the country header,
+// if country header is absent region is taken from the most preferred language tag
+func FromRequest(r *http.Request, countryHeader string) Locale {
+	loc := Locale{Languages: ParseAcceptLanguage(r.Header.Get("Accept-Language")), Region: Detected(r, countryHeader)}
+	if loc.Region == "" {
+		for _, lang := range loc.Languages {
+			if _, region, ok := strings.Cut(lang, "-"); ok {
+				loc.Region = normalizeRegion(region)
+				break
+			}
+		}
+	}
+	return loc
+}
+
+// Detected returns the country of the client detected by the CDN with the country header,
+// empty when the header is not configured or the country is unknown
+func Detected(r *http.Request, countryHeader string) string {
+	if countryHeader == "" {
+		return ""
+	}
+	return normalizeRegion(r.Header.Get(countryHeader))
+}
+
+// ParseAcceptLanguage returns language tags ordered by their quality, every
+// regional tag is followed by its base language, so "pl-PL" also matches "pl"
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+		tags = append(tags, weighted{tag, q})
+	}
+	// stable sort keeps the order of the header for equal qualities
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	seen := map[string]bool{}
+	langs := make([]string, 0, len(tags)*2)
+	add := func(tag string) {
+		if !seen[tag] {
+			seen[tag] = true
+			langs = append(langs, tag)
+		}
+	}
+	for _, t := range tags {
+		add(t.tag)
+		if base, _, ok := strings.Cut(t.tag, "-"); ok {
+			add(base)
+		}
+	}
+	return langs
+}
+
+func normalizeRegion(region string) string {
+	region = strings.ToUpper(strings.TrimSpace(region))
+	// XX and T1 are used by CDNs for unknown country and Tor
+	if len(region) != 2 || region == "XX" || region == "T1" {
+		return ""
+	}
+	return region
+}
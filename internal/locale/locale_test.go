@@ -0,0 +1,58 @@
+package locale
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromRequest(t *testing.T) {
+	type testCase struct {
+		name           string
+		acceptLanguage string
+		country        string
+		expected       Locale
+	}
+	testCases := []testCase{
+		{
+			"ordered by quality",
+			"en;q=0.5, pl-PL, de;q=0.8",
+			"",
+			Locale{Languages: []string{"pl-pl", "pl", "de", "en"}, Region: "PL"},
+		},
+		{
+			"country header wins over language region",
+			"en-GB,en;q=0.9",
+			"ua",
+			Locale{Languages: []string{"en-gb", "en"}, Region: "UA"},
+		},
+		{
+			"unknown country is ignored",
+			"de",
+			"XX",
+			Locale{Languages: []string{"de"}, Region: ""},
+		},
+		{
+			"wildcard and zero quality are skipped",
+			"*, fr;q=0, es;q=bad, it",
+			"",
+			Locale{Languages: []string{"it"}, Region: ""},
+		},
+		{
+			"empty",
+			"",
+			"",
+			Locale{Languages: []string{}, Region: ""},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept-Language", tc.acceptLanguage)
+			r.Header.Set("CF-IPCountry", tc.country)
+			assert.Equal(t, tc.expected, FromRequest(r, "CF-IPCountry"))
+		})
+	}
+}
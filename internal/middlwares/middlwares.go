@@ -1,11 +1,25 @@
 package middlwares
 
 import (
+	"context"
+	"crypto/subtle"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"go.uber.org/zap"
+
+	"payment-api/internal/tokens"
+)
+
+type ctxKey int
+
+const (
+	requestIDKey ctxKey = iota
+	userIDKey
 )
 
 // ResponseWriteWrapper is wrapper around http.ResponseWriter interface
@@ -37,7 +51,8 @@ func LogMiddlware(log *zap.SugaredLogger) func(http.HandlerFunc) http.HandlerFun
 		return func(w http.ResponseWriter, r *http.Request) {
 			log.Infow("Request",
 				"method", r.Method,
-				"path", r.URL.Path)
+				"path", r.URL.Path,
+				"request_id", RequestID(r.Context()))
 			h.ServeHTTP(w, r)
 		}
 	}
@@ -52,3 +67,86 @@ func HeaderMiddlware(h http.HandlerFunc) http.HandlerFunc {
 		w.Header().Set("X-Response-Time", strconv.Itoa(int(time.Since(d).Microseconds())))
 	}
 }
+
+// AdminMiddlware allows only requests carrying valid X-Admin-Token header,
+// if token is not configured admin endpoints are closed for everyone
+func AdminMiddlware(token string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			provided := r.Header.Get("X-Admin-Token")
+			if token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"code":401,"message":"Unauthorized"}`))
+				return
+			}
+			h.ServeHTTP(w, r)
+		}
+	}
+}
+
+// UserMiddlware authenticates the user with the token of the "Authorization: Bearer" header signed
+// by the Headway backend, requests without the header pass as anonymous ones. Nobody is authenticated
+// when the signer is not configured
+func UserMiddlware(signer *tokens.Signer) func(http.HandlerFunc) http.HandlerFunc {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || signer == nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+			claims, err := signer.Verify(strings.TrimSpace(token))
+			if err != nil || claims.UserID == "" {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"code":401,"message":"Unauthorized"}`))
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userIDKey, claims.UserID)))
+		}
+	}
+}
+
+// UserID returns id of the user authenticated by UserMiddlware, empty for anonymous requests
+func UserID(ctx context.Context) string {
+	id, _ := ctx.Value(userIDKey).(string)
+	return id
+}
+
+// RequestIDMiddlware propagates X-Request-ID header of the request or generates
+// a new one, the id is returned back in the response and stored in the context
+func RequestIDMiddlware(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 128 {
+			id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	}
+}
+
+// RequestID returns id of the request stored by RequestIDMiddlware
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// ClientIP returns ip of the client set by the CDN in the header, the address of the connection
+// is used when the header is absent or malformed
+func ClientIP(r *http.Request, header string) string {
+	if header != "" {
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get(header))); ip != nil {
+			return ip.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// StoreClick is an event of the client following the link to the app store
+type StoreClick struct {
+	ID          string
+	Store       string
+	ProductID   string
+	Platform    string
+	RequestID   string
+	UtmSource   string
+	UtmMedium   string
+	UtmCampaign string
+	UtmTerm     string
+	UtmContent  string
+	CreatedAt   time.Time `json:"created_at"`
+}
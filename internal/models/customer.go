@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Customer is our user who pays on the web
+type Customer struct {
+	ID     string
+	UserID string
+	Email  string
+	// Country is ISO 3166-1 alpha-2 code
+	Country string
+	// ProviderIDs maps provider name to the id of the customer on the provider side
+	ProviderIDs map[string]string
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Statuses of disputes, disputes needing response or under review are open
+const (
+	DisputeNeedsResponse = "needs_response"
+	DisputeUnderReview   = "under_review"
+	DisputeWon           = "won"
+	DisputeLost          = "lost"
+	// DisputeClosed is closed without a chargeback, e.g. an inquiry or a refunded payment
+	DisputeClosed = "closed"
+)
+
+// Dispute is a dispute of the captured payment opened by the card issuer on behalf of the customer
+type Dispute struct {
+	ID         string
+	ProviderID string
+	// ExternalID is id of the dispute on the provider side
+	ExternalID string
+	SessionID  string
+	// UserID is the user who paid with the session, empty for anonymous payments
+	UserID string
+	Reason string
+	Status string
+	// Amount is disputed in minor units of the currency
+	Amount        int64
+	Currency      string
+	EvidenceDueBy *time.Time
+	// AccessRevokedAt is set once access bought with the disputed payment is revoked
+	AccessRevokedAt *time.Time
+	Evidence        []DisputeEvidence
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// DisputeEvidence is a document supporting our side of the dispute, e.g. a receipt or a usage log
+type DisputeEvidence struct {
+	ID          string
+	DisputeID   string
+	Filename    string
+	ContentType string
+	Size        int64
+	// Content is loaded only when the document itself is requested
+	Content   []byte
+	CreatedAt time.Time `json:"created_at"`
+}
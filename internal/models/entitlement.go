@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+const (
+	EntitlementSourceAppStore   = "app_store"
+	EntitlementSourceGooglePlay = "google_play"
+	EntitlementSourceWeb        = "web"
+)
+
+// Entitlement grants the user premium access bought through one of the sources
+type Entitlement struct {
+	ID     string
+	UserID string
+	Source string
+	// ProductID is id of the product in the source
+	ProductID string
+	// ExternalID identifies the purchase in the source across renewals, e.g. original transaction id
+	ExternalID string
+	// TransactionID is the latest transaction of the purchase
+	TransactionID string
+	PurchasedAt   time.Time
+	ExpiresAt     *time.Time
+	RevokedAt     *time.Time
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Active tells whether the entitlement grants access at the moment
+func (e *Entitlement) Active(now time.Time) bool {
+	if e.RevokedAt != nil {
+		return false
+	}
+	return e.ExpiresAt == nil || now.Before(*e.ExpiresAt)
+}
@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// Statuses of the experiments
+const (
+	ExperimentRunning = "running"
+	ExperimentStopped = "stopped"
+)
+
+// Experiment splits paying users between variants of the paywall
+type Experiment struct {
+	ID string
+	// Key is passed by the client with the payment request
+	Key       string
+	Status    string
+	Variants  []ExperimentVariant
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ExperimentVariant overrides parts of the checkout, fields left empty keep the requested ones
+type ExperimentVariant struct {
+	Key string
+	// Weight is the share of the traffic relative to the weights of the other variants
+	Weight     int
+	ProviderID string
+	PlanID     string
+	// Amount and Currency override the price of the one-time checkout
+	Amount   int64
+	Currency string
+}
+
+// ExperimentExposure is a payment link issued to the user bucketed into the variant
+type ExperimentExposure struct {
+	ExperimentID string
+	VariantKey   string
+	UserID       string
+	SessionID    string
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// VariantResult counts users exposed to the variant and those of them who paid
+type VariantResult struct {
+	VariantKey  string
+	Exposures   int
+	Conversions int
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Flag switches a feature on for the targeted part of the traffic
+type Flag struct {
+	Key     string
+	Enabled bool
+	// Percentage of the targeted users the flag is on for, 100 stands for every one of them
+	Percentage int
+	// Countries, Platforms and Clients narrow the flag down, empty lists match everybody
+	Countries []string
+	Platforms []string
+	Clients   []string
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// FxRate is the exchange rate of the base currency to the quote one
+type FxRate struct {
+	Base  string
+	Quote string
+	// Rate is decimal number of quote units per base unit, kept as text so it stays exact
+	Rate      string
+	UpdatedAt time.Time `json:"updated_at"`
+}
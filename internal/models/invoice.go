@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Invoice is the receipt of the captured payment session issued by the legal entity selling to the payer
+type Invoice struct {
+	ID       string
+	EntityID string
+	// Sequence numbers invoices of the entity without gaps, Number is its formatted form, e.g. "EU-000042"
+	Sequence  int64
+	Number    string
+	SessionID string
+	// ProviderInvoiceID is the invoice of the provider a subscription renewal is billed with,
+	// empty for the invoice of the checkout
+	ProviderInvoiceID string
+	// UserID, Email and Country of the payer are empty when not provided
+	UserID  string
+	Email   string
+	Country string
+	// SellerName, SellerAddress and SellerTaxID are kept as of the issue, so later changes of the entity
+	// do not alter issued invoices
+	SellerName    string
+	SellerAddress []string
+	SellerTaxID   string
+	// Description is the item sold, the plan or the product
+	Description string
+	// NetAmount, TaxAmount and TotalAmount are in minor units of the currency
+	NetAmount   int64
+	TaxAmount   int64
+	TotalAmount int64
+	Currency    string
+	Taxes       []TaxLine
+	IssuedAt    time.Time `json:"issued_at"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// Kinds of ledger entries
+const (
+	LedgerEntryPayment    = "payment"
+	LedgerEntryRefund     = "refund"
+	LedgerEntryChargeback = "chargeback"
+)
+
+// Ledger accounts, funds held by a provider are kept in LedgerAccountProvider prefixed account
+const (
+	LedgerAccountProvider    = "provider:"
+	LedgerAccountRevenue     = "revenue"
+	LedgerAccountRefunds     = "refunds"
+	LedgerAccountChargebacks = "chargebacks"
+)
+
+// LedgerEntry is a balanced set of postings describing a single money movement
+type LedgerEntry struct {
+	ID   string
+	Kind string
+	// Reference identifies the movement, e.g. payment session id, an entry is posted once per reference
+	Reference string
+	Postings  []LedgerPosting
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LedgerPosting debits the account with positive amount and credits it with negative one
+type LedgerPosting struct {
+	Account  string
+	Currency string
+	// Amount is in minor units of the currency
+	Amount int64
+}
+
+// LedgerBalance is the sum of postings of the account in a single currency,
+// debits increase it and credits decrease it
+type LedgerBalance struct {
+	Account  string
+	Currency string
+	Balance  int64
+}
+
+// LedgerImbalance is a non-zero sum of postings which must sum up to zero,
+// EntryID is empty when the whole ledger is out of balance
+type LedgerImbalance struct {
+	EntryID  string
+	Currency string
+	Amount   int64
+}
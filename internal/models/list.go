@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Lists the entries belong to, allowed values override blocked ones
+const (
+	ListBlock = "block"
+	ListAllow = "allow"
+)
+
+// Kinds of the listed values
+const (
+	ListKindEmail  = "email"
+	ListKindIP     = "ip"
+	ListKindBIN    = "bin"
+	ListKindDevice = "device"
+)
+
+// Matches of the listed values
+const (
+	ListMatchExact  = "exact"
+	ListMatchCIDR   = "cidr"
+	ListMatchPrefix = "prefix"
+)
+
+// ListEntry blocks or allows the value of the kind, e.g. an email, a range of ips or a card BIN
+type ListEntry struct {
+	ID    string
+	List  string
+	Kind  string
+	Match string
+	Value string
+	// Reason and CreatedBy are kept for the support team
+	Reason    string
+	CreatedBy string
+	// ExpiresAt is nil for the entries which never expire
+	ExpiresAt *time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Active tells whether the entry is in effect at the time
+func (e *ListEntry) Active(at time.Time) bool {
+	return e.ExpiresAt == nil || at.Before(*e.ExpiresAt)
+}
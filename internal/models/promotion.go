@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Kinds of promotion discounts
+const (
+	PromotionPercent = "percent"
+	PromotionFixed   = "fixed"
+)
+
+// Promotion is a promo code discounting the checkout price
+type Promotion struct {
+	ID string
+	// Code is entered by the customer, it is kept uppercase and matched case-insensitively
+	Code string
+	Kind string
+	// PercentOff is set for percent discounts, from 1 to 99
+	PercentOff int
+	// AmountOff and Currency are set for fixed discounts, the discount applies to prices of that currency only
+	AmountOff int64
+	Currency  string
+	// ProductIDs the promotion is eligible for, empty for every product
+	ProductIDs []string
+	StartsAt   *time.Time
+	EndsAt     *time.Time
+	// MaxRedemptions caps redemptions of every customer together, zero is unlimited
+	MaxRedemptions int
+	// PerCustomerLimit caps redemptions of a single user, zero is unlimited
+	PerCustomerLimit int
+	Redemptions      int
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// PromoRedemption is a single use of the promotion by a payment session
+type PromoRedemption struct {
+	SessionID   string
+	PromotionID string
+	// UserID is empty for anonymous payers
+	UserID string
+	// Discount is the amount taken off the price in minor units of the currency
+	Discount  int64
+	Currency  string
+	CreatedAt time.Time `json:"created_at"`
+}
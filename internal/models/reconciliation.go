@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Kinds of discrepancies between our payments and provider settlements
+const (
+	// DiscrepancyMissing is our captured payment the provider didn't settle
+	DiscrepancyMissing = "missing"
+	// DiscrepancyExtra is settled payment we have no record of
+	DiscrepancyExtra = "extra"
+	// DiscrepancyAmountMismatch is settled payment which amount or currency differs from ours
+	DiscrepancyAmountMismatch = "amount_mismatch"
+)
+
+// ReconciliationReport is the result of matching a single provider settlement file against our payments
+type ReconciliationReport struct {
+	ID       string
+	FileName string
+	Provider string
+	// PeriodStart and PeriodEnd bound settled transactions of the file, payments captured within are expected in it
+	PeriodStart   time.Time
+	PeriodEnd     time.Time
+	SettledCount  int
+	MatchedCount  int
+	Discrepancies []ReconciliationDiscrepancy
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type ReconciliationDiscrepancy struct {
+	Kind string
+	// PaymentSessionID is empty for extra settlements that don't refer to our payments
+	PaymentSessionID string
+	// TransactionID is id of the settled transaction, empty for missing payments
+	TransactionID    string
+	ExpectedAmount   int64
+	ExpectedCurrency string
+	SettledAmount    int64
+	SettledCurrency  string
+}
@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// Actions of risk decisions
+const (
+	RiskAllow = "allow"
+	// RiskChallenge lets the payer check out with additional verification, e.g. 3-D Secure
+	RiskChallenge = "challenge"
+	RiskDeny      = "deny"
+)
+
+// Reviews of risk decisions
+const (
+	RiskReviewFraud = "fraud"
+	RiskReviewLegit = "legit"
+)
+
+// RiskDecision is the outcome of screening an attempt to get the payment link
+type RiskDecision struct {
+	ID string
+	// SessionID is the payment session the link is issued with, denied attempts have no session
+	SessionID string
+	UserID    string
+	Email     string
+	IP        string
+	DeviceID  string
+	// Country is claimed by the payer and IPCountry is detected by the CDN
+	Country   string
+	IPCountry string
+	// Score is the sum of weights of the triggered rules
+	Score  int
+	Action string
+	// Reasons are names of the triggered rules
+	Reasons []string
+	// Review is the verdict of the reviewer, empty until the decision is reviewed
+	Review     string
+	ReviewedAt *time.Time
+	CreatedAt  time.Time
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// PaymentSession is a single checkout attempt that is handed to the client
+// in the form of a signed redirect url
+type PaymentSession struct {
+	ID          string
+	ProviderID  string
+	ProviderUrl string
+	// ProviderSessionID is id of the checkout on the provider side, empty for mocked providers
+	ProviderSessionID string
+	// UserID is the user paying, empty when the checkout is anonymous
+	UserID string
+	// Email and Country of the payer are kept for the invoice, empty when not provided
+	Email   string
+	Country string
+	// Amount is the price of the checkout in minor units of the currency, taxes included
+	Amount   int64
+	Currency string
+	// TaxAmount is the part of the amount paid as Taxes
+	TaxAmount int64
+	Taxes     []TaxLine
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	// PlanID is set when the checkout starts a subscription
+	PlanID string
+	// TrialDays the subscription starts with, zero without a trial
+	TrialDays int
+	// PaidAt is set once the payment is captured
+	PaidAt *time.Time
+	// ProviderPaymentID is id of the payment on the provider side, e.g. Stripe payment intent,
+	// empty until the payment is captured or when the provider doesn't report it
+	ProviderPaymentID string
+	Visits            int
+	CreatedAt         time.Time `json:"created_at"`
+}
@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// Billing intervals of the plans
+const (
+	IntervalMonth = "month"
+	IntervalYear  = "year"
+)
+
+// Statuses of the subscriptions
+const (
+	SubscriptionTrialing = "trialing"
+	SubscriptionActive   = "active"
+	// SubscriptionCanceled subscriptions are never renewed, e.g. once the payment is lost to a dispute
+	SubscriptionCanceled = "canceled"
+)
+
+// Plan is a subscription sold on the web, plans are kept in the plans file
+type Plan struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Amount is the regular price of a period in minor units of the currency
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	Interval string `json:"interval"`
+	// TrialDays of free access before the first charge, zero when the plan has no trial
+	TrialDays int `json:"trial_days"`
+	// IntroAmount is charged instead of Amount for the first IntroPeriods periods, zero when the plan has no intro price
+	IntroAmount  int64 `json:"intro_amount"`
+	IntroPeriods int   `json:"intro_periods"`
+}
+
+// PeriodEnd returns end of the billing period starting at the provided time
+func (p *Plan) PeriodEnd(start time.Time) time.Time {
+	if p.Interval == IntervalYear {
+		return start.AddDate(1, 0, 0)
+	}
+	return start.AddDate(0, 1, 0)
+}
+
+// Subscription is a plan bought with a web payment
+type Subscription struct {
+	ID        string
+	UserID    string
+	PlanID    string
+	SessionID string
+	Status    string
+	// TrialEndsAt is set for subscriptions started with a trial, it is kept after the conversion
+	TrialEndsAt      *time.Time
+	CurrentPeriodEnd time.Time
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
@@ -0,0 +1,12 @@
+package models
+
+// TaxLine is a single tax of the checkout amount
+type TaxLine struct {
+	Name string
+	// Rate is percent as a decimal number, e.g. "9.975"
+	Rate string
+	// Inclusive tax is part of the price, exclusive one is added on top of it
+	Inclusive bool
+	Amount    int64
+	Currency  string
+}
@@ -0,0 +1,52 @@
+package money
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrUnknownCurrency = errors.New("currency is unknown")
+
+// exponents are numbers of minor unit digits of ISO 4217 currencies
+var exponents = map[string]int{
+	"aed": 2, "ars": 2, "aud": 2, "bgn": 2, "bhd": 3, "brl": 2, "cad": 2, "chf": 2, "clp": 0, "cny": 2,
+	"cop": 2, "czk": 2, "dkk": 2, "egp": 2, "eur": 2, "gbp": 2, "hkd": 2, "huf": 2, "idr": 2, "ils": 2,
+	"inr": 2, "isk": 0, "jod": 3, "jpy": 0, "kes": 2, "krw": 0, "kwd": 3, "kzt": 2, "mxn": 2, "myr": 2,
+	"ngn": 2, "nok": 2, "nzd": 2, "omr": 3, "pen": 2, "php": 2, "pkr": 2, "pln": 2, "qar": 2, "ron": 2,
+	"rsd": 2, "sar": 2, "sek": 2, "sgd": 2, "thb": 2, "try": 2, "twd": 2, "uah": 2, "usd": 2, "vnd": 0,
+	"zar": 2,
+}
+
+// countryCurrencies are currencies of ISO 3166-1 alpha-2 countries the prices are localized for
+var countryCurrencies = map[string]string{
+	"AE": "aed", "AR": "ars", "AT": "eur", "AU": "aud", "BE": "eur", "BG": "bgn", "BH": "bhd", "BR": "brl",
+	"CA": "cad", "CH": "chf", "CL": "clp", "CN": "cny", "CO": "cop", "CY": "eur", "CZ": "czk", "DE": "eur",
+	"DK": "dkk", "EE": "eur", "EG": "egp", "ES": "eur", "FI": "eur", "FR": "eur", "GB": "gbp", "GR": "eur",
+	"HK": "hkd", "HR": "eur", "HU": "huf", "ID": "idr", "IE": "eur", "IL": "ils", "IN": "inr", "IS": "isk",
+	"IT": "eur", "JO": "jod", "JP": "jpy", "KE": "kes", "KR": "krw", "KW": "kwd", "KZ": "kzt", "LI": "chf",
+	"LT": "eur", "LU": "eur", "LV": "eur", "MT": "eur", "MX": "mxn", "MY": "myr", "NG": "ngn", "NL": "eur",
+	"NO": "nok", "NZ": "nzd", "OM": "omr", "PE": "pen", "PH": "php", "PK": "pkr", "PL": "pln", "PT": "eur",
+	"QA": "qar", "RO": "ron", "RS": "rsd", "SA": "sar", "SE": "sek", "SG": "sgd", "SI": "eur", "SK": "eur",
+	"TH": "thb", "TR": "try", "TW": "twd", "UA": "uah", "US": "usd", "VN": "vnd", "ZA": "zar",
+}
+
+// Exponent returns number of minor unit digits of the currency
+func Exponent(currency string) (int, error) {
+	exp, ok := exponents[strings.ToLower(currency)]
+	if !ok {
+		return 0, ErrUnknownCurrency
+	}
+	return exp, nil
+}
+
+// Known tells whether the currency is in the registry
+func Known(currency string) bool {
+	_, ok := exponents[strings.ToLower(currency)]
+	return ok
+}
+
+// CurrencyOf returns lowercase currency of the country, false is returned for unknown countries
+func CurrencyOf(country string) (string, bool) {
+	c, ok := countryCurrencies[strings.ToUpper(country)]
+	return c, ok
+}
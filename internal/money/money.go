@@ -0,0 +1,131 @@
+package money
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+)
+
+var (
+	ErrCurrencyMismatch = errors.New("currencies don't match")
+	ErrInvalidAmount    = errors.New("amount is invalid")
+)
+
+// Money is an amount in minor units of the currency, e.g. 1299 usd is $12.99
+type Money struct {
+	Amount int64
+	// Currency is lowercase ISO 4217 code
+	Currency string
+}
+
+// New returns money of the amount in minor units, the currency must be known
+func New(amount int64, currency string) (Money, error) {
+	currency = strings.ToLower(currency)
+	if !Known(currency) {
+		return Money{}, ErrUnknownCurrency
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// Add sums money of the same currency
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+// Sub subtracts money of the same currency
+func (m Money) Sub(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	return Money{Amount: m.Amount - o.Amount, Currency: m.Currency}, nil
+}
+
+// Mul multiplies the amount by the quantity
+func (m Money) Mul(quantity int64) Money {
+	return Money{Amount: m.Amount * quantity, Currency: m.Currency}
+}
+
+// Major formats the amount in major units, e.g. "12.99" for 1299 usd and "1300" for 1300 jpy
+func (m Money) Major() string {
+	exp, err := Exponent(m.Currency)
+	if err != nil {
+		exp = 2
+	}
+	return new(big.Rat).SetFrac(big.NewInt(m.Amount), pow10(exp)).FloatString(exp)
+}
+
+// String formats money for humans, e.g. "12.99 USD"
+func (m Money) String() string {
+	return m.Major() + " " + strings.ToUpper(m.Currency)
+}
+
+// Format formats money for documents with thousands grouped, e.g. "1,234.56 EUR" and "-1,300 JPY"
+func (m Money) Format() string {
+	major := m.Major()
+	sign := ""
+	if strings.HasPrefix(major, "-") {
+		sign, major = "-", major[1:]
+	}
+	whole, fraction, _ := strings.Cut(major, ".")
+	var b strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(digit)
+	}
+	if fraction != "" {
+		b.WriteString("." + fraction)
+	}
+	return sign + b.String() + " " + strings.ToUpper(m.Currency)
+}
+
+// ParseMajor parses decimal amount in major units, e.g. "12.99", amount with fractions of minor units is invalid
+func ParseMajor(value, currency string) (Money, error) {
+	currency = strings.ToLower(currency)
+	exp, err := Exponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	amount, ok := new(big.Rat).SetString(strings.TrimSpace(value))
+	if !ok {
+		return Money{}, ErrInvalidAmount
+	}
+	amount.Mul(amount, new(big.Rat).SetInt(pow10(exp)))
+	if !amount.IsInt() || !amount.Num().IsInt64() {
+		return Money{}, ErrInvalidAmount
+	}
+	return Money{Amount: amount.Num().Int64(), Currency: currency}, nil
+}
+
+// Convert converts money to another currency by the rate of units of the target currency per unit of the source one,
+// the exact result is rounded to minor units of the target currency with the rounding
+func Convert(m Money, to string, rate *big.Rat, rounding Rounding) (Money, error) {
+	to = strings.ToLower(to)
+	fromExp, err := Exponent(m.Currency)
+	if err != nil {
+		return Money{}, err
+	}
+	toExp, err := Exponent(to)
+	if err != nil {
+		return Money{}, err
+	}
+	if rate == nil || rate.Sign() <= 0 {
+		return Money{}, ErrInvalidAmount
+	}
+	// minor units of the target = minor units of the source / 10^fromExp * rate * 10^toExp
+	exact := new(big.Rat).SetFrac(new(big.Int).Mul(big.NewInt(m.Amount), pow10(toExp)), pow10(fromExp))
+	exact.Mul(exact, rate)
+	amount, err := rounding.apply(exact, toExp)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: amount, Currency: to}, nil
+}
+
+func pow10(exp int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp)), nil)
+}
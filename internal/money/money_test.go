@@ -0,0 +1,118 @@
+package money
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMoney(t *testing.T) {
+	price, err := New(1299, "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, Money{Amount: 1299, Currency: "usd"}, price)
+	assert.Equal(t, "12.99", price.Major())
+	assert.Equal(t, "12.99 USD", price.String())
+	assert.Equal(t, "1300", Money{Amount: 1300, Currency: "jpy"}.Major())
+	assert.Equal(t, "1.005", Money{Amount: 1005, Currency: "kwd"}.Major())
+	assert.Equal(t, "0.05", Money{Amount: 5, Currency: "eur"}.Major())
+	assert.Equal(t, "12.99 USD", price.Format())
+	assert.Equal(t, "1,234,567.89 EUR", Money{Amount: 123456789, Currency: "eur"}.Format())
+	assert.Equal(t, "-1,300 JPY", Money{Amount: -1300, Currency: "jpy"}.Format())
+	assert.Equal(t, "100.000 KWD", Money{Amount: 100000, Currency: "kwd"}.Format())
+
+	sum, err := price.Add(price.Mul(2))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3897), sum.Amount)
+	diff, err := sum.Sub(price)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2598), diff.Amount)
+	_, err = price.Add(Money{Amount: 1, Currency: "eur"})
+	assert.ErrorIs(t, err, ErrCurrencyMismatch)
+	_, err = New(1, "xxx")
+	assert.ErrorIs(t, err, ErrUnknownCurrency)
+}
+
+func TestParseMajor(t *testing.T) {
+	type testCase struct {
+		value    string
+		currency string
+		expected Money
+		err      error
+	}
+	testCases := []testCase{
+		{value: "12.99", currency: "USD", expected: Money{Amount: 1299, Currency: "usd"}},
+		{value: "9.5", currency: "eur", expected: Money{Amount: 950, Currency: "eur"}},
+		{value: "-12.99", currency: "usd", expected: Money{Amount: -1299, Currency: "usd"}},
+		{value: "1300", currency: "jpy", expected: Money{Amount: 1300, Currency: "jpy"}},
+		{value: "1.005", currency: "kwd", expected: Money{Amount: 1005, Currency: "kwd"}},
+		{value: "12.999", currency: "usd", err: ErrInvalidAmount},
+		{value: "1300.5", currency: "jpy", err: ErrInvalidAmount},
+		{value: "twelve", currency: "usd", err: ErrInvalidAmount},
+		{value: "12.99", currency: "xxx", err: ErrUnknownCurrency},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.value+" "+tc.currency, func(t *testing.T) {
+			m, err := ParseMajor(tc.value, tc.currency)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.expected, m)
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	type testCase struct {
+		name     string
+		from     Money
+		to       string
+		rate     string
+		rounding Rounding
+		expected int64
+		err      error
+	}
+	usd := Money{Amount: 1299, Currency: "usd"}
+	testCases := []testCase{
+		// 12.99 * 3.9871 = 51.792429
+		{name: "half up", from: usd, to: "pln", rate: "3.9871", rounding: RoundHalfUp, expected: 5179},
+		{name: "up", from: usd, to: "pln", rate: "3.9871", rounding: RoundUp, expected: 5180},
+		{name: "whole", from: usd, to: "pln", rate: "3.9871", rounding: RoundWhole, expected: 5200},
+		{name: "charm", from: usd, to: "pln", rate: "3.9871", rounding: RoundCharm, expected: 5199},
+		{name: "exact whole is kept", from: Money{Amount: 1000, Currency: "usd"}, to: "eur", rate: "2", rounding: RoundWhole, expected: 2000},
+		{name: "charm above the whole", from: Money{Amount: 1000, Currency: "usd"}, to: "eur", rate: "2", rounding: RoundCharm, expected: 2099},
+		// 12.99 * 149.32 = 1939.6668 yen
+		{name: "zero decimal", from: usd, to: "jpy", rate: "149.32", rounding: RoundHalfUp, expected: 1940},
+		{name: "zero decimal charm", from: usd, to: "jpy", rate: "149.32", rounding: RoundCharm, expected: 1949},
+		// 1940 yen / 149.32 = 12.9922...
+		{name: "from zero decimal", from: Money{Amount: 1940, Currency: "jpy"}, to: "usd", rate: "0.0066970", rounding: RoundUp, expected: 1300},
+		{name: "three decimals", from: usd, to: "kwd", rate: "0.3075", rounding: RoundHalfUp, expected: 3994},
+		{name: "unknown rounding", from: usd, to: "eur", rate: "0.92", rounding: "floor", err: ErrUnknownRounding},
+		{name: "unknown currency", from: usd, to: "xxx", rate: "0.92", rounding: RoundUp, err: ErrUnknownCurrency},
+		{name: "negative rate", from: usd, to: "eur", rate: "-0.92", rounding: RoundUp, err: ErrInvalidAmount},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rate, ok := new(big.Rat).SetString(tc.rate)
+			assert.True(t, ok)
+			m, err := Convert(tc.from, tc.to, rate, tc.rounding)
+			assert.ErrorIs(t, err, tc.err)
+			if tc.err == nil {
+				assert.Equal(t, Money{Amount: tc.expected, Currency: tc.to}, m)
+			}
+		})
+	}
+}
+
+func TestCurrencyOf(t *testing.T) {
+	currency, ok := CurrencyOf("pl")
+	assert.True(t, ok)
+	assert.Equal(t, "pln", currency)
+	currency, ok = CurrencyOf("DE")
+	assert.True(t, ok)
+	assert.Equal(t, "eur", currency)
+	_, ok = CurrencyOf("AQ")
+	assert.False(t, ok)
+	// every country currency is in the registry
+	for country, currency := range countryCurrencies {
+		assert.True(t, Known(currency), country)
+	}
+}
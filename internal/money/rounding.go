@@ -0,0 +1,76 @@
+package money
+
+import (
+	"errors"
+	"math/big"
+)
+
+// Rounding rule of converted prices
+type Rounding string
+
+const (
+	// RoundHalfUp rounds to the nearest minor unit, halves away from zero
+	RoundHalfUp Rounding = "half_up"
+	// RoundUp rounds up to the next minor unit, so conversion never undercharges
+	RoundUp Rounding = "up"
+	// RoundWhole rounds up to the next whole major unit, e.g. 49.12 to 50.00
+	RoundWhole Rounding = "whole"
+	// RoundCharm rounds up to a price ending with nines, e.g. 49.12 to 49.99 and 1301 jpy to 1309
+	RoundCharm Rounding = "charm"
+)
+
+var ErrUnknownRounding = errors.New("rounding is unknown")
+
+// Valid tells whether the rounding is known
+func (r Rounding) Valid() bool {
+	switch r {
+	case RoundHalfUp, RoundUp, RoundWhole, RoundCharm:
+		return true
+	}
+	return false
+}
+
+// apply rounds exact non-negative amount of minor units of the currency with exp digits
+func (r Rounding) apply(exact *big.Rat, exp int) (int64, error) {
+	var step *big.Int
+	switch r {
+	case RoundHalfUp:
+		half := new(big.Rat).Add(exact, big.NewRat(1, 2))
+		return intOf(new(big.Int).Quo(half.Num(), half.Denom()))
+	case RoundUp:
+		step = big.NewInt(1)
+	case RoundWhole:
+		step = pow10(exp)
+	case RoundCharm:
+		// currencies without minor units end with a single nine
+		if exp == 0 {
+			exp = 1
+		}
+		step = pow10(exp)
+	default:
+		return 0, ErrUnknownRounding
+	}
+
+	// ceiling to the multiple of the step
+	scaled := new(big.Rat).Quo(exact, new(big.Rat).SetInt(step))
+	ceil := new(big.Int).Quo(scaled.Num(), scaled.Denom())
+	if !scaled.IsInt() {
+		ceil.Add(ceil, big.NewInt(1))
+	}
+	if r == RoundCharm {
+		// the price ending with nines is just below the multiple, unless that is already below the exact amount
+		charm := new(big.Int).Sub(new(big.Int).Mul(ceil, step), big.NewInt(1))
+		if new(big.Rat).SetInt(charm).Cmp(exact) < 0 {
+			charm.Add(charm, step)
+		}
+		return intOf(charm)
+	}
+	return intOf(ceil.Mul(ceil, step))
+}
+
+func intOf(i *big.Int) (int64, error) {
+	if !i.IsInt64() {
+		return 0, ErrInvalidAmount
+	}
+	return i.Int64(), nil
+}
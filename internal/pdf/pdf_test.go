@@ -0,0 +1,45 @@
+package pdf
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocument(t *testing.T) {
+	doc := New()
+	doc.Text(40, 60, HelveticaBold, 18, AlignLeft, "Invoice (copy)")
+	doc.Text(555, 60, Helvetica, 10, AlignRight, "Total 1,234.56 €")
+	doc.Rule(40, 555, 70, 0.5)
+	out := doc.Bytes()
+
+	assert.True(t, bytes.HasPrefix(out, []byte("%PDF-1.4\n")))
+	assert.True(t, bytes.HasSuffix(out, []byte("%%EOF\n")))
+	assert.Contains(t, string(out), `BT /F2 18 Tf 40 781.89 Td (Invoice \(copy\)) Tj ET`)
+	assert.Contains(t, string(out), "(Total 1,234.56 \x80) Tj")
+
+	// every object must be found at the offset listed in the cross-reference table
+	startxref := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(out)
+	assert.NotNil(t, startxref)
+	xref, err := strconv.Atoi(string(startxref[1]))
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(out[xref:], []byte("xref\n0 7\n")))
+	offsets := regexp.MustCompile(`(\d{10}) 00000 n`).FindAllSubmatch(out[xref:], -1)
+	assert.Len(t, offsets, 6)
+	for i, offset := range offsets {
+		at, err := strconv.Atoi(string(offset[1]))
+		assert.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(out[at:], []byte(strconv.Itoa(i+1)+" 0 obj\n")))
+	}
+}
+
+func TestWidth(t *testing.T) {
+	assert.Equal(t, 5.56, Width(Helvetica, 10, "0"))
+	assert.InDelta(t, 25.02, Width(Helvetica, 10, "12.99"), 0.001)
+	assert.Greater(t, Width(HelveticaBold, 10, "Total"), Width(Helvetica, 10, "Total"))
+	// characters out of the encoding are rendered as "?"
+	assert.Equal(t, Width(Helvetica, 10, "?"), Width(Helvetica, 10, "ł"))
+}
@@ -0,0 +1,47 @@
+package platform
+
+import (
+	"net/http"
+	"strings"
+)
+
+type Platform string
+
+const (
+	PlatformIOS     Platform = "ios"
+	PlatformAndroid Platform = "android"
+	PlatformDesktop Platform = "desktop"
+)
+
+// Detect resolves platform of the client, User-Agent client hints are preferred
+// since they are not frozen like User-Agent string, everything that is not
+// recognized as mobile is treated as desktop
+func Detect(r *http.Request) Platform {
+	if hint := strings.Trim(r.Header.Get("Sec-CH-UA-Platform"), `" `); hint != "" {
+		switch strings.ToLower(hint) {
+		case "ios", "ipados":
+			return PlatformIOS
+		case "android":
+			return PlatformAndroid
+		default:
+			return PlatformDesktop
+		}
+	}
+	return FromUserAgent(r.Header.Get("User-Agent"))
+}
+
+// FromUserAgent resolves platform from the User-Agent header value
+func FromUserAgent(ua string) Platform {
+	ua = strings.ToLower(ua)
+	switch {
+	// Windows Phone pretends to be both Android and iPhone, so it is not a mobile store client
+	case strings.Contains(ua, "windows phone"):
+		return PlatformDesktop
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"), strings.Contains(ua, "ipod"):
+		return PlatformIOS
+	case strings.Contains(ua, "android"):
+		return PlatformAndroid
+	default:
+		return PlatformDesktop
+	}
+}
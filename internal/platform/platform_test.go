@@ -0,0 +1,78 @@
+package platform
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetect(t *testing.T) {
+	type testCase struct {
+		name     string
+		ua       string
+		hint     string
+		expected Platform
+	}
+	testCases := []testCase{
+		{
+			"iPhone",
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1",
+			"",
+			PlatformIOS,
+		},
+		{
+			"iPad",
+			"Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+			"",
+			PlatformIOS,
+		},
+		{
+			"Android",
+			"Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Mobile Safari/537.36",
+			"",
+			PlatformAndroid,
+		},
+		{
+			"Windows Phone",
+			"Mozilla/5.0 (Windows Phone 10.0; Android 6.0.1; Microsoft; Lumia 950) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/52.0.2743.116 Mobile Safari/537.36 Edge/15.14977",
+			"",
+			PlatformDesktop,
+		},
+		{
+			"macOS",
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/605.1.15",
+			"",
+			PlatformDesktop,
+		},
+		{
+			"empty",
+			"",
+			"",
+			PlatformDesktop,
+		},
+		{
+			"client hint wins over frozen User-Agent",
+			"Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Safari/537.36",
+			`"Windows"`,
+			PlatformDesktop,
+		},
+		{
+			"client hint Android",
+			"",
+			`"Android"`,
+			PlatformAndroid,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("User-Agent", tc.ua)
+			if tc.hint != "" {
+				r.Header.Set("Sec-CH-UA-Platform", tc.hint)
+			}
+			assert.Equal(t, tc.expected, Detect(r))
+		})
+	}
+}
@@ -7,16 +7,65 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 
 	"go.uber.org/zap"
 
 	"payment-api/internal/config"
+	"payment-api/internal/integrations/appstore"
+	"payment-api/internal/integrations/googleplay"
 	intpayment "payment-api/internal/integrations/payment"
 	"payment-api/internal/integrations/stores"
 	"payment-api/internal/middlwares"
+	"payment-api/internal/money"
+	appstoresvc "payment-api/internal/services/appstore"
+	appstorev1 "payment-api/internal/services/appstore/handlers/http/v1"
+	"payment-api/internal/services/clicks"
+	clicksv1 "payment-api/internal/services/clicks/handlers/http/v1"
+	clicksrepo "payment-api/internal/services/clicks/repository"
+	"payment-api/internal/services/disputes"
+	disputesv1 "payment-api/internal/services/disputes/handlers/http/v1"
+	disputesrepo "payment-api/internal/services/disputes/repository"
+	"payment-api/internal/services/entitlements"
+	entitlementsv1 "payment-api/internal/services/entitlements/handlers/http/v1"
+	entitlementsrepo "payment-api/internal/services/entitlements/repository"
+	"payment-api/internal/services/experiments"
+	experimentsv1 "payment-api/internal/services/experiments/handlers/http/v1"
+	experimentsrepo "payment-api/internal/services/experiments/repository"
+	"payment-api/internal/services/flags"
+	flagsv1 "payment-api/internal/services/flags/handlers/http/v1"
+	flagsrepo "payment-api/internal/services/flags/repository"
+	googleplaysvc "payment-api/internal/services/googleplay"
+	googleplayv1 "payment-api/internal/services/googleplay/handlers/http/v1"
+	"payment-api/internal/services/invoices"
+	invoicesv1 "payment-api/internal/services/invoices/handlers/http/v1"
+	invoicesrepo "payment-api/internal/services/invoices/repository"
+	"payment-api/internal/services/ledger"
+	ledgerv1 "payment-api/internal/services/ledger/handlers/http/v1"
+	ledgerrepo "payment-api/internal/services/ledger/repository"
+	"payment-api/internal/services/lists"
+	listsv1 "payment-api/internal/services/lists/handlers/http/v1"
+	listsrepo "payment-api/internal/services/lists/repository"
 	"payment-api/internal/services/payment"
 	v1 "payment-api/internal/services/payment/handlers/http/v1"
 	"payment-api/internal/services/payment/repository"
+	"payment-api/internal/services/pricing"
+	pricingv1 "payment-api/internal/services/pricing/handlers/http/v1"
+	pricingrepo "payment-api/internal/services/pricing/repository"
+	"payment-api/internal/services/promotions"
+	promotionsv1 "payment-api/internal/services/promotions/handlers/http/v1"
+	promotionsrepo "payment-api/internal/services/promotions/repository"
+	"payment-api/internal/services/reconciliation"
+	reconciliationv1 "payment-api/internal/services/reconciliation/handlers/http/v1"
+	reconciliationrepo "payment-api/internal/services/reconciliation/repository"
+	"payment-api/internal/services/risk"
+	riskv1 "payment-api/internal/services/risk/handlers/http/v1"
+	riskrepo "payment-api/internal/services/risk/repository"
+	"payment-api/internal/services/subscriptions"
+	subscriptionsv1 "payment-api/internal/services/subscriptions/handlers/http/v1"
+	subscriptionsrepo "payment-api/internal/services/subscriptions/repository"
+	"payment-api/internal/services/tax"
+	"payment-api/internal/tokens"
 	"syscall"
 	"time"
 )
@@ -25,26 +74,191 @@ import (
 func Run(log *zap.SugaredLogger, cnf *config.Config, conn *sql.DB) {
 	// Repos
 	repo := repository.NewProviderRepo(log, conn)
+	sessionRepo := repository.NewSessionRepo(log, conn)
+	customerRepo := repository.NewCustomerRepo(log, conn)
+	clickRepo := clicksrepo.NewClickRepo(log, conn)
+	entitlementRepo := entitlementsrepo.NewEntitlementRepo(log, conn)
+	ledgerRepo := ledgerrepo.NewLedgerRepo(log, conn)
+	reportRepo := reconciliationrepo.NewReportRepo(log, conn)
+	settledPaymentRepo := reconciliationrepo.NewPaymentRepo(log, conn)
+	fxRateRepo := pricingrepo.NewFxRateRepo(log, conn)
+	promotionRepo := promotionsrepo.NewPromotionRepo(log, conn)
+	subscriptionRepo := subscriptionsrepo.NewSubscriptionRepo(log, conn)
+	experimentRepo := experimentsrepo.NewExperimentRepo(log, conn)
+	flagRepo := flagsrepo.NewFlagRepo(log, conn)
 
 	// Integrations
-	payProvider := intpayment.NewPaymentProvider(log, cnf.ProviderFilePath)
+	var providerOpts []intpayment.Option
+	checkoutItems := []intpayment.LineItem{
+		{Name: cnf.Checkout.ProductName, Amount: cnf.Checkout.Amount, Currency: cnf.Checkout.Currency, Quantity: 1},
+	}
+	if cnf.Stripe.BaseUrl != "" {
+		providerOpts = append(providerOpts, intpayment.WithStripe(intpayment.NewStripe(log, intpayment.StripeConfig{
+			BaseUrl:    cnf.Stripe.BaseUrl,
+			SuccessUrl: cnf.Checkout.SuccessUrl,
+			CancelUrl:  cnf.Checkout.CancelUrl,
+			Items:      checkoutItems,
+		})))
+	}
+	if cnf.PayPal.BaseUrl != "" {
+		providerOpts = append(providerOpts, intpayment.WithPayPal(intpayment.NewPayPal(log, intpayment.PayPalConfig{
+			BaseUrl:   cnf.PayPal.BaseUrl,
+			ReturnUrl: strings.TrimRight(cnf.Links.BaseUrl, "/") + "/api/v1/payment/paypal/return",
+			CancelUrl: cnf.Checkout.CancelUrl,
+			Items:     checkoutItems,
+		})))
+	}
+	payProvider := intpayment.NewPaymentProvider(log, cnf.ProviderFilePath, providerOpts...)
 	stores := stores.NewStore(log, cnf.StoresFilePath)
 
 	// Services
-	svc := payment.NewPaymentService(log, payProvider, stores, repo)
+	ledgerSvc := ledger.NewLedgerService(log, ledgerRepo)
+	basePrice := money.Money{Amount: cnf.Checkout.Amount, Currency: strings.ToLower(cnf.Checkout.Currency)}
+	pricingSvc := pricing.NewPricingService(log, fxRateRepo, basePrice, cnf.PricesFilePath)
+	if cnf.FxRatesFilePath != "" {
+		if err := pricingSvc.LoadRates(context.Background(), cnf.FxRatesFilePath); err != nil {
+			log.Errorf("failed to load fx rates, error: %v", err)
+		}
+	}
+	promotionsSvc := promotions.NewPromotionsService(log, promotionRepo)
+	entitlementsSvc := entitlements.NewEntitlementsService(log, entitlementRepo,
+		entitlements.WithWebDuration(cnf.Checkout.AccessDuration),
+		entitlements.WithWebProductID(cnf.Checkout.ProductName),
+	)
+	subscriptionsSvc := subscriptions.NewSubscriptionsService(log, subscriptionRepo, entitlementsSvc, cnf.Subscriptions.PlansFilePath)
+	experimentsSvc := experiments.NewExperimentsService(log, experimentRepo)
+	flagsSvc := flags.NewFlagsService(log, flagRepo)
+	// kill switches must be in effect before the first payment link is issued
+	_ = flagsSvc.Refresh(context.Background())
+	listsSvc := lists.NewListsService(log, listsrepo.NewEntryRepo(log, conn))
+	_ = listsSvc.Refresh(context.Background())
+	paymentOpts := []payment.Option{
+		payment.WithBaseUrl(cnf.Links.BaseUrl),
+		payment.WithLinkTTL(cnf.Links.TTL),
+		payment.WithSuccessUrl(cnf.Checkout.SuccessUrl),
+		payment.WithEntitlements(entitlementsSvc),
+		payment.WithCustomers(customerRepo),
+		payment.WithLedger(ledgerSvc),
+		payment.WithPrice(basePrice.Amount, basePrice.Currency),
+		payment.WithPricing(pricingSvc),
+		payment.WithPromotions(promotionsSvc),
+		payment.WithProductID(cnf.Checkout.ProductName),
+		payment.WithSubscriptions(subscriptionsSvc),
+		payment.WithExperiments(experimentsSvc),
+		payment.WithFlags(flagsSvc),
+	}
+	if cnf.TaxRulesFilePath != "" {
+		paymentOpts = append(paymentOpts, payment.WithTax(tax.NewTaxService(log, cnf.TaxRulesFilePath)))
+	}
+	var invoicesSvc *invoices.InvoicesService
+	if cnf.LegalEntitiesFilePath != "" {
+		invoicesSvc = invoices.NewInvoicesService(log, invoicesrepo.NewInvoiceRepo(log, conn), cnf.LegalEntitiesFilePath,
+			invoices.WithProductName(cnf.Checkout.ProductName),
+			invoices.WithPublisher(invoices.NewLogPublisher(log)),
+		)
+		paymentOpts = append(paymentOpts, payment.WithInvoices(invoicesSvc))
+	}
+	var riskSvc *risk.RiskService
+	if cnf.RiskRulesFilePath != "" {
+		riskSvc = risk.NewRiskService(log, riskrepo.NewDecisionRepo(log, conn), cnf.RiskRulesFilePath)
+		paymentOpts = append(paymentOpts, payment.WithRisk(riskSvc))
+	}
+	svc := payment.NewPaymentService(log, payProvider, stores, repo, sessionRepo, tokens.NewSigner(cnf.Links.Secret), paymentOpts...)
+	disputesSvc := disputes.NewDisputesService(log, disputesrepo.NewDisputeRepo(log, conn), repo, sessionRepo, payProvider,
+		disputes.WithEntitlements(entitlementsSvc),
+		disputes.WithSubscriptions(subscriptionsSvc),
+		disputes.WithLedger(ledgerSvc),
+		disputes.WithRevokeReasons(cnf.Disputes.RevokeReasons),
+	)
+	clicksSvc := clicks.NewClickService(log, stores, clickRepo)
+	reconciliationSvc := reconciliation.NewReconciliationService(log, reportRepo, settledPaymentRepo, cnf.Settlement.Dir)
 
 	// Server setup
-	h := v1.NewHandler(log, svc)
+	h := v1.NewHandler(log, svc, cnf.CountryHeader, cnf.ClientIPHeader)
+	clicksHandler := clicksv1.NewHandler(log, clicksSvc, cnf.CountryHeader)
+	entitlementsHandler := entitlementsv1.NewHandler(log, entitlementsSvc)
+	ledgerHandler := ledgerv1.NewHandler(log, ledgerSvc)
+	reconciliationHandler := reconciliationv1.NewHandler(log, reconciliationSvc)
+	pricingHandler := pricingv1.NewHandler(log, pricingSvc, cnf.CountryHeader)
+	promotionsHandler := promotionsv1.NewHandler(log, promotionsSvc)
+	subscriptionsHandler := subscriptionsv1.NewHandler(log, subscriptionsSvc)
+	experimentsHandler := experimentsv1.NewHandler(log, experimentsSvc)
+	flagsHandler := flagsv1.NewHandler(log, flagsSvc)
+	disputesHandler := disputesv1.NewHandler(log, disputesSvc)
+	listsHandler := listsv1.NewHandler(log, listsSvc, cnf.ClientIPHeader)
 	mux := http.NewServeMux()
 	logMiddlware := middlwares.LogMiddlware(log)
 	headerMiddlware := middlwares.HeaderMiddlware
+	adminMiddlware := middlwares.AdminMiddlware(cnf.AdminToken)
+	requestIDMiddlware := middlwares.RequestIDMiddlware
+	var userSigner *tokens.Signer
+	if cnf.UserTokenSecret != "" {
+		userSigner = tokens.NewSigner(cnf.UserTokenSecret)
+	}
+	userMiddlware := middlwares.UserMiddlware(userSigner)
 
-	mux.HandleFunc("/api/v1/payment/url", headerMiddlware(logMiddlware(h.Payment())))
+	mux.HandleFunc("/api/v1/payment/url", requestIDMiddlware(headerMiddlware(logMiddlware(userMiddlware(listsHandler.Enforce(h.Payment()))))))
+	mux.HandleFunc("/api/v1/payment/urls", requestIDMiddlware(headerMiddlware(logMiddlware(userMiddlware(listsHandler.Enforce(h.PaymentUrls()))))))
+	mux.HandleFunc("/api/v1/payment/session/revoke", requestIDMiddlware(headerMiddlware(logMiddlware(adminMiddlware(h.RevokeSession())))))
+	mux.HandleFunc("/api/v1/payment/paypal/return", requestIDMiddlware(headerMiddlware(logMiddlware(h.PayPalReturn()))))
+	mux.HandleFunc("/api/v1/payment/webhooks/", requestIDMiddlware(headerMiddlware(logMiddlware(h.Webhook()))))
+	mux.HandleFunc("/pay/", requestIDMiddlware(headerMiddlware(logMiddlware(listsHandler.Enforce(h.Redirect())))))
+	mux.HandleFunc("/r/store/", requestIDMiddlware(headerMiddlware(logMiddlware(clicksHandler.StoreRedirect()))))
+	mux.HandleFunc("/api/v1/prices", requestIDMiddlware(headerMiddlware(logMiddlware(pricingHandler.Price()))))
+	mux.HandleFunc("/api/v1/fx/rates", requestIDMiddlware(headerMiddlware(logMiddlware(adminMiddlware(pricingHandler.Rates())))))
+	mux.HandleFunc("/api/v1/plans", requestIDMiddlware(headerMiddlware(logMiddlware(subscriptionsHandler.Plans()))))
+	mux.HandleFunc("/api/v1/promotions", requestIDMiddlware(headerMiddlware(logMiddlware(adminMiddlware(promotionsHandler.Promotions())))))
+	mux.HandleFunc("/api/v1/experiments", requestIDMiddlware(headerMiddlware(logMiddlware(adminMiddlware(experimentsHandler.Experiments())))))
+	mux.HandleFunc("/api/v1/experiments/", requestIDMiddlware(headerMiddlware(logMiddlware(adminMiddlware(experimentsHandler.Experiment())))))
+	mux.HandleFunc("/api/v1/flags", requestIDMiddlware(headerMiddlware(logMiddlware(adminMiddlware(flagsHandler.Flags())))))
+	mux.HandleFunc("/api/v1/flags/", requestIDMiddlware(headerMiddlware(logMiddlware(adminMiddlware(flagsHandler.Flag())))))
+	mux.HandleFunc("/api/v1/disputes", requestIDMiddlware(headerMiddlware(logMiddlware(adminMiddlware(disputesHandler.Disputes())))))
+	mux.HandleFunc("/api/v1/disputes/", requestIDMiddlware(headerMiddlware(logMiddlware(adminMiddlware(disputesHandler.Dispute())))))
+	mux.HandleFunc("/api/v1/disputes/webhooks/", requestIDMiddlware(headerMiddlware(logMiddlware(disputesHandler.Webhook()))))
+	mux.HandleFunc("/api/v1/lists/entries", requestIDMiddlware(headerMiddlware(logMiddlware(adminMiddlware(listsHandler.Entries())))))
+	mux.HandleFunc("/api/v1/lists/entries/", requestIDMiddlware(headerMiddlware(logMiddlware(adminMiddlware(listsHandler.Entry())))))
+	mux.HandleFunc("/api/v1/lists/check", requestIDMiddlware(headerMiddlware(logMiddlware(adminMiddlware(listsHandler.Check())))))
+	mux.HandleFunc("/api/v1/ledger/balances", requestIDMiddlware(headerMiddlware(logMiddlware(adminMiddlware(ledgerHandler.Balances())))))
+	mux.HandleFunc("/api/v1/reconciliation/reports", requestIDMiddlware(headerMiddlware(logMiddlware(adminMiddlware(reconciliationHandler.Reports())))))
+	mux.HandleFunc("/api/v1/reconciliation/reports/", requestIDMiddlware(headerMiddlware(logMiddlware(adminMiddlware(reconciliationHandler.Report())))))
+	mux.HandleFunc("/api/v1/users/", requestIDMiddlware(headerMiddlware(logMiddlware(userMiddlware(entitlementsHandler.UserEntitlements())))))
+	if invoicesSvc != nil {
+		invoicesHandler := invoicesv1.NewHandler(log, invoicesSvc)
+		mux.HandleFunc("/api/v1/invoices/", requestIDMiddlware(headerMiddlware(logMiddlware(userMiddlware(invoicesHandler.Invoice())))))
+	}
+	if riskSvc != nil {
+		riskHandler := riskv1.NewHandler(log, riskSvc)
+		mux.HandleFunc("/api/v1/risk/decisions", requestIDMiddlware(headerMiddlware(logMiddlware(adminMiddlware(riskHandler.Decisions())))))
+		mux.HandleFunc("/api/v1/risk/decisions/", requestIDMiddlware(headerMiddlware(logMiddlware(adminMiddlware(riskHandler.Decision())))))
+	}
+	if appStore := newAppStore(log, cnf.AppStore); appStore != nil {
+		appStoreHandler := appstorev1.NewHandler(log, appstoresvc.NewAppStoreService(log, appStore, entitlementRepo))
+		mux.HandleFunc("/api/v1/appstore/transactions", requestIDMiddlware(headerMiddlware(logMiddlware(userMiddlware(appStoreHandler.Transaction())))))
+	}
+	if googlePlay := newGooglePlay(log, cnf.GooglePlay); googlePlay != nil {
+		googlePlayHandler := googleplayv1.NewHandler(log, googleplaysvc.NewGooglePlayService(log, googlePlay, entitlementRepo), cnf.GooglePlay.PushToken)
+		mux.HandleFunc("/api/v1/googleplay/purchases", requestIDMiddlware(headerMiddlware(logMiddlware(userMiddlware(googlePlayHandler.Purchase())))))
+		if cnf.GooglePlay.PushToken != "" {
+			mux.HandleFunc("/api/v1/googleplay/notifications", requestIDMiddlware(headerMiddlware(logMiddlware(googlePlayHandler.Notification()))))
+		}
+	}
 	svr := http.Server{
 		Addr:    cnf.Service.Host + ":" + cnf.Service.Port,
 		Handler: mux,
 	}
 
+	// Background jobs stop together with the server
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	checker := ledger.NewChecker(log, ledgerRepo, ledger.NewLogAlerter(log), cnf.Ledger.CheckInterval)
+	go checker.Run(jobsCtx)
+	go promotionsSvc.Run(jobsCtx, cnf.Promotions.ReleaseInterval)
+	go flagsSvc.Run(jobsCtx, cnf.Flags.RefreshInterval)
+	go listsSvc.Run(jobsCtx, cnf.Lists.RefreshInterval)
+	if cnf.Settlement.Dir != "" {
+		go reconciliationSvc.Run(jobsCtx, cnf.Settlement.ImportInterval)
+	}
+
 	go func() {
 		if err := svr.ListenAndServe(); err != nil {
 			if errors.Is(err, http.ErrServerClosed) {
@@ -59,9 +273,56 @@ func Run(log *zap.SugaredLogger, cnf *config.Config, conn *sql.DB) {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
+	stopJobs()
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 	if err := svr.Shutdown(ctx); err != nil {
 		log.Errorf("failed to gracefully shutdown the server, error: %v", err)
 	}
 }
+
+// newAppStore builds App Store integration, nil is returned when it is not configured
+func newAppStore(log *zap.SugaredLogger, cnf config.ConfigAppStore) *appstore.AppStore {
+	if cnf.RootCertPath == "" {
+		return nil
+	}
+	roots, err := appstore.LoadRootCerts(cnf.RootCertPath)
+	if err != nil {
+		log.Errorf("failed to load app store root certificates, error: %v", err)
+		return nil
+	}
+	appStoreCnf := appstore.Config{
+		BaseUrl:     cnf.BaseUrl,
+		BundleID:    cnf.BundleID,
+		Environment: cnf.Environment,
+		IssuerID:    cnf.IssuerID,
+		KeyID:       cnf.KeyID,
+		Roots:       roots,
+	}
+	if cnf.PrivateKeyPath != "" {
+		key, err := appstore.LoadPrivateKey(cnf.PrivateKeyPath)
+		if err != nil {
+			log.Errorf("failed to load app store private key, error: %v", err)
+			return nil
+		}
+		appStoreCnf.PrivateKey = key
+	}
+	return appstore.NewAppStore(log, appStoreCnf)
+}
+
+// newGooglePlay builds Google Play integration, nil is returned when it is not configured
+func newGooglePlay(log *zap.SugaredLogger, cnf config.ConfigGooglePlay) *googleplay.GooglePlay {
+	if cnf.ServiceAccountPath == "" {
+		return nil
+	}
+	account, err := googleplay.LoadServiceAccount(cnf.ServiceAccountPath)
+	if err != nil {
+		log.Errorf("failed to load google play service account, error: %v", err)
+		return nil
+	}
+	return googleplay.NewGooglePlay(log, googleplay.Config{
+		BaseUrl:     cnf.BaseUrl,
+		PackageName: cnf.PackageName,
+		Account:     account,
+	})
+}
@@ -0,0 +1,88 @@
+package appstore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"payment-api/internal/integrations/appstore"
+	"payment-api/internal/models"
+)
+
+// AppStore verifies transactions signed by Apple
+type AppStore interface {
+	VerifyTransaction(signed string) (*appstore.Transaction, error)
+	Transaction(ctx context.Context, transactionID string) (*appstore.Transaction, error)
+}
+
+// Repository for entitlements
+type EntitlementRepo interface {
+	Upsert(ctx context.Context, e *models.Entitlement) error
+}
+
+type AppStoreService struct {
+	log             *zap.SugaredLogger
+	appStore        AppStore
+	entitlementRepo EntitlementRepo
+}
+
+func NewAppStoreService(log *zap.SugaredLogger, appStore AppStore, entitlementRepo EntitlementRepo) *AppStoreService {
+	return &AppStoreService{log: log, appStore: appStore, entitlementRepo: entitlementRepo}
+}
+
+// TransactionRequest carries either signed transaction sent by the app
+// or id of the transaction to be fetched from the App Store
+type TransactionRequest struct {
+	SignedTransaction string
+	TransactionID     string
+	// UserID is the authenticated caller, it is used when the app didn't set appAccountToken at purchase
+	UserID string
+}
+
+// VerifyTransaction verifies the transaction and persists entitlement it grants,
+// the entitlement stays with the user it was bound to first
+func (s *AppStoreService) VerifyTransaction(ctx context.Context, req TransactionRequest) (*models.Entitlement, error) {
+	var tx *appstore.Transaction
+	var err error
+	if req.SignedTransaction != "" {
+		tx, err = s.appStore.VerifyTransaction(req.SignedTransaction)
+	} else {
+		tx, err = s.appStore.Transaction(ctx, req.TransactionID)
+	}
+	if err != nil {
+		s.log.Errorw("failed to verify app store transaction",
+			"transactionID", req.TransactionID,
+			"error", err)
+		switch {
+		case errors.Is(err, appstore.ErrTransactionNotFound):
+			return nil, ErrNotFound
+		case errors.Is(err, appstore.ErrUnavailable), errors.Is(err, appstore.ErrUnauthorized):
+			return nil, ErrAppStore
+		default:
+			return nil, ErrInvalidTransaction
+		}
+	}
+
+	userID := tx.AppAccountToken
+	if userID == "" {
+		userID = req.UserID
+	}
+	entitlement := &models.Entitlement{
+		ID:            uuid.NewString(),
+		UserID:        userID,
+		Source:        models.EntitlementSourceAppStore,
+		ProductID:     tx.ProductID,
+		ExternalID:    tx.OriginalTransactionID,
+		TransactionID: tx.TransactionID,
+		PurchasedAt:   tx.PurchaseDate,
+		ExpiresAt:     tx.ExpiresDate,
+		RevokedAt:     tx.RevocationDate,
+	}
+	if err := s.entitlementRepo.Upsert(ctx, entitlement); err != nil {
+		s.log.Errorf("failed to persist entitlement of transaction %v, error: %v", tx.OriginalTransactionID, err)
+		return nil, ErrUnexpectedResult
+	}
+	return entitlement, nil
+}
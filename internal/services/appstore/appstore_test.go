@@ -0,0 +1,103 @@
+package appstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"payment-api/internal/integrations/appstore"
+	"payment-api/internal/models"
+)
+
+// FakeAppStore returns transactions by their signed value or id
+type FakeAppStore struct {
+	Transactions map[string]*appstore.Transaction
+}
+
+func (m *FakeAppStore) VerifyTransaction(signed string) (*appstore.Transaction, error) {
+	tx, ok := m.Transactions[signed]
+	if !ok {
+		return nil, appstore.ErrSignature
+	}
+	return tx, nil
+}
+
+func (m *FakeAppStore) Transaction(ctx context.Context, transactionID string) (*appstore.Transaction, error) {
+	for _, tx := range m.Transactions {
+		if tx.TransactionID == transactionID {
+			return tx, nil
+		}
+	}
+	return nil, appstore.ErrTransactionNotFound
+}
+
+// FakeEntitlementRepo keeps entitlements in memory by their purchase
+type FakeEntitlementRepo struct {
+	Entitlements map[string]*models.Entitlement
+}
+
+func (m *FakeEntitlementRepo) Upsert(ctx context.Context, e *models.Entitlement) error {
+	if stored, ok := m.Entitlements[e.Source+e.ExternalID]; ok && stored.UserID != "" {
+		e.UserID = stored.UserID
+	}
+	m.Entitlements[e.Source+e.ExternalID] = e
+	return nil
+}
+
+func TestAppStoreServiceVerifyTransaction(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+	fakeAppStore := &FakeAppStore{Transactions: map[string]*appstore.Transaction{
+		"signed-with-token": {
+			TransactionID:         "2",
+			OriginalTransactionID: "1",
+			ProductID:             "premium",
+			AppAccountToken:       "user-from-token",
+			ExpiresDate:           &expiresAt,
+		},
+		"signed-without-token": {
+			TransactionID:         "4",
+			OriginalTransactionID: "3",
+			ProductID:             "premium",
+		},
+	}}
+
+	type testCase struct {
+		name           string
+		req            TransactionRequest
+		stored         *models.Entitlement
+		expectedUserID string
+		expectedErr    error
+	}
+	testCases := []testCase{
+		{"success app account token wins", TransactionRequest{SignedTransaction: "signed-with-token", UserID: "user"}, nil, "user-from-token", nil},
+		{"success authenticated user", TransactionRequest{SignedTransaction: "signed-without-token", UserID: "user"}, nil, "user", nil},
+		{"success stored user is kept", TransactionRequest{SignedTransaction: "signed-without-token", UserID: "another"},
+			&models.Entitlement{Source: models.EntitlementSourceAppStore, ExternalID: "3", UserID: "user"}, "user", nil},
+		{"success by transaction id", TransactionRequest{TransactionID: "2"}, nil, "user-from-token", nil},
+		{"fail invalid signature", TransactionRequest{SignedTransaction: "forged"}, nil, "", ErrInvalidTransaction},
+		{"fail unknown transaction id", TransactionRequest{TransactionID: "5"}, nil, "", ErrNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &FakeEntitlementRepo{Entitlements: map[string]*models.Entitlement{}}
+			if tc.stored != nil {
+				repo.Entitlements[tc.stored.Source+tc.stored.ExternalID] = tc.stored
+			}
+			service := NewAppStoreService(zap.NewNop().Sugar(), fakeAppStore, repo)
+			e, err := service.VerifyTransaction(context.Background(), tc.req)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				assert.Empty(t, repo.Entitlements)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedUserID, e.UserID)
+			assert.Equal(t, models.EntitlementSourceAppStore, e.Source)
+			assert.Equal(t, e, repo.Entitlements[models.EntitlementSourceAppStore+e.ExternalID])
+		})
+	}
+}
@@ -0,0 +1,10 @@
+package appstore
+
+import "errors"
+
+var (
+	ErrInvalidTransaction = errors.New("transaction is invalid")
+	ErrNotFound           = errors.New("transaction is not found")
+	ErrAppStore           = errors.New("something happened on the app store side")
+	ErrUnexpectedResult   = errors.New("unexpected error")
+)
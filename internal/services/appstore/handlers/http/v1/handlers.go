@@ -0,0 +1,90 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/middlwares"
+	"payment-api/internal/models"
+	"payment-api/internal/services/appstore"
+)
+
+type AppStore interface {
+	VerifyTransaction(ctx context.Context, req appstore.TransactionRequest) (*models.Entitlement, error)
+}
+
+type Handler struct {
+	log         *zap.SugaredLogger
+	appStoreSvc AppStore
+}
+
+func NewHandler(log *zap.SugaredLogger, appStoreSvc AppStore) *Handler {
+	return &Handler{log: log, appStoreSvc: appStoreSvc}
+}
+
+// Transaction endpoint verifies App Store transaction and returns entitlement it grants,
+// transactions without appAccountToken are bound to the authenticated user
+func (h *Handler) Transaction() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+			return
+		}
+		var body struct {
+			SignedTransaction string `json:"signed_transaction"`
+			TransactionID     string `json:"transaction_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || (body.SignedTransaction == "" && body.TransactionID == "") {
+			writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Missing signed_transaction or transaction_id"})
+			return
+		}
+
+		e, err := h.appStoreSvc.VerifyTransaction(r.Context(), appstore.TransactionRequest{
+			SignedTransaction: body.SignedTransaction,
+			TransactionID:     body.TransactionID,
+			UserID:            middlwares.UserID(r.Context()),
+		})
+		if err != nil {
+			h.log.Errorf("failed to verify app store transaction")
+			switch {
+			case errors.Is(err, appstore.ErrInvalidTransaction):
+				writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Transaction is invalid"})
+			case errors.Is(err, appstore.ErrNotFound):
+				writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Transaction is not found"})
+			case errors.Is(err, appstore.ErrAppStore):
+				writeJson(w, http.StatusBadGateway, map[string]any{"code": http.StatusBadGateway, "message": "App Store is unavailable"})
+			default:
+				writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+			}
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": map[string]any{
+			"user_id":                 e.UserID,
+			"product_id":              e.ProductID,
+			"original_transaction_id": e.ExternalID,
+			"expires_at":              e.ExpiresAt,
+			"active":                  e.Active(time.Now()),
+		}})
+	}
+}
+
+// writeJson peforms write to the response writer and header as json content-type
+func writeJson(w http.ResponseWriter, status int, data map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if data == nil {
+		return
+	}
+
+	enc, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	_, _ = w.Write(enc)
+}
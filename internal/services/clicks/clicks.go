@@ -0,0 +1,55 @@
+package clicks
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"payment-api/internal/integrations/stores"
+	"payment-api/internal/locale"
+	"payment-api/internal/models"
+)
+
+type Stores interface {
+	// AppUrl retrieves url to the app from a provided store
+	AppUrl(name stores.StoreName, loc locale.Locale) (string, error)
+}
+
+// Repository for click events
+type ClickRepo interface {
+	Create(ctx context.Context, c *models.StoreClick) error
+}
+
+type ClickService struct {
+	log       *zap.SugaredLogger
+	stores    Stores
+	clickRepo ClickRepo
+}
+
+func NewClickService(log *zap.SugaredLogger, stores Stores, clickRepo ClickRepo) *ClickService {
+	return &ClickService{log: log, stores: stores, clickRepo: clickRepo}
+}
+
+// Track records the click event and returns url of the store it leads to,
+// localized for the client
+func (s *ClickService) Track(ctx context.Context, click models.StoreClick, loc locale.Locale) (string, error) {
+	url, err := s.stores.AppUrl(stores.StoreName(click.Store), loc)
+	if err != nil {
+		s.log.Errorw("failed to resolve store url",
+			"store", click.Store,
+			"error", err)
+		if errors.Is(err, stores.ErrUnknownStoreName) {
+			return "", ErrUnknownStore
+		}
+		return "", ErrStore
+	}
+
+	click.ID = uuid.NewString()
+	// the client must get to the store even if the click was not recorded
+	if err := s.clickRepo.Create(ctx, &click); err != nil {
+		s.log.Errorf("failed to record click to %v store, error: %v", click.Store, err)
+	}
+	return url, nil
+}
@@ -0,0 +1,113 @@
+package clicks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"payment-api/internal/integrations/stores"
+	"payment-api/internal/locale"
+	"payment-api/internal/models"
+)
+
+// FakeClickRepo keeps click events in memory
+type FakeClickRepo struct {
+	Clicks []*models.StoreClick
+}
+
+func (m *FakeClickRepo) Create(ctx context.Context, c *models.StoreClick) error {
+	m.Clicks = append(m.Clicks, c)
+	return nil
+}
+
+func TestClickServiceTrack(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	stores := stores.NewStore(mockLogger, "../../../assets/stores.json")
+
+	type testCase struct {
+		name        string
+		store       string
+		loc         locale.Locale
+		expectedUrl string
+		expectedErr error
+	}
+	testCases := []testCase{
+		{
+			"success AppleStore",
+			"AppleStore",
+			locale.Locale{},
+			"https://apps.apple.com/us/app/headway-daily-book-summaries/id1457185832",
+			nil,
+		},
+		{
+			"success PlayMarket",
+			"PlayMarket",
+			locale.Locale{},
+			"https://play.google.com/store/apps/details?id=com.headway.books&hl=en",
+			nil,
+		},
+		{
+			"success AppleStore region",
+			"AppleStore",
+			locale.Locale{Languages: []string{"pl"}, Region: "PL"},
+			"https://apps.apple.com/pl/app/headway-daily-book-summaries/id1457185832?l=pl",
+			nil,
+		},
+		{
+			"success AppleStore region and language",
+			"AppleStore",
+			locale.Locale{Languages: []string{"en-gb", "en"}, Region: "UA"},
+			"https://apps.apple.com/ua/app/headway-daily-book-summaries/id1457185832",
+			nil,
+		},
+		{
+			"success AppleStore unknown region",
+			"AppleStore",
+			locale.Locale{Languages: []string{"fr"}, Region: "FR"},
+			"https://apps.apple.com/us/app/headway-daily-book-summaries/id1457185832",
+			nil,
+		},
+		{
+			"success PlayMarket language fallback",
+			"PlayMarket",
+			locale.Locale{Languages: []string{"pl-pl", "pl"}, Region: "DE"},
+			"https://play.google.com/store/apps/details?id=com.headway.books&hl=pl&pli=1",
+			nil,
+		},
+		{
+			"success AppGallery",
+			"AppGallery",
+			locale.Locale{Languages: []string{"pl"}, Region: "PL"},
+			"https://appgallery.huawei.com/app/C101457185",
+			nil,
+		},
+		{
+			"fail unknown store",
+			"WindowsStore",
+			locale.Locale{},
+			"",
+			ErrUnknownStore,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &FakeClickRepo{}
+			service := NewClickService(mockLogger, stores, repo)
+			url, err := service.Track(context.Background(), models.StoreClick{Store: tc.store, ProductID: "product", UtmSource: "email"}, tc.loc)
+			assert.Equal(t, tc.expectedUrl, url)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				assert.Empty(t, repo.Clicks)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Len(t, repo.Clicks, 1)
+			assert.Equal(t, tc.store, repo.Clicks[0].Store)
+			assert.Equal(t, "email", repo.Clicks[0].UtmSource)
+			assert.NotEmpty(t, repo.Clicks[0].ID)
+		})
+	}
+}
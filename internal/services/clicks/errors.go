@@ -0,0 +1,8 @@
+package clicks
+
+import "errors"
+
+var (
+	ErrUnknownStore = errors.New("unknown store")
+	ErrStore        = errors.New("something happened on the stores side")
+)
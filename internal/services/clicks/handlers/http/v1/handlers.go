@@ -0,0 +1,77 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/locale"
+	"payment-api/internal/middlwares"
+	"payment-api/internal/models"
+	"payment-api/internal/services/clicks"
+)
+
+// maxUtmLength is the size of the utm columns
+const maxUtmLength = 255
+
+type Clicks interface {
+	Track(ctx context.Context, click models.StoreClick, loc locale.Locale) (string, error)
+}
+
+type Handler struct {
+	log           *zap.SugaredLogger
+	clicksSvc     Clicks
+	countryHeader string
+}
+
+func NewHandler(log *zap.SugaredLogger, clicksSvc Clicks, countryHeader string) *Handler {
+	return &Handler{log: log, clicksSvc: clicksSvc, countryHeader: countryHeader}
+}
+
+// StoreRedirect endpoint records the click and redirects to the store from the path
+func (h *Handler) StoreRedirect() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		// request id of the payment call which produced the link ties the click to it,
+		// otherwise the id of the current request is used
+		requestID := q.Get("rid")
+		if requestID == "" {
+			requestID = middlwares.RequestID(r.Context())
+		}
+		// values are cut to sizes of the columns, so long links are still tracked
+		click := models.StoreClick{
+			Store:       strings.TrimPrefix(r.URL.Path, "/r/store/"),
+			ProductID:   truncate(q.Get("product"), 64),
+			Platform:    truncate(q.Get("platform"), 32),
+			RequestID:   truncate(requestID, 128),
+			UtmSource:   truncate(q.Get("utm_source"), maxUtmLength),
+			UtmMedium:   truncate(q.Get("utm_medium"), maxUtmLength),
+			UtmCampaign: truncate(q.Get("utm_campaign"), maxUtmLength),
+			UtmTerm:     truncate(q.Get("utm_term"), maxUtmLength),
+			UtmContent:  truncate(q.Get("utm_content"), maxUtmLength),
+		}
+
+		url, err := h.clicksSvc.Track(r.Context(), click, locale.FromRequest(r, h.countryHeader))
+		if err != nil {
+			if errors.Is(err, clicks.ErrUnknownStore) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, "Oops, something went wrong", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, url, http.StatusFound)
+	}
+}
+
+// truncate cuts the value to at most n characters
+func truncate(value string, n int) string {
+	if utf8.RuneCountInString(value) <= n {
+		return value
+	}
+	return string([]rune(value)[:n])
+}
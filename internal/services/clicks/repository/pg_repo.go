@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+type ClickRepo struct {
+	log  *zap.SugaredLogger
+	conn *sql.DB
+}
+
+func NewClickRepo(log *zap.SugaredLogger, conn *sql.DB) *ClickRepo {
+	return &ClickRepo{log: log, conn: conn}
+}
+
+// Create stores a single store click event
+func (r *ClickRepo) Create(ctx context.Context, c *models.StoreClick) error {
+	stmnt := `INSERT INTO store_clicks
+		(id, store, product_id, platform, request_id, utm_source, utm_medium, utm_campaign, utm_term, utm_content)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	_, err := r.conn.ExecContext(ctx, stmnt,
+		c.ID, c.Store, c.ProductID, c.Platform, c.RequestID,
+		c.UtmSource, c.UtmMedium, c.UtmCampaign, c.UtmTerm, c.UtmContent)
+	if err != nil {
+		r.log.Errorw("failed to store click event",
+			"store", c.Store,
+			"error", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package disputes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	intpayment "payment-api/internal/integrations/payment"
+	"payment-api/internal/models"
+	"payment-api/internal/services/disputes/repository"
+	"payment-api/internal/services/ledger"
+	paymentrepo "payment-api/internal/services/payment/repository"
+)
+
+// maxEvidenceSize is the largest document providers accept as dispute evidence
+const maxEvidenceSize = 5 << 20
+
+// evidenceTypes are content types of the documents accepted as evidence, they are sniffed from the content
+var evidenceTypes = map[string]bool{
+	"application/pdf": true,
+	"image/png":       true,
+	"image/jpeg":      true,
+}
+
+// Repository for disputes
+type DisputeRepo interface {
+	Upsert(ctx context.Context, d *models.Dispute) error
+	MarkAccessRevoked(ctx context.Context, id string, at time.Time) error
+	FetchByID(ctx context.Context, id string) (*models.Dispute, error)
+	List(ctx context.Context, status string) ([]models.Dispute, error)
+	AddEvidence(ctx context.Context, e *models.DisputeEvidence) error
+	FetchEvidence(ctx context.Context, disputeID, id string) (*models.DisputeEvidence, error)
+}
+
+// Repository for providers
+type ProviderRepo interface {
+	FetchByID(id string) (*models.Provider, error)
+}
+
+// Repository for payment sessions
+type SessionRepo interface {
+	FetchByID(ctx context.Context, id string) (*models.PaymentSession, error)
+}
+
+// PaymentProvider verifies and parses webhook events of the providers
+type PaymentProvider interface {
+	Dispute(ctx context.Context, name, apiKey, secret string, payload []byte, signature string) (*intpayment.Dispute, error)
+}
+
+// Entitlements revokes access bought with disputed payments
+type Entitlements interface {
+	RevokeWebPayment(ctx context.Context, session *models.PaymentSession, revokedAt time.Time) (bool, error)
+}
+
+// Subscriptions cancels subscriptions bought with payments lost to disputes
+type Subscriptions interface {
+	Cancel(ctx context.Context, sessionID string) error
+}
+
+// Ledger records funds withdrawn by lost disputes
+type Ledger interface {
+	RecordChargeback(ctx context.Context, m ledger.Movement) error
+}
+
+type DisputesService struct {
+	log             *zap.SugaredLogger
+	disputeRepo     DisputeRepo
+	providerRepo    ProviderRepo
+	sessionRepo     SessionRepo
+	paymentProvider PaymentProvider
+	entitlements    Entitlements
+	subscriptions   Subscriptions
+	ledger          Ledger
+	revokeReasons   map[string]bool
+}
+
+// Option configures optional parts of the DisputesService
+type Option func(s *DisputesService)
+
+// WithEntitlements enables revocation of access bought with disputed payments
+func WithEntitlements(e Entitlements) Option {
+	return func(s *DisputesService) {
+		s.entitlements = e
+	}
+}
+
+// WithSubscriptions enables cancellation of subscriptions bought with payments lost to disputes
+func WithSubscriptions(sub Subscriptions) Option {
+	return func(s *DisputesService) {
+		s.subscriptions = sub
+	}
+}
+
+// WithLedger enables chargebacks of lost disputes in the ledger
+func WithLedger(l Ledger) Option {
+	return func(s *DisputesService) {
+		s.ledger = l
+	}
+}
+
+// WithRevokeReasons sets reasons of disputes revoking access as soon as they are opened, e.g. "fraudulent",
+// other disputes revoke access once they are lost
+func WithRevokeReasons(reasons []string) Option {
+	return func(s *DisputesService) {
+		for _, r := range reasons {
+			s.revokeReasons[strings.ToLower(r)] = true
+		}
+	}
+}
+
+func NewDisputesService(log *zap.SugaredLogger, disputeRepo DisputeRepo, providerRepo ProviderRepo, sessionRepo SessionRepo,
+	paymentProvider PaymentProvider, opts ...Option) *DisputesService {
+	s := &DisputesService{
+		log:             log,
+		disputeRepo:     disputeRepo,
+		providerRepo:    providerRepo,
+		sessionRepo:     sessionRepo,
+		paymentProvider: paymentProvider,
+		revokeReasons:   map[string]bool{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// HandleWebhook records the dispute reported by the webhook event of the provider and applies the rules:
+//   - access bought with the payment is revoked when the dispute is lost, or right away for the revoking reasons;
+//   - the subscription bought with the payment is canceled when the dispute is lost, so renewals don't restore access;
+//   - funds of the lost dispute are posted to the ledger as a chargeback.
+//
+// Events of other kinds are ignored and nil is returned. Repeated and out of order events are safe,
+// closed disputes are not reopened and the rules apply once
+func (s *DisputesService) HandleWebhook(ctx context.Context, providerID string, payload []byte, signature string) (*models.Dispute, error) {
+	provider, err := s.providerRepo.FetchByID(providerID)
+	if err != nil {
+		s.log.Errorw("failed to fetch provider by ID",
+			"ID", providerID,
+			"error", err)
+		if errors.Is(err, paymentrepo.ErrNotFound) || errors.Is(err, paymentrepo.ErrUuidInvalidFormat) {
+			return nil, ErrNotFound
+		}
+		return nil, ErrUnexpectedResult
+	}
+	reported, err := s.paymentProvider.Dispute(ctx, provider.Name, provider.ApiKey, provider.Secret, payload, signature)
+	if err != nil {
+		s.log.Errorf("failed to read %v webhook event, error: %v", provider.Name, err)
+		switch {
+		case errors.Is(err, intpayment.ErrSignatureInvalid):
+			return nil, ErrSignatureInvalid
+		case errors.Is(err, intpayment.ErrNotSupported):
+			return nil, ErrNotSupported
+		default:
+			return nil, ErrUnexpectedResult
+		}
+	}
+	if reported == nil {
+		return nil, nil
+	}
+
+	session, err := s.sessionRepo.FetchByID(ctx, reported.SessionID)
+	if err != nil {
+		s.log.Errorf("failed to fetch payment session %v of dispute %v, error: %v", reported.SessionID, reported.ID, err)
+		if errors.Is(err, paymentrepo.ErrNotFound) || errors.Is(err, paymentrepo.ErrUuidInvalidFormat) {
+			return nil, ErrNotFound
+		}
+		return nil, ErrUnexpectedResult
+	}
+	d := &models.Dispute{
+		ID:            uuid.NewString(),
+		ProviderID:    provider.ID,
+		ExternalID:    reported.ID,
+		SessionID:     session.ID,
+		UserID:        session.UserID,
+		Reason:        strings.ToLower(reported.Reason),
+		Status:        reported.Status,
+		Amount:        reported.Amount,
+		Currency:      reported.Currency,
+		EvidenceDueBy: reported.EvidenceDueBy,
+	}
+	if err := s.disputeRepo.Upsert(ctx, d); err != nil {
+		s.log.Errorf("failed to store dispute %v, error: %v", reported.ID, err)
+		return nil, ErrUnexpectedResult
+	}
+
+	if d.Status == models.DisputeLost && s.ledger != nil {
+		err := s.ledger.RecordChargeback(ctx, ledger.Movement{
+			Reference: d.ExternalID,
+			Provider:  provider.Name,
+			Amount:    d.Amount,
+			Currency:  d.Currency,
+		})
+		if err != nil {
+			s.log.Errorf("failed to post chargeback of dispute %v to the ledger, error: %v", d.ID, err)
+			return nil, ErrUnexpectedResult
+		}
+	}
+	if d.Status == models.DisputeLost && session.PlanID != "" && s.subscriptions != nil {
+		if err := s.subscriptions.Cancel(ctx, session.ID); err != nil {
+			s.log.Errorf("failed to cancel subscription of dispute %v, error: %v", d.ID, err)
+			return nil, ErrUnexpectedResult
+		}
+	}
+	if s.revokes(d) {
+		if err := s.revoke(ctx, d, session); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// revokes tells whether the rules revoke access of the dispute which is not revoked yet
+func (s *DisputesService) revokes(d *models.Dispute) bool {
+	if s.entitlements == nil || d.AccessRevokedAt != nil {
+		return false
+	}
+	switch d.Status {
+	case models.DisputeLost:
+		return true
+	case models.DisputeNeedsResponse, models.DisputeUnderReview:
+		return s.revokeReasons[d.Reason]
+	default:
+		return false
+	}
+}
+
+func (s *DisputesService) revoke(ctx context.Context, d *models.Dispute, session *models.PaymentSession) error {
+	now := time.Now().UTC()
+	revoked, err := s.entitlements.RevokeWebPayment(ctx, session, now)
+	if err != nil {
+		s.log.Errorf("failed to revoke access of dispute %v, error: %v", d.ID, err)
+		return ErrUnexpectedResult
+	}
+	// anonymous payments have no access to revoke
+	if !revoked {
+		return nil
+	}
+	if err := s.disputeRepo.MarkAccessRevoked(ctx, d.ID, now); err != nil {
+		s.log.Errorf("failed to mark access of dispute %v revoked, error: %v", d.ID, err)
+		return ErrUnexpectedResult
+	}
+	d.AccessRevokedAt = &now
+	return nil
+}
+
+// Disputes lists disputes of the status, empty status lists every dispute
+func (s *DisputesService) Disputes(ctx context.Context, status string) ([]models.Dispute, error) {
+	disputes, err := s.disputeRepo.List(ctx, status)
+	if err != nil {
+		s.log.Errorf("failed to list disputes, error: %v", err)
+		return nil, ErrUnexpectedResult
+	}
+	return disputes, nil
+}
+
+// Dispute returns the dispute with its evidence
+func (s *DisputesService) Dispute(ctx context.Context, id string) (*models.Dispute, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	d, err := s.disputeRepo.FetchByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		s.log.Errorw("failed to fetch dispute",
+			"id", id,
+			"error", err)
+		return nil, ErrUnexpectedResult
+	}
+	return d, nil
+}
+
+// AddEvidence attaches the document to the open dispute, PDF, PNG and JPEG documents up to 5 MB are accepted
+func (s *DisputesService) AddEvidence(ctx context.Context, disputeID, filename string, content []byte) (*models.DisputeEvidence, error) {
+	filename = filepath.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if filename == "." || filename == "/" || len(filename) > 255 {
+		return nil, fmt.Errorf("%w: filename is missing or too long", ErrEvidenceInvalid)
+	}
+	if len(content) == 0 || len(content) > maxEvidenceSize {
+		return nil, fmt.Errorf("%w: document must be up to %d bytes", ErrEvidenceInvalid, maxEvidenceSize)
+	}
+	contentType := http.DetectContentType(content)
+	if !evidenceTypes[contentType] {
+		return nil, fmt.Errorf("%w: %v documents are not accepted", ErrEvidenceInvalid, contentType)
+	}
+
+	d, err := s.Dispute(ctx, disputeID)
+	if err != nil {
+		return nil, err
+	}
+	if d.Status != models.DisputeNeedsResponse && d.Status != models.DisputeUnderReview {
+		return nil, ErrDisputeClosed
+	}
+	e := &models.DisputeEvidence{
+		ID:          uuid.NewString(),
+		DisputeID:   d.ID,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        int64(len(content)),
+		Content:     content,
+	}
+	if err := s.disputeRepo.AddEvidence(ctx, e); err != nil {
+		s.log.Errorf("failed to add evidence of dispute %v, error: %v", d.ID, err)
+		return nil, ErrUnexpectedResult
+	}
+	return e, nil
+}
+
+// Evidence returns the document of the dispute with its content
+func (s *DisputesService) Evidence(ctx context.Context, disputeID, id string) (*models.DisputeEvidence, error) {
+	if _, err := uuid.Parse(disputeID); err != nil {
+		return nil, ErrNotFound
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	e, err := s.disputeRepo.FetchEvidence(ctx, disputeID, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		s.log.Errorw("failed to fetch dispute evidence",
+			"id", id,
+			"error", err)
+		return nil, ErrUnexpectedResult
+	}
+	return e, nil
+}
@@ -0,0 +1,392 @@
+package disputes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	intpayment "payment-api/internal/integrations/payment"
+	"payment-api/internal/integrations/payment/simulator"
+	"payment-api/internal/models"
+	"payment-api/internal/services/disputes/repository"
+	"payment-api/internal/services/ledger"
+	paymentrepo "payment-api/internal/services/payment/repository"
+)
+
+const stripeProviderID = "39251d76-1b3c-470d-969d-c7dade716d97"
+
+// FakeDisputeRepo keeps disputes in memory by their provider id, closed disputes are not reopened
+type FakeDisputeRepo struct {
+	Disputes map[string]*models.Dispute
+	Evidence []models.DisputeEvidence
+}
+
+func NewFakeDisputeRepo() *FakeDisputeRepo {
+	return &FakeDisputeRepo{Disputes: map[string]*models.Dispute{}}
+}
+
+func (m *FakeDisputeRepo) Upsert(ctx context.Context, d *models.Dispute) error {
+	stored, ok := m.Disputes[d.ExternalID]
+	if !ok {
+		stored = d
+		m.Disputes[d.ExternalID] = stored
+	}
+	switch stored.Status {
+	case models.DisputeWon, models.DisputeLost, models.DisputeClosed:
+	default:
+		stored.Status = d.Status
+	}
+	stored.Reason = d.Reason
+	stored.Amount = d.Amount
+	*d = *stored
+	return nil
+}
+
+func (m *FakeDisputeRepo) MarkAccessRevoked(ctx context.Context, id string, at time.Time) error {
+	for _, d := range m.Disputes {
+		if d.ID == id {
+			d.AccessRevokedAt = &at
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+func (m *FakeDisputeRepo) FetchByID(ctx context.Context, id string) (*models.Dispute, error) {
+	for _, d := range m.Disputes {
+		if d.ID == id {
+			found := *d
+			return &found, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (m *FakeDisputeRepo) List(ctx context.Context, status string) ([]models.Dispute, error) {
+	list := []models.Dispute{}
+	for _, d := range m.Disputes {
+		if status == "" || d.Status == status {
+			list = append(list, *d)
+		}
+	}
+	return list, nil
+}
+
+func (m *FakeDisputeRepo) AddEvidence(ctx context.Context, e *models.DisputeEvidence) error {
+	m.Evidence = append(m.Evidence, *e)
+	return nil
+}
+
+func (m *FakeDisputeRepo) FetchEvidence(ctx context.Context, disputeID, id string) (*models.DisputeEvidence, error) {
+	for _, e := range m.Evidence {
+		if e.DisputeID == disputeID && e.ID == id {
+			return &e, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+// FakeProviderRepo knows the only Stripe provider signing webhooks with whsec_test
+type FakeProviderRepo struct{}
+
+func (m *FakeProviderRepo) FetchByID(id string) (*models.Provider, error) {
+	if id != stripeProviderID {
+		return nil, paymentrepo.ErrNotFound
+	}
+	return &models.Provider{ID: id, Name: models.ProviderNameStripe, ApiKey: "sk_test", Secret: "whsec_test"}, nil
+}
+
+// FakeSessionRepo keeps payment sessions in memory by id
+type FakeSessionRepo struct {
+	Sessions map[string]*models.PaymentSession
+}
+
+func (m *FakeSessionRepo) FetchByID(ctx context.Context, id string) (*models.PaymentSession, error) {
+	s, ok := m.Sessions[id]
+	if !ok {
+		return nil, paymentrepo.ErrNotFound
+	}
+	return s, nil
+}
+
+// FakeEntitlements grants access to every session with a user until it is revoked
+type FakeEntitlements struct {
+	Revoked map[string]int
+	Err     error
+}
+
+func (m *FakeEntitlements) RevokeWebPayment(ctx context.Context, session *models.PaymentSession, revokedAt time.Time) (bool, error) {
+	if m.Err != nil {
+		return false, m.Err
+	}
+	if session.UserID == "" || m.Revoked[session.ID] > 0 {
+		return false, nil
+	}
+	m.Revoked[session.ID]++
+	return true, nil
+}
+
+// FakeSubscriptions remembers canceled subscriptions by session
+type FakeSubscriptions struct {
+	Canceled map[string]bool
+}
+
+func (m *FakeSubscriptions) Cancel(ctx context.Context, sessionID string) error {
+	m.Canceled[sessionID] = true
+	return nil
+}
+
+// FakeLedger remembers chargebacks by reference
+type FakeLedger struct {
+	Chargebacks map[string]ledger.Movement
+}
+
+func (m *FakeLedger) RecordChargeback(ctx context.Context, mv ledger.Movement) error {
+	m.Chargebacks[mv.Reference] = mv
+	return nil
+}
+
+// webhookRecorder keeps the last event delivered by the simulator
+type webhookRecorder struct {
+	payload   []byte
+	signature string
+}
+
+func (rec *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec.payload, _ = io.ReadAll(r.Body)
+	rec.signature = r.Header.Get(simulator.SignatureHeader)
+}
+
+func TestDisputesServiceHandleWebhook(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	rec := &webhookRecorder{}
+	webhook := httptest.NewServer(rec)
+	defer webhook.Close()
+	sim := simulator.NewServer(mockLogger, simulator.WithApiKey("sk_test"), simulator.WithWebhook(webhook.URL, "whsec_test"))
+	srv := httptest.NewServer(sim)
+	defer srv.Close()
+	stripe := intpayment.NewStripe(mockLogger, intpayment.StripeConfig{
+		BaseUrl:    srv.URL,
+		SuccessUrl: "https://merchant.test/success",
+		Items:      []intpayment.LineItem{{Name: "Premium", Amount: 1299, Currency: "usd"}},
+	})
+	provider := intpayment.NewPaymentProvider(mockLogger, "../../../assets/providers.json", intpayment.WithStripe(stripe))
+
+	fakeDisputeRepo := NewFakeDisputeRepo()
+	fakeSessionRepo := &FakeSessionRepo{Sessions: map[string]*models.PaymentSession{}}
+	fakeEntitlements := &FakeEntitlements{Revoked: map[string]int{}}
+	fakeLedger := &FakeLedger{Chargebacks: map[string]ledger.Movement{}}
+	fakeSubscriptions := &FakeSubscriptions{Canceled: map[string]bool{}}
+	service := NewDisputesService(mockLogger, fakeDisputeRepo, &FakeProviderRepo{}, fakeSessionRepo, provider,
+		WithEntitlements(fakeEntitlements),
+		WithSubscriptions(fakeSubscriptions),
+		WithLedger(fakeLedger),
+		WithRevokeReasons([]string{"Fraudulent"}),
+	)
+	client := http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	// paidIntent pays a new session of the user on the simulator and returns its payment intent
+	paidIntent := func(userID string) (*models.PaymentSession, string) {
+		session := &models.PaymentSession{ID: uuid.NewString(), UserID: userID, Amount: 1299, Currency: "usd"}
+		fakeSessionRepo.Sessions[session.ID] = session
+		checkout, err := stripe.CreateCheckout(context.Background(), "sk_test", intpayment.Checkout{ReferenceID: session.ID})
+		assert.NoError(t, err)
+		resp, err := client.Get(checkout.Url)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		events := sim.Events()
+		return session, events[len(events)-1].Data.Object.(simulator.Session).PaymentIntent
+	}
+	handle := func() (*models.Dispute, error) {
+		return service.HandleWebhook(context.Background(), stripeProviderID, rec.payload, rec.signature)
+	}
+
+	t.Run("success fraudulent dispute revokes access right away", func(t *testing.T) {
+		session, intent := paidIntent("user-1")
+		opened, err := sim.OpenDispute(intent, "fraudulent")
+		assert.NoError(t, err)
+		d, err := handle()
+		assert.NoError(t, err)
+		assert.Equal(t, opened.ID, d.ExternalID)
+		assert.Equal(t, session.ID, d.SessionID)
+		assert.Equal(t, "user-1", d.UserID)
+		assert.Equal(t, models.DisputeNeedsResponse, d.Status)
+		assert.Equal(t, int64(1299), d.Amount)
+		assert.Equal(t, opened.EvidenceDetails.DueBy, d.EvidenceDueBy.Unix())
+		assert.NotNil(t, d.AccessRevokedAt)
+		assert.Equal(t, 1, fakeEntitlements.Revoked[session.ID])
+		assert.Empty(t, fakeLedger.Chargebacks)
+
+		// the same event delivered again changes nothing
+		_, err = handle()
+		assert.NoError(t, err)
+		assert.Len(t, fakeDisputeRepo.Disputes, 1)
+		assert.Equal(t, 1, fakeEntitlements.Revoked[session.ID])
+	})
+
+	t.Run("success other dispute revokes access once lost", func(t *testing.T) {
+		session, intent := paidIntent("user-2")
+		session.PlanID = "monthly"
+		opened, err := sim.OpenDispute(intent, "product_not_received")
+		assert.NoError(t, err)
+		d, err := handle()
+		assert.NoError(t, err)
+		assert.Nil(t, d.AccessRevokedAt)
+		assert.Zero(t, fakeEntitlements.Revoked[session.ID])
+		assert.False(t, fakeSubscriptions.Canceled[session.ID])
+
+		_, err = sim.UpdateDispute(opened.ID, "under_review")
+		assert.NoError(t, err)
+		d, err = handle()
+		assert.NoError(t, err)
+		assert.Equal(t, models.DisputeUnderReview, d.Status)
+		assert.Nil(t, d.AccessRevokedAt)
+
+		_, err = sim.UpdateDispute(opened.ID, "lost")
+		assert.NoError(t, err)
+		d, err = handle()
+		assert.NoError(t, err)
+		assert.Equal(t, models.DisputeLost, d.Status)
+		assert.NotNil(t, d.AccessRevokedAt)
+		assert.Equal(t, 1, fakeEntitlements.Revoked[session.ID])
+		// the subscription is canceled, so its renewals don't restore access
+		assert.True(t, fakeSubscriptions.Canceled[session.ID])
+		assert.Equal(t, ledger.Movement{Reference: opened.ID, Provider: models.ProviderNameStripe, Amount: 1299, Currency: "usd"},
+			fakeLedger.Chargebacks[opened.ID])
+	})
+
+	t.Run("success won dispute keeps access", func(t *testing.T) {
+		session, intent := paidIntent("user-3")
+		opened, err := sim.OpenDispute(intent, "subscription_canceled")
+		assert.NoError(t, err)
+		_, err = handle()
+		assert.NoError(t, err)
+		updated := rec.payload
+
+		_, err = sim.UpdateDispute(opened.ID, "won")
+		assert.NoError(t, err)
+		d, err := handle()
+		assert.NoError(t, err)
+		assert.Equal(t, models.DisputeWon, d.Status)
+		assert.Nil(t, d.AccessRevokedAt)
+		assert.NotContains(t, fakeLedger.Chargebacks, opened.ID)
+
+		// late update does not reopen the closed dispute
+		d, err = service.HandleWebhook(context.Background(), stripeProviderID, updated, simulator.Sign("whsec_test", updated, time.Now()))
+		assert.NoError(t, err)
+		assert.Equal(t, models.DisputeWon, d.Status)
+		assert.Zero(t, fakeEntitlements.Revoked[session.ID])
+	})
+
+	t.Run("success anonymous payment", func(t *testing.T) {
+		_, intent := paidIntent("")
+		_, err := sim.OpenDispute(intent, "fraudulent")
+		assert.NoError(t, err)
+		d, err := handle()
+		assert.NoError(t, err)
+		assert.Nil(t, d.AccessRevokedAt)
+	})
+
+	t.Run("success other event is ignored", func(t *testing.T) {
+		paidIntent("user-4")
+		d, err := handle()
+		assert.NoError(t, err)
+		assert.Nil(t, d)
+	})
+
+	t.Run("fail revocation", func(t *testing.T) {
+		_, intent := paidIntent("user-5")
+		_, err := sim.OpenDispute(intent, "fraudulent")
+		assert.NoError(t, err)
+		fakeEntitlements.Err = errors.New("connection refused")
+		_, err = handle()
+		assert.ErrorIs(t, err, ErrUnexpectedResult)
+
+		// the provider retries the event
+		fakeEntitlements.Err = nil
+		d, err := handle()
+		assert.NoError(t, err)
+		assert.NotNil(t, d.AccessRevokedAt)
+	})
+
+	t.Run("fail unknown session", func(t *testing.T) {
+		_, intent := paidIntent("user-6")
+		_, err := sim.OpenDispute(intent, "fraudulent")
+		assert.NoError(t, err)
+		fakeSessionRepo.Sessions = map[string]*models.PaymentSession{}
+		_, err = handle()
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	type testCase struct {
+		name       string
+		providerID string
+		signature  string
+		err        error
+	}
+	testCases := []testCase{
+		{name: "fail signature", providerID: stripeProviderID, signature: simulator.Sign("whsec_wrong", rec.payload, time.Now()), err: ErrSignatureInvalid},
+		{name: "fail unknown provider", providerID: uuid.NewString(), signature: rec.signature, err: ErrNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := service.HandleWebhook(context.Background(), tc.providerID, rec.payload, tc.signature)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestDisputesServiceAddEvidence(t *testing.T) {
+	fakeDisputeRepo := NewFakeDisputeRepo()
+	open := &models.Dispute{ID: uuid.NewString(), ExternalID: "dp_open", Status: models.DisputeNeedsResponse}
+	lost := &models.Dispute{ID: uuid.NewString(), ExternalID: "dp_lost", Status: models.DisputeLost}
+	fakeDisputeRepo.Disputes[open.ExternalID] = open
+	fakeDisputeRepo.Disputes[lost.ExternalID] = lost
+	service := NewDisputesService(zap.NewNop().Sugar(), fakeDisputeRepo, &FakeProviderRepo{}, nil, nil)
+
+	pdf := []byte("%PDF-1.4\n%receipt")
+	type testCase struct {
+		name      string
+		disputeID string
+		filename  string
+		content   []byte
+		err       error
+	}
+	testCases := []testCase{
+		{name: "success", disputeID: open.ID, filename: "receipt.pdf", content: pdf},
+		{name: "success path is stripped", disputeID: open.ID, filename: `C:\Users\ops\usage.png`, content: []byte("\x89PNG\r\n\x1a\n")},
+		{name: "fail text", disputeID: open.ID, filename: "notes.txt", content: []byte("customer used the app"), err: ErrEvidenceInvalid},
+		{name: "fail empty", disputeID: open.ID, filename: "receipt.pdf", err: ErrEvidenceInvalid},
+		{name: "fail too large", disputeID: open.ID, filename: "receipt.pdf", content: append(pdf, bytes.Repeat([]byte("0"), maxEvidenceSize)...), err: ErrEvidenceInvalid},
+		{name: "fail no filename", disputeID: open.ID, content: pdf, err: ErrEvidenceInvalid},
+		{name: "fail closed", disputeID: lost.ID, filename: "receipt.pdf", content: pdf, err: ErrDisputeClosed},
+		{name: "fail unknown", disputeID: uuid.NewString(), filename: "receipt.pdf", content: pdf, err: ErrNotFound},
+		{name: "fail malformed id", disputeID: "dp_open", filename: "receipt.pdf", content: pdf, err: ErrNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := service.AddEvidence(context.Background(), tc.disputeID, tc.filename, tc.content)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+	assert.Len(t, fakeDisputeRepo.Evidence, 2)
+	assert.Equal(t, "application/pdf", fakeDisputeRepo.Evidence[0].ContentType)
+	assert.Equal(t, "usage.png", fakeDisputeRepo.Evidence[1].Filename)
+
+	e, err := service.Evidence(context.Background(), open.ID, fakeDisputeRepo.Evidence[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, pdf, e.Content)
+	_, err = service.Evidence(context.Background(), lost.ID, fakeDisputeRepo.Evidence[0].ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
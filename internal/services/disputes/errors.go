@@ -0,0 +1,12 @@
+package disputes
+
+import "errors"
+
+var (
+	ErrNotFound         = errors.New("dispute is not found")
+	ErrSignatureInvalid = errors.New("webhook signature is invalid")
+	ErrNotSupported     = errors.New("provider does not report disputes")
+	ErrEvidenceInvalid  = errors.New("evidence document is invalid")
+	ErrDisputeClosed    = errors.New("dispute is closed")
+	ErrUnexpectedResult = errors.New("unexpected error")
+)
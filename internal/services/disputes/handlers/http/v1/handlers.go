@@ -0,0 +1,253 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/services/disputes"
+)
+
+const (
+	// signatureHeader carries signature of the webhook event, only Stripe reports disputes so far
+	signatureHeader = "Stripe-Signature"
+	maxEventSize    = 1 << 20
+	// maxEvidenceUpload leaves room for the multipart envelope around the largest accepted document
+	maxEvidenceUpload = 6 << 20
+)
+
+type Disputes interface {
+	HandleWebhook(ctx context.Context, providerID string, payload []byte, signature string) (*models.Dispute, error)
+	Disputes(ctx context.Context, status string) ([]models.Dispute, error)
+	Dispute(ctx context.Context, id string) (*models.Dispute, error)
+	AddEvidence(ctx context.Context, disputeID, filename string, content []byte) (*models.DisputeEvidence, error)
+	Evidence(ctx context.Context, disputeID, id string) (*models.DisputeEvidence, error)
+}
+
+type Handler struct {
+	log         *zap.SugaredLogger
+	disputesSvc Disputes
+}
+
+func NewHandler(log *zap.SugaredLogger, disputesSvc Disputes) *Handler {
+	return &Handler{log: log, disputesSvc: disputesSvc}
+}
+
+type disputeBody struct {
+	ID              string         `json:"id"`
+	ProviderID      string         `json:"provider_id"`
+	ExternalID      string         `json:"external_id"`
+	SessionID       string         `json:"session_id"`
+	UserID          string         `json:"user_id,omitempty"`
+	Reason          string         `json:"reason"`
+	Status          string         `json:"status"`
+	Amount          int64          `json:"amount"`
+	Currency        string         `json:"currency"`
+	EvidenceDueBy   *time.Time     `json:"evidence_due_by"`
+	AccessRevokedAt *time.Time     `json:"access_revoked_at"`
+	Evidence        []evidenceBody `json:"evidence,omitempty"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+}
+
+type evidenceBody struct {
+	ID          string    `json:"id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Webhook endpoint receives events of the provider on POST /api/v1/disputes/webhooks/{providerID},
+// events are authenticated with their signature
+func (h *Handler) Webhook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+			return
+		}
+		providerID, _ := strings.CutPrefix(r.URL.Path, "/api/v1/disputes/webhooks/")
+		payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxEventSize))
+		if err != nil {
+			writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Provided parameter has bad format"})
+			return
+		}
+
+		if _, err := h.disputesSvc.HandleWebhook(r.Context(), providerID, payload, r.Header.Get(signatureHeader)); err != nil {
+			h.log.Errorf("failed to handle dispute webhook")
+			switch {
+			case errors.Is(err, disputes.ErrSignatureInvalid):
+				writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Signature is invalid"})
+			case errors.Is(err, disputes.ErrNotSupported):
+				writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Provider does not send webhooks"})
+			case errors.Is(err, disputes.ErrNotFound):
+				writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Not found"})
+			default:
+				writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+			}
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "message": "Event is processed"})
+	}
+}
+
+// Disputes endpoint lists disputes on GET, optionally of the status
+func (h *Handler) Disputes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+			return
+		}
+		list, err := h.disputesSvc.Disputes(r.Context(), r.URL.Query().Get("status"))
+		if err != nil {
+			h.log.Errorf("failed to list disputes")
+			writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+			return
+		}
+		items := make([]disputeBody, 0, len(list))
+		for i := range list {
+			items = append(items, toBody(&list[i]))
+		}
+		writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": items})
+	}
+}
+
+// Dispute endpoint returns the dispute with its evidence on GET /api/v1/disputes/{id},
+// attaches the document sent as multipart "file" field on POST /api/v1/disputes/{id}/evidence
+// and returns the document on GET /api/v1/disputes/{id}/evidence/{evidenceID}
+func (h *Handler) Dispute() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rest, _ := strings.CutPrefix(r.URL.Path, "/api/v1/disputes/")
+		parts := strings.Split(rest, "/")
+		switch {
+		case len(parts) == 1 && parts[0] != "":
+			if r.Method != http.MethodGet {
+				writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+				return
+			}
+			h.dispute(w, r, parts[0])
+		case len(parts) == 2 && parts[1] == "evidence":
+			if r.Method != http.MethodPost {
+				writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+				return
+			}
+			h.addEvidence(w, r, parts[0])
+		case len(parts) == 3 && parts[1] == "evidence":
+			if r.Method != http.MethodGet {
+				writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+				return
+			}
+			h.evidence(w, r, parts[0], parts[2])
+		default:
+			writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Not found"})
+		}
+	}
+}
+
+func (h *Handler) dispute(w http.ResponseWriter, r *http.Request, id string) {
+	d, err := h.disputesSvc.Dispute(r.Context(), id)
+	if err != nil {
+		h.log.Errorf("failed to fetch dispute")
+		writeError(w, err)
+		return
+	}
+	writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": toBody(d)})
+}
+
+func (h *Handler) addEvidence(w http.ResponseWriter, r *http.Request, disputeID string) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxEvidenceUpload)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Provided parameter has bad format"})
+		return
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Provided parameter has bad format"})
+		return
+	}
+
+	e, err := h.disputesSvc.AddEvidence(r.Context(), disputeID, header.Filename, content)
+	if err != nil {
+		h.log.Errorf("failed to add dispute evidence")
+		writeError(w, err)
+		return
+	}
+	writeJson(w, http.StatusCreated, map[string]any{"code": http.StatusCreated, "data": toEvidenceBody(e)})
+}
+
+func (h *Handler) evidence(w http.ResponseWriter, r *http.Request, disputeID, id string) {
+	e, err := h.disputesSvc.Evidence(r.Context(), disputeID, id)
+	if err != nil {
+		h.log.Errorf("failed to fetch dispute evidence")
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", e.ContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+strings.ReplaceAll(e.Filename, `"`, "")+`"`)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(e.Content)
+}
+
+func toBody(d *models.Dispute) disputeBody {
+	body := disputeBody{
+		ID:              d.ID,
+		ProviderID:      d.ProviderID,
+		ExternalID:      d.ExternalID,
+		SessionID:       d.SessionID,
+		UserID:          d.UserID,
+		Reason:          d.Reason,
+		Status:          d.Status,
+		Amount:          d.Amount,
+		Currency:        d.Currency,
+		EvidenceDueBy:   d.EvidenceDueBy,
+		AccessRevokedAt: d.AccessRevokedAt,
+		CreatedAt:       d.CreatedAt,
+		UpdatedAt:       d.UpdatedAt,
+	}
+	for i := range d.Evidence {
+		body.Evidence = append(body.Evidence, toEvidenceBody(&d.Evidence[i]))
+	}
+	return body
+}
+
+func toEvidenceBody(e *models.DisputeEvidence) evidenceBody {
+	return evidenceBody{ID: e.ID, Filename: e.Filename, ContentType: e.ContentType, Size: e.Size, CreatedAt: e.CreatedAt}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, disputes.ErrNotFound):
+		writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Not found"})
+	case errors.Is(err, disputes.ErrEvidenceInvalid):
+		writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": err.Error()})
+	case errors.Is(err, disputes.ErrDisputeClosed):
+		writeJson(w, http.StatusConflict, map[string]any{"code": http.StatusConflict, "message": "Dispute is closed"})
+	default:
+		writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+	}
+}
+
+// writeJson peforms write to the response writer and header as json content-type
+func writeJson(w http.ResponseWriter, status int, data map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if data == nil {
+		return
+	}
+
+	enc, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	_, _ = w.Write(enc)
+}
@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+const disputeColumns = `id, provider_id, external_id, session_id, COALESCE(user_id, ''), reason, status, amount, currency,
+	evidence_due_by, access_revoked_at, created_at, updated_at`
+
+type DisputeRepo struct {
+	log  *zap.SugaredLogger
+	conn *sql.DB
+}
+
+func NewDisputeRepo(log *zap.SugaredLogger, conn *sql.DB) *DisputeRepo {
+	return &DisputeRepo{log: log, conn: conn}
+}
+
+// Upsert stores the dispute reported by the provider or updates the stored one. Closed disputes
+// are not reopened by updates delivered out of order. The stored dispute is returned into d
+func (r *DisputeRepo) Upsert(ctx context.Context, d *models.Dispute) error {
+	stmnt := `INSERT INTO disputes (id, provider_id, external_id, session_id, user_id, reason, status, amount, currency,
+		evidence_due_by) VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10)
+		ON CONFLICT (provider_id, external_id) DO UPDATE SET
+			reason = EXCLUDED.reason,
+			status = CASE WHEN disputes.status IN ('won', 'lost', 'closed') THEN disputes.status ELSE EXCLUDED.status END,
+			amount = EXCLUDED.amount,
+			evidence_due_by = COALESCE(EXCLUDED.evidence_due_by, disputes.evidence_due_by),
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING ` + disputeColumns
+	stored, err := scanDispute(r.conn.QueryRowContext(ctx, stmnt, d.ID, d.ProviderID, d.ExternalID, d.SessionID, d.UserID,
+		d.Reason, d.Status, d.Amount, d.Currency, d.EvidenceDueBy))
+	if err != nil {
+		r.log.Errorw("failed to upsert dispute",
+			"externalID", d.ExternalID,
+			"error", err)
+		return err
+	}
+	*d = *stored
+	return nil
+}
+
+// MarkAccessRevoked records the time access bought with the disputed payment was revoked
+func (r *DisputeRepo) MarkAccessRevoked(ctx context.Context, id string, at time.Time) error {
+	stmnt := "UPDATE disputes SET access_revoked_at = COALESCE(access_revoked_at, $2) WHERE id = $1"
+	res, err := r.conn.ExecContext(ctx, stmnt, id, at)
+	if err != nil {
+		r.log.Errorw("failed to mark dispute access revoked",
+			"id", id,
+			"error", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// FetchByID fetches single dispute by id with its evidence, contents of the documents are not loaded
+func (r *DisputeRepo) FetchByID(ctx context.Context, id string) (*models.Dispute, error) {
+	stmnt := "SELECT " + disputeColumns + " FROM disputes WHERE id = $1"
+	d, err := scanDispute(r.conn.QueryRowContext(ctx, stmnt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		r.log.Errorw("failed to fetch dispute by ID",
+			"id", id,
+			"error", err)
+		return nil, err
+	}
+
+	stmnt = `SELECT id, dispute_id, filename, content_type, size, created_at FROM dispute_evidence
+		WHERE dispute_id = $1 ORDER BY created_at`
+	rows, err := r.conn.QueryContext(ctx, stmnt, id)
+	if err != nil {
+		r.log.Errorw("failed to fetch dispute evidence",
+			"id", id,
+			"error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	d.Evidence = []models.DisputeEvidence{}
+	for rows.Next() {
+		e := models.DisputeEvidence{}
+		if err := rows.Scan(&e.ID, &e.DisputeID, &e.Filename, &e.ContentType, &e.Size, &e.CreatedAt); err != nil {
+			r.log.Errorf("failed to scan dispute evidence, error: %v", err)
+			return nil, err
+		}
+		d.Evidence = append(d.Evidence, e)
+	}
+	return d, rows.Err()
+}
+
+// List fetches disputes of the status, empty status stands for every dispute, the ones due first go first
+func (r *DisputeRepo) List(ctx context.Context, status string) ([]models.Dispute, error) {
+	stmnt := "SELECT " + disputeColumns + ` FROM disputes WHERE $1 = '' OR status = $1
+		ORDER BY evidence_due_by NULLS LAST, created_at DESC`
+	rows, err := r.conn.QueryContext(ctx, stmnt, status)
+	if err != nil {
+		r.log.Errorf("failed to list disputes, error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	disputes := []models.Dispute{}
+	for rows.Next() {
+		d, err := scanDispute(rows)
+		if err != nil {
+			r.log.Errorf("failed to scan dispute, error: %v", err)
+			return nil, err
+		}
+		disputes = append(disputes, *d)
+	}
+	return disputes, rows.Err()
+}
+
+// AddEvidence stores the document of the dispute
+func (r *DisputeRepo) AddEvidence(ctx context.Context, e *models.DisputeEvidence) error {
+	stmnt := `INSERT INTO dispute_evidence (id, dispute_id, filename, content_type, size, content)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING created_at`
+	if err := r.conn.QueryRowContext(ctx, stmnt, e.ID, e.DisputeID, e.Filename, e.ContentType, e.Size,
+		e.Content).Scan(&e.CreatedAt); err != nil {
+		r.log.Errorw("failed to add dispute evidence",
+			"disputeID", e.DisputeID,
+			"error", err)
+		return err
+	}
+	return nil
+}
+
+// FetchEvidence fetches the document of the dispute with its content
+func (r *DisputeRepo) FetchEvidence(ctx context.Context, disputeID, id string) (*models.DisputeEvidence, error) {
+	stmnt := `SELECT id, dispute_id, filename, content_type, size, content, created_at FROM dispute_evidence
+		WHERE dispute_id = $1 AND id = $2`
+	e := models.DisputeEvidence{}
+	if err := r.conn.QueryRowContext(ctx, stmnt, disputeID, id).Scan(&e.ID, &e.DisputeID, &e.Filename, &e.ContentType,
+		&e.Size, &e.Content, &e.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		r.log.Errorw("failed to fetch dispute evidence",
+			"id", id,
+			"error", err)
+		return nil, err
+	}
+	return &e, nil
+}
+
+// scanner is satisfied by both sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanDispute(row scanner) (*models.Dispute, error) {
+	d := models.Dispute{}
+	if err := row.Scan(&d.ID, &d.ProviderID, &d.ExternalID, &d.SessionID, &d.UserID, &d.Reason, &d.Status, &d.Amount,
+		&d.Currency, &d.EvidenceDueBy, &d.AccessRevokedAt, &d.CreatedAt, &d.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
@@ -0,0 +1,213 @@
+package entitlements
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+const (
+	defaultWebDuration  = 365 * 24 * time.Hour
+	defaultWebProductID = "premium"
+	// user ids of the stores are limited by the column size
+	maxUserIDLength = 64
+)
+
+// sourcePriority breaks ties between entitlements lasting equally long,
+// store subscriptions are preferred since they renew and are managed by the stores
+var sourcePriority = map[string]int{
+	models.EntitlementSourceAppStore:   0,
+	models.EntitlementSourceGooglePlay: 1,
+	models.EntitlementSourceWeb:        2,
+}
+
+// Repository for entitlements
+type EntitlementRepo interface {
+	Upsert(ctx context.Context, e *models.Entitlement) error
+	FetchByUserID(ctx context.Context, userID string) ([]models.Entitlement, error)
+	Revoke(ctx context.Context, source, reference string, revokedAt time.Time) (int64, error)
+}
+
+type EntitlementsService struct {
+	log             *zap.SugaredLogger
+	entitlementRepo EntitlementRepo
+	webDuration     time.Duration
+	webProductID    string
+	now             func() time.Time
+}
+
+// Option configures optional parts of the EntitlementsService
+type Option func(s *EntitlementsService)
+
+// WithWebDuration sets how long access bought with a web payment lasts
+func WithWebDuration(d time.Duration) Option {
+	return func(s *EntitlementsService) {
+		s.webDuration = d
+	}
+}
+
+// WithWebProductID sets product id of the entitlements granted by web payments
+func WithWebProductID(id string) Option {
+	return func(s *EntitlementsService) {
+		s.webProductID = id
+	}
+}
+
+func NewEntitlementsService(log *zap.SugaredLogger, entitlementRepo EntitlementRepo, opts ...Option) *EntitlementsService {
+	s := &EntitlementsService{
+		log:             log,
+		entitlementRepo: entitlementRepo,
+		webDuration:     defaultWebDuration,
+		webProductID:    defaultWebProductID,
+		now:             time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Access is the resolved access of the user across every source
+type Access struct {
+	UserID string
+	// Primary is the entitlement access is granted by, nil when the user has no access
+	Primary *models.Entitlement
+	// Overlapping are active entitlements besides the primary one, e.g. the user is subscribed
+	// in both stores, they are candidates for a refund
+	Overlapping map[string]bool
+	// Entitlements are all entitlements of the user, the latest purchase first
+	Entitlements []models.Entitlement
+}
+
+// Active tells whether the user has access at all
+func (a *Access) Active() bool {
+	return a.Primary != nil
+}
+
+// ExpiresAt is when the access ends, nil stands for no expiry or no access
+func (a *Access) ExpiresAt() *time.Time {
+	if a.Primary == nil {
+		return nil
+	}
+	return a.Primary.ExpiresAt
+}
+
+// GrantWebPayment persists entitlement bought with the captured web payment,
+// repeated calls for the same session keep a single entitlement
+func (s *EntitlementsService) GrantWebPayment(ctx context.Context, session *models.PaymentSession, paidAt time.Time) error {
+	if session.UserID == "" {
+		return nil
+	}
+	expiresAt := paidAt.Add(s.webDuration)
+	e := &models.Entitlement{
+		ID:            uuid.NewString(),
+		UserID:        session.UserID,
+		Source:        models.EntitlementSourceWeb,
+		ProductID:     s.webProductID,
+		ExternalID:    session.ID,
+		TransactionID: session.ProviderSessionID,
+		PurchasedAt:   paidAt,
+		ExpiresAt:     &expiresAt,
+	}
+	if err := s.entitlementRepo.Upsert(ctx, e); err != nil {
+		s.log.Errorf("failed to persist entitlement of payment session %v, error: %v", session.ID, err)
+		return ErrUnexpectedResult
+	}
+	return nil
+}
+
+// RevokeWebPayment revokes access bought with the payment session, either the one-time purchase
+// or the subscription started by it, and tells whether there was any access to revoke
+func (s *EntitlementsService) RevokeWebPayment(ctx context.Context, session *models.PaymentSession, revokedAt time.Time) (bool, error) {
+	n, err := s.entitlementRepo.Revoke(ctx, models.EntitlementSourceWeb, session.ID, revokedAt)
+	if err != nil {
+		s.log.Errorf("failed to revoke access paid with session %v, error: %v", session.ID, err)
+		return false, ErrUnexpectedResult
+	}
+	return n > 0, nil
+}
+
+// GrantSubscription persists entitlement of the subscription bought on the web, it lasts until the end
+// of the current period and is extended with every next period starting at periodStart
+func (s *EntitlementsService) GrantSubscription(ctx context.Context, sub *models.Subscription, periodStart time.Time) error {
+	expiresAt := sub.CurrentPeriodEnd
+	e := &models.Entitlement{
+		ID:            uuid.NewString(),
+		UserID:        sub.UserID,
+		Source:        models.EntitlementSourceWeb,
+		ProductID:     s.webProductID,
+		ExternalID:    sub.ID,
+		TransactionID: sub.SessionID,
+		PurchasedAt:   periodStart,
+		ExpiresAt:     &expiresAt,
+	}
+	if err := s.entitlementRepo.Upsert(ctx, e); err != nil {
+		s.log.Errorf("failed to persist entitlement of subscription %v, error: %v", sub.ID, err)
+		return ErrUnexpectedResult
+	}
+	return nil
+}
+
+// UserAccess resolves access of the user from entitlements of every source.
+// When entitlements overlap the following rules apply:
+//   - revoked and expired entitlements never grant access;
+//   - access is granted by the active entitlement lasting the longest, one without expiry outlasts any other;
+//   - equally lasting entitlements are ordered by source, App Store, Google Play, then web,
+//     and then by the latest purchase;
+//   - durations of overlapping entitlements don't add up, access ends when the primary one expires.
+func (s *EntitlementsService) UserAccess(ctx context.Context, userID string) (*Access, error) {
+	if userID == "" || len(userID) > maxUserIDLength {
+		return nil, ErrUserIDInvalid
+	}
+	entitlements, err := s.entitlementRepo.FetchByUserID(ctx, userID)
+	if err != nil {
+		s.log.Errorw("failed to fetch entitlements",
+			"userID", userID,
+			"error", err)
+		return nil, ErrUnexpectedResult
+	}
+
+	sort.SliceStable(entitlements, func(i, j int) bool {
+		return entitlements[i].PurchasedAt.After(entitlements[j].PurchasedAt)
+	})
+	access := &Access{UserID: userID, Overlapping: map[string]bool{}, Entitlements: entitlements}
+	now := s.now()
+	for i := range entitlements {
+		e := &entitlements[i]
+		if !e.Active(now) {
+			continue
+		}
+		if access.Primary == nil {
+			access.Primary = e
+			continue
+		}
+		if outranks(e, access.Primary) {
+			access.Overlapping[access.Primary.ID] = true
+			access.Primary = e
+		} else {
+			access.Overlapping[e.ID] = true
+		}
+	}
+	return access, nil
+}
+
+// outranks tells whether entitlement a should grant access instead of b
+func outranks(a, b *models.Entitlement) bool {
+	switch {
+	case a.ExpiresAt == nil && b.ExpiresAt != nil:
+		return true
+	case a.ExpiresAt != nil && b.ExpiresAt == nil:
+		return false
+	case a.ExpiresAt != nil && !a.ExpiresAt.Equal(*b.ExpiresAt):
+		return a.ExpiresAt.After(*b.ExpiresAt)
+	}
+	if sourcePriority[a.Source] != sourcePriority[b.Source] {
+		return sourcePriority[a.Source] < sourcePriority[b.Source]
+	}
+	return a.PurchasedAt.After(b.PurchasedAt)
+}
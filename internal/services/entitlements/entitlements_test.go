@@ -0,0 +1,223 @@
+package entitlements
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+// FakeEntitlementRepo keeps entitlements in memory by their purchase
+type FakeEntitlementRepo struct {
+	Entitlements map[string]*models.Entitlement
+	Err          error
+}
+
+func (m *FakeEntitlementRepo) Upsert(ctx context.Context, e *models.Entitlement) error {
+	m.Entitlements[e.Source+e.ExternalID] = e
+	return nil
+}
+
+func (m *FakeEntitlementRepo) FetchByUserID(ctx context.Context, userID string) ([]models.Entitlement, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	entitlements := []models.Entitlement{}
+	for _, e := range m.Entitlements {
+		if e.UserID == userID {
+			entitlements = append(entitlements, *e)
+		}
+	}
+	return entitlements, nil
+}
+
+func (m *FakeEntitlementRepo) Revoke(ctx context.Context, source, reference string, revokedAt time.Time) (int64, error) {
+	if m.Err != nil {
+		return 0, m.Err
+	}
+	var n int64
+	for _, e := range m.Entitlements {
+		if e.Source == source && (e.ExternalID == reference || e.TransactionID == reference) && e.RevokedAt == nil {
+			e.RevokedAt = &revokedAt
+			n++
+		}
+	}
+	return n, nil
+}
+
+func TestEntitlementsServiceUserAccess(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	at := func(days int) *time.Time {
+		t := now.AddDate(0, 0, days)
+		return &t
+	}
+	entitlement := func(id, source string, purchasedDaysAgo int, expiresAt, revokedAt *time.Time) *models.Entitlement {
+		return &models.Entitlement{
+			ID:          id,
+			UserID:      "user",
+			Source:      source,
+			ProductID:   "premium",
+			ExternalID:  id,
+			PurchasedAt: *at(-purchasedDaysAgo),
+			ExpiresAt:   expiresAt,
+			RevokedAt:   revokedAt,
+		}
+	}
+
+	type testCase struct {
+		name                string
+		entitlements        []*models.Entitlement
+		expectedPrimary     string
+		expectedOverlapping []string
+	}
+	testCases := []testCase{
+		{"no entitlements", nil, "", nil},
+		{"expired and revoked don't grant", []*models.Entitlement{
+			entitlement("expired", models.EntitlementSourceAppStore, 40, at(-10), nil),
+			entitlement("revoked", models.EntitlementSourceGooglePlay, 5, at(30), at(-1)),
+		}, "", nil},
+		{"single active", []*models.Entitlement{
+			entitlement("expired", models.EntitlementSourceAppStore, 40, at(-10), nil),
+			entitlement("web", models.EntitlementSourceWeb, 5, at(360), nil),
+		}, "web", nil},
+		{"longest lasting wins", []*models.Entitlement{
+			entitlement("app-store", models.EntitlementSourceAppStore, 5, at(25), nil),
+			entitlement("web", models.EntitlementSourceWeb, 10, at(355), nil),
+		}, "web", []string{"app-store"}},
+		{"no expiry outlasts any", []*models.Entitlement{
+			entitlement("web", models.EntitlementSourceWeb, 10, at(355), nil),
+			entitlement("lifetime", models.EntitlementSourceGooglePlay, 100, nil, nil),
+		}, "lifetime", []string{"web"}},
+		{"stores win equal expiry", []*models.Entitlement{
+			entitlement("web", models.EntitlementSourceWeb, 1, at(30), nil),
+			entitlement("google-play", models.EntitlementSourceGooglePlay, 2, at(30), nil),
+			entitlement("app-store", models.EntitlementSourceAppStore, 3, at(30), nil),
+		}, "app-store", []string{"google-play", "web"}},
+		{"latest purchase wins same source", []*models.Entitlement{
+			entitlement("older", models.EntitlementSourceAppStore, 20, at(30), nil),
+			entitlement("newer", models.EntitlementSourceAppStore, 2, at(30), nil),
+		}, "newer", []string{"older"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &FakeEntitlementRepo{Entitlements: map[string]*models.Entitlement{}}
+			for _, e := range tc.entitlements {
+				repo.Entitlements[e.ID] = e
+			}
+			service := NewEntitlementsService(zap.NewNop().Sugar(), repo)
+			service.now = func() time.Time { return now }
+
+			access, err := service.UserAccess(context.Background(), "user")
+			assert.NoError(t, err)
+			assert.Len(t, access.Entitlements, len(tc.entitlements))
+			if tc.expectedPrimary == "" {
+				assert.False(t, access.Active())
+				assert.Nil(t, access.ExpiresAt())
+				return
+			}
+			assert.True(t, access.Active())
+			assert.Equal(t, tc.expectedPrimary, access.Primary.ID)
+			assert.Equal(t, repo.Entitlements[tc.expectedPrimary].ExpiresAt, access.ExpiresAt())
+			assert.Len(t, access.Overlapping, len(tc.expectedOverlapping))
+			for _, id := range tc.expectedOverlapping {
+				assert.True(t, access.Overlapping[id])
+			}
+		})
+	}
+
+	t.Run("fail invalid user id", func(t *testing.T) {
+		service := NewEntitlementsService(zap.NewNop().Sugar(), &FakeEntitlementRepo{})
+		_, err := service.UserAccess(context.Background(), "")
+		assert.ErrorIs(t, err, ErrUserIDInvalid)
+	})
+
+	t.Run("fail repository", func(t *testing.T) {
+		service := NewEntitlementsService(zap.NewNop().Sugar(), &FakeEntitlementRepo{Err: errors.New("connection refused")})
+		_, err := service.UserAccess(context.Background(), "user")
+		assert.ErrorIs(t, err, ErrUnexpectedResult)
+	})
+}
+
+func TestEntitlementsServiceGrantWebPayment(t *testing.T) {
+	repo := &FakeEntitlementRepo{Entitlements: map[string]*models.Entitlement{}}
+	service := NewEntitlementsService(zap.NewNop().Sugar(), repo, WithWebDuration(30*24*time.Hour), WithWebProductID("web_premium"))
+	paidAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	session := &models.PaymentSession{ID: "session", ProviderSessionID: "order", UserID: "user"}
+	assert.NoError(t, service.GrantWebPayment(context.Background(), session, paidAt))
+	e := repo.Entitlements[models.EntitlementSourceWeb+"session"]
+	assert.Equal(t, "user", e.UserID)
+	assert.Equal(t, "web_premium", e.ProductID)
+	assert.Equal(t, "order", e.TransactionID)
+	assert.Equal(t, paidAt.AddDate(0, 0, 30), *e.ExpiresAt)
+
+	// anonymous checkout grants nothing
+	assert.NoError(t, service.GrantWebPayment(context.Background(), &models.PaymentSession{ID: "anonymous"}, paidAt))
+	assert.Len(t, repo.Entitlements, 1)
+}
+
+func TestEntitlementsServiceGrantSubscription(t *testing.T) {
+	repo := &FakeEntitlementRepo{Entitlements: map[string]*models.Entitlement{}}
+	service := NewEntitlementsService(zap.NewNop().Sugar(), repo, WithWebProductID("web_premium"))
+	trialEnd := time.Date(2024, 6, 8, 0, 0, 0, 0, time.UTC)
+	sub := &models.Subscription{ID: "subscription", UserID: "user", SessionID: "session", CurrentPeriodEnd: trialEnd}
+
+	assert.NoError(t, service.GrantSubscription(context.Background(), sub, trialEnd.AddDate(0, 0, -7)))
+	assert.Equal(t, trialEnd, *repo.Entitlements[models.EntitlementSourceWeb+"subscription"].ExpiresAt)
+
+	// the next period extends the same entitlement
+	sub.CurrentPeriodEnd = trialEnd.AddDate(0, 1, 0)
+	assert.NoError(t, service.GrantSubscription(context.Background(), sub, trialEnd))
+	assert.Len(t, repo.Entitlements, 1)
+	e := repo.Entitlements[models.EntitlementSourceWeb+"subscription"]
+	assert.Equal(t, "web_premium", e.ProductID)
+	assert.Equal(t, trialEnd.AddDate(0, 1, 0), *e.ExpiresAt)
+}
+
+func TestEntitlementsServiceRevokeWebPayment(t *testing.T) {
+	repo := &FakeEntitlementRepo{Entitlements: map[string]*models.Entitlement{}}
+	service := NewEntitlementsService(zap.NewNop().Sugar(), repo)
+	paidAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	revokedAt := paidAt.AddDate(0, 0, 20)
+
+	purchase := &models.PaymentSession{ID: "purchase", ProviderSessionID: "order", UserID: "user"}
+	assert.NoError(t, service.GrantWebPayment(context.Background(), purchase, paidAt))
+	sub := &models.Subscription{ID: "subscription", UserID: "user", SessionID: "subscription-session", CurrentPeriodEnd: paidAt.AddDate(0, 1, 0)}
+	assert.NoError(t, service.GrantSubscription(context.Background(), sub, paidAt))
+
+	type testCase struct {
+		name    string
+		session *models.PaymentSession
+		revoked bool
+		key     string
+	}
+	testCases := []testCase{
+		{name: "success purchase", session: purchase, revoked: true, key: "purchase"},
+		{name: "success subscription", session: &models.PaymentSession{ID: "subscription-session"}, revoked: true, key: "subscription"},
+		{name: "success revoked already", session: purchase, key: "purchase"},
+		{name: "success nothing granted", session: &models.PaymentSession{ID: "anonymous"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			revoked, err := service.RevokeWebPayment(context.Background(), tc.session, revokedAt)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.revoked, revoked)
+			if tc.key != "" {
+				assert.Equal(t, revokedAt, *repo.Entitlements[models.EntitlementSourceWeb+tc.key].RevokedAt)
+			}
+		})
+	}
+
+	t.Run("fail repo", func(t *testing.T) {
+		repo.Err = errors.New("connection refused")
+		defer func() { repo.Err = nil }()
+		_, err := service.RevokeWebPayment(context.Background(), purchase, revokedAt)
+		assert.ErrorIs(t, err, ErrUnexpectedResult)
+	})
+}
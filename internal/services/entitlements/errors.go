@@ -0,0 +1,8 @@
+package entitlements
+
+import "errors"
+
+var (
+	ErrUserIDInvalid    = errors.New("user id is invalid")
+	ErrUnexpectedResult = errors.New("unexpected error")
+)
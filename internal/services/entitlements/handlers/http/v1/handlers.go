@@ -0,0 +1,110 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/middlwares"
+	"payment-api/internal/services/entitlements"
+)
+
+type Entitlements interface {
+	UserAccess(ctx context.Context, userID string) (*entitlements.Access, error)
+}
+
+type Handler struct {
+	log             *zap.SugaredLogger
+	entitlementsSvc Entitlements
+}
+
+func NewHandler(log *zap.SugaredLogger, entitlementsSvc Entitlements) *Handler {
+	return &Handler{log: log, entitlementsSvc: entitlementsSvc}
+}
+
+// UserEntitlements endpoint returns access of the user at /api/v1/users/{id}/entitlements,
+// it is available only to the user authenticated with their token
+func (h *Handler) UserEntitlements() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+			return
+		}
+		rest, _ := strings.CutPrefix(r.URL.EscapedPath(), "/api/v1/users/")
+		escapedID, ok := strings.CutSuffix(rest, "/entitlements")
+		userID, err := url.PathUnescape(escapedID)
+		if !ok || err != nil || strings.Contains(escapedID, "/") {
+			writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Not found"})
+			return
+		}
+		caller := middlwares.UserID(r.Context())
+		if caller == "" {
+			writeJson(w, http.StatusUnauthorized, map[string]any{"code": http.StatusUnauthorized, "message": "Unauthorized"})
+			return
+		}
+		if caller != userID {
+			writeJson(w, http.StatusForbidden, map[string]any{"code": http.StatusForbidden, "message": "Forbidden"})
+			return
+		}
+
+		access, err := h.entitlementsSvc.UserAccess(r.Context(), userID)
+		if err != nil {
+			h.log.Errorf("failed to resolve user entitlements")
+			switch {
+			case errors.Is(err, entitlements.ErrUserIDInvalid):
+				writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Provided parameter has bad format"})
+			default:
+				writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+			}
+			return
+		}
+
+		now := time.Now()
+		items := make([]map[string]any, 0, len(access.Entitlements))
+		for _, e := range access.Entitlements {
+			items = append(items, map[string]any{
+				"source":       e.Source,
+				"product_id":   e.ProductID,
+				"purchased_at": e.PurchasedAt,
+				"expires_at":   e.ExpiresAt,
+				"revoked_at":   e.RevokedAt,
+				"active":       e.Active(now),
+				"primary":      access.Primary != nil && access.Primary.ID == e.ID,
+				"overlapping":  access.Overlapping[e.ID],
+			})
+		}
+		data := map[string]any{
+			"user_id":      access.UserID,
+			"active":       access.Active(),
+			"expires_at":   access.ExpiresAt(),
+			"entitlements": items,
+		}
+		if access.Primary != nil {
+			data["source"] = access.Primary.Source
+			data["product_id"] = access.Primary.ProductID
+		}
+		writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": data})
+	}
+}
+
+// writeJson peforms write to the response writer and header as json content-type
+func writeJson(w http.ResponseWriter, status int, data map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if data == nil {
+		return
+	}
+
+	enc, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	_, _ = w.Write(enc)
+}
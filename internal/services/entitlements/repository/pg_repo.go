@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+type EntitlementRepo struct {
+	log  *zap.SugaredLogger
+	conn *sql.DB
+}
+
+func NewEntitlementRepo(log *zap.SugaredLogger, conn *sql.DB) *EntitlementRepo {
+	return &EntitlementRepo{log: log, conn: conn}
+}
+
+// Upsert stores the entitlement or updates the existing one of the same purchase,
+// transaction older than the stored one doesn't override it. The purchase is never moved
+// to another user once it has one, the stored user is returned in the entitlement.
+// Revocation sticks, later renewals or recoveries of the purchase don't give the access back
+func (r *EntitlementRepo) Upsert(ctx context.Context, e *models.Entitlement) error {
+	stmnt := `INSERT INTO entitlements
+		(id, user_id, source, product_id, external_id, transaction_id, purchased_at, expires_at, revoked_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (source, external_id) DO UPDATE SET
+			user_id = COALESCE(NULLIF(entitlements.user_id, ''), NULLIF(EXCLUDED.user_id, '')),
+			product_id = EXCLUDED.product_id,
+			transaction_id = EXCLUDED.transaction_id,
+			purchased_at = EXCLUDED.purchased_at,
+			expires_at = EXCLUDED.expires_at,
+			revoked_at = COALESCE(entitlements.revoked_at, EXCLUDED.revoked_at),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE entitlements.purchased_at <= EXCLUDED.purchased_at
+		RETURNING id, COALESCE(user_id, ''), revoked_at`
+	err := r.conn.QueryRowContext(ctx, stmnt,
+		e.ID, e.UserID, e.Source, e.ProductID, e.ExternalID, e.TransactionID,
+		e.PurchasedAt, e.ExpiresAt, e.RevokedAt).Scan(&e.ID, &e.UserID, &e.RevokedAt)
+	// nothing is returned when the stored transaction is newer
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		r.log.Errorw("failed to upsert entitlement",
+			"source", e.Source,
+			"externalID", e.ExternalID,
+			"error", err)
+		return err
+	}
+	return nil
+}
+
+// Revoke revokes entitlements of the source purchased with the reference, it is either external id
+// of the purchase or its first transaction, e.g. the session which started the subscription.
+// Entitlements revoked earlier keep their time, number of the entitlements revoked now is returned
+func (r *EntitlementRepo) Revoke(ctx context.Context, source, reference string, revokedAt time.Time) (int64, error) {
+	stmnt := `UPDATE entitlements SET revoked_at = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE source = $1 AND (external_id = $2 OR transaction_id = $2) AND revoked_at IS NULL`
+	res, err := r.conn.ExecContext(ctx, stmnt, source, reference, revokedAt)
+	if err != nil {
+		r.log.Errorw("failed to revoke entitlements",
+			"source", source,
+			"reference", reference,
+			"error", err)
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// FetchByUserID fetches every entitlement of the user, including expired and revoked ones
+func (r *EntitlementRepo) FetchByUserID(ctx context.Context, userID string) ([]models.Entitlement, error) {
+	stmnt := `SELECT id, user_id, source, product_id, external_id, COALESCE(transaction_id, ''),
+		purchased_at, expires_at, revoked_at, created_at, updated_at
+		FROM entitlements WHERE user_id = $1 ORDER BY purchased_at`
+	rows, err := r.conn.QueryContext(ctx, stmnt, userID)
+	if err != nil {
+		r.log.Errorw("failed to fetch entitlements of the user",
+			"userID", userID,
+			"error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entitlements := []models.Entitlement{}
+	for rows.Next() {
+		e := models.Entitlement{}
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Source, &e.ProductID, &e.ExternalID, &e.TransactionID,
+			&e.PurchasedAt, &e.ExpiresAt, &e.RevokedAt, &e.CreatedAt, &e.UpdatedAt); err != nil {
+			r.log.Errorw("failed to scan entitlement",
+				"userID", userID,
+				"error", err)
+			return nil, err
+		}
+		entitlements = append(entitlements, e)
+	}
+	return entitlements, rows.Err()
+}
@@ -0,0 +1,10 @@
+package experiments
+
+import "errors"
+
+var (
+	ErrInvalid          = errors.New("experiment is invalid")
+	ErrDuplicate        = errors.New("experiment key is taken")
+	ErrNotFound         = errors.New("experiment is not found")
+	ErrUnexpectedResult = errors.New("unexpected error")
+)
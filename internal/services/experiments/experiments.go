@@ -0,0 +1,187 @@
+package experiments
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/money"
+	"payment-api/internal/services/experiments/repository"
+)
+
+var keyPattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// Repository for experiments and their exposures
+type ExperimentRepo interface {
+	Create(ctx context.Context, e *models.Experiment) error
+	FetchByKey(ctx context.Context, key string) (*models.Experiment, error)
+	List(ctx context.Context) ([]models.Experiment, error)
+	SetStatus(ctx context.Context, key, status string) error
+	CreateExposure(ctx context.Context, x *models.ExperimentExposure) error
+	Results(ctx context.Context, experimentID string) ([]models.VariantResult, error)
+}
+
+type ExperimentsService struct {
+	log            *zap.SugaredLogger
+	experimentRepo ExperimentRepo
+}
+
+func NewExperimentsService(log *zap.SugaredLogger, experimentRepo ExperimentRepo) *ExperimentsService {
+	return &ExperimentsService{log: log, experimentRepo: experimentRepo}
+}
+
+// Assignment is the variant of the experiment the user is bucketed into
+type Assignment struct {
+	ExperimentID string
+	Variant      models.ExperimentVariant
+}
+
+// Create validates and starts a new experiment
+func (s *ExperimentsService) Create(ctx context.Context, e *models.Experiment) error {
+	e.Key = strings.ToLower(strings.TrimSpace(e.Key))
+	for i := range e.Variants {
+		e.Variants[i].Currency = strings.ToLower(e.Variants[i].Currency)
+	}
+	if err := validate(e); err != nil {
+		return err
+	}
+	e.ID = uuid.NewString()
+	e.Status = models.ExperimentRunning
+	if err := s.experimentRepo.Create(ctx, e); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			return ErrDuplicate
+		}
+		s.log.Errorf("failed to create experiment %v, error: %v", e.Key, err)
+		return ErrUnexpectedResult
+	}
+	return nil
+}
+
+// Experiments returns every experiment
+func (s *ExperimentsService) Experiments(ctx context.Context) ([]models.Experiment, error) {
+	experiments, err := s.experimentRepo.List(ctx)
+	if err != nil {
+		s.log.Errorf("failed to list experiments, error: %v", err)
+		return nil, ErrUnexpectedResult
+	}
+	return experiments, nil
+}
+
+// Stop ends the experiment, its users get the default checkout afterwards while the results are kept
+func (s *ExperimentsService) Stop(ctx context.Context, key string) error {
+	if err := s.experimentRepo.SetStatus(ctx, key, models.ExperimentStopped); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrNotFound
+		}
+		s.log.Errorf("failed to stop experiment %v, error: %v", key, err)
+		return ErrUnexpectedResult
+	}
+	return nil
+}
+
+// Results counts exposed users and conversions of every variant of the experiment
+func (s *ExperimentsService) Results(ctx context.Context, key string) ([]models.VariantResult, error) {
+	e, err := s.fetch(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	results, err := s.experimentRepo.Results(ctx, e.ID)
+	if err != nil {
+		s.log.Errorf("failed to count results of experiment %v, error: %v", key, err)
+		return nil, ErrUnexpectedResult
+	}
+	return results, nil
+}
+
+// Assign buckets the user into a variant of the running experiment, the same user always gets the same variant.
+// Anonymous users can't be bucketed consistently and stopped experiments bucket nobody, so nil is returned for them
+func (s *ExperimentsService) Assign(ctx context.Context, key, userID string) (*Assignment, error) {
+	e, err := s.fetch(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if userID == "" || e.Status != models.ExperimentRunning {
+		return nil, nil
+	}
+	return &Assignment{ExperimentID: e.ID, Variant: e.Variants[Bucket(e.Key, userID, e.Variants)]}, nil
+}
+
+// Expose records the payment link issued to the bucketed user
+func (s *ExperimentsService) Expose(ctx context.Context, x *models.ExperimentExposure) error {
+	if err := s.experimentRepo.CreateExposure(ctx, x); err != nil {
+		s.log.Errorf("failed to record exposure of session %v, error: %v", x.SessionID, err)
+		return ErrUnexpectedResult
+	}
+	return nil
+}
+
+func (s *ExperimentsService) fetch(ctx context.Context, key string) (*models.Experiment, error) {
+	e, err := s.experimentRepo.FetchByKey(ctx, strings.ToLower(key))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		s.log.Errorf("failed to fetch experiment %v, error: %v", key, err)
+		return nil, ErrUnexpectedResult
+	}
+	return e, nil
+}
+
+// Bucket returns index of the variant the user falls into. The user id is hashed together with the key
+// of the experiment, so users are split independently between experiments
+func Bucket(key, userID string, variants []models.ExperimentVariant) int {
+	total := 0
+	for _, v := range variants {
+		total += v.Weight
+	}
+	sum := sha256.Sum256([]byte(key + ":" + userID))
+	point := int(binary.BigEndian.Uint64(sum[:8]) % uint64(total))
+	for i, v := range variants {
+		if point < v.Weight {
+			return i
+		}
+		point -= v.Weight
+	}
+	return len(variants) - 1
+}
+
+func validUuid(id string) bool {
+	_, err := uuid.Parse(id)
+	return err == nil
+}
+
+func validate(e *models.Experiment) error {
+	if !keyPattern.MatchString(e.Key) {
+		return fmt.Errorf("%w: key must be 1 to 64 lowercase letters, digits, dashes or underscores", ErrInvalid)
+	}
+	if len(e.Variants) < 2 {
+		return fmt.Errorf("%w: at least two variants are required", ErrInvalid)
+	}
+	keys := map[string]bool{}
+	for _, v := range e.Variants {
+		switch {
+		case !keyPattern.MatchString(v.Key):
+			return fmt.Errorf("%w: variant key must be 1 to 64 lowercase letters, digits, dashes or underscores", ErrInvalid)
+		case keys[v.Key]:
+			return fmt.Errorf("%w: variant %v is duplicated", ErrInvalid, v.Key)
+		case v.Weight <= 0:
+			return fmt.Errorf("%w: weight of variant %v must be positive", ErrInvalid, v.Key)
+		case v.ProviderID != "" && !validUuid(v.ProviderID):
+			return fmt.Errorf("%w: provider_id of variant %v must be a uuid", ErrInvalid, v.Key)
+		case (v.Amount != 0 || v.Currency != "") && (v.Amount <= 0 || !money.Known(v.Currency)):
+			return fmt.Errorf("%w: amount and currency of variant %v are required together", ErrInvalid, v.Key)
+		case v.Amount != 0 && v.PlanID != "":
+			return fmt.Errorf("%w: variant %v can't override both plan and price", ErrInvalid, v.Key)
+		}
+		keys[v.Key] = true
+	}
+	return nil
+}
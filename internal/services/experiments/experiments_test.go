@@ -0,0 +1,250 @@
+package experiments
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/services/experiments/repository"
+)
+
+// FakeExperimentRepo keeps experiments in memory by key and exposures in the order they are recorded
+type FakeExperimentRepo struct {
+	Experiments map[string]*models.Experiment
+	Exposures   []*models.ExperimentExposure
+}
+
+func NewFakeExperimentRepo() *FakeExperimentRepo {
+	return &FakeExperimentRepo{Experiments: map[string]*models.Experiment{}}
+}
+
+func (m *FakeExperimentRepo) Create(ctx context.Context, e *models.Experiment) error {
+	if _, ok := m.Experiments[e.Key]; ok {
+		return repository.ErrDuplicate
+	}
+	m.Experiments[e.Key] = e
+	return nil
+}
+
+func (m *FakeExperimentRepo) FetchByKey(ctx context.Context, key string) (*models.Experiment, error) {
+	e, ok := m.Experiments[key]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return e, nil
+}
+
+func (m *FakeExperimentRepo) List(ctx context.Context) ([]models.Experiment, error) {
+	list := []models.Experiment{}
+	for _, e := range m.Experiments {
+		list = append(list, *e)
+	}
+	return list, nil
+}
+
+func (m *FakeExperimentRepo) SetStatus(ctx context.Context, key, status string) error {
+	e, ok := m.Experiments[key]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	e.Status = status
+	return nil
+}
+
+func (m *FakeExperimentRepo) CreateExposure(ctx context.Context, x *models.ExperimentExposure) error {
+	m.Exposures = append(m.Exposures, x)
+	return nil
+}
+
+// Results counts every exposure of the variant, sessions ending with "paid" are conversions
+func (m *FakeExperimentRepo) Results(ctx context.Context, experimentID string) ([]models.VariantResult, error) {
+	var e *models.Experiment
+	for _, experiment := range m.Experiments {
+		if experiment.ID == experimentID {
+			e = experiment
+		}
+	}
+	results := []models.VariantResult{}
+	for _, v := range e.Variants {
+		res := models.VariantResult{VariantKey: v.Key}
+		for _, x := range m.Exposures {
+			if x.ExperimentID == experimentID && x.VariantKey == v.Key {
+				res.Exposures++
+				if strings.HasSuffix(x.SessionID, "paid") {
+					res.Conversions++
+				}
+			}
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func TestExperimentsServiceCreate(t *testing.T) {
+	service := NewExperimentsService(zap.NewNop().Sugar(), NewFakeExperimentRepo())
+
+	type testCase struct {
+		name       string
+		experiment models.Experiment
+		err        error
+	}
+	testCases := []testCase{
+		{name: "success", experiment: models.Experiment{Key: "Paywall-Price", Variants: []models.ExperimentVariant{
+			{Key: "control", Weight: 50},
+			{Key: "cheaper", Weight: 50, Amount: 999, Currency: "USD"},
+		}}},
+		{name: "success provider and plan", experiment: models.Experiment{Key: "paywall-provider", Variants: []models.ExperimentVariant{
+			{Key: "stripe", Weight: 1, ProviderID: "39251d76-1b3c-470d-969d-c7dade716d97"},
+			{Key: "yearly", Weight: 3, PlanID: "premium-yearly"},
+		}}},
+		{name: "fail duplicate", experiment: models.Experiment{Key: "paywall-price", Variants: []models.ExperimentVariant{
+			{Key: "a", Weight: 1},
+			{Key: "b", Weight: 1},
+		}}, err: ErrDuplicate},
+		{name: "fail key", experiment: models.Experiment{Key: "pay wall", Variants: []models.ExperimentVariant{
+			{Key: "a", Weight: 1},
+			{Key: "b", Weight: 1},
+		}}, err: ErrInvalid},
+		{name: "fail single variant", experiment: models.Experiment{Key: "single", Variants: []models.ExperimentVariant{
+			{Key: "a", Weight: 1},
+		}}, err: ErrInvalid},
+		{name: "fail duplicated variant", experiment: models.Experiment{Key: "duplicated", Variants: []models.ExperimentVariant{
+			{Key: "a", Weight: 1},
+			{Key: "a", Weight: 1},
+		}}, err: ErrInvalid},
+		{name: "fail zero weight", experiment: models.Experiment{Key: "weight", Variants: []models.ExperimentVariant{
+			{Key: "a", Weight: 1},
+			{Key: "b"},
+		}}, err: ErrInvalid},
+		{name: "fail provider", experiment: models.Experiment{Key: "provider", Variants: []models.ExperimentVariant{
+			{Key: "a", Weight: 1},
+			{Key: "b", Weight: 1, ProviderID: "stripe"},
+		}}, err: ErrInvalid},
+		{name: "fail amount without currency", experiment: models.Experiment{Key: "amount", Variants: []models.ExperimentVariant{
+			{Key: "a", Weight: 1},
+			{Key: "b", Weight: 1, Amount: 999},
+		}}, err: ErrInvalid},
+		{name: "fail price and plan", experiment: models.Experiment{Key: "plan", Variants: []models.ExperimentVariant{
+			{Key: "a", Weight: 1},
+			{Key: "b", Weight: 1, Amount: 999, Currency: "usd", PlanID: "premium-yearly"},
+		}}, err: ErrInvalid},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := tc.experiment
+			err := service.Create(context.Background(), &e)
+			assert.ErrorIs(t, err, tc.err)
+			if tc.err == nil {
+				assert.NotEmpty(t, e.ID)
+				assert.Equal(t, models.ExperimentRunning, e.Status)
+			}
+		})
+	}
+}
+
+func TestBucket(t *testing.T) {
+	variants := []models.ExperimentVariant{{Key: "a", Weight: 1}, {Key: "b", Weight: 3}}
+
+	t.Run("deterministic", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			userID := fmt.Sprintf("user-%v", i)
+			assert.Equal(t, Bucket("paywall", userID, variants), Bucket("paywall", userID, variants))
+		}
+	})
+
+	t.Run("follows weights", func(t *testing.T) {
+		counts := make([]int, len(variants))
+		for i := 0; i < 10000; i++ {
+			counts[Bucket("paywall", fmt.Sprintf("user-%v", i), variants)]++
+		}
+		assert.InDelta(t, 2500, counts[0], 200)
+		assert.InDelta(t, 7500, counts[1], 200)
+	})
+
+	t.Run("independent between experiments", func(t *testing.T) {
+		even := []models.ExperimentVariant{{Key: "a", Weight: 1}, {Key: "b", Weight: 1}}
+		same := 0
+		for i := 0; i < 1000; i++ {
+			userID := fmt.Sprintf("user-%v", i)
+			if Bucket("first", userID, even) == Bucket("second", userID, even) {
+				same++
+			}
+		}
+		assert.InDelta(t, 500, same, 80)
+	})
+}
+
+func TestExperimentsServiceAssign(t *testing.T) {
+	fakeExperimentRepo := NewFakeExperimentRepo()
+	service := NewExperimentsService(zap.NewNop().Sugar(), fakeExperimentRepo)
+	e := &models.Experiment{Key: "paywall", Variants: []models.ExperimentVariant{
+		{Key: "control", Weight: 1},
+		{Key: "cheaper", Weight: 1, Amount: 999, Currency: "usd"},
+	}}
+	assert.NoError(t, service.Create(context.Background(), e))
+
+	t.Run("same variant for the user", func(t *testing.T) {
+		first, err := service.Assign(context.Background(), "paywall", "user")
+		assert.NoError(t, err)
+		second, err := service.Assign(context.Background(), "PAYWALL", "user")
+		assert.NoError(t, err)
+		assert.Equal(t, e.ID, first.ExperimentID)
+		assert.Equal(t, first, second)
+		assert.Equal(t, e.Variants[Bucket("paywall", "user", e.Variants)], first.Variant)
+	})
+
+	t.Run("anonymous user", func(t *testing.T) {
+		assignment, err := service.Assign(context.Background(), "paywall", "")
+		assert.NoError(t, err)
+		assert.Nil(t, assignment)
+	})
+
+	t.Run("unknown experiment", func(t *testing.T) {
+		_, err := service.Assign(context.Background(), "unknown", "user")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("results", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			userID := fmt.Sprintf("user-%v", i)
+			assignment, err := service.Assign(context.Background(), "paywall", userID)
+			assert.NoError(t, err)
+			sessionID := fmt.Sprintf("session-%v", i)
+			if i%2 == 0 {
+				sessionID += "-paid"
+			}
+			assert.NoError(t, service.Expose(context.Background(), &models.ExperimentExposure{
+				ExperimentID: assignment.ExperimentID,
+				VariantKey:   assignment.Variant.Key,
+				UserID:       userID,
+				SessionID:    sessionID,
+			}))
+		}
+		results, err := service.Results(context.Background(), "paywall")
+		assert.NoError(t, err)
+		assert.Len(t, results, 2)
+		exposures, conversions := 0, 0
+		for _, res := range results {
+			exposures += res.Exposures
+			conversions += res.Conversions
+		}
+		assert.Equal(t, 4, exposures)
+		assert.Equal(t, 2, conversions)
+
+		_, err = service.Results(context.Background(), "unknown")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("stopped experiment", func(t *testing.T) {
+		assert.NoError(t, service.Stop(context.Background(), "paywall"))
+		assignment, err := service.Assign(context.Background(), "paywall", "user")
+		assert.NoError(t, err)
+		assert.Nil(t, assignment)
+		assert.ErrorIs(t, service.Stop(context.Background(), "unknown"), ErrNotFound)
+	})
+}
@@ -0,0 +1,188 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/services/experiments"
+)
+
+type Experiments interface {
+	Create(ctx context.Context, e *models.Experiment) error
+	Experiments(ctx context.Context) ([]models.Experiment, error)
+	Stop(ctx context.Context, key string) error
+	Results(ctx context.Context, key string) ([]models.VariantResult, error)
+}
+
+type Handler struct {
+	log            *zap.SugaredLogger
+	experimentsSvc Experiments
+}
+
+func NewHandler(log *zap.SugaredLogger, experimentsSvc Experiments) *Handler {
+	return &Handler{log: log, experimentsSvc: experimentsSvc}
+}
+
+// experimentBody is the experiment as it is created and listed
+type experimentBody struct {
+	Key       string        `json:"key"`
+	Status    string        `json:"status"`
+	Variants  []variantBody `json:"variants"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+type variantBody struct {
+	Key        string `json:"key"`
+	Weight     int    `json:"weight"`
+	ProviderID string `json:"provider_id,omitempty"`
+	PlanID     string `json:"plan_id,omitempty"`
+	Amount     int64  `json:"amount,omitempty"`
+	Currency   string `json:"currency,omitempty"`
+}
+
+// Experiments endpoint lists experiments on GET and starts a new one on POST
+func (h *Handler) Experiments() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			list, err := h.experimentsSvc.Experiments(r.Context())
+			if err != nil {
+				h.log.Errorf("failed to list experiments")
+				writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+				return
+			}
+			items := make([]experimentBody, 0, len(list))
+			for i := range list {
+				items = append(items, toBody(&list[i]))
+			}
+			writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": items})
+		case http.MethodPost:
+			var body experimentBody
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Provided parameter has bad format"})
+				return
+			}
+			e := &models.Experiment{Key: body.Key}
+			for _, v := range body.Variants {
+				e.Variants = append(e.Variants, models.ExperimentVariant{
+					Key:        v.Key,
+					Weight:     v.Weight,
+					ProviderID: v.ProviderID,
+					PlanID:     v.PlanID,
+					Amount:     v.Amount,
+					Currency:   v.Currency,
+				})
+			}
+			if err := h.experimentsSvc.Create(r.Context(), e); err != nil {
+				h.log.Errorf("failed to create experiment")
+				switch {
+				case errors.Is(err, experiments.ErrInvalid):
+					writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": err.Error()})
+				case errors.Is(err, experiments.ErrDuplicate):
+					writeJson(w, http.StatusConflict, map[string]any{"code": http.StatusConflict, "message": "Experiment key is taken"})
+				default:
+					writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+				}
+				return
+			}
+			writeJson(w, http.StatusCreated, map[string]any{"code": http.StatusCreated, "data": toBody(e)})
+		default:
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+		}
+	}
+}
+
+// Experiment endpoint returns per variant results on GET /api/v1/experiments/{key}/results
+// and stops the experiment on POST /api/v1/experiments/{key}/stop
+func (h *Handler) Experiment() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rest, _ := strings.CutPrefix(r.URL.Path, "/api/v1/experiments/")
+		key, action, _ := strings.Cut(rest, "/")
+		if key == "" || (action != "results" && action != "stop") {
+			writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Not found"})
+			return
+		}
+		if (action == "results" && r.Method != http.MethodGet) || (action == "stop" && r.Method != http.MethodPost) {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+			return
+		}
+
+		if action == "stop" {
+			if err := h.experimentsSvc.Stop(r.Context(), key); err != nil {
+				h.log.Errorf("failed to stop experiment")
+				writeError(w, err)
+				return
+			}
+			writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "message": "Experiment is stopped"})
+			return
+		}
+
+		results, err := h.experimentsSvc.Results(r.Context(), key)
+		if err != nil {
+			h.log.Errorf("failed to fetch experiment results")
+			writeError(w, err)
+			return
+		}
+		items := make([]map[string]any, 0, len(results))
+		for _, res := range results {
+			rate := 0.0
+			if res.Exposures > 0 {
+				rate = float64(res.Conversions) / float64(res.Exposures)
+			}
+			items = append(items, map[string]any{
+				"variant":         res.VariantKey,
+				"exposures":       res.Exposures,
+				"conversions":     res.Conversions,
+				"conversion_rate": rate,
+			})
+		}
+		writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": items})
+	}
+}
+
+func toBody(e *models.Experiment) experimentBody {
+	body := experimentBody{Key: e.Key, Status: e.Status, Variants: []variantBody{}, CreatedAt: e.CreatedAt}
+	for _, v := range e.Variants {
+		body.Variants = append(body.Variants, variantBody{
+			Key:        v.Key,
+			Weight:     v.Weight,
+			ProviderID: v.ProviderID,
+			PlanID:     v.PlanID,
+			Amount:     v.Amount,
+			Currency:   v.Currency,
+		})
+	}
+	return body
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, experiments.ErrNotFound):
+		writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Not found"})
+	default:
+		writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+	}
+}
+
+// writeJson peforms write to the response writer and header as json content-type
+func writeJson(w http.ResponseWriter, status int, data map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if data == nil {
+		return
+	}
+
+	enc, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	_, _ = w.Write(enc)
+}
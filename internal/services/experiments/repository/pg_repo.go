@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+type ExperimentRepo struct {
+	log  *zap.SugaredLogger
+	conn *sql.DB
+}
+
+func NewExperimentRepo(log *zap.SugaredLogger, conn *sql.DB) *ExperimentRepo {
+	return &ExperimentRepo{log: log, conn: conn}
+}
+
+// Create stores a new experiment together with its variants, ErrDuplicate is returned when the key is taken
+func (r *ExperimentRepo) Create(ctx context.Context, e *models.Experiment) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		r.log.Errorf("failed to begin experiment transaction, error: %v", err)
+		return err
+	}
+	// rollback is no-op after commit
+	defer func() { _ = tx.Rollback() }()
+
+	stmnt := `INSERT INTO experiments (id, key, status) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO NOTHING RETURNING created_at, updated_at`
+	if err := tx.QueryRowContext(ctx, stmnt, e.ID, e.Key, e.Status).Scan(&e.CreatedAt, &e.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDuplicate
+		}
+		r.log.Errorw("failed to create experiment",
+			"key", e.Key,
+			"error", err)
+		return err
+	}
+	stmnt = `INSERT INTO experiment_variants (experiment_id, key, position, weight, provider_id, plan_id, amount, currency)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid, NULLIF($6, ''), $7, $8)`
+	for i, v := range e.Variants {
+		if _, err := tx.ExecContext(ctx, stmnt, e.ID, v.Key, i, v.Weight, v.ProviderID, v.PlanID, v.Amount, v.Currency); err != nil {
+			r.log.Errorw("failed to create experiment variant",
+				"key", e.Key,
+				"variant", v.Key,
+				"error", err)
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		r.log.Errorf("failed to commit experiment %v, error: %v", e.Key, err)
+		return err
+	}
+	return nil
+}
+
+// FetchByKey fetches single experiment with its variants
+func (r *ExperimentRepo) FetchByKey(ctx context.Context, key string) (*models.Experiment, error) {
+	stmnt := "SELECT id, key, status, created_at, updated_at FROM experiments WHERE key = $1"
+	e := models.Experiment{}
+	if err := r.conn.QueryRowContext(ctx, stmnt, key).Scan(&e.ID, &e.Key, &e.Status, &e.CreatedAt, &e.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		r.log.Errorw("failed to fetch experiment by key",
+			"key", key,
+			"error", err)
+		return nil, err
+	}
+	variants, err := r.variants(ctx, "WHERE experiment_id = $1", e.ID)
+	if err != nil {
+		return nil, err
+	}
+	e.Variants = variants[e.ID]
+	return &e, nil
+}
+
+// List fetches every experiment with its variants, the latest first
+func (r *ExperimentRepo) List(ctx context.Context) ([]models.Experiment, error) {
+	rows, err := r.conn.QueryContext(ctx, "SELECT id, key, status, created_at, updated_at FROM experiments ORDER BY created_at DESC")
+	if err != nil {
+		r.log.Errorf("failed to list experiments, error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	experiments := []models.Experiment{}
+	for rows.Next() {
+		e := models.Experiment{}
+		if err := rows.Scan(&e.ID, &e.Key, &e.Status, &e.CreatedAt, &e.UpdatedAt); err != nil {
+			r.log.Errorf("failed to scan experiment, error: %v", err)
+			return nil, err
+		}
+		experiments = append(experiments, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	variants, err := r.variants(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for i := range experiments {
+		experiments[i].Variants = variants[experiments[i].ID]
+	}
+	return experiments, nil
+}
+
+// SetStatus changes status of the experiment, ErrNotFound is returned for unknown keys
+func (r *ExperimentRepo) SetStatus(ctx context.Context, key, status string) error {
+	stmnt := "UPDATE experiments SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE key = $2"
+	res, err := r.conn.ExecContext(ctx, stmnt, status, key)
+	if err != nil {
+		r.log.Errorw("failed to set experiment status",
+			"key", key,
+			"error", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CreateExposure records the payment link issued within the experiment
+func (r *ExperimentRepo) CreateExposure(ctx context.Context, x *models.ExperimentExposure) error {
+	stmnt := `INSERT INTO experiment_exposures (experiment_id, variant_key, user_id, session_id)
+		VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`
+	if _, err := r.conn.ExecContext(ctx, stmnt, x.ExperimentID, x.VariantKey, x.UserID, x.SessionID); err != nil {
+		r.log.Errorw("failed to create experiment exposure",
+			"experimentID", x.ExperimentID,
+			"sessionID", x.SessionID,
+			"error", err)
+		return err
+	}
+	return nil
+}
+
+// Results counts distinct users exposed to every variant of the experiment and those of them
+// who paid with any of the exposed sessions
+func (r *ExperimentRepo) Results(ctx context.Context, experimentID string) ([]models.VariantResult, error) {
+	stmnt := `SELECT v.key, COUNT(DISTINCT x.user_id), COUNT(DISTINCT x.user_id) FILTER (WHERE s.paid_at IS NOT NULL)
+		FROM experiment_variants v
+		LEFT JOIN experiment_exposures x ON x.experiment_id = v.experiment_id AND x.variant_key = v.key
+		LEFT JOIN payment_sessions s ON s.id = x.session_id
+		WHERE v.experiment_id = $1 GROUP BY v.key, v.position ORDER BY v.position`
+	rows, err := r.conn.QueryContext(ctx, stmnt, experimentID)
+	if err != nil {
+		r.log.Errorw("failed to count experiment results",
+			"experimentID", experimentID,
+			"error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.VariantResult{}
+	for rows.Next() {
+		res := models.VariantResult{}
+		if err := rows.Scan(&res.VariantKey, &res.Exposures, &res.Conversions); err != nil {
+			r.log.Errorf("failed to scan experiment result, error: %v", err)
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// variants fetches variants matching the condition, grouped by experiment in their order
+func (r *ExperimentRepo) variants(ctx context.Context, where string, args ...any) (map[string][]models.ExperimentVariant, error) {
+	stmnt := `SELECT experiment_id, key, weight, COALESCE(provider_id::text, ''), COALESCE(plan_id, ''), amount, currency
+		FROM experiment_variants ` + where + " ORDER BY experiment_id, position"
+	rows, err := r.conn.QueryContext(ctx, stmnt, args...)
+	if err != nil {
+		r.log.Errorf("failed to fetch experiment variants, error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := map[string][]models.ExperimentVariant{}
+	for rows.Next() {
+		var experimentID string
+		v := models.ExperimentVariant{}
+		if err := rows.Scan(&experimentID, &v.Key, &v.Weight, &v.ProviderID, &v.PlanID, &v.Amount, &v.Currency); err != nil {
+			r.log.Errorf("failed to scan experiment variant, error: %v", err)
+			return nil, err
+		}
+		variants[experimentID] = append(variants[experimentID], v)
+	}
+	return variants, rows.Err()
+}
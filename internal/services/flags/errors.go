@@ -0,0 +1,9 @@
+package flags
+
+import "errors"
+
+var (
+	ErrInvalid          = errors.New("feature flag is invalid")
+	ErrNotFound         = errors.New("feature flag is not found")
+	ErrUnexpectedResult = errors.New("unexpected error")
+)
@@ -0,0 +1,197 @@
+package flags
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/platform"
+	"payment-api/internal/services/flags/repository"
+)
+
+var keyPattern = regexp.MustCompile(`^[a-z0-9_.-]{1,128}$`)
+
+// Repository for feature flags
+type FlagRepo interface {
+	Upsert(ctx context.Context, f *models.Flag) error
+	List(ctx context.Context) ([]models.Flag, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// FlagsService answers flags from memory, the cache is refreshed from the repository periodically
+// and right away on changes made by the instance itself
+type FlagsService struct {
+	log      *zap.SugaredLogger
+	flagRepo FlagRepo
+
+	mu    sync.RWMutex
+	cache map[string]models.Flag
+}
+
+func NewFlagsService(log *zap.SugaredLogger, flagRepo FlagRepo) *FlagsService {
+	return &FlagsService{log: log, flagRepo: flagRepo, cache: map[string]models.Flag{}}
+}
+
+// Target is who the flag is evaluated for, every field is optional
+type Target struct {
+	// UserID keeps the user in or out of the partial rollout consistently
+	UserID string
+	// Country is ISO 3166-1 alpha-2 code
+	Country  string
+	Platform platform.Platform
+	Client   string
+}
+
+// ProviderKillSwitch returns key of the flag which disables the provider
+func ProviderKillSwitch(providerName string) string {
+	return "kill_switch.provider." + strings.ToLower(providerName)
+}
+
+// Enabled tells whether the flag is on for the target, unknown flags are off. Partial rollouts
+// bucket users by their id, so anonymous targets are left out of them
+func (s *FlagsService) Enabled(key string, t Target) bool {
+	s.mu.RLock()
+	f, ok := s.cache[key]
+	s.mu.RUnlock()
+	if !ok || !f.Enabled {
+		return false
+	}
+	if !matches(f.Countries, strings.ToUpper(t.Country)) || !matches(f.Platforms, string(t.Platform)) || !matches(f.Clients, t.Client) {
+		return false
+	}
+	if f.Percentage >= 100 {
+		return true
+	}
+	if t.UserID == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(key + ":" + t.UserID))
+	return int(binary.BigEndian.Uint64(sum[:8])%100) < f.Percentage
+}
+
+// Flags returns every flag as it is stored
+func (s *FlagsService) Flags(ctx context.Context) ([]models.Flag, error) {
+	flags, err := s.flagRepo.List(ctx)
+	if err != nil {
+		s.log.Errorf("failed to list feature flags, error: %v", err)
+		return nil, ErrUnexpectedResult
+	}
+	return flags, nil
+}
+
+// Set validates and stores the flag, it takes effect on this instance immediately
+// and on the others with the next refresh
+func (s *FlagsService) Set(ctx context.Context, f *models.Flag) error {
+	f.Key = strings.ToLower(strings.TrimSpace(f.Key))
+	for i := range f.Countries {
+		f.Countries[i] = strings.ToUpper(f.Countries[i])
+	}
+	for i := range f.Platforms {
+		f.Platforms[i] = strings.ToLower(f.Platforms[i])
+	}
+	if err := validate(f); err != nil {
+		return err
+	}
+	if err := s.flagRepo.Upsert(ctx, f); err != nil {
+		s.log.Errorf("failed to store feature flag %v, error: %v", f.Key, err)
+		return ErrUnexpectedResult
+	}
+	s.mu.Lock()
+	s.cache[f.Key] = *f
+	s.mu.Unlock()
+	return nil
+}
+
+// Delete removes the flag, which turns it off
+func (s *FlagsService) Delete(ctx context.Context, key string) error {
+	if err := s.flagRepo.Delete(ctx, key); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrNotFound
+		}
+		s.log.Errorf("failed to delete feature flag %v, error: %v", key, err)
+		return ErrUnexpectedResult
+	}
+	s.mu.Lock()
+	delete(s.cache, key)
+	s.mu.Unlock()
+	return nil
+}
+
+// Refresh replaces the cache with the stored flags, the cache is kept when they can't be fetched
+func (s *FlagsService) Refresh(ctx context.Context) error {
+	flags, err := s.flagRepo.List(ctx)
+	if err != nil {
+		s.log.Errorf("failed to refresh feature flags, error: %v", err)
+		return ErrUnexpectedResult
+	}
+	cache := make(map[string]models.Flag, len(flags))
+	for _, f := range flags {
+		cache[f.Key] = f
+	}
+	s.mu.Lock()
+	s.cache = cache
+	s.mu.Unlock()
+	return nil
+}
+
+// Run refreshes the cache every interval until the context is cancelled
+func (s *FlagsService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_ = s.Refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func matches(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func validate(f *models.Flag) error {
+	if !keyPattern.MatchString(f.Key) {
+		return fmt.Errorf("%w: key must be 1 to 128 lowercase letters, digits, dots, dashes or underscores", ErrInvalid)
+	}
+	if f.Percentage < 0 || f.Percentage > 100 {
+		return fmt.Errorf("%w: percentage must be from 0 to 100", ErrInvalid)
+	}
+	for _, c := range f.Countries {
+		if len(c) != 2 || strings.Trim(c, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			return fmt.Errorf("%w: countries must be ISO 3166-1 alpha-2 codes", ErrInvalid)
+		}
+	}
+	for _, p := range f.Platforms {
+		switch platform.Platform(p) {
+		case platform.PlatformIOS, platform.PlatformAndroid, platform.PlatformDesktop:
+		default:
+			return fmt.Errorf("%w: platforms must be ios, android or desktop", ErrInvalid)
+		}
+	}
+	for _, c := range f.Clients {
+		if c == "" {
+			return fmt.Errorf("%w: clients can't be empty", ErrInvalid)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package flags
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/platform"
+	"payment-api/internal/services/flags/repository"
+)
+
+// FakeFlagRepo keeps flags in memory by key
+type FakeFlagRepo struct {
+	Flags map[string]models.Flag
+}
+
+func NewFakeFlagRepo() *FakeFlagRepo {
+	return &FakeFlagRepo{Flags: map[string]models.Flag{}}
+}
+
+func (m *FakeFlagRepo) Upsert(ctx context.Context, f *models.Flag) error {
+	m.Flags[f.Key] = *f
+	return nil
+}
+
+func (m *FakeFlagRepo) List(ctx context.Context) ([]models.Flag, error) {
+	list := []models.Flag{}
+	for _, f := range m.Flags {
+		list = append(list, f)
+	}
+	return list, nil
+}
+
+func (m *FakeFlagRepo) Delete(ctx context.Context, key string) error {
+	if _, ok := m.Flags[key]; !ok {
+		return repository.ErrNotFound
+	}
+	delete(m.Flags, key)
+	return nil
+}
+
+func TestFlagsServiceSet(t *testing.T) {
+	service := NewFlagsService(zap.NewNop().Sugar(), NewFakeFlagRepo())
+
+	type testCase struct {
+		name string
+		flag models.Flag
+		err  error
+	}
+	testCases := []testCase{
+		{name: "success", flag: models.Flag{Key: "Kill_Switch.Provider.Stripe", Enabled: true, Percentage: 100}},
+		{name: "success targeted", flag: models.Flag{Key: "new-checkout", Enabled: true, Percentage: 20,
+			Countries: []string{"pl"}, Platforms: []string{"iOS"}, Clients: []string{"web"}}},
+		{name: "fail key", flag: models.Flag{Key: "new checkout", Percentage: 100}, err: ErrInvalid},
+		{name: "fail percentage", flag: models.Flag{Key: "new-checkout", Percentage: 101}, err: ErrInvalid},
+		{name: "fail country", flag: models.Flag{Key: "new-checkout", Percentage: 100, Countries: []string{"POL"}}, err: ErrInvalid},
+		{name: "fail platform", flag: models.Flag{Key: "new-checkout", Percentage: 100, Platforms: []string{"tv"}}, err: ErrInvalid},
+		{name: "fail client", flag: models.Flag{Key: "new-checkout", Percentage: 100, Clients: []string{""}}, err: ErrInvalid},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := tc.flag
+			err := service.Set(context.Background(), &f)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+	// changes take effect without waiting for the refresh
+	assert.True(t, service.Enabled(ProviderKillSwitch("Stripe"), Target{}))
+}
+
+func TestFlagsServiceEnabled(t *testing.T) {
+	fakeFlagRepo := NewFakeFlagRepo()
+	fakeFlagRepo.Flags["off"] = models.Flag{Key: "off", Percentage: 100}
+	fakeFlagRepo.Flags["on"] = models.Flag{Key: "on", Enabled: true, Percentage: 100}
+	fakeFlagRepo.Flags["targeted"] = models.Flag{Key: "targeted", Enabled: true, Percentage: 100,
+		Countries: []string{"PL"}, Platforms: []string{"ios", "android"}, Clients: []string{"web"}}
+	fakeFlagRepo.Flags["half"] = models.Flag{Key: "half", Enabled: true, Percentage: 50}
+	service := NewFlagsService(zap.NewNop().Sugar(), fakeFlagRepo)
+	assert.NoError(t, service.Refresh(context.Background()))
+
+	type testCase struct {
+		name     string
+		key      string
+		target   Target
+		expected bool
+	}
+	testCases := []testCase{
+		{name: "unknown", key: "unknown", expected: false},
+		{name: "disabled", key: "off", expected: false},
+		{name: "enabled", key: "on", expected: true},
+		{name: "targeted match", key: "targeted", target: Target{Country: "pl", Platform: platform.PlatformIOS, Client: "web"}, expected: true},
+		{name: "other country", key: "targeted", target: Target{Country: "US", Platform: platform.PlatformIOS, Client: "web"}, expected: false},
+		{name: "other platform", key: "targeted", target: Target{Country: "PL", Platform: platform.PlatformDesktop, Client: "web"}, expected: false},
+		{name: "other client", key: "targeted", target: Target{Country: "PL", Platform: platform.PlatformAndroid, Client: "ios-app"}, expected: false},
+		{name: "anonymous out of rollout", key: "half", expected: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, service.Enabled(tc.key, tc.target))
+		})
+	}
+
+	t.Run("percentage rollout", func(t *testing.T) {
+		enabled := 0
+		for i := 0; i < 10000; i++ {
+			target := Target{UserID: fmt.Sprintf("user-%v", i)}
+			if service.Enabled("half", target) {
+				enabled++
+			}
+			assert.Equal(t, service.Enabled("half", target), service.Enabled("half", target))
+		}
+		assert.InDelta(t, 5000, enabled, 300)
+	})
+
+	t.Run("refresh picks up stored changes", func(t *testing.T) {
+		delete(fakeFlagRepo.Flags, "on")
+		fakeFlagRepo.Flags["off"] = models.Flag{Key: "off", Enabled: true, Percentage: 100}
+		assert.True(t, service.Enabled("on", Target{}))
+		assert.NoError(t, service.Refresh(context.Background()))
+		assert.False(t, service.Enabled("on", Target{}))
+		assert.True(t, service.Enabled("off", Target{}))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		assert.NoError(t, service.Delete(context.Background(), "off"))
+		assert.False(t, service.Enabled("off", Target{}))
+		assert.ErrorIs(t, service.Delete(context.Background(), "off"), ErrNotFound)
+	})
+}
@@ -0,0 +1,155 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/services/flags"
+)
+
+type Flags interface {
+	Flags(ctx context.Context) ([]models.Flag, error)
+	Set(ctx context.Context, f *models.Flag) error
+	Delete(ctx context.Context, key string) error
+}
+
+type Handler struct {
+	log      *zap.SugaredLogger
+	flagsSvc Flags
+}
+
+func NewHandler(log *zap.SugaredLogger, flagsSvc Flags) *Handler {
+	return &Handler{log: log, flagsSvc: flagsSvc}
+}
+
+// flagBody is the flag as it is set and listed
+type flagBody struct {
+	Key     string `json:"key"`
+	Enabled bool   `json:"enabled"`
+	// Percentage is 100 when it is omitted
+	Percentage *int      `json:"percentage"`
+	Countries  []string  `json:"countries"`
+	Platforms  []string  `json:"platforms"`
+	Clients    []string  `json:"clients"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Flags endpoint lists flags on GET and creates or replaces the flag on POST
+func (h *Handler) Flags() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			list, err := h.flagsSvc.Flags(r.Context())
+			if err != nil {
+				h.log.Errorf("failed to list feature flags")
+				writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+				return
+			}
+			items := make([]flagBody, 0, len(list))
+			for i := range list {
+				items = append(items, toBody(&list[i]))
+			}
+			writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": items})
+		case http.MethodPost:
+			var body flagBody
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Provided parameter has bad format"})
+				return
+			}
+			f := &models.Flag{
+				Key:        body.Key,
+				Enabled:    body.Enabled,
+				Percentage: 100,
+				Countries:  nonNil(body.Countries),
+				Platforms:  nonNil(body.Platforms),
+				Clients:    nonNil(body.Clients),
+			}
+			if body.Percentage != nil {
+				f.Percentage = *body.Percentage
+			}
+			if err := h.flagsSvc.Set(r.Context(), f); err != nil {
+				h.log.Errorf("failed to set feature flag")
+				switch {
+				case errors.Is(err, flags.ErrInvalid):
+					writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": err.Error()})
+				default:
+					writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+				}
+				return
+			}
+			writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": toBody(f)})
+		default:
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+		}
+	}
+}
+
+// Flag endpoint deletes the flag at /api/v1/flags/{key}
+func (h *Handler) Flag() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+			return
+		}
+		key, _ := strings.CutPrefix(r.URL.Path, "/api/v1/flags/")
+		if key == "" || strings.Contains(key, "/") {
+			writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Not found"})
+			return
+		}
+
+		if err := h.flagsSvc.Delete(r.Context(), key); err != nil {
+			h.log.Errorf("failed to delete feature flag")
+			switch {
+			case errors.Is(err, flags.ErrNotFound):
+				writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Not found"})
+			default:
+				writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+			}
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "message": "Feature flag is deleted"})
+	}
+}
+
+func toBody(f *models.Flag) flagBody {
+	percentage := f.Percentage
+	return flagBody{
+		Key:        f.Key,
+		Enabled:    f.Enabled,
+		Percentage: &percentage,
+		Countries:  nonNil(f.Countries),
+		Platforms:  nonNil(f.Platforms),
+		Clients:    nonNil(f.Clients),
+		UpdatedAt:  f.UpdatedAt,
+	}
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// writeJson peforms write to the response writer and header as json content-type
+func writeJson(w http.ResponseWriter, status int, data map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if data == nil {
+		return
+	}
+
+	enc, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	_, _ = w.Write(enc)
+}
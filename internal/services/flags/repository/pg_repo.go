@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+type FlagRepo struct {
+	log  *zap.SugaredLogger
+	conn *sql.DB
+}
+
+func NewFlagRepo(log *zap.SugaredLogger, conn *sql.DB) *FlagRepo {
+	return &FlagRepo{log: log, conn: conn}
+}
+
+// Upsert creates the flag or replaces every setting of the existing one
+func (r *FlagRepo) Upsert(ctx context.Context, f *models.Flag) error {
+	stmnt := `INSERT INTO feature_flags (key, enabled, percentage, countries, platforms, clients)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (key) DO UPDATE SET enabled = EXCLUDED.enabled, percentage = EXCLUDED.percentage,
+			countries = EXCLUDED.countries, platforms = EXCLUDED.platforms, clients = EXCLUDED.clients,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING updated_at`
+	if err := r.conn.QueryRowContext(ctx, stmnt, f.Key, f.Enabled, f.Percentage, pq.Array(f.Countries),
+		pq.Array(f.Platforms), pq.Array(f.Clients)).Scan(&f.UpdatedAt); err != nil {
+		r.log.Errorw("failed to upsert feature flag",
+			"key", f.Key,
+			"error", err)
+		return err
+	}
+	return nil
+}
+
+// List fetches every flag ordered by key
+func (r *FlagRepo) List(ctx context.Context) ([]models.Flag, error) {
+	stmnt := "SELECT key, enabled, percentage, countries, platforms, clients, updated_at FROM feature_flags ORDER BY key"
+	rows, err := r.conn.QueryContext(ctx, stmnt)
+	if err != nil {
+		r.log.Errorf("failed to list feature flags, error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	flags := []models.Flag{}
+	for rows.Next() {
+		f := models.Flag{}
+		if err := rows.Scan(&f.Key, &f.Enabled, &f.Percentage, pq.Array(&f.Countries), pq.Array(&f.Platforms),
+			pq.Array(&f.Clients), &f.UpdatedAt); err != nil {
+			r.log.Errorf("failed to scan feature flag, error: %v", err)
+			return nil, err
+		}
+		flags = append(flags, f)
+	}
+	return flags, rows.Err()
+}
+
+// Delete removes the flag, ErrNotFound is returned for unknown keys
+func (r *FlagRepo) Delete(ctx context.Context, key string) error {
+	res, err := r.conn.ExecContext(ctx, "DELETE FROM feature_flags WHERE key = $1", key)
+	if err != nil {
+		r.log.Errorw("failed to delete feature flag",
+			"key", key,
+			"error", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+package googleplay
+
+import "errors"
+
+var (
+	ErrNotFound         = errors.New("purchase is not found")
+	ErrPending          = errors.New("purchase is pending payment")
+	ErrGooglePlay       = errors.New("something happened on the google play side")
+	ErrUnexpectedResult = errors.New("unexpected error")
+)
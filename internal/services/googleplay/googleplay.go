@@ -0,0 +1,126 @@
+package googleplay
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"payment-api/internal/integrations/googleplay"
+	"payment-api/internal/models"
+)
+
+// GooglePlay serves subscription purchases of the app
+type GooglePlay interface {
+	PackageName() string
+	Subscription(ctx context.Context, purchaseToken string) (*googleplay.Subscription, error)
+	Acknowledge(ctx context.Context, productID, purchaseToken string) error
+}
+
+// Repository for entitlements
+type EntitlementRepo interface {
+	Upsert(ctx context.Context, e *models.Entitlement) error
+}
+
+type GooglePlayService struct {
+	log             *zap.SugaredLogger
+	googlePlay      GooglePlay
+	entitlementRepo EntitlementRepo
+	now             func() time.Time
+}
+
+func NewGooglePlayService(log *zap.SugaredLogger, googlePlay GooglePlay, entitlementRepo EntitlementRepo) *GooglePlayService {
+	return &GooglePlayService{log: log, googlePlay: googlePlay, entitlementRepo: entitlementRepo, now: time.Now}
+}
+
+// PurchaseRequest carries purchase token sent by the app
+type PurchaseRequest struct {
+	PurchaseToken string
+	// UserID is the authenticated caller, it is used when the app didn't set obfuscatedAccountId at purchase
+	UserID string
+}
+
+// VerifyPurchase verifies the subscription purchase, persists entitlement it grants and acknowledges it,
+// the entitlement stays with the user it was bound to first
+func (s *GooglePlayService) VerifyPurchase(ctx context.Context, req PurchaseRequest) (*models.Entitlement, error) {
+	return s.sync(ctx, req.PurchaseToken, req.UserID, nil)
+}
+
+// HandleNotification updates entitlement of the subscription the notification is about, revoked
+// entitlement stays revoked whatever comes next. Error is returned only when it is worth to redeliver the notification
+func (s *GooglePlayService) HandleNotification(ctx context.Context, n *googleplay.Notification) error {
+	if n.PackageName != s.googlePlay.PackageName() {
+		s.log.Errorw("notification belongs to another app",
+			"packageName", n.PackageName,
+			"messageID", n.MessageID)
+		return nil
+	}
+	if n.Test {
+		s.log.Infof("received test notification %v", n.MessageID)
+		return nil
+	}
+	// one-time products are not sold in the app
+	if n.Subscription == nil {
+		return nil
+	}
+
+	var revokedAt *time.Time
+	if n.Subscription.Type == googleplay.NotificationRevoked {
+		at := n.EventTime
+		if at.IsZero() {
+			at = s.now()
+		}
+		revokedAt = &at
+	}
+	_, err := s.sync(ctx, n.Subscription.PurchaseToken, "", revokedAt)
+	if errors.Is(err, ErrGooglePlay) || errors.Is(err, ErrUnexpectedResult) {
+		return err
+	}
+	return nil
+}
+
+// sync fetches the current state of the subscription and stores it as entitlement
+func (s *GooglePlayService) sync(ctx context.Context, purchaseToken, userID string, revokedAt *time.Time) (*models.Entitlement, error) {
+	sub, err := s.googlePlay.Subscription(ctx, purchaseToken)
+	if err != nil {
+		s.log.Errorw("failed to fetch google play subscription",
+			"error", err)
+		if errors.Is(err, googleplay.ErrPurchaseNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, ErrGooglePlay
+	}
+	if sub.State == googleplay.StatePending {
+		return nil, ErrPending
+	}
+
+	if sub.AccountID != "" {
+		userID = sub.AccountID
+	}
+	entitlement := &models.Entitlement{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Source:    models.EntitlementSourceGooglePlay,
+		ProductID: sub.ProductID,
+		// purchase token stays the same across renewals
+		ExternalID:    sub.PurchaseToken,
+		TransactionID: sub.OrderID,
+		PurchasedAt:   sub.StartTime,
+		ExpiresAt:     sub.ExpiryTime,
+		RevokedAt:     revokedAt,
+	}
+	if err := s.entitlementRepo.Upsert(ctx, entitlement); err != nil {
+		s.log.Errorf("failed to persist entitlement of order %v, error: %v", sub.OrderID, err)
+		return nil, ErrUnexpectedResult
+	}
+
+	if !sub.Acknowledged && (sub.State == googleplay.StateActive || sub.State == googleplay.StateInGracePeriod) {
+		if err := s.googlePlay.Acknowledge(ctx, sub.ProductID, sub.PurchaseToken); err != nil {
+			s.log.Errorf("failed to acknowledge order %v, error: %v", sub.OrderID, err)
+			return nil, ErrGooglePlay
+		}
+	}
+	return entitlement, nil
+}
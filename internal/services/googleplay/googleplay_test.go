@@ -0,0 +1,176 @@
+package googleplay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"payment-api/internal/integrations/googleplay"
+	"payment-api/internal/models"
+)
+
+// FakeGooglePlay returns subscriptions by their purchase token and records acknowledgements
+type FakeGooglePlay struct {
+	Subscriptions map[string]googleplay.Subscription
+	Acknowledged  []string
+	Err           error
+}
+
+func (m *FakeGooglePlay) PackageName() string {
+	return "com.headway.books"
+}
+
+func (m *FakeGooglePlay) Subscription(ctx context.Context, purchaseToken string) (*googleplay.Subscription, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	sub, ok := m.Subscriptions[purchaseToken]
+	if !ok {
+		return nil, googleplay.ErrPurchaseNotFound
+	}
+	return &sub, nil
+}
+
+func (m *FakeGooglePlay) Acknowledge(ctx context.Context, productID, purchaseToken string) error {
+	m.Acknowledged = append(m.Acknowledged, purchaseToken)
+	return nil
+}
+
+// FakeEntitlementRepo keeps entitlements in memory by their purchase
+type FakeEntitlementRepo struct {
+	Entitlements map[string]*models.Entitlement
+}
+
+func (m *FakeEntitlementRepo) Upsert(ctx context.Context, e *models.Entitlement) error {
+	if stored, ok := m.Entitlements[e.Source+e.ExternalID]; ok {
+		if stored.UserID != "" {
+			e.UserID = stored.UserID
+		}
+		if stored.RevokedAt != nil {
+			e.RevokedAt = stored.RevokedAt
+		}
+	}
+	m.Entitlements[e.Source+e.ExternalID] = e
+	return nil
+}
+
+func newFakeGooglePlay() *FakeGooglePlay {
+	expiresAt := time.Now().Add(time.Hour)
+	return &FakeGooglePlay{Subscriptions: map[string]googleplay.Subscription{
+		"new":          {PurchaseToken: "new", ProductID: "premium", OrderID: "GPA.1", State: googleplay.StateActive, AccountID: "user-from-play", ExpiryTime: &expiresAt},
+		"acknowledged": {PurchaseToken: "acknowledged", ProductID: "premium", OrderID: "GPA.2", State: googleplay.StateActive, Acknowledged: true, ExpiryTime: &expiresAt},
+		"pending":      {PurchaseToken: "pending", ProductID: "premium", State: googleplay.StatePending},
+	}}
+}
+
+func TestGooglePlayServiceVerifyPurchase(t *testing.T) {
+	type testCase struct {
+		name                 string
+		req                  PurchaseRequest
+		err                  error
+		expectedUserID       string
+		expectedAcknowledged []string
+		expectedErr          error
+	}
+	testCases := []testCase{
+		{"success account id wins and purchase is acknowledged", PurchaseRequest{PurchaseToken: "new", UserID: "user"}, nil, "user-from-play", []string{"new"}, nil},
+		{"success authenticated user", PurchaseRequest{PurchaseToken: "acknowledged", UserID: "user"}, nil, "user", nil, nil},
+		{"fail pending", PurchaseRequest{PurchaseToken: "pending"}, nil, "", nil, ErrPending},
+		{"fail unknown token", PurchaseRequest{PurchaseToken: "unknown"}, nil, "", nil, ErrNotFound},
+		{"fail outage", PurchaseRequest{PurchaseToken: "new"}, googleplay.ErrUnavailable, "", nil, ErrGooglePlay},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			play := newFakeGooglePlay()
+			play.Err = tc.err
+			repo := &FakeEntitlementRepo{Entitlements: map[string]*models.Entitlement{}}
+			service := NewGooglePlayService(zap.NewNop().Sugar(), play, repo)
+			e, err := service.VerifyPurchase(context.Background(), tc.req)
+			assert.Equal(t, tc.expectedAcknowledged, play.Acknowledged)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				assert.Empty(t, repo.Entitlements)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedUserID, e.UserID)
+			assert.Equal(t, models.EntitlementSourceGooglePlay, e.Source)
+			assert.Equal(t, tc.req.PurchaseToken, e.ExternalID)
+			assert.True(t, e.Active(time.Now()))
+			assert.Equal(t, e, repo.Entitlements[models.EntitlementSourceGooglePlay+tc.req.PurchaseToken])
+		})
+	}
+
+	t.Run("success owner is kept", func(t *testing.T) {
+		repo := &FakeEntitlementRepo{Entitlements: map[string]*models.Entitlement{}}
+		service := NewGooglePlayService(zap.NewNop().Sugar(), newFakeGooglePlay(), repo)
+		_, err := service.VerifyPurchase(context.Background(), PurchaseRequest{PurchaseToken: "acknowledged", UserID: "user"})
+		assert.NoError(t, err)
+		e, err := service.VerifyPurchase(context.Background(), PurchaseRequest{PurchaseToken: "acknowledged", UserID: "another"})
+		assert.NoError(t, err)
+		assert.Equal(t, "user", e.UserID)
+	})
+}
+
+func TestGooglePlayServiceHandleNotification(t *testing.T) {
+	eventTime := time.Now().Add(-time.Minute).UTC()
+	notification := func(packageName string, notificationType int, purchaseToken string) *googleplay.Notification {
+		return &googleplay.Notification{
+			PackageName:  packageName,
+			EventTime:    eventTime,
+			Subscription: &googleplay.SubscriptionNotification{Type: notificationType, PurchaseToken: purchaseToken},
+		}
+	}
+
+	t.Run("success renewal", func(t *testing.T) {
+		repo := &FakeEntitlementRepo{Entitlements: map[string]*models.Entitlement{}}
+		service := NewGooglePlayService(zap.NewNop().Sugar(), newFakeGooglePlay(), repo)
+		assert.NoError(t, service.HandleNotification(context.Background(), notification("com.headway.books", googleplay.NotificationRenewed, "acknowledged")))
+		e := repo.Entitlements[models.EntitlementSourceGooglePlay+"acknowledged"]
+		assert.Equal(t, "GPA.2", e.TransactionID)
+		assert.True(t, e.Active(time.Now()))
+	})
+
+	t.Run("success revocation", func(t *testing.T) {
+		repo := &FakeEntitlementRepo{Entitlements: map[string]*models.Entitlement{}}
+		service := NewGooglePlayService(zap.NewNop().Sugar(), newFakeGooglePlay(), repo)
+		assert.NoError(t, service.HandleNotification(context.Background(), notification("com.headway.books", googleplay.NotificationRevoked, "acknowledged")))
+		e := repo.Entitlements[models.EntitlementSourceGooglePlay+"acknowledged"]
+		assert.Equal(t, eventTime, *e.RevokedAt)
+		assert.False(t, e.Active(time.Now()))
+
+		// renewal coming after the refund doesn't give the access back
+		assert.NoError(t, service.HandleNotification(context.Background(), notification("com.headway.books", googleplay.NotificationRenewed, "acknowledged")))
+		e = repo.Entitlements[models.EntitlementSourceGooglePlay+"acknowledged"]
+		assert.Equal(t, eventTime, *e.RevokedAt)
+		assert.False(t, e.Active(time.Now()))
+	})
+
+	type testCase struct {
+		name         string
+		notification *googleplay.Notification
+		err          error
+		expectedErr  error
+	}
+	testCases := []testCase{
+		{"ignored another app", notification("com.another.app", googleplay.NotificationPurchased, "new"), nil, nil},
+		{"ignored test", &googleplay.Notification{PackageName: "com.headway.books", Test: true}, nil, nil},
+		{"ignored unknown token", notification("com.headway.books", googleplay.NotificationPurchased, "unknown"), nil, nil},
+		{"fail outage to be redelivered", notification("com.headway.books", googleplay.NotificationPurchased, "new"), googleplay.ErrUnavailable, ErrGooglePlay},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			play := newFakeGooglePlay()
+			play.Err = tc.err
+			repo := &FakeEntitlementRepo{Entitlements: map[string]*models.Entitlement{}}
+			service := NewGooglePlayService(zap.NewNop().Sugar(), play, repo)
+			err := service.HandleNotification(context.Background(), tc.notification)
+			assert.ErrorIs(t, err, tc.expectedErr)
+			assert.Empty(t, repo.Entitlements)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package v1
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	intgoogleplay "payment-api/internal/integrations/googleplay"
+	"payment-api/internal/middlwares"
+	"payment-api/internal/models"
+	"payment-api/internal/services/googleplay"
+)
+
+type GooglePlay interface {
+	VerifyPurchase(ctx context.Context, req googleplay.PurchaseRequest) (*models.Entitlement, error)
+	HandleNotification(ctx context.Context, n *intgoogleplay.Notification) error
+}
+
+type Handler struct {
+	log           *zap.SugaredLogger
+	googlePlaySvc GooglePlay
+	// pushToken authenticates Pub/Sub push requests, it is set in the push endpoint url
+	pushToken string
+}
+
+func NewHandler(log *zap.SugaredLogger, googlePlaySvc GooglePlay, pushToken string) *Handler {
+	return &Handler{log: log, googlePlaySvc: googlePlaySvc, pushToken: pushToken}
+}
+
+// Purchase endpoint verifies Google Play purchase token and returns entitlement it grants,
+// purchases without obfuscatedAccountId are bound to the authenticated user
+func (h *Handler) Purchase() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+			return
+		}
+		var body struct {
+			PurchaseToken string `json:"purchase_token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.PurchaseToken == "" {
+			writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Missing purchase_token"})
+			return
+		}
+
+		e, err := h.googlePlaySvc.VerifyPurchase(r.Context(), googleplay.PurchaseRequest{
+			PurchaseToken: body.PurchaseToken,
+			UserID:        middlwares.UserID(r.Context()),
+		})
+		if err != nil {
+			h.log.Errorf("failed to verify google play purchase")
+			switch {
+			case errors.Is(err, googleplay.ErrNotFound):
+				writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Purchase is not found"})
+			case errors.Is(err, googleplay.ErrPending):
+				writeJson(w, http.StatusConflict, map[string]any{"code": http.StatusConflict, "message": "Purchase is pending payment"})
+			case errors.Is(err, googleplay.ErrGooglePlay):
+				writeJson(w, http.StatusBadGateway, map[string]any{"code": http.StatusBadGateway, "message": "Google Play is unavailable"})
+			default:
+				writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+			}
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": map[string]any{
+			"user_id":    e.UserID,
+			"product_id": e.ProductID,
+			"order_id":   e.TransactionID,
+			"expires_at": e.ExpiresAt,
+			"active":     e.Active(time.Now()),
+		}})
+	}
+}
+
+// Notification endpoint receives Real-Time Developer Notifications pushed by Pub/Sub,
+// any response but 2xx makes Pub/Sub redeliver the message
+func (h *Handler) Notification() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(h.pushToken)) != 1 {
+			writeJson(w, http.StatusUnauthorized, map[string]any{"code": http.StatusUnauthorized, "message": "Unauthorized"})
+			return
+		}
+		raw, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Failed to read body"})
+			return
+		}
+		n, err := intgoogleplay.ParseNotification(raw)
+		if err != nil {
+			// redelivery won't fix malformed message, so it is acknowledged
+			h.log.Errorf("failed to parse google play notification, error: %v", err)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err := h.googlePlaySvc.HandleNotification(r.Context(), n); err != nil {
+			h.log.Errorf("failed to handle google play notification %v, error: %v", n.MessageID, err)
+			writeJson(w, http.StatusServiceUnavailable, map[string]any{"code": http.StatusServiceUnavailable, "message": "Try again later"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// writeJson peforms write to the response writer and header as json content-type
+func writeJson(w http.ResponseWriter, status int, data map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if data == nil {
+		return
+	}
+
+	enc, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	_, _ = w.Write(enc)
+}
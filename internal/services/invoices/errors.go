@@ -0,0 +1,9 @@
+package invoices
+
+import "errors"
+
+var (
+	ErrNotFound         = errors.New("invoice is not found")
+	ErrEntitiesInvalid  = errors.New("legal entities are invalid")
+	ErrUnexpectedResult = errors.New("unexpected error")
+)
@@ -0,0 +1,148 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/middlwares"
+	"payment-api/internal/models"
+	"payment-api/internal/services/invoices"
+)
+
+type Invoices interface {
+	Invoice(ctx context.Context, id string) (*models.Invoice, error)
+}
+
+type Handler struct {
+	log         *zap.SugaredLogger
+	invoicesSvc Invoices
+}
+
+func NewHandler(log *zap.SugaredLogger, invoicesSvc Invoices) *Handler {
+	return &Handler{log: log, invoicesSvc: invoicesSvc}
+}
+
+type invoiceBody struct {
+	ID            string    `json:"id"`
+	Number        string    `json:"number"`
+	SessionID     string    `json:"session_id"`
+	Email         string    `json:"email,omitempty"`
+	Country       string    `json:"country,omitempty"`
+	SellerName    string    `json:"seller_name"`
+	SellerAddress []string  `json:"seller_address"`
+	SellerTaxID   string    `json:"seller_tax_id,omitempty"`
+	Description   string    `json:"description"`
+	NetAmount     int64     `json:"net_amount"`
+	TaxAmount     int64     `json:"tax_amount"`
+	TotalAmount   int64     `json:"total_amount"`
+	Currency      string    `json:"currency"`
+	Taxes         []taxBody `json:"taxes"`
+	IssuedAt      time.Time `json:"issued_at"`
+}
+
+type taxBody struct {
+	Name      string `json:"name"`
+	Rate      string `json:"rate"`
+	Inclusive bool   `json:"inclusive"`
+	Amount    int64  `json:"amount"`
+}
+
+// Invoice endpoint returns the invoice on GET /api/v1/invoices/{id} as json,
+// or as a document with format=html or format=pdf. It is available only to the user the invoice is issued to,
+// invoices of other users and of anonymous payers are not found
+func (h *Handler) Invoice() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+			return
+		}
+		id, _ := strings.CutPrefix(r.URL.Path, "/api/v1/invoices/")
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "json" && format != "html" && format != "pdf" {
+			writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Provided parameter has bad format"})
+			return
+		}
+
+		inv, err := h.invoicesSvc.Invoice(r.Context(), id)
+		if err != nil {
+			h.log.Errorf("failed to fetch invoice")
+			switch {
+			case errors.Is(err, invoices.ErrNotFound):
+				writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Not found"})
+			default:
+				writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+			}
+			return
+		}
+		if caller := middlwares.UserID(r.Context()); caller == "" || caller != inv.UserID {
+			writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Not found"})
+			return
+		}
+
+		switch format {
+		case "html":
+			page, err := invoices.RenderHTML(inv)
+			if err != nil {
+				h.log.Errorf("failed to render invoice %v, error: %v", inv.ID, err)
+				writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(page)
+		case "pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", `inline; filename="`+inv.Number+`.pdf"`)
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(invoices.RenderPDF(inv))
+		default:
+			writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": toBody(inv)})
+		}
+	}
+}
+
+func toBody(inv *models.Invoice) invoiceBody {
+	body := invoiceBody{
+		ID:            inv.ID,
+		Number:        inv.Number,
+		SessionID:     inv.SessionID,
+		Email:         inv.Email,
+		Country:       inv.Country,
+		SellerName:    inv.SellerName,
+		SellerAddress: inv.SellerAddress,
+		SellerTaxID:   inv.SellerTaxID,
+		Description:   inv.Description,
+		NetAmount:     inv.NetAmount,
+		TaxAmount:     inv.TaxAmount,
+		TotalAmount:   inv.TotalAmount,
+		Currency:      inv.Currency,
+		Taxes:         []taxBody{},
+		IssuedAt:      inv.IssuedAt,
+	}
+	for _, t := range inv.Taxes {
+		body.Taxes = append(body.Taxes, taxBody{Name: t.Name, Rate: t.Rate, Inclusive: t.Inclusive, Amount: t.Amount})
+	}
+	return body
+}
+
+// writeJson peforms write to the response writer and header as json content-type
+func writeJson(w http.ResponseWriter, status int, data map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if data == nil {
+		return
+	}
+
+	enc, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	_, _ = w.Write(enc)
+}
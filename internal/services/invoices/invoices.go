@@ -0,0 +1,244 @@
+package invoices
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/services/invoices/repository"
+)
+
+// Repository for invoices
+type InvoiceRepo interface {
+	Create(ctx context.Context, inv *models.Invoice, prefix string) error
+	FetchByID(ctx context.Context, id string) (*models.Invoice, error)
+	FetchBySessionID(ctx context.Context, sessionID string) (*models.Invoice, error)
+	FetchByProviderInvoiceID(ctx context.Context, providerInvoiceID string) (*models.Invoice, error)
+}
+
+// entity is the legal entity selling to the countries, entity without countries sells to the rest of the world
+type entity struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Address []string `json:"address"`
+	TaxID   string   `json:"tax_id"`
+	// Prefix starts invoice numbers of the entity, e.g. "EU" of "EU-000042"
+	Prefix    string   `json:"prefix"`
+	Countries []string `json:"countries"`
+}
+
+type entityTable struct {
+	Entities []entity `json:"entities"`
+}
+
+type InvoicesService struct {
+	log         *zap.SugaredLogger
+	invoiceRepo InvoiceRepo
+	filePath    string
+	productName string
+	publisher   Publisher
+}
+
+// Option configures optional parts of the InvoicesService
+type Option func(s *InvoicesService)
+
+// WithProductName sets the item of invoices of one-time payments
+func WithProductName(name string) Option {
+	return func(s *InvoicesService) {
+		s.productName = name
+	}
+}
+
+// WithPublisher enables email events of issued invoices
+func WithPublisher(p Publisher) Option {
+	return func(s *InvoicesService) {
+		s.publisher = p
+	}
+}
+
+func NewInvoicesService(log *zap.SugaredLogger, invoiceRepo InvoiceRepo, filePath string, opts ...Option) *InvoicesService {
+	s := &InvoicesService{log: log, invoiceRepo: invoiceRepo, filePath: filePath}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Issue invoices the paid session on behalf of the entity selling to the country of the payer.
+// Sessions without amount, e.g. trials, are not invoiced and nil is returned. Repeated calls return
+// the invoice issued first, the email event is published only once
+func (s *InvoicesService) Issue(ctx context.Context, session *models.PaymentSession) (*models.Invoice, error) {
+	return s.issue(ctx, session, "")
+}
+
+// IssueRenewal invoices the renewal of the subscription started with the session, which is billed with
+// the provider invoice. The session carries the amounts charged for the renewal. Repeated calls of the provider
+// invoice return the invoice issued first
+func (s *InvoicesService) IssueRenewal(ctx context.Context, session *models.PaymentSession, providerInvoiceID string) (*models.Invoice, error) {
+	return s.issue(ctx, session, providerInvoiceID)
+}
+
+func (s *InvoicesService) issue(ctx context.Context, session *models.PaymentSession, providerInvoiceID string) (*models.Invoice, error) {
+	if session.Amount == 0 {
+		return nil, nil
+	}
+	table, err := s.load()
+	if err != nil {
+		s.log.Errorf("failed to load legal entities %v, error: %v", s.filePath, err)
+		return nil, ErrUnexpectedResult
+	}
+	seller := table.seller(session.Country)
+
+	description := s.productName
+	if session.PlanID != "" {
+		description = "Subscription " + session.PlanID
+	}
+	inv := &models.Invoice{
+		ID:                uuid.NewString(),
+		EntityID:          seller.ID,
+		SessionID:         session.ID,
+		ProviderInvoiceID: providerInvoiceID,
+		UserID:            session.UserID,
+		Email:             session.Email,
+		Country:           session.Country,
+		SellerName:        seller.Name,
+		SellerAddress:     seller.Address,
+		SellerTaxID:       seller.TaxID,
+		Description:       description,
+		NetAmount:         session.Amount - session.TaxAmount,
+		TaxAmount:         session.TaxAmount,
+		TotalAmount:       session.Amount,
+		Currency:          session.Currency,
+		Taxes:             session.Taxes,
+	}
+	if inv.Taxes == nil {
+		inv.Taxes = []models.TaxLine{}
+	}
+	if err := s.invoiceRepo.Create(ctx, inv, seller.Prefix); err != nil {
+		if !errors.Is(err, repository.ErrDuplicate) {
+			s.log.Errorf("failed to create invoice of session %v, error: %v", session.ID, err)
+			return nil, ErrUnexpectedResult
+		}
+		var existing *models.Invoice
+		if providerInvoiceID != "" {
+			existing, err = s.invoiceRepo.FetchByProviderInvoiceID(ctx, providerInvoiceID)
+		} else {
+			existing, err = s.invoiceRepo.FetchBySessionID(ctx, session.ID)
+		}
+		if err != nil {
+			s.log.Errorf("failed to fetch invoice of session %v, error: %v", session.ID, err)
+			return nil, ErrUnexpectedResult
+		}
+		return existing, nil
+	}
+	s.publish(ctx, inv)
+	return inv, nil
+}
+
+// publish sends the email event of the invoice, the invoice stays issued and available with the API
+// when the event is lost
+func (s *InvoicesService) publish(ctx context.Context, inv *models.Invoice) {
+	if s.publisher == nil || inv.Email == "" {
+		return
+	}
+	email, err := NewInvoiceEmail(inv)
+	if err != nil {
+		s.log.Errorf("failed to render email of invoice %v, error: %v", inv.ID, err)
+		return
+	}
+	if err := s.publisher.Publish(ctx, email); err != nil {
+		s.log.Errorf("failed to publish email of invoice %v, error: %v", inv.ID, err)
+	}
+}
+
+// Invoice returns the invoice by its id
+func (s *InvoicesService) Invoice(ctx context.Context, id string) (*models.Invoice, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	inv, err := s.invoiceRepo.FetchByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		s.log.Errorw("failed to fetch invoice",
+			"id", id,
+			"error", err)
+		return nil, ErrUnexpectedResult
+	}
+	return inv, nil
+}
+
+// seller returns the entity selling to the country, the entity without countries sells otherwise
+func (t *entityTable) seller(country string) *entity {
+	var fallback *entity
+	for i, e := range t.Entities {
+		if len(e.Countries) == 0 {
+			fallback = &t.Entities[i]
+			continue
+		}
+		for _, c := range e.Countries {
+			if c == country {
+				return &t.Entities[i]
+			}
+		}
+	}
+	return fallback
+}
+
+// load reads legal entities on every call, so they can be updated without restart
+func (s *InvoicesService) load() (*entityTable, error) {
+	raw, err := os.ReadFile(s.filePath)
+	if err != nil {
+		return nil, err
+	}
+	var table entityTable
+	if err := json.Unmarshal(raw, &table); err != nil {
+		return nil, err
+	}
+	if err := validate(&table); err != nil {
+		return nil, err
+	}
+	return &table, nil
+}
+
+// validate checks that every country is sold to by a single entity and there is a single fallback entity,
+// the prefixes must be unique, otherwise numbers of different entities would clash
+func validate(table *entityTable) error {
+	ids := map[string]bool{}
+	prefixes := map[string]bool{}
+	countries := map[string]bool{}
+	fallbacks := 0
+	for i := range table.Entities {
+		e := &table.Entities[i]
+		if e.ID == "" || len(e.ID) > 64 || e.Name == "" || e.Prefix == "" {
+			return fmt.Errorf("%w: entity %q misses id, name or prefix", ErrEntitiesInvalid, e.ID)
+		}
+		if ids[e.ID] || prefixes[e.Prefix] {
+			return fmt.Errorf("%w: id or prefix of entity %q is not unique", ErrEntitiesInvalid, e.ID)
+		}
+		ids[e.ID], prefixes[e.Prefix] = true, true
+		if len(e.Countries) == 0 {
+			fallbacks++
+		}
+		for j, c := range e.Countries {
+			c = strings.ToUpper(c)
+			if countries[c] {
+				return fmt.Errorf("%w: country %v is sold to by several entities", ErrEntitiesInvalid, c)
+			}
+			countries[c] = true
+			e.Countries[j] = c
+		}
+	}
+	if fallbacks != 1 {
+		return fmt.Errorf("%w: exactly one entity without countries is expected, got %d", ErrEntitiesInvalid, fallbacks)
+	}
+	return nil
+}
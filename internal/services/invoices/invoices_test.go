@@ -0,0 +1,313 @@
+package invoices
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/services/invoices/repository"
+)
+
+const testEntities = `{
+	"entities": [
+		{"id": "eu", "name": "Headway EU B.V.", "address": ["Herengracht 420", "1017 BZ Amsterdam"], "tax_id": "NL859876543B01",
+			"prefix": "EU", "countries": ["de", "FR"]},
+		{"id": "us", "name": "Headway Inc.", "address": ["2261 Market Street"], "prefix": "US"}
+	]
+}`
+
+// FakeInvoiceRepo keeps invoices in memory, a failing invoice does not advance the sequence
+type FakeInvoiceRepo struct {
+	Invoices  map[string]models.Invoice
+	Sequences map[string]int64
+	Err       error
+}
+
+func NewFakeInvoiceRepo() *FakeInvoiceRepo {
+	return &FakeInvoiceRepo{Invoices: map[string]models.Invoice{}, Sequences: map[string]int64{}}
+}
+
+func (m *FakeInvoiceRepo) Create(ctx context.Context, inv *models.Invoice, prefix string) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	// the checkout invoice is unique per session, renewals are unique per provider invoice
+	for _, existing := range m.Invoices {
+		if existing.SessionID == inv.SessionID && existing.ProviderInvoiceID == inv.ProviderInvoiceID {
+			return repository.ErrDuplicate
+		}
+		if inv.ProviderInvoiceID != "" && existing.ProviderInvoiceID == inv.ProviderInvoiceID {
+			return repository.ErrDuplicate
+		}
+	}
+	m.Sequences[inv.EntityID]++
+	inv.Sequence = m.Sequences[inv.EntityID]
+	inv.Number = fmt.Sprintf("%s-%06d", prefix, inv.Sequence)
+	inv.IssuedAt = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	m.Invoices[inv.ID] = *inv
+	return nil
+}
+
+func (m *FakeInvoiceRepo) FetchByID(ctx context.Context, id string) (*models.Invoice, error) {
+	inv, ok := m.Invoices[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &inv, nil
+}
+
+func (m *FakeInvoiceRepo) FetchBySessionID(ctx context.Context, sessionID string) (*models.Invoice, error) {
+	for _, inv := range m.Invoices {
+		if inv.SessionID == sessionID && inv.ProviderInvoiceID == "" {
+			return &inv, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (m *FakeInvoiceRepo) FetchByProviderInvoiceID(ctx context.Context, providerInvoiceID string) (*models.Invoice, error) {
+	for _, inv := range m.Invoices {
+		if inv.ProviderInvoiceID == providerInvoiceID {
+			return &inv, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+// FakePublisher remembers published emails
+type FakePublisher struct {
+	Emails []*InvoiceEmail
+	Err    error
+}
+
+func (m *FakePublisher) Publish(ctx context.Context, email *InvoiceEmail) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	m.Emails = append(m.Emails, email)
+	return nil
+}
+
+func writeEntities(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "legal_entities.json")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func testSession(country string) *models.PaymentSession {
+	return &models.PaymentSession{
+		ID:        uuid.NewString(),
+		UserID:    "user-1",
+		Email:     "jane@example.com",
+		Country:   country,
+		Amount:    1299,
+		Currency:  "eur",
+		TaxAmount: 217,
+		Taxes:     []models.TaxLine{{Name: "VAT", Rate: "20", Inclusive: true, Amount: 217, Currency: "eur"}},
+	}
+}
+
+func TestInvoicesServiceIssue(t *testing.T) {
+	fakeInvoiceRepo := NewFakeInvoiceRepo()
+	fakePublisher := &FakePublisher{}
+	service := NewInvoicesService(zap.NewNop().Sugar(), fakeInvoiceRepo, writeEntities(t, testEntities),
+		WithProductName("Headway Premium"), WithPublisher(fakePublisher))
+
+	type testCase struct {
+		name        string
+		session     *models.PaymentSession
+		entityID    string
+		number      string
+		description string
+	}
+	testCases := []testCase{
+		{name: "success eu", session: testSession("FR"), entityID: "eu", number: "EU-000001", description: "Headway Premium"},
+		{name: "success eu next", session: testSession("DE"), entityID: "eu", number: "EU-000002", description: "Headway Premium"},
+		{name: "success fallback", session: testSession("US"), entityID: "us", number: "US-000001", description: "Headway Premium"},
+		{name: "success anonymous country", session: testSession(""), entityID: "us", number: "US-000002", description: "Headway Premium"},
+		{name: "success plan", session: &models.PaymentSession{ID: uuid.NewString(), Country: "FR", Amount: 999, Currency: "eur",
+			PlanID: "premium-monthly"}, entityID: "eu", number: "EU-000003", description: "Subscription premium-monthly"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			inv, err := service.Issue(context.Background(), tc.session)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.entityID, inv.EntityID)
+			assert.Equal(t, tc.number, inv.Number)
+			assert.Equal(t, tc.description, inv.Description)
+			assert.NotEmpty(t, inv.SellerName)
+			assert.Equal(t, tc.session.Amount, inv.TotalAmount)
+			assert.Equal(t, tc.session.Amount-tc.session.TaxAmount, inv.NetAmount)
+			assert.NotNil(t, inv.Taxes)
+		})
+	}
+	// the plan session has no email, so there is nothing to send
+	assert.Len(t, fakePublisher.Emails, 4)
+	assert.Equal(t, "jane@example.com", fakePublisher.Emails[0].To)
+	assert.Equal(t, "EU-000001.pdf", fakePublisher.Emails[0].Attachments[0].Filename)
+
+	t.Run("repeated session", func(t *testing.T) {
+		session := testSession("FR")
+		first, err := service.Issue(context.Background(), session)
+		assert.NoError(t, err)
+		again, err := service.Issue(context.Background(), session)
+		assert.NoError(t, err)
+		assert.Equal(t, first.ID, again.ID)
+		assert.Equal(t, "EU-000004", again.Number)
+		assert.Equal(t, int64(4), fakeInvoiceRepo.Sequences["eu"])
+		assert.Len(t, fakePublisher.Emails, 5)
+	})
+	t.Run("renewals of the session", func(t *testing.T) {
+		session := testSession("FR")
+		checkout, err := service.Issue(context.Background(), session)
+		assert.NoError(t, err)
+		first, err := service.IssueRenewal(context.Background(), session, "in_1")
+		assert.NoError(t, err)
+		again, err := service.IssueRenewal(context.Background(), session, "in_1")
+		assert.NoError(t, err)
+		next, err := service.IssueRenewal(context.Background(), session, "in_2")
+		assert.NoError(t, err)
+		assert.Equal(t, first.ID, again.ID)
+		assert.Equal(t, "in_1", first.ProviderInvoiceID)
+		assert.Equal(t, []string{"EU-000005", "EU-000006", "EU-000007"}, []string{checkout.Number, first.Number, next.Number})
+		assert.Len(t, fakePublisher.Emails, 8)
+
+		// the checkout invoice is still found by the session
+		repeated, err := service.Issue(context.Background(), session)
+		assert.NoError(t, err)
+		assert.Equal(t, checkout.ID, repeated.ID)
+	})
+	t.Run("trial", func(t *testing.T) {
+		inv, err := service.Issue(context.Background(), &models.PaymentSession{ID: uuid.NewString(), PlanID: "premium-monthly",
+			TrialDays: 7, Currency: "eur"})
+		assert.NoError(t, err)
+		assert.Nil(t, inv)
+	})
+	t.Run("fail repo", func(t *testing.T) {
+		fakeInvoiceRepo.Err = errors.New("connection reset")
+		defer func() { fakeInvoiceRepo.Err = nil }()
+		_, err := service.Issue(context.Background(), testSession("FR"))
+		assert.ErrorIs(t, err, ErrUnexpectedResult)
+		assert.Equal(t, int64(7), fakeInvoiceRepo.Sequences["eu"])
+	})
+	t.Run("publish failure keeps the invoice", func(t *testing.T) {
+		fakePublisher.Err = errors.New("queue is down")
+		defer func() { fakePublisher.Err = nil }()
+		inv, err := service.Issue(context.Background(), testSession("FR"))
+		assert.NoError(t, err)
+		assert.Equal(t, "EU-000008", inv.Number)
+	})
+}
+
+func TestInvoicesServiceEntities(t *testing.T) {
+	type testCase struct {
+		name    string
+		content string
+		err     error
+	}
+	testCases := []testCase{
+		{name: "fail no fallback", content: `{"entities": [{"id": "eu", "name": "EU", "prefix": "EU", "countries": ["DE"]}]}`},
+		{name: "fail two fallbacks", content: `{"entities": [{"id": "eu", "name": "EU", "prefix": "EU"}, {"id": "us", "name": "US", "prefix": "US"}]}`},
+		{name: "fail same prefix", content: `{"entities": [{"id": "eu", "name": "EU", "prefix": "X", "countries": ["DE"]},
+			{"id": "us", "name": "US", "prefix": "X"}]}`},
+		{name: "fail shared country", content: `{"entities": [{"id": "eu", "name": "EU", "prefix": "EU", "countries": ["DE"]},
+			{"id": "de", "name": "DE", "prefix": "DE", "countries": ["de"]}, {"id": "us", "name": "US", "prefix": "US"}]}`},
+		{name: "fail missing prefix", content: `{"entities": [{"id": "us", "name": "US"}]}`},
+		{name: "fail malformed", content: `{"entities": `},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeInvoiceRepo := NewFakeInvoiceRepo()
+			service := NewInvoicesService(zap.NewNop().Sugar(), fakeInvoiceRepo, writeEntities(t, tc.content))
+			_, err := service.Issue(context.Background(), testSession("DE"))
+			assert.ErrorIs(t, err, ErrUnexpectedResult)
+			assert.Empty(t, fakeInvoiceRepo.Invoices)
+		})
+	}
+}
+
+func TestInvoicesServiceInvoice(t *testing.T) {
+	fakeInvoiceRepo := NewFakeInvoiceRepo()
+	service := NewInvoicesService(zap.NewNop().Sugar(), fakeInvoiceRepo, writeEntities(t, testEntities))
+	issued, err := service.Issue(context.Background(), testSession("FR"))
+	assert.NoError(t, err)
+
+	type testCase struct {
+		name string
+		id   string
+		err  error
+	}
+	testCases := []testCase{
+		{name: "success", id: issued.ID},
+		{name: "fail unknown", id: uuid.NewString(), err: ErrNotFound},
+		{name: "fail malformed", id: "EU-000001", err: ErrNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			inv, err := service.Invoice(context.Background(), tc.id)
+			assert.ErrorIs(t, err, tc.err)
+			if tc.err == nil {
+				assert.Equal(t, issued.Number, inv.Number)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	inv := &models.Invoice{
+		ID:            uuid.NewString(),
+		Number:        "EU-000042",
+		SellerName:    "Headway EU B.V.",
+		SellerAddress: []string{"Herengracht 420", "1017 BZ Amsterdam"},
+		SellerTaxID:   "NL859876543B01",
+		Email:         "jane+<test>@example.com",
+		Country:       "CA",
+		Description:   "Headway Premium",
+		NetAmount:     123456,
+		TaxAmount:     18487,
+		TotalAmount:   141943,
+		Currency:      "cad",
+		Taxes: []models.TaxLine{
+			{Name: "GST", Rate: "5", Amount: 6173, Currency: "cad"},
+			{Name: "QST", Rate: "9.975", Amount: 12314, Currency: "cad"},
+		},
+		IssuedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	t.Run("html", func(t *testing.T) {
+		page, err := RenderHTML(inv)
+		assert.NoError(t, err)
+		html := string(page)
+		assert.Contains(t, html, "<title>Invoice EU-000042</title>")
+		assert.Contains(t, html, "Issued on 1 March 2024")
+		assert.Contains(t, html, "1,234.56 CAD")
+		assert.Contains(t, html, "QST 9.975%")
+		assert.Contains(t, html, "1,419.43 CAD")
+		// payer data is escaped
+		assert.Contains(t, html, "jane&#43;&lt;test&gt;@example.com")
+	})
+	t.Run("text", func(t *testing.T) {
+		text := RenderText(inv)
+		assert.Contains(t, text, "Headway Premium: 1,234.56 CAD\n")
+		assert.Contains(t, text, "GST 5%: 61.73 CAD\n")
+		assert.Contains(t, text, "Total paid: 1,419.43 CAD\n")
+	})
+	t.Run("pdf", func(t *testing.T) {
+		doc := RenderPDF(inv)
+		assert.True(t, bytes.HasPrefix(doc, []byte("%PDF-1.4")))
+		assert.True(t, strings.HasSuffix(string(doc), "%%EOF\n"))
+		assert.Contains(t, string(doc), "(Invoice EU-000042) Tj")
+		assert.Contains(t, string(doc), "(1,419.43 CAD) Tj")
+	})
+}
@@ -0,0 +1,70 @@
+package invoices
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+// InvoiceEmail is the email of the issued invoice ready to be sent by the mailer
+type InvoiceEmail struct {
+	InvoiceID   string       `json:"invoice_id"`
+	To          string       `json:"to"`
+	Subject     string       `json:"subject"`
+	Text        string       `json:"text"`
+	HTML        string       `json:"html"`
+	Attachments []Attachment `json:"attachments"`
+}
+
+// Attachment is a file of the email, its content is base64 encoded in JSON
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Content     []byte `json:"content"`
+}
+
+// NewInvoiceEmail builds the email of the invoice to its payer with the PDF attached
+func NewInvoiceEmail(inv *models.Invoice) (*InvoiceEmail, error) {
+	html, err := RenderHTML(inv)
+	if err != nil {
+		return nil, err
+	}
+	return &InvoiceEmail{
+		InvoiceID: inv.ID,
+		To:        inv.Email,
+		Subject:   "Your receipt from " + inv.SellerName + " #" + inv.Number,
+		Text:      RenderText(inv),
+		HTML:      string(html),
+		Attachments: []Attachment{{
+			Filename:    inv.Number + ".pdf",
+			ContentType: "application/pdf",
+			Content:     RenderPDF(inv),
+		}},
+	}, nil
+}
+
+// Publisher hands emails of invoices over to the mailer
+type Publisher interface {
+	Publish(ctx context.Context, email *InvoiceEmail) error
+}
+
+// LogPublisher writes email events to the log, which is shipped to the mailer by the log pipeline.
+// Only the metadata is logged, the mailer renders the body with the invoice API
+type LogPublisher struct {
+	log *zap.SugaredLogger
+}
+
+func NewLogPublisher(log *zap.SugaredLogger) *LogPublisher {
+	return &LogPublisher{log: log}
+}
+
+func (p *LogPublisher) Publish(ctx context.Context, email *InvoiceEmail) error {
+	p.log.Infow("invoice email is ready",
+		"event", "invoice_email",
+		"invoiceID", email.InvoiceID,
+		"to", email.To,
+		"subject", email.Subject)
+	return nil
+}
@@ -0,0 +1,190 @@
+package invoices
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+
+	"payment-api/internal/models"
+	"payment-api/internal/money"
+	"payment-api/internal/pdf"
+)
+
+const dateLayout = "2 January 2006"
+
+// line is a row of the totals of the invoice
+type line struct {
+	Label  string
+	Amount string
+}
+
+// view is the invoice prepared for rendering, amounts are formatted with their currency.
+// The item is priced net of taxes, so the taxes below it add up to the total whether they are inclusive or not
+type view struct {
+	Title         string
+	Number        string
+	Date          string
+	SellerName    string
+	SellerAddress []string
+	SellerTaxID   string
+	Email         string
+	Country       string
+	Description   string
+	Net           string
+	Taxes         []line
+	Total         string
+}
+
+func newView(inv *models.Invoice) view {
+	format := func(amount int64) string {
+		return money.Money{Amount: amount, Currency: inv.Currency}.Format()
+	}
+	v := view{
+		Title:         "Invoice " + inv.Number,
+		Number:        inv.Number,
+		Date:          inv.IssuedAt.UTC().Format(dateLayout),
+		SellerName:    inv.SellerName,
+		SellerAddress: inv.SellerAddress,
+		SellerTaxID:   inv.SellerTaxID,
+		Email:         inv.Email,
+		Country:       inv.Country,
+		Description:   inv.Description,
+		Net:           format(inv.NetAmount),
+		Taxes:         []line{},
+		Total:         format(inv.TotalAmount),
+	}
+	for _, t := range inv.Taxes {
+		v.Taxes = append(v.Taxes, line{Label: fmt.Sprintf("%s %s%%", t.Name, t.Rate), Amount: format(t.Amount)})
+	}
+	return v
+}
+
+var htmlTemplate = template.Must(template.New("invoice").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+</head>
+<body style="font-family: Helvetica, Arial, sans-serif; color: #222; max-width: 640px; margin: 24px auto;">
+<h1 style="font-size: 22px;">{{.Title}}</h1>
+<p>Issued on {{.Date}}</p>
+<table style="width: 100%; margin-bottom: 24px;">
+<tr>
+<td style="vertical-align: top;">
+<strong>{{.SellerName}}</strong><br>
+{{range .SellerAddress}}{{.}}<br>
+{{end}}{{if .SellerTaxID}}Tax ID: {{.SellerTaxID}}<br>
+{{end}}</td>
+<td style="vertical-align: top; text-align: right;">
+<strong>Billed to</strong><br>
+{{if .Email}}{{.Email}}<br>
+{{end}}{{if .Country}}{{.Country}}<br>
+{{end}}</td>
+</tr>
+</table>
+<table style="width: 100%; border-collapse: collapse;">
+<tr style="border-bottom: 1px solid #ccc;">
+<th style="text-align: left;">Description</th>
+<th style="text-align: right;">Amount</th>
+</tr>
+<tr>
+<td>{{.Description}}</td>
+<td style="text-align: right;">{{.Net}}</td>
+</tr>
+{{range .Taxes}}<tr>
+<td style="color: #666;">{{.Label}}</td>
+<td style="text-align: right; color: #666;">{{.Amount}}</td>
+</tr>
+{{end}}<tr style="border-top: 1px solid #ccc;">
+<td><strong>Total paid</strong></td>
+<td style="text-align: right;"><strong>{{.Total}}</strong></td>
+</tr>
+</table>
+</body>
+</html>
+`))
+
+// RenderHTML renders the invoice as a standalone HTML page, which is also the body of the email
+func RenderHTML(inv *models.Invoice) ([]byte, error) {
+	var out bytes.Buffer
+	if err := htmlTemplate.Execute(&out, newView(inv)); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// RenderText renders the invoice as plain text for mail clients without HTML
+func RenderText(inv *models.Invoice) string {
+	v := newView(inv)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\nIssued on %s\n\n", v.Title, v.Date)
+	b.WriteString(v.SellerName + "\n")
+	for _, l := range v.SellerAddress {
+		b.WriteString(l + "\n")
+	}
+	if v.SellerTaxID != "" {
+		fmt.Fprintf(&b, "Tax ID: %s\n", v.SellerTaxID)
+	}
+	fmt.Fprintf(&b, "\n%s: %s\n", v.Description, v.Net)
+	for _, l := range v.Taxes {
+		fmt.Fprintf(&b, "%s: %s\n", l.Label, l.Amount)
+	}
+	fmt.Fprintf(&b, "Total paid: %s\n", v.Total)
+	return b.String()
+}
+
+// RenderPDF renders the invoice as a single A4 page
+func RenderPDF(inv *models.Invoice) []byte {
+	const (
+		left  = 56.0
+		right = pdf.PageWidth - 56
+	)
+	v := newView(inv)
+	doc := pdf.New()
+
+	doc.Text(left, 72, pdf.HelveticaBold, 20, pdf.AlignLeft, v.Title)
+	doc.Text(left, 92, pdf.Helvetica, 10, pdf.AlignLeft, "Issued on "+v.Date)
+
+	y := 130.0
+	doc.Text(left, y, pdf.HelveticaBold, 10, pdf.AlignLeft, v.SellerName)
+	doc.Text(right, y, pdf.HelveticaBold, 10, pdf.AlignRight, "Billed to")
+	seller := append([]string{}, v.SellerAddress...)
+	if v.SellerTaxID != "" {
+		seller = append(seller, "Tax ID: "+v.SellerTaxID)
+	}
+	var buyer []string
+	if v.Email != "" {
+		buyer = append(buyer, v.Email)
+	}
+	if v.Country != "" {
+		buyer = append(buyer, v.Country)
+	}
+	for i := 0; i < len(seller) || i < len(buyer); i++ {
+		y += 14
+		if i < len(seller) {
+			doc.Text(left, y, pdf.Helvetica, 10, pdf.AlignLeft, seller[i])
+		}
+		if i < len(buyer) {
+			doc.Text(right, y, pdf.Helvetica, 10, pdf.AlignRight, buyer[i])
+		}
+	}
+
+	y += 40
+	doc.Text(left, y, pdf.HelveticaBold, 10, pdf.AlignLeft, "Description")
+	doc.Text(right, y, pdf.HelveticaBold, 10, pdf.AlignRight, "Amount")
+	doc.Rule(left, right, y+6, 0.5)
+	y += 24
+	doc.Text(left, y, pdf.Helvetica, 10, pdf.AlignLeft, v.Description)
+	doc.Text(right, y, pdf.Helvetica, 10, pdf.AlignRight, v.Net)
+	for _, l := range v.Taxes {
+		y += 16
+		doc.Text(left, y, pdf.Helvetica, 9, pdf.AlignLeft, l.Label)
+		doc.Text(right, y, pdf.Helvetica, 9, pdf.AlignRight, l.Amount)
+	}
+	doc.Rule(left, right, y+8, 0.5)
+	y += 26
+	doc.Text(left, y, pdf.HelveticaBold, 11, pdf.AlignLeft, "Total paid")
+	doc.Text(right, y, pdf.HelveticaBold, 11, pdf.AlignRight, v.Total)
+	return doc.Bytes()
+}
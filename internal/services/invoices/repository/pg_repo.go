@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+const invoiceColumns = `id, entity_id, sequence, number, session_id, COALESCE(user_id, ''), COALESCE(email, ''),
+	COALESCE(country, ''), seller_name, seller_address, COALESCE(seller_tax_id, ''), description, net_amount, tax_amount,
+	total_amount, currency, COALESCE(provider_invoice_id, ''), issued_at`
+
+type InvoiceRepo struct {
+	log  *zap.SugaredLogger
+	conn *sql.DB
+}
+
+func NewInvoiceRepo(log *zap.SugaredLogger, conn *sql.DB) *InvoiceRepo {
+	return &InvoiceRepo{log: log, conn: conn}
+}
+
+// Create numbers the invoice with the next number of its entity and stores it, the number is formatted
+// with the prefix. The sequence is advanced in the same transaction, so an invoice that is not stored
+// leaves no gap. ErrDuplicate is returned when the session or the provider invoice is invoiced already
+func (r *InvoiceRepo) Create(ctx context.Context, inv *models.Invoice, prefix string) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		r.log.Errorf("failed to begin invoice transaction, error: %v", err)
+		return err
+	}
+	// rollback is no-op after commit
+	defer func() { _ = tx.Rollback() }()
+
+	// the row of the sequence stays locked until commit, so concurrent invoices of the entity queue up
+	stmnt := `INSERT INTO invoice_sequences (entity_id, last_number) VALUES ($1, 1)
+		ON CONFLICT (entity_id) DO UPDATE SET last_number = invoice_sequences.last_number + 1
+		RETURNING last_number`
+	var sequence int64
+	if err := tx.QueryRowContext(ctx, stmnt, inv.EntityID).Scan(&sequence); err != nil {
+		r.log.Errorw("failed to advance invoice sequence",
+			"entityID", inv.EntityID,
+			"error", err)
+		return err
+	}
+	number := fmt.Sprintf("%s-%06d", prefix, sequence)
+
+	stmnt = `INSERT INTO invoices (id, entity_id, sequence, number, session_id, user_id, email, country, seller_name,
+		seller_address, seller_tax_id, description, net_amount, tax_amount, total_amount, currency, provider_invoice_id)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), $9, $10, NULLIF($11, ''), $12,
+		$13, $14, $15, $16, NULLIF($17, ''))
+		ON CONFLICT DO NOTHING RETURNING issued_at`
+	if err := tx.QueryRowContext(ctx, stmnt, inv.ID, inv.EntityID, sequence, number, inv.SessionID, inv.UserID,
+		inv.Email, inv.Country, inv.SellerName, pq.Array(inv.SellerAddress), inv.SellerTaxID, inv.Description,
+		inv.NetAmount, inv.TaxAmount, inv.TotalAmount, inv.Currency, inv.ProviderInvoiceID).Scan(&inv.IssuedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDuplicate
+		}
+		r.log.Errorw("failed to create invoice",
+			"sessionID", inv.SessionID,
+			"error", err)
+		return err
+	}
+	stmnt = `INSERT INTO invoice_taxes (invoice_id, position, name, rate, inclusive, amount, currency)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	for i, t := range inv.Taxes {
+		if _, err := tx.ExecContext(ctx, stmnt, inv.ID, i, t.Name, t.Rate, t.Inclusive, t.Amount, t.Currency); err != nil {
+			r.log.Errorw("failed to create invoice tax",
+				"id", inv.ID,
+				"tax", t.Name,
+				"error", err)
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		r.log.Errorf("failed to commit invoice %v, error: %v", inv.ID, err)
+		return err
+	}
+	inv.Sequence = sequence
+	inv.Number = number
+	return nil
+}
+
+// FetchByID fetches single invoice by id with its taxes
+func (r *InvoiceRepo) FetchByID(ctx context.Context, id string) (*models.Invoice, error) {
+	return r.fetch(ctx, "id = $1", id)
+}
+
+// FetchBySessionID fetches the checkout invoice of the payment session with its taxes
+func (r *InvoiceRepo) FetchBySessionID(ctx context.Context, sessionID string) (*models.Invoice, error) {
+	return r.fetch(ctx, "session_id = $1 AND provider_invoice_id IS NULL", sessionID)
+}
+
+// FetchByProviderInvoiceID fetches the invoice of the subscription renewal with its taxes
+func (r *InvoiceRepo) FetchByProviderInvoiceID(ctx context.Context, providerInvoiceID string) (*models.Invoice, error) {
+	return r.fetch(ctx, "provider_invoice_id = $1", providerInvoiceID)
+}
+
+func (r *InvoiceRepo) fetch(ctx context.Context, condition, value string) (*models.Invoice, error) {
+	stmnt := "SELECT " + invoiceColumns + " FROM invoices WHERE " + condition
+	inv := models.Invoice{}
+	if err := r.conn.QueryRowContext(ctx, stmnt, value).Scan(&inv.ID, &inv.EntityID, &inv.Sequence, &inv.Number,
+		&inv.SessionID, &inv.UserID, &inv.Email, &inv.Country, &inv.SellerName, pq.Array(&inv.SellerAddress),
+		&inv.SellerTaxID, &inv.Description, &inv.NetAmount, &inv.TaxAmount, &inv.TotalAmount, &inv.Currency,
+		&inv.ProviderInvoiceID, &inv.IssuedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		r.log.Errorw("failed to fetch invoice",
+			"condition", condition,
+			"value", value,
+			"error", err)
+		return nil, err
+	}
+
+	stmnt = `SELECT name, rate, inclusive, amount, currency FROM invoice_taxes
+		WHERE invoice_id = $1 ORDER BY position`
+	rows, err := r.conn.QueryContext(ctx, stmnt, inv.ID)
+	if err != nil {
+		r.log.Errorw("failed to fetch invoice taxes",
+			"id", inv.ID,
+			"error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	inv.Taxes = []models.TaxLine{}
+	for rows.Next() {
+		t := models.TaxLine{}
+		if err := rows.Scan(&t.Name, &t.Rate, &t.Inclusive, &t.Amount, &t.Currency); err != nil {
+			r.log.Errorf("failed to scan invoice tax, error: %v", err)
+			return nil, err
+		}
+		inv.Taxes = append(inv.Taxes, t)
+	}
+	return &inv, rows.Err()
+}
@@ -0,0 +1,73 @@
+package ledger
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+// Alerter notifies about broken invariants
+type Alerter interface {
+	Alert(ctx context.Context, message string, imbalances []models.LedgerImbalance)
+}
+
+// LogAlerter reports alerts to the error log, which is watched by the log based alerting
+type LogAlerter struct {
+	log *zap.SugaredLogger
+}
+
+func NewLogAlerter(log *zap.SugaredLogger) *LogAlerter {
+	return &LogAlerter{log: log}
+}
+
+func (a *LogAlerter) Alert(ctx context.Context, message string, imbalances []models.LedgerImbalance) {
+	for _, i := range imbalances {
+		a.log.Errorw(message,
+			"alert", "ledger_imbalance",
+			"entryID", i.EntryID,
+			"currency", i.Currency,
+			"amount", i.Amount)
+	}
+}
+
+// Checker periodically verifies that debits and credits of the ledger match
+type Checker struct {
+	log        *zap.SugaredLogger
+	ledgerRepo LedgerRepo
+	alerter    Alerter
+	interval   time.Duration
+}
+
+func NewChecker(log *zap.SugaredLogger, ledgerRepo LedgerRepo, alerter Alerter, interval time.Duration) *Checker {
+	return &Checker{log: log, ledgerRepo: ledgerRepo, alerter: alerter, interval: interval}
+}
+
+// Check verifies the ledger once and alerts about every imbalance, they are returned as well
+func (c *Checker) Check(ctx context.Context) ([]models.LedgerImbalance, error) {
+	imbalances, err := c.ledgerRepo.Imbalances(ctx)
+	if err != nil {
+		c.log.Errorf("failed to check ledger, error: %v", err)
+		return nil, ErrUnexpectedResult
+	}
+	if len(imbalances) > 0 {
+		c.alerter.Alert(ctx, "ledger debits and credits diverge", imbalances)
+	}
+	return imbalances, nil
+}
+
+// Run checks the ledger on start and then every interval until the context is done
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		_, _ = c.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,9 @@
+package ledger
+
+import "errors"
+
+var (
+	ErrUnbalanced       = errors.New("entry is not balanced")
+	ErrInvalidMovement  = errors.New("movement has invalid amount or currency")
+	ErrUnexpectedResult = errors.New("unexpected error")
+)
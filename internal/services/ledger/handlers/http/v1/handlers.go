@@ -0,0 +1,67 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+type Ledger interface {
+	Balances(ctx context.Context, account string) ([]models.LedgerBalance, error)
+}
+
+type Handler struct {
+	log       *zap.SugaredLogger
+	ledgerSvc Ledger
+}
+
+func NewHandler(log *zap.SugaredLogger, ledgerSvc Ledger) *Handler {
+	return &Handler{log: log, ledgerSvc: ledgerSvc}
+}
+
+// Balances endpoint returns balances per account and currency, optional `account` narrows them to a single account
+func (h *Handler) Balances() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+			return
+		}
+
+		balances, err := h.ledgerSvc.Balances(r.Context(), r.URL.Query().Get("account"))
+		if err != nil {
+			h.log.Errorf("failed to fetch ledger balances")
+			writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+			return
+		}
+
+		items := make([]map[string]any, 0, len(balances))
+		for _, b := range balances {
+			items = append(items, map[string]any{
+				"account":  b.Account,
+				"currency": b.Currency,
+				"balance":  b.Balance,
+			})
+		}
+		writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": items})
+	}
+}
+
+// writeJson peforms write to the response writer and header as json content-type
+func writeJson(w http.ResponseWriter, status int, data map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if data == nil {
+		return
+	}
+
+	enc, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	_, _ = w.Write(enc)
+}
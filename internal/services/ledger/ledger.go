@@ -0,0 +1,142 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/services/ledger/repository"
+)
+
+// Repository for ledger entries
+type LedgerRepo interface {
+	Post(ctx context.Context, e *models.LedgerEntry) error
+	Balances(ctx context.Context, account string) ([]models.LedgerBalance, error)
+	Imbalances(ctx context.Context) ([]models.LedgerImbalance, error)
+}
+
+type LedgerService struct {
+	log        *zap.SugaredLogger
+	ledgerRepo LedgerRepo
+}
+
+func NewLedgerService(log *zap.SugaredLogger, ledgerRepo LedgerRepo) *LedgerService {
+	return &LedgerService{log: log, ledgerRepo: ledgerRepo}
+}
+
+// Movement is money moved through the provider
+type Movement struct {
+	// Reference identifies the movement, it is posted only once
+	Reference string
+	Provider  string
+	// Amount is positive and in minor units of the currency
+	Amount   int64
+	Currency string
+}
+
+// ProviderAccount is the account of funds held by the provider until they are settled
+func ProviderAccount(provider string) string {
+	return models.LedgerAccountProvider + strings.ToLower(provider)
+}
+
+// RecordPayment posts the captured payment of the session, funds held by the provider earn revenue
+func (s *LedgerService) RecordPayment(ctx context.Context, session *models.PaymentSession, provider string) error {
+	return s.post(ctx, models.LedgerEntryPayment, Movement{
+		Reference: session.ID,
+		Provider:  provider,
+		Amount:    session.Amount,
+		Currency:  session.Currency,
+	}, models.LedgerAccountRevenue, true)
+}
+
+// RecordRenewal posts the payment the provider has charged for the renewal of a subscription,
+// it is referenced by the provider invoice as the session is paid once
+func (s *LedgerService) RecordRenewal(ctx context.Context, m Movement) error {
+	return s.post(ctx, models.LedgerEntryPayment, m, models.LedgerAccountRevenue, true)
+}
+
+// RecordRefund posts the refund returned to the customer from funds held by the provider
+func (s *LedgerService) RecordRefund(ctx context.Context, m Movement) error {
+	return s.post(ctx, models.LedgerEntryRefund, m, models.LedgerAccountRefunds, false)
+}
+
+// RecordChargeback posts the chargeback the provider withdrew on behalf of the issuer
+func (s *LedgerService) RecordChargeback(ctx context.Context, m Movement) error {
+	return s.post(ctx, models.LedgerEntryChargeback, m, models.LedgerAccountChargebacks, false)
+}
+
+// Balances returns balances of the account per currency, empty account stands for every account
+func (s *LedgerService) Balances(ctx context.Context, account string) ([]models.LedgerBalance, error) {
+	balances, err := s.ledgerRepo.Balances(ctx, account)
+	if err != nil {
+		s.log.Errorw("failed to fetch ledger balances",
+			"account", account,
+			"error", err)
+		return nil, ErrUnexpectedResult
+	}
+	return balances, nil
+}
+
+// post writes the movement between the provider account and the counter account,
+// incoming movement debits the provider account and outgoing one credits it.
+// Repeated movement of the same reference is not posted twice
+func (s *LedgerService) post(ctx context.Context, kind string, m Movement, counter string, incoming bool) error {
+	currency := strings.ToLower(m.Currency)
+	if m.Reference == "" || m.Amount <= 0 || len(currency) != 3 {
+		s.log.Errorw("failed to post invalid movement",
+			"kind", kind,
+			"reference", m.Reference,
+			"amount", m.Amount,
+			"currency", m.Currency)
+		return ErrInvalidMovement
+	}
+	amount := m.Amount
+	if !incoming {
+		amount = -amount
+	}
+	entry := &models.LedgerEntry{
+		ID:        uuid.NewString(),
+		Kind:      kind,
+		Reference: m.Reference,
+		Postings: []models.LedgerPosting{
+			{Account: ProviderAccount(m.Provider), Currency: currency, Amount: amount},
+			{Account: counter, Currency: currency, Amount: -amount},
+		},
+	}
+	if err := Validate(entry); err != nil {
+		return err
+	}
+	err := s.ledgerRepo.Post(ctx, entry)
+	if errors.Is(err, repository.ErrDuplicate) {
+		return nil
+	}
+	if err != nil {
+		s.log.Errorf("failed to post %v of %v, error: %v", kind, m.Reference, err)
+		return ErrUnexpectedResult
+	}
+	return nil
+}
+
+// Validate checks that the entry has at least two postings and they sum up to zero in every currency
+func Validate(e *models.LedgerEntry) error {
+	if len(e.Postings) < 2 {
+		return ErrUnbalanced
+	}
+	sums := map[string]int64{}
+	for _, p := range e.Postings {
+		if p.Amount == 0 || p.Account == "" {
+			return ErrUnbalanced
+		}
+		sums[p.Currency] += p.Amount
+	}
+	for _, sum := range sums {
+		if sum != 0 {
+			return ErrUnbalanced
+		}
+	}
+	return nil
+}
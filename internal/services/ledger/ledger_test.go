@@ -0,0 +1,161 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/services/ledger/repository"
+)
+
+// FakeLedgerRepo keeps posted entries in memory, Imbalances are served from the field
+type FakeLedgerRepo struct {
+	Entries    []*models.LedgerEntry
+	Imbalanced []models.LedgerImbalance
+	Err        error
+}
+
+func (m *FakeLedgerRepo) Post(ctx context.Context, e *models.LedgerEntry) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	for _, posted := range m.Entries {
+		if posted.Kind == e.Kind && posted.Reference == e.Reference {
+			return repository.ErrDuplicate
+		}
+	}
+	m.Entries = append(m.Entries, e)
+	return nil
+}
+
+func (m *FakeLedgerRepo) Balances(ctx context.Context, account string) ([]models.LedgerBalance, error) {
+	sums := map[[2]string]int64{}
+	for _, e := range m.Entries {
+		for _, p := range e.Postings {
+			if account == "" || p.Account == account {
+				sums[[2]string{p.Account, p.Currency}] += p.Amount
+			}
+		}
+	}
+	var balances []models.LedgerBalance
+	for k, v := range sums {
+		balances = append(balances, models.LedgerBalance{Account: k[0], Currency: k[1], Balance: v})
+	}
+	return balances, nil
+}
+
+func (m *FakeLedgerRepo) Imbalances(ctx context.Context) ([]models.LedgerImbalance, error) {
+	return m.Imbalanced, m.Err
+}
+
+// FakeAlerter records imbalances it was alerted about
+type FakeAlerter struct {
+	Alerted []models.LedgerImbalance
+}
+
+func (m *FakeAlerter) Alert(ctx context.Context, message string, imbalances []models.LedgerImbalance) {
+	m.Alerted = append(m.Alerted, imbalances...)
+}
+
+func TestLedgerService(t *testing.T) {
+	repo := &FakeLedgerRepo{}
+	service := NewLedgerService(zap.NewNop().Sugar(), repo)
+	ctx := context.Background()
+	session := &models.PaymentSession{ID: "session", Amount: 1299, Currency: "USD"}
+
+	assert.NoError(t, service.RecordPayment(ctx, session, "Stripe"))
+	// repeated capture of the same session is posted once
+	assert.NoError(t, service.RecordPayment(ctx, session, "Stripe"))
+	assert.Len(t, repo.Entries, 1)
+	assert.Equal(t, []models.LedgerPosting{
+		{Account: "provider:stripe", Currency: "usd", Amount: 1299},
+		{Account: models.LedgerAccountRevenue, Currency: "usd", Amount: -1299},
+	}, repo.Entries[0].Postings)
+
+	// renewals of the session are posted once per provider invoice
+	renewal := Movement{Reference: "in_1", Provider: "Stripe", Amount: 1299, Currency: "usd"}
+	assert.NoError(t, service.RecordRenewal(ctx, renewal))
+	assert.NoError(t, service.RecordRenewal(ctx, renewal))
+	assert.NoError(t, service.RecordRenewal(ctx, Movement{Reference: "in_2", Provider: "Stripe", Amount: 1299, Currency: "usd"}))
+	assert.Len(t, repo.Entries, 3)
+	assert.Equal(t, models.LedgerEntryPayment, repo.Entries[1].Kind)
+	assert.Equal(t, "in_1", repo.Entries[1].Reference)
+
+	assert.NoError(t, service.RecordRefund(ctx, Movement{Reference: "re_1", Provider: "Stripe", Amount: 299, Currency: "usd"}))
+	assert.NoError(t, service.RecordChargeback(ctx, Movement{Reference: "dp_1", Provider: "Stripe", Amount: 1000, Currency: "usd"}))
+
+	balances, err := service.Balances(ctx, "provider:stripe")
+	assert.NoError(t, err)
+	assert.Equal(t, []models.LedgerBalance{{Account: "provider:stripe", Currency: "usd", Balance: 2598}}, balances)
+	balances, err = service.Balances(ctx, models.LedgerAccountRefunds)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.LedgerBalance{{Account: models.LedgerAccountRefunds, Currency: "usd", Balance: 299}}, balances)
+}
+
+func TestLedgerServiceFail(t *testing.T) {
+	type testCase struct {
+		name     string
+		movement Movement
+		repoErr  error
+		err      error
+	}
+	testCases := []testCase{
+		{name: "zero amount", movement: Movement{Reference: "re_1", Provider: "stripe", Currency: "usd"}, err: ErrInvalidMovement},
+		{name: "negative amount", movement: Movement{Reference: "re_1", Provider: "stripe", Amount: -1, Currency: "usd"}, err: ErrInvalidMovement},
+		{name: "missing currency", movement: Movement{Reference: "re_1", Provider: "stripe", Amount: 100}, err: ErrInvalidMovement},
+		{name: "missing reference", movement: Movement{Provider: "stripe", Amount: 100, Currency: "usd"}, err: ErrInvalidMovement},
+		{name: "repository failure", movement: Movement{Reference: "re_1", Provider: "stripe", Amount: 100, Currency: "usd"}, repoErr: errors.New("db is down"), err: ErrUnexpectedResult},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &FakeLedgerRepo{Err: tc.repoErr}
+			service := NewLedgerService(zap.NewNop().Sugar(), repo)
+			assert.ErrorIs(t, service.RecordRefund(context.Background(), tc.movement), tc.err)
+			assert.Empty(t, repo.Entries)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	posting := func(account, currency string, amount int64) models.LedgerPosting {
+		return models.LedgerPosting{Account: account, Currency: currency, Amount: amount}
+	}
+	assert.NoError(t, Validate(&models.LedgerEntry{Postings: []models.LedgerPosting{
+		posting("provider:stripe", "usd", 100), posting("provider:stripe", "eur", 90),
+		posting("revenue", "usd", -100), posting("revenue", "eur", -90),
+	}}))
+	assert.ErrorIs(t, Validate(&models.LedgerEntry{Postings: []models.LedgerPosting{posting("revenue", "usd", 0)}}), ErrUnbalanced)
+	assert.ErrorIs(t, Validate(&models.LedgerEntry{Postings: []models.LedgerPosting{
+		posting("provider:stripe", "usd", 100), posting("revenue", "usd", -99),
+	}}), ErrUnbalanced)
+	// balanced in total, but not per currency
+	assert.ErrorIs(t, Validate(&models.LedgerEntry{Postings: []models.LedgerPosting{
+		posting("provider:stripe", "usd", 100), posting("revenue", "eur", -100),
+	}}), ErrUnbalanced)
+}
+
+func TestChecker(t *testing.T) {
+	repo := &FakeLedgerRepo{}
+	alerter := &FakeAlerter{}
+	checker := NewChecker(zap.NewNop().Sugar(), repo, alerter, time.Hour)
+
+	imbalances, err := checker.Check(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, imbalances)
+	assert.Empty(t, alerter.Alerted)
+
+	repo.Imbalanced = []models.LedgerImbalance{{EntryID: "entry", Currency: "usd", Amount: 1}}
+	imbalances, err = checker.Check(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, repo.Imbalanced, imbalances)
+	assert.Equal(t, repo.Imbalanced, alerter.Alerted)
+
+	repo.Err = errors.New("db is down")
+	_, err = checker.Check(context.Background())
+	assert.ErrorIs(t, err, ErrUnexpectedResult)
+}
@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+var ErrDuplicate = errors.New("entry is already posted")
+
+type LedgerRepo struct {
+	log  *zap.SugaredLogger
+	conn *sql.DB
+}
+
+func NewLedgerRepo(log *zap.SugaredLogger, conn *sql.DB) *LedgerRepo {
+	return &LedgerRepo{log: log, conn: conn}
+}
+
+// Post writes the entry together with its postings in a single transaction,
+// ErrDuplicate is returned when the entry of the same kind and reference exists
+func (r *LedgerRepo) Post(ctx context.Context, e *models.LedgerEntry) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		r.log.Errorf("failed to begin ledger transaction, error: %v", err)
+		return err
+	}
+	// rollback is no-op after commit
+	defer func() { _ = tx.Rollback() }()
+
+	stmnt := `INSERT INTO ledger_entries (id, kind, reference) VALUES ($1, $2, $3)
+		ON CONFLICT (kind, reference) DO NOTHING RETURNING created_at`
+	if err := tx.QueryRowContext(ctx, stmnt, e.ID, e.Kind, e.Reference).Scan(&e.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDuplicate
+		}
+		r.log.Errorw("failed to insert ledger entry",
+			"kind", e.Kind,
+			"reference", e.Reference,
+			"error", err)
+		return err
+	}
+	for _, p := range e.Postings {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO ledger_accounts (code) VALUES ($1) ON CONFLICT DO NOTHING", p.Account); err != nil {
+			r.log.Errorw("failed to insert ledger account",
+				"account", p.Account,
+				"error", err)
+			return err
+		}
+		stmnt := "INSERT INTO ledger_postings (entry_id, account, currency, amount) VALUES ($1, $2, $3, $4)"
+		if _, err := tx.ExecContext(ctx, stmnt, e.ID, p.Account, p.Currency, p.Amount); err != nil {
+			r.log.Errorw("failed to insert ledger posting",
+				"entryID", e.ID,
+				"account", p.Account,
+				"error", err)
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		r.log.Errorf("failed to commit ledger entry %v, error: %v", e.ID, err)
+		return err
+	}
+	return nil
+}
+
+// Balances sums postings of every account per currency, empty account stands for every account
+func (r *LedgerRepo) Balances(ctx context.Context, account string) ([]models.LedgerBalance, error) {
+	stmnt := `SELECT account, currency, SUM(amount) FROM ledger_postings
+		WHERE $1 = '' OR account = $1
+		GROUP BY account, currency ORDER BY account, currency`
+	rows, err := r.conn.QueryContext(ctx, stmnt, account)
+	if err != nil {
+		r.log.Errorw("failed to fetch ledger balances",
+			"account", account,
+			"error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := []models.LedgerBalance{}
+	for rows.Next() {
+		b := models.LedgerBalance{}
+		if err := rows.Scan(&b.Account, &b.Currency, &b.Balance); err != nil {
+			return nil, err
+		}
+		balances = append(balances, b)
+	}
+	return balances, rows.Err()
+}
+
+// Imbalances returns entries which postings don't sum up to zero together with
+// the currencies the whole ledger is out of balance in
+func (r *LedgerRepo) Imbalances(ctx context.Context) ([]models.LedgerImbalance, error) {
+	stmnt := `SELECT entry_id::text, currency, SUM(amount) FROM ledger_postings
+		GROUP BY entry_id, currency HAVING SUM(amount) <> 0
+		UNION ALL
+		SELECT '', currency, SUM(amount) FROM ledger_postings
+		GROUP BY currency HAVING SUM(amount) <> 0`
+	rows, err := r.conn.QueryContext(ctx, stmnt)
+	if err != nil {
+		r.log.Errorf("failed to check ledger balance, error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	imbalances := []models.LedgerImbalance{}
+	for rows.Next() {
+		i := models.LedgerImbalance{}
+		if err := rows.Scan(&i.EntryID, &i.Currency, &i.Amount); err != nil {
+			return nil, err
+		}
+		imbalances = append(imbalances, i)
+	}
+	return imbalances, rows.Err()
+}
@@ -0,0 +1,10 @@
+package lists
+
+import "errors"
+
+var (
+	ErrNotFound         = errors.New("list entry is not found")
+	ErrInvalid          = errors.New("list entry is invalid")
+	ErrDuplicate        = errors.New("value is already listed")
+	ErrUnexpectedResult = errors.New("unexpected error")
+)
@@ -0,0 +1,225 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/middlwares"
+	"payment-api/internal/models"
+	"payment-api/internal/services/lists"
+)
+
+type Lists interface {
+	Entries(ctx context.Context, list, kind string) ([]models.ListEntry, error)
+	Entry(ctx context.Context, id string) (*models.ListEntry, error)
+	Create(ctx context.Context, e *models.ListEntry) error
+	Update(ctx context.Context, e *models.ListEntry) error
+	Delete(ctx context.Context, id string) error
+	Matches(t lists.Target) []models.ListEntry
+	Blocked(t lists.Target) *models.ListEntry
+}
+
+type Handler struct {
+	log      *zap.SugaredLogger
+	listsSvc Lists
+	// ipHeader is set by CDN with the ip of the client
+	ipHeader string
+}
+
+func NewHandler(log *zap.SugaredLogger, listsSvc Lists, ipHeader string) *Handler {
+	return &Handler{log: log, listsSvc: listsSvc, ipHeader: ipHeader}
+}
+
+// entryBody is the entry as it is created and listed, list, kind, match and value can't be updated
+type entryBody struct {
+	ID        string     `json:"id"`
+	List      string     `json:"list"`
+	Kind      string     `json:"kind"`
+	Match     string     `json:"match"`
+	Value     string     `json:"value"`
+	Reason    string     `json:"reason"`
+	CreatedBy string     `json:"created_by"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// Enforce rejects requests of blocked payers before they reach the handler, the payer is matched
+// by the ip of the client and the email and deviceID parameters. The parameters are chosen by the client,
+// which is why allow entries override blocks of their own kind only. BIN entries are not enforced here:
+// the card is entered on the checkout page of the provider, so BIN blocks are mirrored to the block lists
+// of the provider (e.g. Stripe Radar) and the entries serve the check endpoint used by support
+func (h *Handler) Enforce(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		blocked := h.listsSvc.Blocked(lists.Target{
+			Email:    q.Get("email"),
+			IP:       middlwares.ClientIP(r, h.ipHeader),
+			DeviceID: q.Get("deviceID"),
+		})
+		if blocked != nil {
+			h.log.Infow("request is blocked",
+				"entryID", blocked.ID,
+				"kind", blocked.Kind,
+				"request_id", middlwares.RequestID(r.Context()))
+			writeJson(w, http.StatusForbidden, map[string]any{"code": http.StatusForbidden, "message": "Payment is not available"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// Entries endpoint lists entries on GET, optionally of the list and the kind, and creates the entry on POST
+func (h *Handler) Entries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			list, err := h.listsSvc.Entries(r.Context(), r.URL.Query().Get("list"), r.URL.Query().Get("kind"))
+			if err != nil {
+				h.log.Errorf("failed to list entries")
+				writeError(w, err)
+				return
+			}
+			items := make([]entryBody, 0, len(list))
+			for i := range list {
+				items = append(items, toBody(&list[i]))
+			}
+			writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": items})
+		case http.MethodPost:
+			var body entryBody
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Provided parameter has bad format"})
+				return
+			}
+			e := &models.ListEntry{
+				List:      body.List,
+				Kind:      body.Kind,
+				Match:     body.Match,
+				Value:     body.Value,
+				Reason:    body.Reason,
+				CreatedBy: body.CreatedBy,
+				ExpiresAt: body.ExpiresAt,
+			}
+			if err := h.listsSvc.Create(r.Context(), e); err != nil {
+				h.log.Errorf("failed to create list entry")
+				writeError(w, err)
+				return
+			}
+			writeJson(w, http.StatusCreated, map[string]any{"code": http.StatusCreated, "data": toBody(e)})
+		default:
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+		}
+	}
+}
+
+// Entry endpoint returns the entry on GET /api/v1/lists/entries/{id}, replaces its reason
+// and expiry on PUT and deletes it on DELETE
+func (h *Handler) Entry() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strings.CutPrefix(r.URL.Path, "/api/v1/lists/entries/")
+		if id == "" || strings.Contains(id, "/") {
+			writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Not found"})
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			e, err := h.listsSvc.Entry(r.Context(), id)
+			if err != nil {
+				h.log.Errorf("failed to fetch list entry")
+				writeError(w, err)
+				return
+			}
+			writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": toBody(e)})
+		case http.MethodPut:
+			var body entryBody
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Provided parameter has bad format"})
+				return
+			}
+			e := &models.ListEntry{ID: id, Reason: body.Reason, ExpiresAt: body.ExpiresAt}
+			if err := h.listsSvc.Update(r.Context(), e); err != nil {
+				h.log.Errorf("failed to update list entry")
+				writeError(w, err)
+				return
+			}
+			writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": toBody(e)})
+		case http.MethodDelete:
+			if err := h.listsSvc.Delete(r.Context(), id); err != nil {
+				h.log.Errorf("failed to delete list entry")
+				writeError(w, err)
+				return
+			}
+			writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "message": "List entry is deleted"})
+		default:
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+		}
+	}
+}
+
+// Check endpoint tells on GET whether the email, ip, bin or device parameters are blocked and which entries match them
+func (h *Handler) Check() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+			return
+		}
+		q := r.URL.Query()
+		t := lists.Target{Email: q.Get("email"), IP: q.Get("ip"), BIN: q.Get("bin"), DeviceID: q.Get("device")}
+		matches := h.listsSvc.Matches(t)
+		items := make([]entryBody, 0, len(matches))
+		for i := range matches {
+			items = append(items, toBody(&matches[i]))
+		}
+		writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "blocked": h.listsSvc.Blocked(t) != nil, "data": items})
+	}
+}
+
+func toBody(e *models.ListEntry) entryBody {
+	return entryBody{
+		ID:        e.ID,
+		List:      e.List,
+		Kind:      e.Kind,
+		Match:     e.Match,
+		Value:     e.Value,
+		Reason:    e.Reason,
+		CreatedBy: e.CreatedBy,
+		ExpiresAt: e.ExpiresAt,
+		CreatedAt: e.CreatedAt,
+		UpdatedAt: e.UpdatedAt,
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, lists.ErrNotFound):
+		writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Not found"})
+	case errors.Is(err, lists.ErrInvalid):
+		writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": err.Error()})
+	case errors.Is(err, lists.ErrDuplicate):
+		writeJson(w, http.StatusConflict, map[string]any{"code": http.StatusConflict, "message": "Value is already listed"})
+	default:
+		writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+	}
+}
+
+// writeJson peforms write to the response writer and header as json content-type
+func writeJson(w http.ResponseWriter, status int, data map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if data == nil {
+		return
+	}
+
+	enc, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	_, _ = w.Write(enc)
+}
@@ -0,0 +1,117 @@
+package lists
+
+import (
+	"net"
+	"time"
+
+	"payment-api/internal/models"
+)
+
+// cidrNode is a node of the binary radix tree of ip ranges, the range of the node is the path of bits to it
+type cidrNode struct {
+	children [2]*cidrNode
+	entries  []models.ListEntry
+}
+
+// cidrTree matches ips against ranges in up to 128 steps however many ranges are listed. Addresses are
+// 16 bytes long, so IPv4 ranges sit under the IPv4-mapped prefix and match IPv4 addresses in either form
+type cidrTree struct {
+	root cidrNode
+}
+
+func (t *cidrTree) insert(network *net.IPNet, e models.ListEntry) {
+	ones, bits := network.Mask.Size()
+	ip := network.IP.To16()
+	if bits == 8*net.IPv4len {
+		ones += 8 * (net.IPv6len - net.IPv4len)
+	}
+	n := &t.root
+	for i := 0; i < ones; i++ {
+		bit := bitAt(ip, i)
+		if n.children[bit] == nil {
+			n.children[bit] = &cidrNode{}
+		}
+		n = n.children[bit]
+	}
+	n.entries = append(n.entries, e)
+}
+
+// lookup returns entries of every range containing the ip
+func (t *cidrTree) lookup(ip net.IP) []models.ListEntry {
+	ip = ip.To16()
+	if ip == nil {
+		return nil
+	}
+	var found []models.ListEntry
+	n := &t.root
+	for i := 0; n != nil; i++ {
+		found = append(found, n.entries...)
+		if i == 8*net.IPv6len {
+			break
+		}
+		n = n.children[bitAt(ip, i)]
+	}
+	return found
+}
+
+func bitAt(ip net.IP, i int) int {
+	return int(ip[i/8]>>(7-i%8)) & 1
+}
+
+// index holds active entries of both lists for matching in memory
+type index struct {
+	// exact and prefix entries are keyed by kind and value
+	exact  map[string][]models.ListEntry
+	prefix map[string][]models.ListEntry
+	// longestPrefix bounds prefixes tried for the values of the kind
+	longestPrefix map[string]int
+	cidr          cidrTree
+}
+
+func newIndex(entries []models.ListEntry) *index {
+	idx := &index{
+		exact:         map[string][]models.ListEntry{},
+		prefix:        map[string][]models.ListEntry{},
+		longestPrefix: map[string]int{},
+	}
+	for _, e := range entries {
+		switch e.Match {
+		case models.ListMatchExact:
+			key := e.Kind + ":" + e.Value
+			idx.exact[key] = append(idx.exact[key], e)
+		case models.ListMatchPrefix:
+			key := e.Kind + ":" + e.Value
+			idx.prefix[key] = append(idx.prefix[key], e)
+			if len(e.Value) > idx.longestPrefix[e.Kind] {
+				idx.longestPrefix[e.Kind] = len(e.Value)
+			}
+		case models.ListMatchCIDR:
+			if _, network, err := net.ParseCIDR(e.Value); err == nil {
+				idx.cidr.insert(network, e)
+			}
+		}
+	}
+	return idx
+}
+
+// match returns entries active at the time matching the normalized value of the kind
+func (idx *index) match(kind, value string, at time.Time) []models.ListEntry {
+	if value == "" {
+		return nil
+	}
+	found := append([]models.ListEntry{}, idx.exact[kind+":"+value]...)
+	for i := 1; i <= len(value) && i <= idx.longestPrefix[kind]; i++ {
+		found = append(found, idx.prefix[kind+":"+value[:i]]...)
+	}
+	if kind == models.ListKindIP {
+		found = append(found, idx.cidr.lookup(net.ParseIP(value))...)
+	}
+	// entries expired since the last refresh are skipped
+	active := found[:0]
+	for _, e := range found {
+		if e.Active(at) {
+			active = append(active, e)
+		}
+	}
+	return active
+}
@@ -0,0 +1,265 @@
+package lists
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/services/lists/repository"
+)
+
+const (
+	maxValueLength  = 320
+	maxReasonLength = 500
+)
+
+// Repository for list entries
+type EntryRepo interface {
+	Create(ctx context.Context, e *models.ListEntry) error
+	Update(ctx context.Context, e *models.ListEntry) error
+	Delete(ctx context.Context, id string) error
+	FetchByID(ctx context.Context, id string) (*models.ListEntry, error)
+	List(ctx context.Context, list, kind string) ([]models.ListEntry, error)
+	Active(ctx context.Context, at time.Time) ([]models.ListEntry, error)
+}
+
+// ListsService matches payers against the block and allow lists in memory, the index is rebuilt
+// from the repository periodically and right away on changes made by the instance itself
+type ListsService struct {
+	log       *zap.SugaredLogger
+	entryRepo EntryRepo
+	now       func() time.Time
+
+	mu  sync.RWMutex
+	idx *index
+}
+
+func NewListsService(log *zap.SugaredLogger, entryRepo EntryRepo) *ListsService {
+	return &ListsService{log: log, entryRepo: entryRepo, now: time.Now, idx: newIndex(nil)}
+}
+
+// Target is who is matched against the lists, every field is optional
+type Target struct {
+	Email    string
+	IP       string
+	BIN      string
+	DeviceID string
+}
+
+// Matches returns every active entry of both lists matching the target
+func (s *ListsService) Matches(t Target) []models.ListEntry {
+	now := s.now()
+	s.mu.RLock()
+	idx := s.idx
+	s.mu.RUnlock()
+
+	found := []models.ListEntry{}
+	found = append(found, idx.match(models.ListKindEmail, strings.ToLower(strings.TrimSpace(t.Email)), now)...)
+	if ip := net.ParseIP(strings.TrimSpace(t.IP)); ip != nil {
+		found = append(found, idx.match(models.ListKindIP, ip.String(), now)...)
+	}
+	found = append(found, idx.match(models.ListKindBIN, strings.TrimSpace(t.BIN), now)...)
+	found = append(found, idx.match(models.ListKindDevice, strings.TrimSpace(t.DeviceID), now)...)
+	return found
+}
+
+// Blocked returns the block entry matching the target, nil is returned when nothing blocks the target.
+// An allow entry overrides blocks of its own kind only, e.g. an office range inside a blocked network,
+// so an allowed email doesn't let a blocked ip or device through
+func (s *ListsService) Blocked(t Target) *models.ListEntry {
+	matches := s.Matches(t)
+	allowed := map[string]bool{}
+	for _, e := range matches {
+		if e.List == models.ListAllow {
+			allowed[e.Kind] = true
+		}
+	}
+	for i := range matches {
+		if matches[i].List == models.ListBlock && !allowed[matches[i].Kind] {
+			return &matches[i]
+		}
+	}
+	return nil
+}
+
+// Entries returns stored entries of the list and the kind, expired ones included
+func (s *ListsService) Entries(ctx context.Context, list, kind string) ([]models.ListEntry, error) {
+	entries, err := s.entryRepo.List(ctx, list, kind)
+	if err != nil {
+		s.log.Errorf("failed to list entries, error: %v", err)
+		return nil, ErrUnexpectedResult
+	}
+	return entries, nil
+}
+
+// Entry returns single entry by id
+func (s *ListsService) Entry(ctx context.Context, id string) (*models.ListEntry, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	e, err := s.entryRepo.FetchByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		s.log.Errorw("failed to fetch list entry",
+			"id", id,
+			"error", err)
+		return nil, ErrUnexpectedResult
+	}
+	return e, nil
+}
+
+// Create validates and stores the entry, it takes effect on this instance immediately
+// and on the others with the next refresh
+func (s *ListsService) Create(ctx context.Context, e *models.ListEntry) error {
+	e.ID = uuid.NewString()
+	if err := s.normalize(e); err != nil {
+		return err
+	}
+	if err := s.entryRepo.Create(ctx, e); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			return ErrDuplicate
+		}
+		s.log.Errorf("failed to create %v entry, error: %v", e.Kind, err)
+		return ErrUnexpectedResult
+	}
+	_ = s.Refresh(ctx)
+	return nil
+}
+
+// Update replaces reason and expiry of the entry, the updated entry is returned into e
+func (s *ListsService) Update(ctx context.Context, e *models.ListEntry) error {
+	if _, err := uuid.Parse(e.ID); err != nil {
+		return ErrNotFound
+	}
+	if err := s.validateDetails(e); err != nil {
+		return err
+	}
+	if err := s.entryRepo.Update(ctx, e); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrNotFound
+		}
+		s.log.Errorf("failed to update list entry %v, error: %v", e.ID, err)
+		return ErrUnexpectedResult
+	}
+	_ = s.Refresh(ctx)
+	return nil
+}
+
+// Delete removes the entry
+func (s *ListsService) Delete(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	if err := s.entryRepo.Delete(ctx, id); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrNotFound
+		}
+		s.log.Errorf("failed to delete list entry %v, error: %v", id, err)
+		return ErrUnexpectedResult
+	}
+	_ = s.Refresh(ctx)
+	return nil
+}
+
+// Refresh rebuilds the index from the active entries, the index is kept when they can't be fetched
+func (s *ListsService) Refresh(ctx context.Context) error {
+	entries, err := s.entryRepo.Active(ctx, s.now().UTC())
+	if err != nil {
+		s.log.Errorf("failed to refresh lists, error: %v", err)
+		return ErrUnexpectedResult
+	}
+	idx := newIndex(entries)
+	s.mu.Lock()
+	s.idx = idx
+	s.mu.Unlock()
+	return nil
+}
+
+// Run refreshes the index every interval until the context is cancelled
+func (s *ListsService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_ = s.Refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// normalize validates the entry and brings its value to the form values are matched in
+func (s *ListsService) normalize(e *models.ListEntry) error {
+	if e.List != models.ListBlock && e.List != models.ListAllow {
+		return fmt.Errorf("%w: list must be block or allow", ErrInvalid)
+	}
+	if e.Match != models.ListMatchExact && e.Match != models.ListMatchCIDR && e.Match != models.ListMatchPrefix {
+		return fmt.Errorf("%w: match must be exact, cidr or prefix", ErrInvalid)
+	}
+	e.CreatedBy = strings.TrimSpace(e.CreatedBy)
+	if e.CreatedBy == "" || len(e.CreatedBy) > 128 {
+		return fmt.Errorf("%w: creator must be 1 to 128 characters", ErrInvalid)
+	}
+	e.Value = strings.TrimSpace(e.Value)
+	if e.Value == "" || len(e.Value) > maxValueLength {
+		return fmt.Errorf("%w: value must be 1 to %v characters", ErrInvalid, maxValueLength)
+	}
+	switch {
+	case e.Kind == models.ListKindEmail && e.Match != models.ListMatchCIDR:
+		e.Value = strings.ToLower(e.Value)
+		if e.Match == models.ListMatchExact && !strings.Contains(e.Value, "@") {
+			return fmt.Errorf("%w: email must contain @", ErrInvalid)
+		}
+	case e.Kind == models.ListKindIP && e.Match == models.ListMatchExact:
+		ip := net.ParseIP(e.Value)
+		if ip == nil {
+			return fmt.Errorf("%w: ip is malformed", ErrInvalid)
+		}
+		e.Value = ip.String()
+	case e.Kind == models.ListKindIP && e.Match == models.ListMatchCIDR:
+		_, network, err := net.ParseCIDR(e.Value)
+		if err != nil {
+			return fmt.Errorf("%w: ip range must be in CIDR notation", ErrInvalid)
+		}
+		e.Value = network.String()
+	case e.Kind == models.ListKindBIN && e.Match != models.ListMatchCIDR:
+		// BINs are 6 or 8 first digits of the card number, shorter prefixes block card ranges
+		if strings.Trim(e.Value, "0123456789") != "" || len(e.Value) > 8 ||
+			(e.Match == models.ListMatchExact && len(e.Value) < 6) {
+			return fmt.Errorf("%w: BIN must be 6 to 8 digits", ErrInvalid)
+		}
+	case e.Kind == models.ListKindDevice && e.Match != models.ListMatchCIDR:
+		if len(e.Value) > 128 {
+			return fmt.Errorf("%w: device id must be up to 128 characters", ErrInvalid)
+		}
+	default:
+		return fmt.Errorf("%w: kind must be email, ip, bin or device, ips are matched exactly or by CIDR and the rest exactly or by prefix", ErrInvalid)
+	}
+	return s.validateDetails(e)
+}
+
+func (s *ListsService) validateDetails(e *models.ListEntry) error {
+	e.Reason = strings.TrimSpace(e.Reason)
+	if len(e.Reason) > maxReasonLength {
+		return fmt.Errorf("%w: reason must be up to %v characters", ErrInvalid, maxReasonLength)
+	}
+	if e.ExpiresAt != nil {
+		if !e.ExpiresAt.After(s.now()) {
+			return fmt.Errorf("%w: expiry must be in the future", ErrInvalid)
+		}
+		expiresAt := e.ExpiresAt.UTC()
+		e.ExpiresAt = &expiresAt
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package lists
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/services/lists/repository"
+)
+
+// FakeEntryRepo keeps entries in memory by id
+type FakeEntryRepo struct {
+	Entries map[string]models.ListEntry
+}
+
+func NewFakeEntryRepo() *FakeEntryRepo {
+	return &FakeEntryRepo{Entries: map[string]models.ListEntry{}}
+}
+
+func (m *FakeEntryRepo) Create(ctx context.Context, e *models.ListEntry) error {
+	for _, stored := range m.Entries {
+		if stored.List == e.List && stored.Kind == e.Kind && stored.Match == e.Match && stored.Value == e.Value {
+			return repository.ErrDuplicate
+		}
+	}
+	m.Entries[e.ID] = *e
+	return nil
+}
+
+func (m *FakeEntryRepo) Update(ctx context.Context, e *models.ListEntry) error {
+	stored, ok := m.Entries[e.ID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	stored.Reason = e.Reason
+	stored.ExpiresAt = e.ExpiresAt
+	m.Entries[e.ID] = stored
+	*e = stored
+	return nil
+}
+
+func (m *FakeEntryRepo) Delete(ctx context.Context, id string) error {
+	if _, ok := m.Entries[id]; !ok {
+		return repository.ErrNotFound
+	}
+	delete(m.Entries, id)
+	return nil
+}
+
+func (m *FakeEntryRepo) FetchByID(ctx context.Context, id string) (*models.ListEntry, error) {
+	e, ok := m.Entries[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &e, nil
+}
+
+func (m *FakeEntryRepo) List(ctx context.Context, list, kind string) ([]models.ListEntry, error) {
+	entries := []models.ListEntry{}
+	for _, e := range m.Entries {
+		if (list == "" || e.List == list) && (kind == "" || e.Kind == kind) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func (m *FakeEntryRepo) Active(ctx context.Context, at time.Time) ([]models.ListEntry, error) {
+	entries := []models.ListEntry{}
+	for _, e := range m.Entries {
+		if e.Active(at) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func TestListsServiceCreate(t *testing.T) {
+	service := NewListsService(zap.NewNop().Sugar(), NewFakeEntryRepo())
+	past := time.Now().Add(-time.Hour)
+
+	type testCase struct {
+		name     string
+		entry    models.ListEntry
+		expected string
+		err      error
+	}
+	entry := func(list, kind, match, value string) models.ListEntry {
+		return models.ListEntry{List: list, Kind: kind, Match: match, Value: value, CreatedBy: "support@headway.test"}
+	}
+	testCases := []testCase{
+		{name: "success email", entry: entry(models.ListBlock, models.ListKindEmail, models.ListMatchExact, " Fraud@Headway.test "), expected: "fraud@headway.test"},
+		{name: "success ip", entry: entry(models.ListBlock, models.ListKindIP, models.ListMatchExact, "2001:DB8::1"), expected: "2001:db8::1"},
+		{name: "success range", entry: entry(models.ListBlock, models.ListKindIP, models.ListMatchCIDR, "198.51.100.77/24"), expected: "198.51.100.0/24"},
+		{name: "success bin", entry: entry(models.ListBlock, models.ListKindBIN, models.ListMatchExact, "424242"), expected: "424242"},
+		{name: "success device prefix", entry: entry(models.ListAllow, models.ListKindDevice, models.ListMatchPrefix, "qa-"), expected: "qa-"},
+		{name: "fail duplicate", entry: entry(models.ListBlock, models.ListKindEmail, models.ListMatchExact, "fraud@headway.test"), err: ErrDuplicate},
+		{name: "fail list", entry: entry("deny", models.ListKindEmail, models.ListMatchExact, "a@headway.test"), err: ErrInvalid},
+		{name: "fail kind", entry: entry(models.ListBlock, "phone", models.ListMatchExact, "+48"), err: ErrInvalid},
+		{name: "fail match", entry: entry(models.ListBlock, models.ListKindEmail, "regex", ".*"), err: ErrInvalid},
+		{name: "fail ip prefix", entry: entry(models.ListBlock, models.ListKindIP, models.ListMatchPrefix, "10."), err: ErrInvalid},
+		{name: "fail email range", entry: entry(models.ListBlock, models.ListKindEmail, models.ListMatchCIDR, "10.0.0.0/8"), err: ErrInvalid},
+		{name: "fail malformed ip", entry: entry(models.ListBlock, models.ListKindIP, models.ListMatchExact, "10.0.0"), err: ErrInvalid},
+		{name: "fail malformed range", entry: entry(models.ListBlock, models.ListKindIP, models.ListMatchCIDR, "10.0.0.0/33"), err: ErrInvalid},
+		{name: "fail short bin", entry: entry(models.ListBlock, models.ListKindBIN, models.ListMatchExact, "4242"), err: ErrInvalid},
+		{name: "fail bin letters", entry: entry(models.ListBlock, models.ListKindBIN, models.ListMatchPrefix, "42x"), err: ErrInvalid},
+		{name: "fail email without @", entry: entry(models.ListBlock, models.ListKindEmail, models.ListMatchExact, "headway.test"), err: ErrInvalid},
+		{name: "fail empty value", entry: entry(models.ListBlock, models.ListKindDevice, models.ListMatchExact, " "), err: ErrInvalid},
+		{name: "fail no creator", entry: models.ListEntry{List: models.ListBlock, Kind: models.ListKindDevice, Match: models.ListMatchExact, Value: "emulator"}, err: ErrInvalid},
+		{name: "fail expired", entry: models.ListEntry{List: models.ListBlock, Kind: models.ListKindDevice, Match: models.ListMatchExact, Value: "emulator",
+			CreatedBy: "support@headway.test", ExpiresAt: &past}, err: ErrInvalid},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := tc.entry
+			err := service.Create(context.Background(), &e)
+			assert.ErrorIs(t, err, tc.err)
+			if tc.err == nil {
+				assert.Equal(t, tc.expected, e.Value)
+				assert.NotEmpty(t, e.ID)
+			}
+		})
+	}
+}
+
+func TestListsServiceBlocked(t *testing.T) {
+	fakeRepo := NewFakeEntryRepo()
+	service := NewListsService(zap.NewNop().Sugar(), fakeRepo)
+	now := time.Now()
+	service.now = func() time.Time { return now }
+	soon := now.Add(time.Hour)
+
+	create := func(list, kind, match, value string, expiresAt *time.Time) *models.ListEntry {
+		e := &models.ListEntry{List: list, Kind: kind, Match: match, Value: value, CreatedBy: "support@headway.test", ExpiresAt: expiresAt}
+		assert.NoError(t, service.Create(context.Background(), e))
+		return e
+	}
+	email := create(models.ListBlock, models.ListKindEmail, models.ListMatchExact, "fraud@headway.test", nil)
+	domain := create(models.ListBlock, models.ListKindEmail, models.ListMatchPrefix, "spam", nil)
+	network := create(models.ListBlock, models.ListKindIP, models.ListMatchCIDR, "198.51.100.0/24", nil)
+	office := create(models.ListAllow, models.ListKindIP, models.ListMatchCIDR, "198.51.100.128/25", nil)
+	v6 := create(models.ListBlock, models.ListKindIP, models.ListMatchCIDR, "2001:db8::/32", nil)
+	host := create(models.ListBlock, models.ListKindIP, models.ListMatchExact, "203.0.113.9", nil)
+	bin := create(models.ListBlock, models.ListKindBIN, models.ListMatchPrefix, "4000", nil)
+	device := create(models.ListBlock, models.ListKindDevice, models.ListMatchExact, "emulator", &soon)
+	create(models.ListAllow, models.ListKindEmail, models.ListMatchExact, "qa@headway.test", nil)
+
+	type testCase struct {
+		name     string
+		target   Target
+		expected *models.ListEntry
+	}
+	testCases := []testCase{
+		{name: "email", target: Target{Email: "FRAUD@headway.test"}, expected: email},
+		{name: "email prefix", target: Target{Email: "spammer@headway.test"}, expected: domain},
+		{name: "ip in range", target: Target{IP: "198.51.100.7"}, expected: network},
+		{name: "ipv4-mapped ip in range", target: Target{IP: "::ffff:198.51.100.7"}, expected: network},
+		{name: "ipv6 in range", target: Target{IP: "2001:db8:abcd::1"}, expected: v6},
+		{name: "exact ip", target: Target{IP: "203.0.113.9"}, expected: host},
+		{name: "bin prefix", target: Target{BIN: "40001234"}, expected: bin},
+		{name: "device", target: Target{DeviceID: "emulator"}, expected: device},
+		{name: "allowed range inside blocked one", target: Target{IP: "198.51.100.200"}},
+		{name: "allowed email from blocked ip", target: Target{Email: "qa@headway.test", IP: "203.0.113.9"}, expected: host},
+		{name: "allowed range with blocked device", target: Target{IP: "198.51.100.200", DeviceID: "emulator"}, expected: device},
+		{name: "ip out of ranges", target: Target{IP: "198.51.101.7"}},
+		{name: "malformed ip", target: Target{IP: "198.51.100"}},
+		{name: "nobody", target: Target{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, service.Blocked(tc.target))
+		})
+	}
+	assert.Len(t, service.Matches(Target{IP: "198.51.100.200"}), 2)
+
+	t.Run("expired entry is skipped before the refresh", func(t *testing.T) {
+		now = soon
+		assert.Nil(t, service.Blocked(Target{DeviceID: "emulator"}))
+		now = time.Now()
+	})
+
+	t.Run("changes take effect right away", func(t *testing.T) {
+		assert.NoError(t, service.Delete(context.Background(), office.ID))
+		assert.Equal(t, network, service.Blocked(Target{IP: "198.51.100.200"}))
+
+		later := now.Add(time.Minute)
+		updated := &models.ListEntry{ID: email.ID, Reason: "chargebacks", ExpiresAt: &later}
+		assert.NoError(t, service.Update(context.Background(), updated))
+		assert.Equal(t, "fraud@headway.test", updated.Value)
+		now = later
+		assert.Nil(t, service.Blocked(Target{Email: "fraud@headway.test"}))
+	})
+
+	t.Run("fail unknown entry", func(t *testing.T) {
+		assert.ErrorIs(t, service.Delete(context.Background(), office.ID), ErrNotFound)
+		assert.ErrorIs(t, service.Delete(context.Background(), "office"), ErrNotFound)
+		_, err := service.Entry(context.Background(), office.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
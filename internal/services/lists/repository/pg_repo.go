@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+const entryColumns = "id, list, kind, match_type, value, reason, created_by, expires_at, created_at, updated_at"
+
+type EntryRepo struct {
+	log  *zap.SugaredLogger
+	conn *sql.DB
+}
+
+func NewEntryRepo(log *zap.SugaredLogger, conn *sql.DB) *EntryRepo {
+	return &EntryRepo{log: log, conn: conn}
+}
+
+// Create stores the entry, the value may be listed once per list, kind and match
+func (r *EntryRepo) Create(ctx context.Context, e *models.ListEntry) error {
+	stmnt := `INSERT INTO list_entries (id, list, kind, match_type, value, reason, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (list, kind, match_type, value) DO NOTHING
+		RETURNING created_at, updated_at`
+	err := r.conn.QueryRowContext(ctx, stmnt, e.ID, e.List, e.Kind, e.Match, e.Value, e.Reason, e.CreatedBy,
+		e.ExpiresAt).Scan(&e.CreatedAt, &e.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDuplicate
+		}
+		r.log.Errorw("failed to create list entry",
+			"kind", e.Kind,
+			"error", err)
+		return err
+	}
+	return nil
+}
+
+// Update replaces reason and expiry of the entry, the stored entry is returned into e
+func (r *EntryRepo) Update(ctx context.Context, e *models.ListEntry) error {
+	stmnt := `UPDATE list_entries SET reason = $2, expires_at = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 RETURNING ` + entryColumns
+	stored, err := scanEntry(r.conn.QueryRowContext(ctx, stmnt, e.ID, e.Reason, e.ExpiresAt))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		r.log.Errorw("failed to update list entry",
+			"id", e.ID,
+			"error", err)
+		return err
+	}
+	*e = *stored
+	return nil
+}
+
+// Delete removes the entry
+func (r *EntryRepo) Delete(ctx context.Context, id string) error {
+	res, err := r.conn.ExecContext(ctx, "DELETE FROM list_entries WHERE id = $1", id)
+	if err != nil {
+		r.log.Errorw("failed to delete list entry",
+			"id", id,
+			"error", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// FetchByID fetches single entry by id
+func (r *EntryRepo) FetchByID(ctx context.Context, id string) (*models.ListEntry, error) {
+	e, err := scanEntry(r.conn.QueryRowContext(ctx, "SELECT "+entryColumns+" FROM list_entries WHERE id = $1", id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		r.log.Errorw("failed to fetch list entry by ID",
+			"id", id,
+			"error", err)
+		return nil, err
+	}
+	return e, nil
+}
+
+// List fetches entries of the list and the kind, expired ones included, empty list or kind stands for every one
+func (r *EntryRepo) List(ctx context.Context, list, kind string) ([]models.ListEntry, error) {
+	stmnt := "SELECT " + entryColumns + ` FROM list_entries WHERE ($1 = '' OR list = $1) AND ($2 = '' OR kind = $2)
+		ORDER BY created_at DESC`
+	return r.fetch(ctx, stmnt, list, kind)
+}
+
+// Active fetches every entry in effect at the time
+func (r *EntryRepo) Active(ctx context.Context, at time.Time) ([]models.ListEntry, error) {
+	stmnt := "SELECT " + entryColumns + " FROM list_entries WHERE expires_at IS NULL OR expires_at > $1"
+	return r.fetch(ctx, stmnt, at)
+}
+
+func (r *EntryRepo) fetch(ctx context.Context, stmnt string, args ...any) ([]models.ListEntry, error) {
+	rows, err := r.conn.QueryContext(ctx, stmnt, args...)
+	if err != nil {
+		r.log.Errorf("failed to list entries, error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.ListEntry{}
+	for rows.Next() {
+		e, err := scanEntry(rows)
+		if err != nil {
+			r.log.Errorf("failed to scan list entry, error: %v", err)
+			return nil, err
+		}
+		entries = append(entries, *e)
+	}
+	return entries, rows.Err()
+}
+
+// scanner is satisfied by both sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanEntry(row scanner) (*models.ListEntry, error) {
+	e := models.ListEntry{}
+	if err := row.Scan(&e.ID, &e.List, &e.Kind, &e.Match, &e.Value, &e.Reason, &e.CreatedBy, &e.ExpiresAt, &e.CreatedAt,
+		&e.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
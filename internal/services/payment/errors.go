@@ -8,4 +8,14 @@ var (
 	ErrUnexpectedResult  = errors.New("unexpected error")
 	ErrProvider          = errors.New("something happened on the provider side")
 	ErrStore             = errors.New("something happened on the stores side")
+	ErrLinkInvalid       = errors.New("payment link is invalid")
+	ErrLinkExpired       = errors.New("payment link is expired or revoked")
+	ErrPaymentDeclined   = errors.New("payment is declined by the provider")
+	ErrCustomerInvalid   = errors.New("customer has invalid email or country")
+	ErrPromoInvalid      = errors.New("promo code is not applicable")
+	ErrPlanNotFound      = errors.New("plan is not found")
+	ErrUserRequired      = errors.New("user is required to subscribe")
+	ErrPaymentDenied     = errors.New("payment is denied by risk screening")
+	ErrBatchInvalid      = errors.New("batch must have 1 to 10 distinct providers")
+	ErrSignatureInvalid  = errors.New("webhook signature is invalid")
 )
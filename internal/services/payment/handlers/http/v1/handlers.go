@@ -4,54 +4,322 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
+	"strings"
 
 	"go.uber.org/zap"
 
+	"payment-api/internal/locale"
+	"payment-api/internal/middlwares"
+	"payment-api/internal/platform"
 	"payment-api/internal/services/payment"
+	"payment-api/internal/services/tax"
+)
+
+const (
+	// maxBatchBody fits the largest batch of product ids
+	maxBatchBody = 4 << 10
+	// signatureHeader carries signature of the webhook event, only Stripe completes payments with webhooks so far
+	signatureHeader = "Stripe-Signature"
+	maxEventSize    = 1 << 20
 )
 
 type Payment interface {
-	PaymentUrl(ctx context.Context, providerID string) (string, error)
-	StoresUrls(ctx context.Context) ([]map[string]string, error)
+	PaymentUrl(ctx context.Context, providerID string, payer payment.Payer, purchase payment.Purchase) (*payment.PaymentLink, error)
+	PaymentUrls(ctx context.Context, providerIDs []string, payer payment.Payer, purchase payment.Purchase) ([]payment.PaymentUrlResult, error)
+	StoresUrls(ctx context.Context, req payment.StoresRequest) ([]map[string]string, error)
+	Redirect(ctx context.Context, token string) (string, error)
+	RevokeSession(ctx context.Context, sessionID string) error
+	Capture(ctx context.Context, sessionID, checkoutID string) (string, error)
+	HandleWebhook(ctx context.Context, providerID string, payload []byte, signature string) error
 }
 
 type Handler struct {
 	log        *zap.SugaredLogger
 	paymentSvc Payment
+	// countryHeader and ipHeader are set by CDN with the country and the ip of the client
+	countryHeader string
+	ipHeader      string
 }
 
-func NewHandler(log *zap.SugaredLogger, paymentSvc Payment) *Handler {
-	return &Handler{log: log, paymentSvc: paymentSvc}
+func NewHandler(log *zap.SugaredLogger, paymentSvc Payment, countryHeader, ipHeader string) *Handler {
+	return &Handler{log: log, paymentSvc: paymentSvc, countryHeader: countryHeader, ipHeader: ipHeader}
 }
 
 // Payment endpoint for retrieving url for the provided productID
 func (h *Handler) Payment() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		// asking browsers to send platform client hint on subsequent requests
+		w.Header().Set("Accept-CH", "Sec-CH-UA-Platform")
 		prodID := r.URL.Query().Get("productID")
 		if prodID == "" {
 			h.log.Errorf("failed to retrieve 'productID' parameter")
 			writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Missing productID parameter"})
 			return
 		}
-		url, err := h.paymentSvc.PaymentUrl(r.Context(), prodID)
+		payer, purchase := h.payerPurchase(r)
+		link, err := h.paymentSvc.PaymentUrl(r.Context(), prodID, payer, purchase)
 
 		if err != nil {
 			h.log.Errorf("failed to receive payment url")
-			switch {
-			case errors.Is(err, payment.ErrUuidInvalidFormat), errors.Is(err, payment.ErrNotFound):
-				writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Provided parameter has bad format"})
-			case errors.Is(err, payment.ErrProvider):
-				urls, err := h.paymentSvc.StoresUrls(r.Context())
+			if errors.Is(err, payment.ErrProvider) {
+				urls, err := h.storesUrls(r, prodID)
 				if err != nil {
 					writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
 					return
 				}
 				writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusOK, "stores_urls": urls})
+				return
+			}
+			status, message := linkError(err)
+			writeJson(w, status, map[string]any{"code": status, "message": message})
+			return
+		}
+		writeJson(w, http.StatusBadRequest, linkJson(link))
+	}
+}
+
+// PaymentUrls endpoint issues payment urls of every productID of the JSON body {"productIDs": [...]} at once,
+// the payer and the purchase are passed with the same parameters as to the Payment endpoint. Every item of
+// the response is either the link or the error it is not issued with, stores urls are returned along when
+// any of the providers is unavailable
+func (h *Handler) PaymentUrls() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+			return
+		}
+		w.Header().Set("Accept-CH", "Sec-CH-UA-Platform")
+		var body struct {
+			ProductIDs []string `json:"productIDs"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBody)).Decode(&body); err != nil {
+			writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Provided parameter has bad format"})
+			return
+		}
+		payer, purchase := h.payerPurchase(r)
+		results, err := h.paymentSvc.PaymentUrls(r.Context(), body.ProductIDs, payer, purchase)
+		if err != nil {
+			h.log.Errorf("failed to receive payment urls")
+			switch {
+			case errors.Is(err, payment.ErrBatchInvalid):
+				writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "productIDs must hold 1 to 10 distinct ids"})
+			default:
+				writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+			}
+			return
+		}
+
+		items := make([]map[string]any, 0, len(results))
+		resp := map[string]any{"code": http.StatusOK}
+		for _, res := range results {
+			if res.Err == nil {
+				item := linkJson(res.Link)
+				item["productID"] = res.ProviderID
+				items = append(items, item)
+				continue
+			}
+			status, message := linkError(res.Err)
+			if errors.Is(res.Err, payment.ErrProvider) {
+				status, message = http.StatusBadGateway, "Provider is not available"
+				if _, ok := resp["stores_urls"]; !ok {
+					if urls, err := h.storesUrls(r, res.ProviderID); err == nil {
+						resp["stores_urls"] = urls
+					}
+				}
+			}
+			items = append(items, map[string]any{"productID": res.ProviderID, "code": status, "message": message})
+		}
+		resp["data"] = items
+		writeJson(w, http.StatusOK, resp)
+	}
+}
+
+// payerPurchase reads the payer and the purchase from the parameters of the request
+func (h *Handler) payerPurchase(r *http.Request) (payment.Payer, payment.Purchase) {
+	payer := payment.Payer{
+		UserID:   r.URL.Query().Get("userID"),
+		Email:    r.URL.Query().Get("email"),
+		Country:  r.URL.Query().Get("country"),
+		Region:   r.URL.Query().Get("region"),
+		Platform: platform.Detect(r),
+		Client:   r.URL.Query().Get("client"),
+		IP:       middlwares.ClientIP(r, h.ipHeader),
+		DeviceID: r.URL.Query().Get("deviceID"),
+	}
+	// the user proven with the token wins over the userID parameter
+	if userID := middlwares.UserID(r.Context()); userID != "" {
+		payer.UserID = userID
+		payer.Authenticated = true
+	}
+	if h.countryHeader != "" {
+		payer.IPCountry = strings.ToUpper(strings.TrimSpace(r.Header.Get(h.countryHeader)))
+	}
+	if payer.Country == "" {
+		payer.Country = locale.FromRequest(r, h.countryHeader).Region
+	}
+	purchase := payment.Purchase{
+		PlanID:     r.URL.Query().Get("plan"),
+		PromoCode:  r.URL.Query().Get("promo"),
+		Experiment: r.URL.Query().Get("experiment"),
+	}
+	return payer, purchase
+}
+
+// storesUrls returns urls of the stores offered instead of the unavailable provider
+func (h *Handler) storesUrls(r *http.Request, prodID string) ([]map[string]string, error) {
+	return h.paymentSvc.StoresUrls(r.Context(), payment.StoresRequest{
+		ProductID: prodID,
+		RequestID: middlwares.RequestID(r.Context()),
+		Platform:  platform.Detect(r),
+		All:       r.URL.Query().Get("stores") == "all",
+		Campaign:  r.URL.Query(),
+	})
+}
+
+// linkError returns status and message of the error the payment link is not issued with
+func linkError(err error) (int, string) {
+	switch {
+	case errors.Is(err, payment.ErrUuidInvalidFormat), errors.Is(err, payment.ErrNotFound), errors.Is(err, payment.ErrCustomerInvalid):
+		return http.StatusBadRequest, "Provided parameter has bad format"
+	case errors.Is(err, payment.ErrPromoInvalid):
+		return http.StatusBadRequest, "Promo code is not applicable"
+	case errors.Is(err, payment.ErrPlanNotFound):
+		return http.StatusBadRequest, "Plan is not found"
+	case errors.Is(err, payment.ErrUserRequired):
+		return http.StatusBadRequest, "Signed in user is required to subscribe"
+	case errors.Is(err, payment.ErrPaymentDenied):
+		return http.StatusForbidden, "Payment is not available"
+	default:
+		return http.StatusInternalServerError, "Oops, something went wrong"
+	}
+}
+
+func linkJson(link *payment.PaymentLink) map[string]any {
+	resp := map[string]any{"code": http.StatusOK, "data": link.Url, "amount": link.Amount.Amount, "currency": link.Amount.Currency}
+	if link.Tax != nil {
+		resp["tax"] = taxJson(link.Tax)
+	}
+	return resp
+}
+
+func taxJson(breakdown *tax.Breakdown) map[string]any {
+	lines := make([]map[string]any, 0, len(breakdown.Lines))
+	for _, l := range breakdown.Lines {
+		lines = append(lines, map[string]any{
+			"name":      l.Name,
+			"rate":      l.Rate,
+			"inclusive": l.Inclusive,
+			"amount":    l.Amount,
+		})
+	}
+	return map[string]any{
+		"net_amount":   breakdown.Net.Amount,
+		"tax_amount":   breakdown.Amount(),
+		"total_amount": breakdown.Total.Amount,
+		"currency":     breakdown.Total.Currency,
+		"lines":        lines,
+	}
+}
+
+// Redirect endpoint validates signed payment link and redirects to the provider checkout
+func (h *Handler) Redirect() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.URL.Path, "/pay/")
+		url, err := h.paymentSvc.Redirect(r.Context(), token)
+		if err != nil {
+			h.log.Errorf("failed to resolve payment link")
+			switch {
+			case errors.Is(err, payment.ErrLinkExpired):
+				writeJson(w, http.StatusGone, map[string]any{"code": http.StatusGone, "message": "Payment link is expired"})
+			case errors.Is(err, payment.ErrLinkInvalid):
+				writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Payment link is invalid"})
+			default:
+				writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+			}
+			return
+		}
+		http.Redirect(w, r, url, http.StatusFound)
+	}
+}
+
+// PayPalReturn endpoint receives the payer after approval on PayPal side,
+// captures the payment and redirects to the success page
+func (h *Handler) PayPalReturn() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		// PayPal passes id of the order in the token parameter
+		url, err := h.paymentSvc.Capture(r.Context(), q.Get("session_id"), q.Get("token"))
+		if err != nil {
+			h.log.Errorf("failed to capture payment")
+			switch {
+			case errors.Is(err, payment.ErrNotFound):
+				writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Payment is not found"})
+			case errors.Is(err, payment.ErrPaymentDeclined):
+				writeJson(w, http.StatusPaymentRequired, map[string]any{"code": http.StatusPaymentRequired, "message": "Payment is declined"})
+			default:
+				writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+			}
+			return
+		}
+		http.Redirect(w, r, url, http.StatusFound)
+	}
+}
+
+// Webhook endpoint receives events of the provider on POST /api/v1/payment/webhooks/{providerID},
+// events are authenticated with their signature
+func (h *Handler) Webhook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+			return
+		}
+		providerID, _ := strings.CutPrefix(r.URL.Path, "/api/v1/payment/webhooks/")
+		payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxEventSize))
+		if err != nil {
+			writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Provided parameter has bad format"})
+			return
+		}
+
+		if err := h.paymentSvc.HandleWebhook(r.Context(), providerID, payload, r.Header.Get(signatureHeader)); err != nil {
+			h.log.Errorf("failed to handle payment webhook")
+			switch {
+			case errors.Is(err, payment.ErrSignatureInvalid):
+				writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Signature is invalid"})
+			case errors.Is(err, payment.ErrNotFound):
+				writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Not found"})
+			default:
+				writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+			}
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "message": "Event is processed"})
+	}
+}
+
+// RevokeSession endpoint invalidates payment link of the provided sessionID
+func (h *Handler) RevokeSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+			return
+		}
+		sessionID := r.URL.Query().Get("sessionID")
+		if sessionID == "" {
+			writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Missing sessionID parameter"})
+			return
+		}
+		if err := h.paymentSvc.RevokeSession(r.Context(), sessionID); err != nil {
+			switch {
+			case errors.Is(err, payment.ErrUuidInvalidFormat), errors.Is(err, payment.ErrNotFound):
+				writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Session is not found"})
+			default:
+				writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
 			}
 			return
 		}
-		writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusOK, "data": url})
+		writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK})
 	}
 }
 
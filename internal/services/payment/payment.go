@@ -2,29 +2,142 @@ package payment
 
 import (
 	"context"
+	"errors"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 
+	intpayment "payment-api/internal/integrations/payment"
 	"payment-api/internal/integrations/stores"
 	"payment-api/internal/models"
+	"payment-api/internal/money"
+	"payment-api/internal/platform"
+	"payment-api/internal/services/experiments"
+	"payment-api/internal/services/flags"
+	"payment-api/internal/services/ledger"
 	"payment-api/internal/services/payment/repository"
+	"payment-api/internal/services/promotions"
+	"payment-api/internal/services/risk"
+	"payment-api/internal/services/subscriptions"
+	"payment-api/internal/services/tax"
+	"payment-api/internal/tokens"
+)
+
+const (
+	defaultBaseUrl = "http://localhost:8080"
+	defaultLinkTTL = 15 * time.Minute
+	// MaxBatchSize is the most payment links issued with one batch
+	MaxBatchSize            = 10
+	defaultBatchParallelism = 4
 )
 
 type Stores interface {
-	// AppUrl retrieves url to the app from a provided store
-	AppUrl(name stores.StoreName) (string, error)
+	// Names retrieves configured stores serving the platform, empty platform stands for every store,
+	// it fails when any of them has no default url
+	Names(p platform.Platform) ([]stores.StoreName, error)
 }
 
 // PaymentProvider allows you to work with provider implementation
 type PaymentProvider interface {
-	// PaymentUrl fetches url that is needed for payment
-	PaymentUrl(name, apiKey, secret string) (string, error)
+	// PaymentUrl creates checkout on the provider side and returns its url
+	PaymentUrl(ctx context.Context, name, apiKey, secret string, checkout intpayment.Checkout) (*intpayment.CheckoutSession, error)
+	// Capture completes payment of the checkout approved by the customer
+	Capture(ctx context.Context, name, apiKey, secret, checkoutID string) error
+	// Completion verifies the webhook event and returns the checkout it reports paid, nil for other events
+	Completion(ctx context.Context, name, secret string, payload []byte, signature string) (*intpayment.Completion, error)
+	// Renewal verifies the webhook event and returns the paid subscription renewal it reports, nil for other events
+	Renewal(ctx context.Context, name, secret string, payload []byte, signature string) (*intpayment.Renewal, error)
+	// Refund verifies the webhook event and returns the succeeded refund it reports, nil for other events
+	Refund(ctx context.Context, name, apiKey, secret string, payload []byte, signature string) (*intpayment.Refund, error)
 }
 
 // Repository for provider
 type ProviderRepo interface {
 	FetchByID(id string) (*models.Provider, error)
+	FetchByIDs(ids []string) ([]models.Provider, error)
+}
+
+// Repository for payment sessions
+type SessionRepo interface {
+	Create(ctx context.Context, s *models.PaymentSession) error
+	FetchByID(ctx context.Context, id string) (*models.PaymentSession, error)
+	Revoke(ctx context.Context, id string) error
+	MarkPaid(ctx context.Context, id, paymentID string) (time.Time, error)
+	TrackVisit(ctx context.Context, id string) error
+}
+
+// Repository for customers
+type CustomerRepo interface {
+	Upsert(ctx context.Context, c *models.Customer) error
+	SaveProviderID(ctx context.Context, customerID, providerName, externalID string) error
+}
+
+// Entitlements grants access bought with web payments
+type Entitlements interface {
+	GrantWebPayment(ctx context.Context, session *models.PaymentSession, paidAt time.Time) error
+}
+
+// Ledger records money movement of captured and refunded payments
+type Ledger interface {
+	RecordPayment(ctx context.Context, session *models.PaymentSession, provider string) error
+	RecordRenewal(ctx context.Context, m ledger.Movement) error
+	RecordRefund(ctx context.Context, m ledger.Movement) error
+}
+
+// Pricer localizes the price to the country of the payer
+type Pricer interface {
+	Price(ctx context.Context, country string) (money.Money, error)
+}
+
+// Promotions applies promo codes to the checkout price
+type Promotions interface {
+	Redeem(ctx context.Context, code, sessionID, userID, productID string, price money.Money) (money.Money, error)
+	Release(ctx context.Context, sessionID string) error
+}
+
+// Subscriptions sells plans, possibly with a trial
+type Subscriptions interface {
+	Offer(ctx context.Context, planID, userID string) (*subscriptions.Offer, error)
+	Start(ctx context.Context, session *models.PaymentSession, paidAt time.Time) error
+	Renew(ctx context.Context, sessionID string, periodStart, periodEnd time.Time) error
+}
+
+// Experiments buckets users into variants of the paywall
+type Experiments interface {
+	Assign(ctx context.Context, key, userID string) (*experiments.Assignment, error)
+	Expose(ctx context.Context, x *models.ExperimentExposure) error
+}
+
+// Flags switches features for the targeted traffic
+type Flags interface {
+	Enabled(key string, t flags.Target) bool
+}
+
+// Tax calculates taxes of the checkout amounts
+type Tax interface {
+	Calculate(ctx context.Context, price money.Money, country, region string) (*tax.Breakdown, error)
+}
+
+// Invoices issues receipts of captured payments
+type Invoices interface {
+	Issue(ctx context.Context, session *models.PaymentSession) (*models.Invoice, error)
+	IssueRenewal(ctx context.Context, session *models.PaymentSession, providerInvoiceID string) (*models.Invoice, error)
+}
+
+// Risk screens payers before the payment links are issued
+type Risk interface {
+	Screen(ctx context.Context, a risk.Attempt) (*models.RiskDecision, error)
+}
+
+// Signer issues and validates tokens of the payment links
+type Signer interface {
+	Sign(c tokens.Claims) (string, error)
+	Verify(token string) (*tokens.Claims, error)
 }
 
 type PaymentService struct {
@@ -32,22 +145,536 @@ type PaymentService struct {
 	paymentProvider PaymentProvider
 	stores          Stores
 	providerRepo    ProviderRepo
+	sessionRepo     SessionRepo
+	signer          Signer
+	entitlements    Entitlements
+	customerRepo    CustomerRepo
+	ledger          Ledger
+	pricer          Pricer
+	promotions      Promotions
+	subscriptions   Subscriptions
+	experiments     Experiments
+	flags           Flags
+	tax             Tax
+	invoices        Invoices
+	risk            Risk
+	productID       string
+	amount          int64
+	currency        string
+	baseUrl         string
+	successUrl      string
+	linkTTL         time.Duration
+	// batchParallelism is how many links of the batch are issued at once
+	batchParallelism int
+}
+
+// Option configures optional parts of the PaymentService
+type Option func(s *PaymentService)
+
+// WithBaseUrl sets public url of the service, which is used to build payment links
+func WithBaseUrl(url string) Option {
+	return func(s *PaymentService) {
+		s.baseUrl = strings.TrimRight(url, "/")
+	}
+}
+
+// WithSuccessUrl sets url the customer lands on after the captured payment,
+// {CHECKOUT_SESSION_ID} placeholder is replaced with id of the provider checkout
+func WithSuccessUrl(url string) Option {
+	return func(s *PaymentService) {
+		s.successUrl = url
+	}
+}
+
+// WithEntitlements grants access to the users of captured payments
+func WithEntitlements(e Entitlements) Option {
+	return func(s *PaymentService) {
+		s.entitlements = e
+	}
+}
+
+// WithCustomers keeps customers of the paying users, so their provider-side customers are reused
+func WithCustomers(repo CustomerRepo) Option {
+	return func(s *PaymentService) {
+		s.customerRepo = repo
+	}
+}
+
+// WithLedger posts captured payments to the ledger
+func WithLedger(l Ledger) Option {
+	return func(s *PaymentService) {
+		s.ledger = l
+	}
+}
+
+// WithPrice sets the price of the checkout in minor units of the currency, it is kept with every session
+func WithPrice(amount int64, currency string) Option {
+	return func(s *PaymentService) {
+		s.amount = amount
+		s.currency = strings.ToLower(currency)
+	}
+}
+
+// WithPricing charges the payer in the currency of their country
+func WithPricing(p Pricer) Option {
+	return func(s *PaymentService) {
+		s.pricer = p
+	}
+}
+
+// WithPromotions accepts promo codes with the payment links
+func WithPromotions(p Promotions) Option {
+	return func(s *PaymentService) {
+		s.promotions = p
+	}
+}
+
+// WithSubscriptions sells plans along with the one-time checkout
+func WithSubscriptions(sub Subscriptions) Option {
+	return func(s *PaymentService) {
+		s.subscriptions = sub
+	}
+}
+
+// WithExperiments lets the payment links take part in the paywall experiments
+func WithExperiments(e Experiments) Option {
+	return func(s *PaymentService) {
+		s.experiments = e
+	}
+}
+
+// WithFlags lets ops disable providers with kill switches, payers of the disabled provider get the stores instead
+func WithFlags(f Flags) Option {
+	return func(s *PaymentService) {
+		s.flags = f
+	}
+}
+
+// WithTax charges taxes of the payer country, exclusive taxes are added on top of the prices
+func WithTax(t Tax) Option {
+	return func(s *PaymentService) {
+		s.tax = t
+	}
+}
+
+// WithInvoices enables invoices of captured payments
+func WithInvoices(i Invoices) Option {
+	return func(s *PaymentService) {
+		s.invoices = i
+	}
+}
+
+// WithRisk screens payers before the payment links are issued, denied payers get no link
+// and challenged ones are verified by the provider on the checkout
+func WithRisk(r Risk) Option {
+	return func(s *PaymentService) {
+		s.risk = r
+	}
+}
+
+// WithProductID sets id of the product sold, promotions may be limited to particular products
+func WithProductID(id string) Option {
+	return func(s *PaymentService) {
+		s.productID = id
+	}
+}
+
+// WithBatchParallelism sets how many links of the batch are issued at once, each of them calls the provider
+func WithBatchParallelism(n int) Option {
+	return func(s *PaymentService) {
+		if n > 0 {
+			s.batchParallelism = n
+		}
+	}
 }
 
-func NewPaymentService(log *zap.SugaredLogger, paymentProvider PaymentProvider, stores Stores, providerRepo ProviderRepo) *PaymentService {
-	return &PaymentService{log: log, paymentProvider: paymentProvider, stores: stores, providerRepo: providerRepo}
+// WithLinkTTL sets how long payment link stays valid
+func WithLinkTTL(ttl time.Duration) Option {
+	return func(s *PaymentService) {
+		s.linkTTL = ttl
+	}
+}
+
+func NewPaymentService(log *zap.SugaredLogger, paymentProvider PaymentProvider, stores Stores, providerRepo ProviderRepo, sessionRepo SessionRepo, signer Signer, opts ...Option) *PaymentService {
+	s := &PaymentService{
+		log:              log,
+		paymentProvider:  paymentProvider,
+		stores:           stores,
+		providerRepo:     providerRepo,
+		sessionRepo:      sessionRepo,
+		signer:           signer,
+		baseUrl:          defaultBaseUrl,
+		linkTTL:          defaultLinkTTL,
+		batchParallelism: defaultBatchParallelism,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Payer identifies who is paying, every field is optional
+type Payer struct {
+	// UserID gets access once the payment is captured
+	UserID string
+	// Authenticated is set when UserID is proven with the user token, only authenticated payers
+	// pay as their stored customer and get its saved payment methods
+	Authenticated bool
+	Email         string
+	// Country is ISO 3166-1 alpha-2 code
+	Country string
+	// Region is ISO 3166-2 subdivision code of the country without its prefix, e.g. "QC", it is used for taxes
+	Region string
+	// Platform and Client are used to target feature flags
+	Platform platform.Platform
+	Client   string
+	// IP, DeviceID and IPCountry detected by the CDN are used to screen the payer,
+	// prices and taxes are localized to IPCountry as well
+	IP        string
+	DeviceID  string
+	IPCountry string
+}
+
+// billing returns the country and the region prices and taxes are localized to. Country of the payer is chosen
+// by the client, it could pick the cheapest price or the lowest tax with it, so the country detected by the CDN
+// wins over it. Country of the payer is used only when the CDN detected none, e.g. in development.
+// Region is kept only within the country of the payer
+func (p Payer) billing() (string, string) {
+	detected := strings.ToUpper(p.IPCountry)
+	if len(detected) != 2 || detected == "XX" || strings.Trim(detected, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return p.Country, p.Region
+	}
+	if detected != p.Country {
+		return detected, ""
+	}
+	return detected, p.Region
+}
+
+// Purchase is what the payer buys, every field is optional
+type Purchase struct {
+	// PlanID makes the checkout a subscription of the plan instead of the one-time checkout
+	PlanID string
+	// PromoCode discounts the price, its redemption is counted once the link is issued
+	PromoCode string
+	// Experiment is key of the experiment, its variant may replace the provider, the plan and the price
+	Experiment string
+}
+
+// PaymentLink is the issued payment url with the amount due on the checkout
+type PaymentLink struct {
+	Url    string
+	Amount money.Money
+	// Tax is the breakdown of the amount, nil when taxes are not calculated or nothing is due
+	Tax *tax.Breakdown
 }
 
-// PaymentUrl returns payment url for the provided providerID
-func (s *PaymentService) PaymentUrl(ctx context.Context, providerID string) (string, error) {
+// PaymentUrlResult is the payment link of the provider of the batch or the error it is not issued with
+type PaymentUrlResult struct {
+	ProviderID string
+	Link       *PaymentLink
+	Err        error
+}
+
+// PaymentUrl returns signed short-lived payment url for the provided providerID,
+// the url leads to our service, which redirects to the provider checkout
+func (s *PaymentService) PaymentUrl(ctx context.Context, providerID string, payer Payer, purchase Purchase) (*PaymentLink, error) {
+	return s.paymentUrl(ctx, providerID, payer, purchase, nil)
+}
+
+// PaymentUrls issues payment links of the providers for the same payer and purchase concurrently, results
+// keep the order of providerIDs. Every link is issued as with PaymentUrl, so failure of one provider doesn't
+// fail the others, while the providers are fetched with a single query for the whole batch
+func (s *PaymentService) PaymentUrls(ctx context.Context, providerIDs []string, payer Payer, purchase Purchase) ([]PaymentUrlResult, error) {
+	if len(providerIDs) == 0 || len(providerIDs) > MaxBatchSize {
+		return nil, ErrBatchInvalid
+	}
+	// prefetched holds nil for the requested providers which don't exist
+	prefetched := make(map[string]*models.Provider, len(providerIDs))
+	for _, id := range providerIDs {
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			continue
+		}
+		if _, ok := prefetched[parsed.String()]; ok {
+			return nil, ErrBatchInvalid
+		}
+		prefetched[parsed.String()] = nil
+	}
+	providers, err := s.providerRepo.FetchByIDs(providerIDs)
+	if err != nil {
+		s.log.Errorf("failed to fetch providers of the batch, error: %v", err)
+		return nil, ErrUnexpectedResult
+	}
+	for i := range providers {
+		prefetched[providers[i].ID] = &providers[i]
+	}
+
+	results := make([]PaymentUrlResult, len(providerIDs))
+	sem := make(chan struct{}, s.batchParallelism)
+	var wg sync.WaitGroup
+	for i, id := range providerIDs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, id string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			link, err := s.paymentUrl(ctx, id, payer, purchase, prefetched)
+			results[i] = PaymentUrlResult{ProviderID: id, Link: link, Err: err}
+		}(i, id)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// paymentUrl issues the payment link, providers prefetched for the batch are not fetched again
+func (s *PaymentService) paymentUrl(ctx context.Context, providerID string, payer Payer, purchase Purchase, prefetched map[string]*models.Provider) (*PaymentLink, error) {
+	assignment := s.assign(ctx, purchase.Experiment, payer)
+	var variantPrice *money.Money
+	if assignment != nil {
+		if assignment.Variant.ProviderID != "" {
+			providerID = assignment.Variant.ProviderID
+		}
+		if assignment.Variant.PlanID != "" {
+			purchase.PlanID = assignment.Variant.PlanID
+		}
+		if assignment.Variant.Amount > 0 {
+			variantPrice = &money.Money{Amount: assignment.Variant.Amount, Currency: assignment.Variant.Currency}
+		}
+	}
+
 	// validating a uuid, since this logic may be used from more than one handler
 	_, err := uuid.Parse(providerID)
 	if err != nil {
 		s.log.Errorw("failed to validate providerID",
 			"ID", providerID)
-		return "", ErrUuidInvalidFormat
+		return nil, ErrUuidInvalidFormat
+	}
+
+	if err := validatePayer(&payer); err != nil {
+		s.log.Errorw("failed to validate payer",
+			"userID", payer.UserID,
+			"error", err)
+		return nil, err
+	}
+	// access of subscriptions is granted to users, besides trials are given once per user, so the user must prove
+	// who they are, otherwise anyone could take the trial of another user and grant it to them
+	if purchase.PlanID != "" && (payer.UserID == "" || !payer.Authenticated) {
+		return nil, ErrUserRequired
 	}
 
+	providerModel, err := s.provider(providerID, prefetched)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.providerDisabled(providerModel.Name, payer) {
+		s.log.Errorw("provider is disabled with the kill switch",
+			"provider", providerModel.Name,
+			"userID", payer.UserID)
+		return nil, ErrProvider
+	}
+
+	// session id is known upfront, so the provider checkout can refer to it
+	sessionID := uuid.NewString()
+	challenge, err := s.screen(ctx, sessionID, payer)
+	if err != nil {
+		return nil, err
+	}
+	metadata := map[string]string{
+		"payment_session_id": sessionID,
+		"provider_id":        providerModel.ID,
+	}
+	if payer.UserID != "" {
+		metadata["user_id"] = payer.UserID
+	}
+	customer, err := s.customer(ctx, payer)
+	if err != nil {
+		return nil, err
+	}
+	var checkoutCustomer *intpayment.Customer
+	if customer != nil {
+		checkoutCustomer = &intpayment.Customer{
+			ID:          customer.ProviderIDs[providerModel.Name],
+			ReferenceID: customer.ID,
+			Email:       customer.Email,
+			Country:     customer.Country,
+		}
+	} else if payer.Email != "" || payer.Country != "" {
+		checkoutCustomer = &intpayment.Customer{Email: payer.Email, Country: payer.Country}
+	}
+	price := money.Money{Amount: s.amount, Currency: s.currency}
+	productID := s.productID
+	var checkoutPrice *money.Money
+	var offer *subscriptions.Offer
+	switch {
+	// plans are priced in their own currency
+	case purchase.PlanID != "":
+		if offer, err = s.offer(ctx, purchase.PlanID, payer.UserID); err != nil {
+			return nil, err
+		}
+		price = money.Money{Amount: offer.Plan.Amount, Currency: offer.Plan.Currency}
+		productID = offer.Plan.ID
+		checkoutPrice = &price
+	// price of the variant is already in the currency of the experiment
+	case variantPrice != nil:
+		price = *variantPrice
+		checkoutPrice = &price
+	case s.pricer != nil:
+		country, _ := payer.billing()
+		if price, err = s.pricer.Price(ctx, country); err != nil {
+			s.log.Errorf("failed to localize price for %v, error: %v", country, err)
+			return nil, ErrUnexpectedResult
+		}
+		checkoutPrice = &price
+	}
+	if purchase.PromoCode != "" {
+		if s.promotions == nil {
+			return nil, ErrPromoInvalid
+		}
+		price, err = s.promotions.Redeem(ctx, purchase.PromoCode, sessionID, payer.UserID, productID, price)
+		if err != nil {
+			s.log.Errorw("failed to redeem promo code",
+				"code", purchase.PromoCode,
+				"userID", payer.UserID,
+				"error", err)
+			if errors.Is(err, promotions.ErrNotApplicable) {
+				return nil, ErrPromoInvalid
+			}
+			return nil, ErrUnexpectedResult
+		}
+		checkoutPrice = &price
+	}
+	// due is charged on the checkout, subscriptions charge nothing for the trial and the intro price for the first periods
+	due := price
+	trialDays := 0
+	var checkoutSubscription *intpayment.Subscription
+	if offer != nil {
+		trialDays = offer.TrialDays
+		checkoutSubscription = &intpayment.Subscription{
+			Name:      offer.Plan.Name,
+			Interval:  offer.Plan.Interval,
+			TrialDays: offer.TrialDays,
+		}
+		if offer.Plan.IntroAmount > 0 && offer.Plan.IntroAmount < price.Amount {
+			intro := money.Money{Amount: offer.Plan.IntroAmount, Currency: price.Currency}
+			checkoutSubscription.IntroPrice = &intro
+			checkoutSubscription.IntroPeriods = offer.Plan.IntroPeriods
+			due = intro
+		}
+		if trialDays > 0 {
+			due.Amount = 0
+		}
+	}
+	// exclusive taxes are added on top of every price sent to the provider, the breakdown is kept of the amount due now
+	var dueTax *tax.Breakdown
+	if s.tax != nil {
+		priceTax, err := s.calculateTax(ctx, price, payer)
+		if err != nil {
+			s.releasePromo(ctx, purchase.PromoCode, sessionID)
+			return nil, err
+		}
+		dueTax = priceTax
+		if checkoutSubscription != nil && checkoutSubscription.IntroPrice != nil {
+			introTax, err := s.calculateTax(ctx, *checkoutSubscription.IntroPrice, payer)
+			if err != nil {
+				s.releasePromo(ctx, purchase.PromoCode, sessionID)
+				return nil, err
+			}
+			checkoutSubscription.IntroPrice = &introTax.Total
+			dueTax = introTax
+		}
+		if priceTax.Total != price {
+			price = priceTax.Total
+			checkoutPrice = &price
+		}
+		due = dueTax.Total
+		// nothing is charged for the trial, the provider charges the taxed price after it
+		if trialDays > 0 {
+			due.Amount = 0
+			dueTax = nil
+		}
+	}
+	// Instead of name could be used ENUM enumeration in the form of iota
+	checkout, err := s.paymentProvider.PaymentUrl(ctx, providerModel.Name, providerModel.ApiKey, providerModel.Secret, intpayment.Checkout{
+		ReferenceID:  sessionID,
+		Metadata:     metadata,
+		Customer:     checkoutCustomer,
+		Price:        checkoutPrice,
+		Subscription: checkoutSubscription,
+		Challenge:    challenge,
+	})
+	if err != nil {
+		s.log.Errorf("failed to get url from %v provider, error: %v", providerModel.Name, err)
+		s.releasePromo(ctx, purchase.PromoCode, sessionID)
+		return nil, ErrProvider
+	}
+	// the checkout is already created, so failure to remember the customer only costs
+	// a new provider-side customer next time
+	if customer != nil && checkout.CustomerID != "" && checkout.CustomerID != customer.ProviderIDs[providerModel.Name] {
+		if err := s.customerRepo.SaveProviderID(ctx, customer.ID, providerModel.Name, checkout.CustomerID); err != nil {
+			s.log.Errorf("failed to save %v customer of %v, error: %v", providerModel.Name, customer.ID, err)
+		}
+	}
+
+	// the invoice is issued to the country the payment is taxed in
+	billingCountry, _ := payer.billing()
+	session := &models.PaymentSession{
+		ID:                sessionID,
+		ProviderID:        providerModel.ID,
+		ProviderUrl:       checkout.Url,
+		ProviderSessionID: checkout.ID,
+		UserID:            payer.UserID,
+		Email:             payer.Email,
+		Country:           billingCountry,
+		PlanID:            purchase.PlanID,
+		Amount:            due.Amount,
+		Currency:          due.Currency,
+		TrialDays:         trialDays,
+		ExpiresAt:         time.Now().UTC().Add(s.linkTTL),
+	}
+	if dueTax != nil {
+		session.TaxAmount = dueTax.Amount()
+		session.Taxes = dueTax.Lines
+	}
+	if err := s.sessionRepo.Create(ctx, session); err != nil {
+		s.log.Errorf("failed to create payment session for %v provider, error: %v", providerModel.Name, err)
+		s.releasePromo(ctx, purchase.PromoCode, sessionID)
+		return nil, ErrUnexpectedResult
+	}
+	// the link is issued anyway, a lost exposure only skews the results of the experiment
+	if assignment != nil {
+		exposure := &models.ExperimentExposure{
+			ExperimentID: assignment.ExperimentID,
+			VariantKey:   assignment.Variant.Key,
+			UserID:       payer.UserID,
+			SessionID:    session.ID,
+		}
+		if err := s.experiments.Expose(ctx, exposure); err != nil {
+			s.log.Errorf("failed to record exposure of session %v, error: %v", session.ID, err)
+		}
+	}
+	token, err := s.signer.Sign(tokens.Claims{SessionID: session.ID, ExpiresAt: session.ExpiresAt.Unix()})
+	if err != nil {
+		s.log.Errorf("failed to sign payment session %v, error: %v", session.ID, err)
+		s.releasePromo(ctx, purchase.PromoCode, sessionID)
+		return nil, ErrUnexpectedResult
+	}
+	return &PaymentLink{Url: s.baseUrl + "/pay/" + token, Amount: due, Tax: dueTax}, nil
+}
+
+// provider returns the provider prefetched for the batch or fetches it, variants of experiments
+// may replace the provider with the one out of the batch
+func (s *PaymentService) provider(providerID string, prefetched map[string]*models.Provider) (*models.Provider, error) {
+	if parsed, err := uuid.Parse(providerID); err == nil {
+		if p, ok := prefetched[parsed.String()]; ok {
+			if p == nil {
+				return nil, ErrNotFound
+			}
+			return p, nil
+		}
+	}
 	// not parsing context for the sake of simplicity of the case
 	providerModel, err := s.providerRepo.FetchByID(providerID)
 	if err != nil {
@@ -55,34 +682,526 @@ func (s *PaymentService) PaymentUrl(ctx context.Context, providerID string) (str
 			"ID", providerID)
 		switch err {
 		case repository.ErrNotFound:
-			return "", ErrNotFound
+			return nil, ErrNotFound
 		case repository.ErrUuidInvalidFormat:
-			return "", ErrUuidInvalidFormat
+			return nil, ErrUuidInvalidFormat
 		default:
-			return "", ErrUuidInvalidFormat
+			return nil, ErrUuidInvalidFormat
 		}
 	}
+	return providerModel, nil
+}
 
-	// Instead of name could be used ENUM enumeration in the form of iota
-	url, err := s.paymentProvider.PaymentUrl(providerModel.Name, providerModel.ApiKey, providerModel.Secret)
+// offer returns terms of the plan for the user, trials are offered only to the users who had none
+func (s *PaymentService) offer(ctx context.Context, planID, userID string) (*subscriptions.Offer, error) {
+	if s.subscriptions == nil {
+		return nil, ErrPlanNotFound
+	}
+	offer, err := s.subscriptions.Offer(ctx, planID, userID)
 	if err != nil {
-		s.log.Errorf("failed to get url from %v provider, error: %v", providerModel.Name, err)
-		return "", ErrProvider
+		s.log.Errorw("failed to offer plan",
+			"planID", planID,
+			"userID", userID,
+			"error", err)
+		if errors.Is(err, subscriptions.ErrPlanNotFound) {
+			return nil, ErrPlanNotFound
+		}
+		return nil, ErrUnexpectedResult
+	}
+	return offer, nil
+}
+
+// assign returns the variant of the experiment the user is bucketed into, nil is returned when the payment
+// doesn't take part in any, so unknown experiments don't break payment links and the defaults are used.
+// Only signed in users are bucketed, otherwise anyone could pick the cheapest variant by trying user ids
+func (s *PaymentService) assign(ctx context.Context, key string, payer Payer) *experiments.Assignment {
+	if key == "" || s.experiments == nil || !payer.Authenticated {
+		return nil
+	}
+	assignment, err := s.experiments.Assign(ctx, key, payer.UserID)
+	if err != nil {
+		s.log.Errorw("failed to assign experiment variant",
+			"experiment", key,
+			"userID", payer.UserID,
+			"error", err)
+		return nil
+	}
+	return assignment
+}
+
+// providerDisabled tells whether the kill switch of the provider is on for the payer
+func (s *PaymentService) providerDisabled(name string, payer Payer) bool {
+	if s.flags == nil {
+		return false
+	}
+	return s.flags.Enabled(flags.ProviderKillSwitch(name), flags.Target{
+		UserID:   payer.UserID,
+		Country:  payer.Country,
+		Platform: payer.Platform,
+		Client:   payer.Client,
+	})
+}
+
+// screen scores the payer before anything is reserved for the session and tells whether the payer
+// is challenged on the checkout. Screening is an extra safeguard, so the link is issued when it fails
+func (s *PaymentService) screen(ctx context.Context, sessionID string, payer Payer) (bool, error) {
+	if s.risk == nil {
+		return false, nil
+	}
+	decision, err := s.risk.Screen(ctx, risk.Attempt{
+		SessionID: sessionID,
+		UserID:    payer.UserID,
+		Email:     payer.Email,
+		IP:        payer.IP,
+		DeviceID:  payer.DeviceID,
+		Country:   payer.Country,
+		IPCountry: payer.IPCountry,
+	})
+	if err != nil {
+		s.log.Errorf("failed to screen session %v, error: %v", sessionID, err)
+		return false, nil
+	}
+	if decision.Action == models.RiskDeny {
+		s.log.Errorw("payment link is denied by risk screening",
+			"decisionID", decision.ID,
+			"userID", payer.UserID,
+			"reasons", decision.Reasons)
+		return false, ErrPaymentDenied
+	}
+	return decision.Action == models.RiskChallenge, nil
+}
+
+// calculateTax returns taxes of the price for the payer, charging less tax than due is worse
+// than not selling, so the payment link is not issued when they can't be calculated
+func (s *PaymentService) calculateTax(ctx context.Context, price money.Money, payer Payer) (*tax.Breakdown, error) {
+	country, region := payer.billing()
+	breakdown, err := s.tax.Calculate(ctx, price, country, region)
+	if err != nil {
+		s.log.Errorw("failed to calculate tax",
+			"country", country,
+			"region", region,
+			"error", err)
+		return nil, ErrUnexpectedResult
+	}
+	return breakdown, nil
+}
+
+// releasePromo takes back the redemption of the session which didn't get the payment link
+func (s *PaymentService) releasePromo(ctx context.Context, promoCode, sessionID string) {
+	if promoCode == "" {
+		return
+	}
+	if err := s.promotions.Release(ctx, sessionID); err != nil {
+		s.log.Errorf("failed to release promo code of session %v, error: %v", sessionID, err)
+	}
+}
+
+// customer returns customer of the paying user, nil is returned for anonymous payers and for users
+// who didn't prove who they are, otherwise anyone could pay with saved cards of another user
+func (s *PaymentService) customer(ctx context.Context, payer Payer) (*models.Customer, error) {
+	if s.customerRepo == nil || payer.UserID == "" || !payer.Authenticated {
+		return nil, nil
+	}
+	customer := &models.Customer{
+		ID:      uuid.NewString(),
+		UserID:  payer.UserID,
+		Email:   payer.Email,
+		Country: payer.Country,
+	}
+	if err := s.customerRepo.Upsert(ctx, customer); err != nil {
+		s.log.Errorf("failed to upsert customer of user %v, error: %v", payer.UserID, err)
+		return nil, ErrUnexpectedResult
+	}
+	return customer, nil
+}
+
+// validatePayer checks the payer and normalizes its country
+func validatePayer(payer *Payer) error {
+	// user ids of the stores and device ids of risk decisions are limited by the column size
+	if len(payer.UserID) > 64 || len(payer.DeviceID) > 128 {
+		return ErrCustomerInvalid
+	}
+	if payer.Email != "" {
+		addr, err := mail.ParseAddress(payer.Email)
+		if err != nil || addr.Address != payer.Email {
+			return ErrCustomerInvalid
+		}
+	}
+	if payer.Country != "" {
+		payer.Country = strings.ToUpper(payer.Country)
+		if len(payer.Country) != 2 || strings.Trim(payer.Country, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			return ErrCustomerInvalid
+		}
+	}
+	// region makes sense only within the country
+	if payer.Region != "" {
+		payer.Region = strings.ToUpper(payer.Region)
+		if payer.Country == "" || len(payer.Region) > 3 || strings.Trim(payer.Region, "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789") != "" {
+			return ErrCustomerInvalid
+		}
 	}
-	return url, nil
+	return nil
 }
 
-// StoresUrls fetches urls to all available stores where app is hosted
-func (s *PaymentService) StoresUrls(ctx context.Context) ([]map[string]string, error) {
-	urls := make([]map[string]string, 0, 2)
-	for _, i := range []stores.StoreName{stores.StoreAppleStore, stores.StorePlayMarket} {
-		url, err := s.stores.AppUrl(i)
+// Redirect validates token of the payment link and returns provider url
+// the client must be redirected to
+func (s *PaymentService) Redirect(ctx context.Context, token string) (string, error) {
+	claims, err := s.signer.Verify(token)
+	if err != nil {
+		s.log.Errorw("failed to verify payment link",
+			"error", err)
+		if errors.Is(err, tokens.ErrExpired) {
+			return "", ErrLinkExpired
+		}
+		return "", ErrLinkInvalid
+	}
+
+	session, err := s.sessionRepo.FetchByID(ctx, claims.SessionID)
+	if err != nil {
+		s.log.Errorw("failed to fetch payment session",
+			"ID", claims.SessionID,
+			"error", err)
+		if errors.Is(err, repository.ErrNotFound) {
+			return "", ErrLinkInvalid
+		}
+		return "", ErrUnexpectedResult
+	}
+	if session.RevokedAt != nil || !time.Now().Before(session.ExpiresAt) {
+		return "", ErrLinkExpired
+	}
+
+	// tracking is not critical for the client, thus failure is only logged
+	if err := s.sessionRepo.TrackVisit(ctx, session.ID); err != nil {
+		s.log.Errorf("failed to track visit of session %v, error: %v", session.ID, err)
+	}
+	return session.ProviderUrl, nil
+}
+
+// Capture completes payment of the session approved by the customer on the provider side
+// and returns url the customer must be redirected to
+func (s *PaymentService) Capture(ctx context.Context, sessionID, checkoutID string) (string, error) {
+	session, err := s.sessionRepo.FetchByID(ctx, sessionID)
+	if err != nil {
+		s.log.Errorw("failed to fetch payment session",
+			"ID", sessionID,
+			"error", err)
+		switch {
+		case errors.Is(err, repository.ErrNotFound), errors.Is(err, repository.ErrUuidInvalidFormat):
+			return "", ErrNotFound
+		default:
+			return "", ErrUnexpectedResult
+		}
+	}
+	// checkout id comes from the client, so it must belong to the session
+	if checkoutID == "" || session.ProviderSessionID != checkoutID {
+		s.log.Errorw("checkout does not belong to the payment session",
+			"ID", sessionID,
+			"checkoutID", checkoutID)
+		return "", ErrNotFound
+	}
+
+	providerModel, err := s.providerRepo.FetchByID(session.ProviderID)
+	if err != nil {
+		s.log.Errorw("failed to fetch provider by ID",
+			"ID", session.ProviderID)
+		return "", ErrUnexpectedResult
+	}
+	// session paid earlier is not captured again, so the return url can be safely reopened
+	if session.PaidAt == nil {
+		err = s.paymentProvider.Capture(ctx, providerModel.Name, providerModel.ApiKey, providerModel.Secret, checkoutID)
+		// payments captured by the provider on its own are completed only by its webhook,
+		// otherwise the return url would mark the unpaid session paid
+		if errors.Is(err, intpayment.ErrCaptureNotNeeded) {
+			s.log.Errorw("payment of the provider is completed by its webhook",
+				"ID", sessionID,
+				"provider", providerModel.Name)
+			return "", ErrNotFound
+		}
 		if err != nil {
-			return nil, ErrStore
+			s.log.Errorf("failed to capture payment of session %v with %v provider, error: %v", sessionID, providerModel.Name, err)
+			if errors.Is(err, intpayment.ErrProviderDeclined) {
+				return "", ErrPaymentDeclined
+			}
+			return "", ErrProvider
 		}
+	}
+	// captured orders are matched in settlements by the session reference
+	if err := s.complete(ctx, session, providerModel.Name, ""); err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(s.successUrl, "{CHECKOUT_SESSION_ID}", checkoutID), nil
+}
+
+// HandleWebhook completes the payment session which checkout the webhook event of the provider reports paid
+// and posts refunds of the payments to the ledger, events of other kinds are ignored.
+// Repeated events are safe, every step of the completion applies once and every refund is posted once
+func (s *PaymentService) HandleWebhook(ctx context.Context, providerID string, payload []byte, signature string) error {
+	providerModel, err := s.providerRepo.FetchByID(providerID)
+	if err != nil {
+		s.log.Errorw("failed to fetch provider by ID",
+			"ID", providerID,
+			"error", err)
+		if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrUuidInvalidFormat) {
+			return ErrNotFound
+		}
+		return ErrUnexpectedResult
+	}
+	completion, err := s.paymentProvider.Completion(ctx, providerModel.Name, providerModel.Secret, payload, signature)
+	if err != nil {
+		s.log.Errorf("failed to read %v webhook event, error: %v", providerModel.Name, err)
+		switch {
+		case errors.Is(err, intpayment.ErrSignatureInvalid):
+			return ErrSignatureInvalid
+		case errors.Is(err, intpayment.ErrNotSupported):
+			return ErrNotFound
+		default:
+			return ErrUnexpectedResult
+		}
+	}
+	if completion == nil {
+		renewal, err := s.paymentProvider.Renewal(ctx, providerModel.Name, providerModel.Secret, payload, signature)
+		if err != nil {
+			s.log.Errorf("failed to read %v renewal event, error: %v", providerModel.Name, err)
+			return ErrUnexpectedResult
+		}
+		if renewal != nil {
+			return s.renew(ctx, providerModel, renewal)
+		}
+		return s.refund(ctx, providerModel, payload, signature)
+	}
+
+	session, err := s.sessionRepo.FetchByID(ctx, completion.SessionID)
+	if err != nil {
+		s.log.Errorf("failed to fetch payment session %v of checkout %v, error: %v", completion.SessionID, completion.CheckoutID, err)
+		if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrUuidInvalidFormat) {
+			return ErrNotFound
+		}
+		return ErrUnexpectedResult
+	}
+	if session.ProviderID != providerModel.ID || session.ProviderSessionID != completion.CheckoutID {
+		s.log.Errorw("checkout does not belong to the payment session",
+			"ID", session.ID,
+			"checkoutID", completion.CheckoutID)
+		return ErrNotFound
+	}
+	return s.complete(ctx, session, providerModel.Name, completion.PaymentID)
+}
+
+// renew posts the payment of the renewal to the ledger, extends the subscription for the period the provider
+// has charged it for and invoices the renewal. The payment and the invoice are referenced by the provider invoice,
+// as the session has its own ones of the checkout
+func (s *PaymentService) renew(ctx context.Context, providerModel *models.Provider, renewal *intpayment.Renewal) error {
+	session, err := s.sessionRepo.FetchByID(ctx, renewal.SessionID)
+	if err != nil {
+		s.log.Errorf("failed to fetch payment session %v of invoice %v, error: %v", renewal.SessionID, renewal.InvoiceID, err)
+		if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrUuidInvalidFormat) {
+			return ErrNotFound
+		}
+		return ErrUnexpectedResult
+	}
+	if session.ProviderID != providerModel.ID || session.PlanID == "" {
+		s.log.Errorw("invoice does not renew a subscription of the payment session",
+			"ID", session.ID,
+			"invoiceID", renewal.InvoiceID)
+		return ErrNotFound
+	}
+	// renewals paid in full with a coupon move no money and are not invoiced
+	if s.ledger != nil && renewal.Amount > 0 {
+		err := s.ledger.RecordRenewal(ctx, ledger.Movement{
+			Reference: renewal.InvoiceID,
+			Provider:  providerModel.Name,
+			Amount:    renewal.Amount,
+			Currency:  renewal.Currency,
+		})
+		if err != nil {
+			s.log.Errorf("failed to post renewal %v of session %v to the ledger, error: %v", renewal.InvoiceID, session.ID, err)
+			return ErrUnexpectedResult
+		}
+	}
+	if s.subscriptions != nil {
+		err := s.subscriptions.Renew(ctx, session.ID, renewal.PeriodStart, renewal.PeriodEnd)
+		switch {
+		case errors.Is(err, subscriptions.ErrSubscriptionNotFound):
+			// the checkout completion has not started the subscription yet, the provider redelivers the event
+			return ErrNotFound
+		case errors.Is(err, subscriptions.ErrSubscriptionCanceled):
+			// the provider keeps charging the subscription canceled on our side, it is to be canceled there as well.
+			// The money is taken anyway, so the renewal is still invoiced until it is refunded
+			s.log.Errorw("canceled subscription is renewed",
+				"alert", "canceled_subscription_renewed",
+				"sessionID", session.ID,
+				"invoiceID", renewal.InvoiceID)
+		case err != nil:
+			return ErrUnexpectedResult
+		}
+	}
+	if s.invoices != nil {
+		// the invoice bills what the provider has charged, tax lines of the checkout price do not apply to it
+		paid := *session
+		paid.Amount, paid.TaxAmount, paid.Currency, paid.Taxes = renewal.Amount, renewal.TaxAmount, renewal.Currency, nil
+		if _, err := s.invoices.IssueRenewal(ctx, &paid, renewal.InvoiceID); err != nil {
+			s.log.Errorf("failed to issue invoice of renewal %v of session %v, error: %v", renewal.InvoiceID, session.ID, err)
+			return ErrUnexpectedResult
+		}
+	}
+	return nil
+}
+
+// refund posts the refund reported by the webhook event to the ledger, nil is returned for events of other kinds
+func (s *PaymentService) refund(ctx context.Context, providerModel *models.Provider, payload []byte, signature string) error {
+	if s.ledger == nil {
+		return nil
+	}
+	refund, err := s.paymentProvider.Refund(ctx, providerModel.Name, providerModel.ApiKey, providerModel.Secret, payload, signature)
+	if err != nil {
+		s.log.Errorf("failed to read %v refund event, error: %v", providerModel.Name, err)
+		if errors.Is(err, intpayment.ErrSignatureInvalid) {
+			return ErrSignatureInvalid
+		}
+		return ErrUnexpectedResult
+	}
+	if refund == nil {
+		return nil
+	}
+	session, err := s.sessionRepo.FetchByID(ctx, refund.SessionID)
+	if err != nil {
+		s.log.Errorf("failed to fetch payment session %v of refund %v, error: %v", refund.SessionID, refund.ID, err)
+		if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrUuidInvalidFormat) {
+			return ErrNotFound
+		}
+		return ErrUnexpectedResult
+	}
+	if session.ProviderID != providerModel.ID {
+		s.log.Errorw("refund does not belong to the payment session",
+			"ID", session.ID,
+			"refundID", refund.ID)
+		return ErrNotFound
+	}
+	err = s.ledger.RecordRefund(ctx, ledger.Movement{
+		Reference: refund.ID,
+		Provider:  providerModel.Name,
+		Amount:    refund.Amount,
+		Currency:  refund.Currency,
+	})
+	if err != nil {
+		s.log.Errorf("failed to post refund %v of session %v to the ledger, error: %v", refund.ID, session.ID, err)
+		return ErrUnexpectedResult
+	}
+	return nil
+}
+
+// complete marks the session paid and delivers what was bought with it: posts the payment to the ledger,
+// grants access or starts the subscription and issues the invoice. The steps are not one transaction,
+// instead every step is safe to repeat: the session stays paid, the ledger posts the entry of the session once
+// and the invoice is issued once. The failed completion is reported to the provider, which redelivers
+// the webhook event, and the reopened return url of captured orders completes the session again,
+// so the completion failed halfway is finished by the next attempt. PaymentID of the provider is kept
+// with the session, settlements of the provider are matched by it
+func (s *PaymentService) complete(ctx context.Context, session *models.PaymentSession, providerName, paymentID string) error {
+	paidAt, err := s.sessionRepo.MarkPaid(ctx, session.ID, paymentID)
+	if err != nil {
+		s.log.Errorf("failed to mark payment session %v paid, error: %v", session.ID, err)
+		return ErrUnexpectedResult
+	}
+	// sessions created before prices were kept have no amount, there is nothing to post
+	if s.ledger != nil && session.Amount > 0 {
+		if err := s.ledger.RecordPayment(ctx, session, providerName); err != nil {
+			s.log.Errorf("failed to post payment of session %v to the ledger, error: %v", session.ID, err)
+			return ErrUnexpectedResult
+		}
+	}
+	switch {
+	case session.PlanID != "" && s.subscriptions != nil:
+		if err := s.subscriptions.Start(ctx, session, paidAt); err != nil {
+			s.log.Errorf("failed to start subscription of session %v, error: %v", session.ID, err)
+			return ErrUnexpectedResult
+		}
+	case s.entitlements != nil:
+		if err := s.entitlements.GrantWebPayment(ctx, session, paidAt); err != nil {
+			s.log.Errorf("failed to grant access paid with session %v, error: %v", session.ID, err)
+			return ErrUnexpectedResult
+		}
+	}
+	// the invoice is issued once per session, so the repeated completion issues the one that failed
+	if s.invoices != nil {
+		if _, err := s.invoices.Issue(ctx, session); err != nil {
+			s.log.Errorf("failed to issue invoice of session %v, error: %v", session.ID, err)
+			return ErrUnexpectedResult
+		}
+	}
+	return nil
+}
+
+// RevokeSession invalidates payment link of the session
+func (s *PaymentService) RevokeSession(ctx context.Context, sessionID string) error {
+	if err := s.sessionRepo.Revoke(ctx, sessionID); err != nil {
+		s.log.Errorw("failed to revoke payment session",
+			"ID", sessionID,
+			"error", err)
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			return ErrNotFound
+		case errors.Is(err, repository.ErrUuidInvalidFormat):
+			return ErrUuidInvalidFormat
+		default:
+			return ErrUnexpectedResult
+		}
+	}
+	return nil
+}
+
+// StoresRequest describes the client asking for the stores fallback
+type StoresRequest struct {
+	ProductID string
+	RequestID string
+	Platform  platform.Platform
+	// All forces every store to be returned regardless of the platform
+	All bool
+	// Campaign holds utm_* parameters, which are passed to the tracking urls
+	Campaign url.Values
+}
+
+// StoresUrls returns tracking urls to the stores relevant for the client platform,
+// tracking url records the click and redirects to the store
+func (s *PaymentService) StoresUrls(ctx context.Context, req StoresRequest) ([]map[string]string, error) {
+	p := req.Platform
+	if req.All {
+		p = ""
+	}
+	names, err := s.stores.Names(p)
+	if err != nil {
+		return nil, ErrStore
+	}
+	urls := make([]map[string]string, 0, len(names))
+	// the url is localized later by the redirect endpoint
+	for _, i := range names {
 		// not the best solution, though for such case it is much more convennient
 		// than using fixed size arrays
-		urls = append(urls, map[string]string{string(i): url})
+		urls = append(urls, map[string]string{string(i): s.trackingUrl(i, req)})
 	}
 	return urls, nil
 }
+
+// trackingUrl builds link to the store redirect endpoint
+func (s *PaymentService) trackingUrl(store stores.StoreName, req StoresRequest) string {
+	q := url.Values{}
+	for k, v := range req.Campaign {
+		if strings.HasPrefix(k, "utm_") && len(v) > 0 {
+			q.Set(k, v[0])
+		}
+	}
+	if req.ProductID != "" {
+		q.Set("product", req.ProductID)
+	}
+	if req.RequestID != "" {
+		q.Set("rid", req.RequestID)
+	}
+	if req.Platform != "" {
+		q.Set("platform", string(req.Platform))
+	}
+	link := s.baseUrl + "/r/store/" + url.PathEscape(string(store))
+	if len(q) > 0 {
+		link += "?" + q.Encode()
+	}
+	return link
+}
@@ -2,12 +2,33 @@ package payment
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"payment-api/internal/integrations/payment"
+	"payment-api/internal/integrations/payment/simulator"
 	"payment-api/internal/integrations/stores"
 	"payment-api/internal/models"
+	"payment-api/internal/money"
+	"payment-api/internal/platform"
+	"payment-api/internal/services/experiments"
+	"payment-api/internal/services/flags"
+	"payment-api/internal/services/ledger"
 	"payment-api/internal/services/payment/repository"
+	"payment-api/internal/services/promotions"
+	"payment-api/internal/services/risk"
+	"payment-api/internal/services/subscriptions"
+	"payment-api/internal/services/tax"
+	"payment-api/internal/tokens"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -17,7 +38,8 @@ import (
 
 // FakeProviderRepo is faked structure for existing repository
 type FakeProviderRepo struct {
-	Providers []*models.Provider
+	Providers    []*models.Provider
+	BatchFetches int
 }
 
 func (m *FakeProviderRepo) Setup() {
@@ -64,6 +86,356 @@ func (m *FakeProviderRepo) FetchByID(id string) (*models.Provider, error) {
 	return nil, repository.ErrNotFound
 }
 
+func (m *FakeProviderRepo) FetchByIDs(ids []string) ([]models.Provider, error) {
+	m.BatchFetches++
+	var res []models.Provider
+	for _, p := range m.Providers {
+		for _, id := range ids {
+			// uuids are compared regardless of the case as by postgres
+			if strings.EqualFold(p.ID, id) {
+				res = append(res, *p)
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+// FakeSessionRepo is in-memory replacement of the sessions repository
+type FakeSessionRepo struct {
+	mu       sync.Mutex
+	Sessions map[string]*models.PaymentSession
+}
+
+func NewFakeSessionRepo() *FakeSessionRepo {
+	return &FakeSessionRepo{Sessions: map[string]*models.PaymentSession{}}
+}
+
+func (m *FakeSessionRepo) Create(ctx context.Context, s *models.PaymentSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Sessions[s.ID] = s
+	return nil
+}
+
+func (m *FakeSessionRepo) FetchByID(ctx context.Context, id string) (*models.PaymentSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.Sessions[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return s, nil
+}
+
+func (m *FakeSessionRepo) Revoke(ctx context.Context, id string) error {
+	s, ok := m.Sessions[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	now := time.Now()
+	s.RevokedAt = &now
+	return nil
+}
+
+func (m *FakeSessionRepo) MarkPaid(ctx context.Context, id, paymentID string) (time.Time, error) {
+	s, ok := m.Sessions[id]
+	if !ok {
+		return time.Time{}, repository.ErrNotFound
+	}
+	if s.PaidAt == nil {
+		now := time.Now()
+		s.PaidAt = &now
+	}
+	if s.ProviderPaymentID == "" {
+		s.ProviderPaymentID = paymentID
+	}
+	return *s.PaidAt, nil
+}
+
+func (m *FakeSessionRepo) TrackVisit(ctx context.Context, id string) error {
+	m.Sessions[id].Visits++
+	return nil
+}
+
+// FakeEntitlements records access granted by sessions
+type FakeEntitlements struct {
+	Granted map[string]string
+}
+
+func (m *FakeEntitlements) GrantWebPayment(ctx context.Context, session *models.PaymentSession, paidAt time.Time) error {
+	m.Granted[session.ID] = session.UserID
+	return nil
+}
+
+// FakeLedger records providers of the posted payments by session and amounts of the posted refunds,
+// it fails with Err when it is set
+type FakeLedger struct {
+	Payments map[string]string
+	Renewals map[string]ledger.Movement
+	Refunds  map[string]int64
+	Err      error
+}
+
+func (m *FakeLedger) RecordPayment(ctx context.Context, session *models.PaymentSession, provider string) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	m.Payments[session.ID] = provider
+	return nil
+}
+
+func (m *FakeLedger) RecordRenewal(ctx context.Context, mv ledger.Movement) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	if m.Renewals == nil {
+		m.Renewals = map[string]ledger.Movement{}
+	}
+	m.Renewals[mv.Reference] = mv
+	return nil
+}
+
+func (m *FakeLedger) RecordRefund(ctx context.Context, mv ledger.Movement) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	if m.Refunds == nil {
+		m.Refunds = map[string]int64{}
+	}
+	m.Refunds[mv.Reference] += mv.Amount
+	return nil
+}
+
+// FakeInvoices remembers the invoiced sessions, renewals are kept by the provider invoice
+type FakeInvoices struct {
+	Sessions map[string]*models.PaymentSession
+	Renewals map[string]*models.PaymentSession
+	Err      error
+}
+
+func (m *FakeInvoices) Issue(ctx context.Context, session *models.PaymentSession) (*models.Invoice, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	m.Sessions[session.ID] = session
+	return &models.Invoice{SessionID: session.ID}, nil
+}
+
+func (m *FakeInvoices) IssueRenewal(ctx context.Context, session *models.PaymentSession, providerInvoiceID string) (*models.Invoice, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	if m.Renewals == nil {
+		m.Renewals = map[string]*models.PaymentSession{}
+	}
+	m.Renewals[providerInvoiceID] = session
+	return &models.Invoice{SessionID: session.ID, ProviderInvoiceID: providerInvoiceID}, nil
+}
+
+// FakePricer charges polish payers in zloty and everybody else in dollars
+type FakePricer struct{}
+
+func (m *FakePricer) Price(ctx context.Context, country string) (money.Money, error) {
+	if country == "PL" {
+		return money.Money{Amount: 5199, Currency: "pln"}, nil
+	}
+	return money.Money{Amount: 1299, Currency: "usd"}, nil
+}
+
+// failingSigner can't sign links
+type failingSigner struct{}
+
+func (failingSigner) Sign(c tokens.Claims) (string, error) {
+	return "", errors.New("signing key is unavailable")
+}
+
+func (failingSigner) Verify(token string) (*tokens.Claims, error) {
+	return nil, tokens.ErrMalformed
+}
+
+// FakePromotions takes 10 percent off with SAVE10 code and remembers redeemed sessions
+type FakePromotions struct {
+	Redeemed map[string]bool
+}
+
+func (m *FakePromotions) Redeem(ctx context.Context, code, sessionID, userID, productID string, price money.Money) (money.Money, error) {
+	if code != "SAVE10" || productID != "premium" {
+		return money.Money{}, promotions.ErrNotApplicable
+	}
+	m.Redeemed[sessionID] = true
+	return money.Money{Amount: price.Amount - price.Amount/10, Currency: price.Currency}, nil
+}
+
+func (m *FakePromotions) Release(ctx context.Context, sessionID string) error {
+	delete(m.Redeemed, sessionID)
+	return nil
+}
+
+// FakeSubscriptions sells monthly plan with a trial, which user "trialed" has used, and yearly plan with an intro price.
+// Renewed keeps end of the renewed period by session
+type FakeSubscriptions struct {
+	Started map[string]*models.PaymentSession
+	Renewed map[string]time.Time
+}
+
+func (m *FakeSubscriptions) Offer(ctx context.Context, planID, userID string) (*subscriptions.Offer, error) {
+	switch planID {
+	case "monthly":
+		offer := &subscriptions.Offer{Plan: models.Plan{ID: planID, Name: "Monthly", Amount: 1299, Currency: "usd", Interval: models.IntervalMonth, TrialDays: 7}}
+		if userID != "trialed" {
+			offer.TrialDays = 7
+		}
+		return offer, nil
+	case "yearly":
+		return &subscriptions.Offer{Plan: models.Plan{ID: planID, Name: "Yearly", Amount: 8999, Currency: "usd", Interval: models.IntervalYear,
+			IntroAmount: 5999, IntroPeriods: 1}}, nil
+	}
+	return nil, subscriptions.ErrPlanNotFound
+}
+
+func (m *FakeSubscriptions) Start(ctx context.Context, session *models.PaymentSession, paidAt time.Time) error {
+	m.Started[session.ID] = session
+	return nil
+}
+
+func (m *FakeSubscriptions) Renew(ctx context.Context, sessionID string, periodStart, periodEnd time.Time) error {
+	if _, ok := m.Started[sessionID]; !ok {
+		return subscriptions.ErrSubscriptionNotFound
+	}
+	m.Renewed[sessionID] = periodEnd
+	return nil
+}
+
+// FakeExperiments runs "paywall" experiment with the variants fixed per user and remembers exposures by session
+type FakeExperiments struct {
+	Variants  map[string]models.ExperimentVariant
+	Exposures map[string]*models.ExperimentExposure
+}
+
+func (m *FakeExperiments) Assign(ctx context.Context, key, userID string) (*experiments.Assignment, error) {
+	if key != "paywall" {
+		return nil, experiments.ErrNotFound
+	}
+	v, ok := m.Variants[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &experiments.Assignment{ExperimentID: "experiment", Variant: v}, nil
+}
+
+func (m *FakeExperiments) Expose(ctx context.Context, x *models.ExperimentExposure) error {
+	m.Exposures[x.SessionID] = x
+	return nil
+}
+
+// FakeFlags turns on the flags of the set for every target from the listed countries, or from everywhere when none is listed
+type FakeFlags struct {
+	Countries map[string][]string
+}
+
+func (m *FakeFlags) Enabled(key string, t flags.Target) bool {
+	countries, ok := m.Countries[key]
+	if !ok {
+		return false
+	}
+	if len(countries) == 0 {
+		return true
+	}
+	for _, c := range countries {
+		if c == t.Country {
+			return true
+		}
+	}
+	return false
+}
+
+// FakeRisk decides by the local part of the email, e.g. deny@ is denied, the decisions are kept by session
+type FakeRisk struct {
+	Attempts []risk.Attempt
+}
+
+func (m *FakeRisk) Screen(ctx context.Context, a risk.Attempt) (*models.RiskDecision, error) {
+	m.Attempts = append(m.Attempts, a)
+	action, _, _ := strings.Cut(a.Email, "@")
+	switch action {
+	case models.RiskDeny, models.RiskChallenge:
+	case "error":
+		return nil, errors.New("connection refused")
+	default:
+		action = models.RiskAllow
+	}
+	return &models.RiskDecision{ID: uuid.NewString(), SessionID: a.SessionID, Action: action}, nil
+}
+
+// FakeTax adds 10 percent GST on top of the prices in Canada and takes 20 percent VAT out of them in Germany
+type FakeTax struct{}
+
+func (m *FakeTax) Calculate(ctx context.Context, price money.Money, country, region string) (*tax.Breakdown, error) {
+	breakdown := &tax.Breakdown{Net: price, Lines: []models.TaxLine{}, Total: price}
+	switch country {
+	case "CA":
+		line := models.TaxLine{Name: "GST", Rate: "10", Amount: price.Amount / 10, Currency: price.Currency}
+		breakdown.Lines = append(breakdown.Lines, line)
+		breakdown.Total.Amount += line.Amount
+	case "DE":
+		line := models.TaxLine{Name: "VAT", Rate: "20", Inclusive: true, Amount: price.Amount / 6, Currency: price.Currency}
+		breakdown.Lines = append(breakdown.Lines, line)
+		breakdown.Net.Amount -= line.Amount
+	}
+	return breakdown, nil
+}
+
+// FakeCustomerRepo keeps customers in memory by their user id
+type FakeCustomerRepo struct {
+	Customers map[string]*models.Customer
+}
+
+func (m *FakeCustomerRepo) Upsert(ctx context.Context, c *models.Customer) error {
+	stored, ok := m.Customers[c.UserID]
+	if !ok {
+		stored = &models.Customer{ID: c.ID, UserID: c.UserID, ProviderIDs: map[string]string{}}
+		m.Customers[c.UserID] = stored
+	}
+	if stored.Email == "" {
+		stored.Email = c.Email
+	}
+	if c.Country != "" {
+		stored.Country = c.Country
+	}
+	*c = *stored
+	c.ProviderIDs = map[string]string{}
+	for k, v := range stored.ProviderIDs {
+		c.ProviderIDs[k] = v
+	}
+	return nil
+}
+
+func (m *FakeCustomerRepo) SaveProviderID(ctx context.Context, customerID, providerName, externalID string) error {
+	for _, c := range m.Customers {
+		if c.ID == customerID {
+			c.ProviderIDs[providerName] = externalID
+		}
+	}
+	return nil
+}
+
+// webhookRecorder keeps the last event delivered by the simulator
+type webhookRecorder struct {
+	payload   []byte
+	signature string
+}
+
+func (rec *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec.payload, _ = io.ReadAll(r.Body)
+	rec.signature = r.Header.Get(simulator.SignatureHeader)
+}
+
+// tokenFromLink cuts the token out of the payment link
+func tokenFromLink(link *PaymentLink) string {
+	return strings.TrimPrefix(link.Url, "https://pay.test/pay/")
+}
+
 func TestPaymentServicePaymentUrl(t *testing.T) {
 	mockLogger := zap.NewNop().Sugar()
 	// Fake repo
@@ -73,7 +445,8 @@ func TestPaymentServicePaymentUrl(t *testing.T) {
 	// it will be used as it is
 	paymentProvider := payment.NewPaymentProvider(mockLogger, "../../../assets/providers.json")
 
-	service := NewPaymentService(mockLogger, paymentProvider, nil, &fakeProviderRepo)
+	fakeSessionRepo := NewFakeSessionRepo()
+	service := NewPaymentService(mockLogger, paymentProvider, nil, &fakeProviderRepo, fakeSessionRepo, tokens.NewSigner("secret"), WithBaseUrl("https://pay.test/"))
 
 	type testCase struct {
 		name        string
@@ -137,33 +510,1002 @@ func TestPaymentServicePaymentUrl(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			url, err := service.PaymentUrl(context.Background(), tc.id)
-			if tc.success {
-				assert.NoError(t, err)
-			} else {
+			url, err := service.PaymentUrl(context.Background(), tc.id, Payer{}, Purchase{})
+			if !tc.success {
 				assert.ErrorIs(t, err, tc.expectedErr)
+				assert.Empty(t, url)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, strings.HasPrefix(url.Url, "https://pay.test/pay/"))
+
+			// signed link must lead to the provider checkout
+			providerUrl, err := service.Redirect(context.Background(), tokenFromLink(url))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedUrl, providerUrl)
+		})
+	}
+}
+
+func TestPaymentServicePaymentUrls(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	fakeProviderRepo := FakeProviderRepo{}
+	fakeProviderRepo.Setup()
+	paymentProvider := payment.NewPaymentProvider(mockLogger, "../../../assets/providers.json")
+	service := NewPaymentService(mockLogger, paymentProvider, nil, &fakeProviderRepo, NewFakeSessionRepo(), tokens.NewSigner("secret"),
+		WithBaseUrl("https://pay.test/"), WithBatchParallelism(2))
+
+	t.Run("per item results", func(t *testing.T) {
+		fakeProviderRepo.BatchFetches = 0
+		ids := []string{
+			fakeProviderRepo.Providers[0].ID,
+			"ab23bd-123efa4b1",
+			fakeProviderRepo.Providers[3].ID,
+			uuid.NewString(),
+			fakeProviderRepo.Providers[4].ID,
+			strings.ToUpper(fakeProviderRepo.Providers[2].ID),
+		}
+		results, err := service.PaymentUrls(context.Background(), ids, Payer{}, Purchase{})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, fakeProviderRepo.BatchFetches)
+		assert.Len(t, results, len(ids))
+		expected := []struct {
+			url string
+			err error
+		}{
+			{"https://apple-pay-gateway.apple.com", nil},
+			{"", ErrUuidInvalidFormat},
+			{"https://stripe.com/pay", nil},
+			{"", ErrNotFound},
+			{"", ErrProvider},
+			{"https://www.paypal.com/pay", nil},
+		}
+		for i, res := range results {
+			assert.Equal(t, ids[i], res.ProviderID)
+			if expected[i].err != nil {
+				assert.ErrorIs(t, res.Err, expected[i].err)
+				assert.Nil(t, res.Link)
+				continue
 			}
-			assert.Equal(t, tc.expectedUrl, url)
+			assert.NoError(t, res.Err)
+			providerUrl, err := service.Redirect(context.Background(), tokenFromLink(res.Link))
+			assert.NoError(t, err)
+			assert.Equal(t, expected[i].url, providerUrl)
+		}
+	})
+
+	tooMany := make([]string, MaxBatchSize+1)
+	for i := range tooMany {
+		tooMany[i] = uuid.NewString()
+	}
+	invalid := map[string][]string{
+		"empty":     nil,
+		"too many":  tooMany,
+		"duplicate": {fakeProviderRepo.Providers[0].ID, strings.ToUpper(fakeProviderRepo.Providers[0].ID)},
+	}
+	for name, ids := range invalid {
+		t.Run(name, func(t *testing.T) {
+			fakeProviderRepo.BatchFetches = 0
+			results, err := service.PaymentUrls(context.Background(), ids, Payer{}, Purchase{})
+			assert.ErrorIs(t, err, ErrBatchInvalid)
+			assert.Nil(t, results)
+			assert.Equal(t, 0, fakeProviderRepo.BatchFetches)
 		})
 	}
 }
 
+func TestPaymentServiceRedirect(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	fakeProviderRepo := FakeProviderRepo{}
+	fakeProviderRepo.Setup()
+	paymentProvider := payment.NewPaymentProvider(mockLogger, "../../../assets/providers.json")
+	fakeSessionRepo := NewFakeSessionRepo()
+	signer := tokens.NewSigner("secret")
+	service := NewPaymentService(mockLogger, paymentProvider, nil, &fakeProviderRepo, fakeSessionRepo, signer, WithBaseUrl("https://pay.test"))
+
+	newLink := func() (string, string) {
+		url, err := service.PaymentUrl(context.Background(), fakeProviderRepo.Providers[0].ID, Payer{}, Purchase{})
+		assert.NoError(t, err)
+		token := tokenFromLink(url)
+		claims, err := signer.Verify(token)
+		assert.NoError(t, err)
+		return token, claims.SessionID
+	}
+
+	t.Run("success tracks visits", func(t *testing.T) {
+		token, sessionID := newLink()
+		for i := 0; i < 2; i++ {
+			_, err := service.Redirect(context.Background(), token)
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, 2, fakeSessionRepo.Sessions[sessionID].Visits)
+	})
+
+	t.Run("fail revoked", func(t *testing.T) {
+		token, sessionID := newLink()
+		assert.NoError(t, service.RevokeSession(context.Background(), sessionID))
+		_, err := service.Redirect(context.Background(), token)
+		assert.ErrorIs(t, err, ErrLinkExpired)
+	})
+
+	t.Run("fail expired", func(t *testing.T) {
+		token, sessionID := newLink()
+		fakeSessionRepo.Sessions[sessionID].ExpiresAt = time.Now().Add(-time.Second)
+		_, err := service.Redirect(context.Background(), token)
+		assert.ErrorIs(t, err, ErrLinkExpired)
+	})
+
+	t.Run("fail foreign signature", func(t *testing.T) {
+		token, err := tokens.NewSigner("another").Sign(tokens.Claims{SessionID: uuid.NewString(), ExpiresAt: time.Now().Add(time.Hour).Unix()})
+		assert.NoError(t, err)
+		_, err = service.Redirect(context.Background(), token)
+		assert.ErrorIs(t, err, ErrLinkInvalid)
+	})
+
+	t.Run("fail revoke unknown session", func(t *testing.T) {
+		assert.ErrorIs(t, service.RevokeSession(context.Background(), uuid.NewString()), ErrNotFound)
+	})
+}
+
 func TestPaymentServiceStoresUrls(t *testing.T) {
 	mockLogger := zap.NewNop().Sugar()
 	// Since it already acts as a fake structure for mocking requests to the payment platforms
 	// it will be used as it is
 	paymentProvider := payment.NewPaymentProvider(mockLogger, "../../../assets/providers.json")
 	// Initiating store dependency
-	stores := stores.NewStore(mockLogger, "../../../assets/stores.json")
-	service := NewPaymentService(mockLogger, paymentProvider, stores, nil)
-	urlMap, err := service.StoresUrls(context.Background())
-
-	assert.NoError(t, err)
-	url, ok := urlMap[0]["AppleStore"]
-	assert.True(t, ok)
-	assert.Equal(t, url, "https://apps.apple.com/us/app/headway-daily-book-summaries/id1457185832")
-
-	url, ok = urlMap[1]["PlayMarket"]
-	assert.True(t, ok)
-	assert.Equal(t, url, "https://play.google.com/store/apps/details?id=com.headway.books&hl=pl&pli=1")
+	appStores := stores.NewStore(mockLogger, "../../../assets/stores.json")
+	service := NewPaymentService(mockLogger, paymentProvider, appStores, nil, nil, nil, WithBaseUrl("https://pay.test"))
+
+	type testCase struct {
+		name     string
+		platform platform.Platform
+		all      bool
+		expected []string
+	}
+	testCases := []testCase{
+		{"iOS", platform.PlatformIOS, false, []string{"AppleStore"}},
+		{"Android", platform.PlatformAndroid, false, []string{"PlayMarket", "AppGallery", "GalaxyStore", "AmazonAppstore"}},
+		{"desktop", platform.PlatformDesktop, false, []string{"AppleStore", "PlayMarket", "Web"}},
+		{"forced full list", platform.PlatformIOS, true, []string{"AppleStore", "PlayMarket", "AppGallery", "GalaxyStore", "AmazonAppstore", "Web"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			urlMap, err := service.StoresUrls(context.Background(), StoresRequest{
+				ProductID: "product",
+				RequestID: "request",
+				Platform:  tc.platform,
+				All:       tc.all,
+				Campaign:  map[string][]string{"utm_source": {"email"}, "productID": {"product"}},
+			})
+			assert.NoError(t, err)
+			assert.Len(t, urlMap, len(tc.expected))
+			for i, name := range tc.expected {
+				url, ok := urlMap[i][name]
+				assert.True(t, ok)
+				assert.Equal(t, "https://pay.test/r/store/"+name+"?platform="+string(tc.platform)+"&product=product&rid=request&utm_source=email", url)
+			}
+		})
+	}
+
+	t.Run("store without default url", func(t *testing.T) {
+		filePath := filepath.Join(t.TempDir(), "stores.json")
+		raw := `{"stores": [{"name": "AppleStore", "platforms": ["ios"], "urls": {"default": "https://apps.apple.com"}},
+			{"name": "Web", "platforms": ["ios"], "urls": {"languages": {"en": "https://makeheadway.com"}}}]}`
+		assert.NoError(t, os.WriteFile(filePath, []byte(raw), 0o600))
+		service := NewPaymentService(mockLogger, paymentProvider, stores.NewStore(mockLogger, filePath), nil, nil, nil)
+		urlMap, err := service.StoresUrls(context.Background(), StoresRequest{Platform: platform.PlatformIOS})
+		assert.ErrorIs(t, err, ErrStore)
+		assert.Nil(t, urlMap)
+	})
+}
+
+func TestPaymentServiceCapture(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	fakeProviderRepo := FakeProviderRepo{}
+	fakeProviderRepo.Setup()
+	payPalModel := fakeProviderRepo.Providers[2]
+	sim := simulator.NewServer(mockLogger, simulator.WithApiKey(payPalModel.ApiKey))
+	srv := httptest.NewServer(sim)
+	defer srv.Close()
+
+	paymentProvider := payment.NewPaymentProvider(mockLogger, "../../../assets/providers.json", payment.WithPayPal(payment.NewPayPal(mockLogger, payment.PayPalConfig{
+		BaseUrl:   srv.URL,
+		ReturnUrl: "https://pay.test/api/v1/payment/paypal/return",
+		Items:     []payment.LineItem{{Name: "Premium", Amount: 1299, Currency: "usd"}},
+	})))
+	fakeSessionRepo := NewFakeSessionRepo()
+	fakeEntitlements := &FakeEntitlements{Granted: map[string]string{}}
+	fakeLedger := &FakeLedger{Payments: map[string]string{}}
+	fakeInvoices := &FakeInvoices{Sessions: map[string]*models.PaymentSession{}}
+	signer := tokens.NewSigner("secret")
+	service := NewPaymentService(mockLogger, paymentProvider, nil, &fakeProviderRepo, fakeSessionRepo, signer,
+		WithBaseUrl("https://pay.test"),
+		WithSuccessUrl("https://merchant.test/success?order={CHECKOUT_SESSION_ID}"),
+		WithEntitlements(fakeEntitlements),
+		WithLedger(fakeLedger),
+		WithInvoices(fakeInvoices),
+		WithPrice(1299, "USD"),
+	)
+	client := http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	// newApprovedOrder creates payment link, follows it to PayPal and approves the order
+	newApprovedOrder := func() (string, string) {
+		link, err := service.PaymentUrl(context.Background(), payPalModel.ID, Payer{UserID: "user", Email: "reader@headway.test", Country: "pl"}, Purchase{})
+		assert.NoError(t, err)
+		approveUrl, err := service.Redirect(context.Background(), tokenFromLink(link))
+		assert.NoError(t, err)
+		resp, err := client.Get(approveUrl)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		returnUrl, err := url.Parse(resp.Header.Get("Location"))
+		assert.NoError(t, err)
+		return returnUrl.Query().Get("session_id"), returnUrl.Query().Get("token")
+	}
+
+	t.Run("success", func(t *testing.T) {
+		sessionID, orderID := newApprovedOrder()
+		assert.Equal(t, orderID, fakeSessionRepo.Sessions[sessionID].ProviderSessionID)
+		redirect, err := service.Capture(context.Background(), sessionID, orderID)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://merchant.test/success?order="+orderID, redirect)
+		assert.NotNil(t, fakeSessionRepo.Sessions[sessionID].PaidAt)
+		assert.Equal(t, "user", fakeEntitlements.Granted[sessionID])
+		assert.Equal(t, int64(1299), fakeSessionRepo.Sessions[sessionID].Amount)
+		assert.Equal(t, "usd", fakeSessionRepo.Sessions[sessionID].Currency)
+		assert.Equal(t, payPalModel.Name, fakeLedger.Payments[sessionID])
+		assert.Equal(t, "reader@headway.test", fakeInvoices.Sessions[sessionID].Email)
+		assert.Equal(t, "PL", fakeInvoices.Sessions[sessionID].Country)
+
+		// reopened return url is not captured twice
+		sim.Script(simulator.RouteCaptureOrder, simulator.Scenario{Status: http.StatusInternalServerError})
+		_, err = service.Capture(context.Background(), sessionID, orderID)
+		assert.NoError(t, err)
+		sim.Reset()
+	})
+
+	t.Run("fail foreign order", func(t *testing.T) {
+		sessionID, _ := newApprovedOrder()
+		_, anotherOrderID := newApprovedOrder()
+		_, err := service.Capture(context.Background(), sessionID, anotherOrderID)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("fail declined", func(t *testing.T) {
+		sessionID, orderID := newApprovedOrder()
+		sim.Script(simulator.RouteCaptureOrder, simulator.Scenario{Decline: true})
+		_, err := service.Capture(context.Background(), sessionID, orderID)
+		assert.ErrorIs(t, err, ErrPaymentDeclined)
+		assert.Nil(t, fakeSessionRepo.Sessions[sessionID].PaidAt)
+		assert.NotContains(t, fakeEntitlements.Granted, sessionID)
+		assert.NotContains(t, fakeLedger.Payments, sessionID)
+		assert.NotContains(t, fakeInvoices.Sessions, sessionID)
+	})
+
+	t.Run("fail invoice", func(t *testing.T) {
+		sessionID, orderID := newApprovedOrder()
+		fakeInvoices.Err = errors.New("legal entities are invalid")
+		_, err := service.Capture(context.Background(), sessionID, orderID)
+		assert.ErrorIs(t, err, ErrUnexpectedResult)
+		assert.Equal(t, "user", fakeEntitlements.Granted[sessionID])
+
+		// the return url is reopened once invoices are back
+		fakeInvoices.Err = nil
+		_, err = service.Capture(context.Background(), sessionID, orderID)
+		assert.NoError(t, err)
+		assert.Contains(t, fakeInvoices.Sessions, sessionID)
+	})
+
+	t.Run("fail provider outage", func(t *testing.T) {
+		sessionID, orderID := newApprovedOrder()
+		sim.Script(simulator.RouteCaptureOrder, simulator.Scenario{Status: http.StatusInternalServerError})
+		_, err := service.Capture(context.Background(), sessionID, orderID)
+		assert.ErrorIs(t, err, ErrProvider)
+	})
+}
+
+func TestPaymentServiceHandleWebhook(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	fakeProviderRepo := FakeProviderRepo{}
+	fakeProviderRepo.Setup()
+	stripeModel := fakeProviderRepo.Providers[3]
+	rec := &webhookRecorder{}
+	webhook := httptest.NewServer(rec)
+	defer webhook.Close()
+	sim := simulator.NewServer(mockLogger, simulator.WithApiKey(stripeModel.ApiKey), simulator.WithWebhook(webhook.URL, stripeModel.Secret))
+	srv := httptest.NewServer(sim)
+	defer srv.Close()
+
+	paymentProvider := payment.NewPaymentProvider(mockLogger, "../../../assets/providers.json", payment.WithStripe(payment.NewStripe(mockLogger, payment.StripeConfig{
+		BaseUrl:    srv.URL,
+		SuccessUrl: "https://merchant.test/success",
+		Items:      []payment.LineItem{{Name: "Premium", Amount: 1299, Currency: "usd"}},
+	})))
+	fakeSessionRepo := NewFakeSessionRepo()
+	fakeEntitlements := &FakeEntitlements{Granted: map[string]string{}}
+	fakeLedger := &FakeLedger{Payments: map[string]string{}}
+	fakeInvoices := &FakeInvoices{Sessions: map[string]*models.PaymentSession{}}
+	signer := tokens.NewSigner("secret")
+	service := NewPaymentService(mockLogger, paymentProvider, nil, &fakeProviderRepo, fakeSessionRepo, signer,
+		WithBaseUrl("https://pay.test"),
+		WithEntitlements(fakeEntitlements),
+		WithLedger(fakeLedger),
+		WithInvoices(fakeInvoices),
+		WithPrice(1299, "usd"),
+	)
+	client := http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	// newCheckout creates payment link and returns its session, paid on the Stripe side when asked
+	newCheckout := func(pay bool) *models.PaymentSession {
+		link, err := service.PaymentUrl(context.Background(), stripeModel.ID, Payer{UserID: "user"}, Purchase{})
+		assert.NoError(t, err)
+		claims, err := signer.Verify(tokenFromLink(link))
+		assert.NoError(t, err)
+		session := fakeSessionRepo.Sessions[claims.SessionID]
+		if pay {
+			resp, err := client.Get(session.ProviderUrl)
+			assert.NoError(t, err)
+			resp.Body.Close()
+		}
+		return session
+	}
+
+	t.Run("success", func(t *testing.T) {
+		session := newCheckout(true)
+		assert.NoError(t, service.HandleWebhook(context.Background(), stripeModel.ID, rec.payload, rec.signature))
+		assert.NotNil(t, session.PaidAt)
+		assert.Equal(t, sim.Events()[len(sim.Events())-1].Data.Object.(simulator.Session).PaymentIntent, session.ProviderPaymentID)
+		assert.Equal(t, "user", fakeEntitlements.Granted[session.ID])
+		assert.Equal(t, stripeModel.Name, fakeLedger.Payments[session.ID])
+		assert.Contains(t, fakeInvoices.Sessions, session.ID)
+
+		// redelivered event is safe
+		paidAt := *session.PaidAt
+		assert.NoError(t, service.HandleWebhook(context.Background(), stripeModel.ID, rec.payload, rec.signature))
+		assert.Equal(t, paidAt, *session.PaidAt)
+	})
+
+	t.Run("success retried after ledger failure", func(t *testing.T) {
+		session := newCheckout(true)
+		fakeLedger.Err = errors.New("connection refused")
+		err := service.HandleWebhook(context.Background(), stripeModel.ID, rec.payload, rec.signature)
+		assert.ErrorIs(t, err, ErrUnexpectedResult)
+		assert.NotNil(t, session.PaidAt)
+		assert.NotContains(t, fakeEntitlements.Granted, session.ID)
+
+		// the provider redelivers the failed event
+		fakeLedger.Err = nil
+		assert.NoError(t, service.HandleWebhook(context.Background(), stripeModel.ID, rec.payload, rec.signature))
+		assert.Equal(t, stripeModel.Name, fakeLedger.Payments[session.ID])
+		assert.Equal(t, "user", fakeEntitlements.Granted[session.ID])
+		assert.Contains(t, fakeInvoices.Sessions, session.ID)
+	})
+
+	t.Run("success refund", func(t *testing.T) {
+		newCheckout(true)
+		intent := sim.Events()[len(sim.Events())-1].Data.Object.(simulator.Session).PaymentIntent
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/v1/refunds", strings.NewReader(url.Values{"payment_intent": {intent}, "amount": {"300"}}.Encode()))
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+stripeModel.ApiKey)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		refundID := sim.Events()[len(sim.Events())-1].Data.Object.(simulator.Refund).ID
+
+		assert.NoError(t, service.HandleWebhook(context.Background(), stripeModel.ID, rec.payload, rec.signature))
+		assert.Equal(t, int64(300), fakeLedger.Refunds[refundID])
+	})
+
+	t.Run("success other event", func(t *testing.T) {
+		other := []byte(`{"id": "evt_1", "type": "customer.created", "data": {"object": {}}}`)
+		assert.NoError(t, service.HandleWebhook(context.Background(), stripeModel.ID, other, simulator.Sign(stripeModel.Secret, other, time.Now())))
+	})
+
+	t.Run("fail return url of unpaid checkout", func(t *testing.T) {
+		session := newCheckout(false)
+		_, err := service.Capture(context.Background(), session.ID, session.ProviderSessionID)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.Nil(t, session.PaidAt)
+		assert.NotContains(t, fakeEntitlements.Granted, session.ID)
+	})
+
+	t.Run("fail forged signature", func(t *testing.T) {
+		session := newCheckout(true)
+		err := service.HandleWebhook(context.Background(), stripeModel.ID, rec.payload, simulator.Sign("forged", rec.payload, time.Now()))
+		assert.ErrorIs(t, err, ErrSignatureInvalid)
+		assert.Nil(t, session.PaidAt)
+	})
+
+	t.Run("fail another provider", func(t *testing.T) {
+		session := newCheckout(true)
+		payPalModel := fakeProviderRepo.Providers[2]
+		err := service.HandleWebhook(context.Background(), payPalModel.ID, rec.payload, rec.signature)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.Nil(t, session.PaidAt)
+	})
+}
+
+func TestPaymentServiceCustomers(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	fakeProviderRepo := FakeProviderRepo{}
+	fakeProviderRepo.Setup()
+	stripeModel := fakeProviderRepo.Providers[3]
+	sim := simulator.NewServer(mockLogger, simulator.WithApiKey(stripeModel.ApiKey))
+	srv := httptest.NewServer(sim)
+	defer srv.Close()
+
+	paymentProvider := payment.NewPaymentProvider(mockLogger, "../../../assets/providers.json", payment.WithStripe(payment.NewStripe(mockLogger, payment.StripeConfig{
+		BaseUrl:    srv.URL,
+		SuccessUrl: "https://merchant.test/success",
+		Items:      []payment.LineItem{{Name: "Premium", Amount: 1299, Currency: "usd"}},
+	})))
+	fakeCustomerRepo := &FakeCustomerRepo{Customers: map[string]*models.Customer{}}
+	service := NewPaymentService(mockLogger, paymentProvider, nil, &fakeProviderRepo, NewFakeSessionRepo(), tokens.NewSigner("secret"),
+		WithCustomers(fakeCustomerRepo),
+	)
+
+	t.Run("success returning user reuses provider customer", func(t *testing.T) {
+		_, err := service.PaymentUrl(context.Background(), stripeModel.ID, Payer{UserID: "user", Authenticated: true, Email: "reader@headway.test", Country: "pl"}, Purchase{})
+		assert.NoError(t, err)
+		customer := fakeCustomerRepo.Customers["user"]
+		assert.Equal(t, "PL", customer.Country)
+		stripeID := customer.ProviderIDs[models.ProviderNameStripe]
+		assert.NotEmpty(t, stripeID)
+
+		_, err = service.PaymentUrl(context.Background(), stripeModel.ID, Payer{UserID: "user", Authenticated: true}, Purchase{})
+		assert.NoError(t, err)
+		assert.Equal(t, stripeID, fakeCustomerRepo.Customers["user"].ProviderIDs[models.ProviderNameStripe])
+		assert.Len(t, sim.Customers(), 1)
+		assert.Equal(t, "reader@headway.test", sim.Customers()[0].Email)
+
+		// email of the request doesn't replace the stored one
+		_, err = service.PaymentUrl(context.Background(), stripeModel.ID, Payer{UserID: "user", Authenticated: true, Email: "new@headway.test"}, Purchase{})
+		assert.NoError(t, err)
+		assert.Equal(t, "reader@headway.test", fakeCustomerRepo.Customers["user"].Email)
+	})
+
+	t.Run("success unauthenticated user doesn't get stored customer", func(t *testing.T) {
+		_, err := service.PaymentUrl(context.Background(), stripeModel.ID, Payer{UserID: "user", Email: "attacker@headway.test"}, Purchase{})
+		assert.NoError(t, err)
+		assert.Equal(t, "reader@headway.test", fakeCustomerRepo.Customers["user"].Email)
+		// the checkout gets a provider-side customer of its own
+		emails := []string{}
+		for _, c := range sim.Customers() {
+			emails = append(emails, c.Email)
+		}
+		assert.ElementsMatch(t, []string{"reader@headway.test", "attacker@headway.test"}, emails)
+	})
+
+	t.Run("success anonymous payer keeps no customer", func(t *testing.T) {
+		_, err := service.PaymentUrl(context.Background(), stripeModel.ID, Payer{Email: "anonymous@headway.test"}, Purchase{})
+		assert.NoError(t, err)
+		assert.Len(t, fakeCustomerRepo.Customers, 1)
+	})
+
+	type testCase struct {
+		name  string
+		payer Payer
+	}
+	testCases := []testCase{
+		{"fail invalid email", Payer{UserID: "user", Email: "not an email"}},
+		{"fail invalid country", Payer{UserID: "user", Country: "Poland"}},
+		{"fail too long user id", Payer{UserID: strings.Repeat("u", 65)}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			url, err := service.PaymentUrl(context.Background(), stripeModel.ID, tc.payer, Purchase{})
+			assert.ErrorIs(t, err, ErrCustomerInvalid)
+			assert.Empty(t, url)
+		})
+	}
+}
+
+func TestPaymentServiceLocalizedPrice(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	fakeProviderRepo := FakeProviderRepo{}
+	fakeProviderRepo.Setup()
+	stripeModel := fakeProviderRepo.Providers[3]
+	sim := simulator.NewServer(mockLogger, simulator.WithApiKey(stripeModel.ApiKey))
+	srv := httptest.NewServer(sim)
+	defer srv.Close()
+
+	paymentProvider := payment.NewPaymentProvider(mockLogger, "../../../assets/providers.json", payment.WithStripe(payment.NewStripe(mockLogger, payment.StripeConfig{
+		BaseUrl:    srv.URL,
+		SuccessUrl: "https://merchant.test/success",
+		Items:      []payment.LineItem{{Name: "Premium", Amount: 1299, Currency: "usd"}},
+	})))
+	fakeSessionRepo := NewFakeSessionRepo()
+	service := NewPaymentService(mockLogger, paymentProvider, nil, &fakeProviderRepo, fakeSessionRepo, tokens.NewSigner("secret"),
+		WithBaseUrl("https://pay.test"),
+		WithPrice(1299, "usd"),
+		WithPricing(&FakePricer{}),
+	)
+
+	type testCase struct {
+		name      string
+		country   string
+		ipCountry string
+		expected  money.Money
+	}
+	testCases := []testCase{
+		{name: "local currency", country: "PL", expected: money.Money{Amount: 5199, Currency: "pln"}},
+		{name: "base currency", country: "US", expected: money.Money{Amount: 1299, Currency: "usd"}},
+		{name: "detected country", ipCountry: "PL", expected: money.Money{Amount: 5199, Currency: "pln"}},
+		{name: "detected country wins over the parameter", country: "PL", ipCountry: "US", expected: money.Money{Amount: 1299, Currency: "usd"}},
+		{name: "unknown detected country", country: "PL", ipCountry: "XX", expected: money.Money{Amount: 5199, Currency: "pln"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			link, err := service.PaymentUrl(context.Background(), stripeModel.ID, Payer{Country: tc.country, IPCountry: tc.ipCountry}, Purchase{})
+			assert.NoError(t, err)
+			claims, err := tokens.NewSigner("secret").Verify(tokenFromLink(link))
+			assert.NoError(t, err)
+			session := fakeSessionRepo.Sessions[claims.SessionID]
+			assert.Equal(t, tc.expected, money.Money{Amount: session.Amount, Currency: session.Currency})
+		})
+	}
+}
+
+func TestPaymentServicePromoCode(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	fakeProviderRepo := FakeProviderRepo{}
+	fakeProviderRepo.Setup()
+	stripeModel := fakeProviderRepo.Providers[3]
+	sim := simulator.NewServer(mockLogger, simulator.WithApiKey(stripeModel.ApiKey))
+	srv := httptest.NewServer(sim)
+	defer srv.Close()
+
+	paymentProvider := payment.NewPaymentProvider(mockLogger, "../../../assets/providers.json", payment.WithStripe(payment.NewStripe(mockLogger, payment.StripeConfig{
+		BaseUrl:    srv.URL,
+		SuccessUrl: "https://merchant.test/success",
+		Items:      []payment.LineItem{{Name: "Premium", Amount: 1299, Currency: "usd"}},
+	})))
+	fakeSessionRepo := NewFakeSessionRepo()
+	fakePromotions := &FakePromotions{Redeemed: map[string]bool{}}
+	service := NewPaymentService(mockLogger, paymentProvider, nil, &fakeProviderRepo, fakeSessionRepo, tokens.NewSigner("secret"),
+		WithBaseUrl("https://pay.test"),
+		WithPrice(1299, "usd"),
+		WithPromotions(fakePromotions),
+		WithProductID("premium"),
+	)
+
+	t.Run("discounted price", func(t *testing.T) {
+		link, err := service.PaymentUrl(context.Background(), stripeModel.ID, Payer{UserID: "user"}, Purchase{PromoCode: "SAVE10"})
+		assert.NoError(t, err)
+		claims, err := tokens.NewSigner("secret").Verify(tokenFromLink(link))
+		assert.NoError(t, err)
+		session := fakeSessionRepo.Sessions[claims.SessionID]
+		assert.Equal(t, money.Money{Amount: 1170, Currency: "usd"}, money.Money{Amount: session.Amount, Currency: session.Currency})
+		assert.True(t, fakePromotions.Redeemed[claims.SessionID])
+	})
+
+	t.Run("not applicable code", func(t *testing.T) {
+		link, err := service.PaymentUrl(context.Background(), stripeModel.ID, Payer{UserID: "user"}, Purchase{PromoCode: "UNKNOWN"})
+		assert.ErrorIs(t, err, ErrPromoInvalid)
+		assert.Empty(t, link)
+	})
+
+	t.Run("redemption released on provider failure", func(t *testing.T) {
+		invalidModel := fakeProviderRepo.Providers[4]
+		redeemed := len(fakePromotions.Redeemed)
+		_, err := service.PaymentUrl(context.Background(), invalidModel.ID, Payer{UserID: "user"}, Purchase{PromoCode: "SAVE10"})
+		assert.ErrorIs(t, err, ErrProvider)
+		assert.Len(t, fakePromotions.Redeemed, redeemed)
+	})
+
+	t.Run("redemption released on signing failure", func(t *testing.T) {
+		service := NewPaymentService(mockLogger, paymentProvider, nil, &fakeProviderRepo, fakeSessionRepo, failingSigner{},
+			WithPrice(1299, "usd"),
+			WithPromotions(fakePromotions),
+			WithProductID("premium"),
+		)
+		redeemed := len(fakePromotions.Redeemed)
+		_, err := service.PaymentUrl(context.Background(), stripeModel.ID, Payer{UserID: "user"}, Purchase{PromoCode: "SAVE10"})
+		assert.ErrorIs(t, err, ErrUnexpectedResult)
+		assert.Len(t, fakePromotions.Redeemed, redeemed)
+	})
+
+	t.Run("promotions are not configured", func(t *testing.T) {
+		service := NewPaymentService(mockLogger, paymentProvider, nil, &fakeProviderRepo, fakeSessionRepo, tokens.NewSigner("secret"),
+			WithPrice(1299, "usd"),
+		)
+		_, err := service.PaymentUrl(context.Background(), stripeModel.ID, Payer{}, Purchase{PromoCode: "SAVE10"})
+		assert.ErrorIs(t, err, ErrPromoInvalid)
+	})
+}
+
+func TestPaymentServiceSubscription(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	fakeProviderRepo := FakeProviderRepo{}
+	fakeProviderRepo.Setup()
+	stripeModel := fakeProviderRepo.Providers[3]
+	rec := &webhookRecorder{}
+	webhook := httptest.NewServer(rec)
+	defer webhook.Close()
+	sim := simulator.NewServer(mockLogger, simulator.WithApiKey(stripeModel.ApiKey), simulator.WithWebhook(webhook.URL, stripeModel.Secret))
+	srv := httptest.NewServer(sim)
+	defer srv.Close()
+
+	paymentProvider := payment.NewPaymentProvider(mockLogger, "../../../assets/providers.json", payment.WithStripe(payment.NewStripe(mockLogger, payment.StripeConfig{
+		BaseUrl:    srv.URL,
+		SuccessUrl: "https://merchant.test/success",
+		Items:      []payment.LineItem{{Name: "Premium", Amount: 1299, Currency: "usd"}},
+	})))
+	fakeSessionRepo := NewFakeSessionRepo()
+	fakeEntitlements := &FakeEntitlements{Granted: map[string]string{}}
+	fakeSubscriptions := &FakeSubscriptions{Started: map[string]*models.PaymentSession{}, Renewed: map[string]time.Time{}}
+	fakeLedger := &FakeLedger{Payments: map[string]string{}}
+	fakeInvoices := &FakeInvoices{Sessions: map[string]*models.PaymentSession{}}
+	service := NewPaymentService(mockLogger, paymentProvider, nil, &fakeProviderRepo, fakeSessionRepo, tokens.NewSigner("secret"),
+		WithBaseUrl("https://pay.test"),
+		WithPrice(499, "eur"),
+		WithEntitlements(fakeEntitlements),
+		WithSubscriptions(fakeSubscriptions),
+		WithLedger(fakeLedger),
+		WithInvoices(fakeInvoices),
+	)
+	client := http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	type testCase struct {
+		name      string
+		userID    string
+		planID    string
+		due       money.Money
+		trialDays int
+	}
+	testCases := []testCase{
+		{name: "trial", userID: "user", planID: "monthly", due: money.Money{Amount: 0, Currency: "usd"}, trialDays: 7},
+		{name: "trial is used", userID: "trialed", planID: "monthly", due: money.Money{Amount: 1299, Currency: "usd"}},
+		{name: "intro price", userID: "user", planID: "yearly", due: money.Money{Amount: 5999, Currency: "usd"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			link, err := service.PaymentUrl(context.Background(), stripeModel.ID, Payer{UserID: tc.userID, Authenticated: true}, Purchase{PlanID: tc.planID})
+			assert.NoError(t, err)
+			claims, err := tokens.NewSigner("secret").Verify(tokenFromLink(link))
+			assert.NoError(t, err)
+			session := fakeSessionRepo.Sessions[claims.SessionID]
+			assert.Equal(t, tc.due, money.Money{Amount: session.Amount, Currency: session.Currency})
+			assert.Equal(t, tc.planID, session.PlanID)
+			assert.Equal(t, tc.trialDays, session.TrialDays)
+
+			// subscription is started instead of the one-time access
+			resp, err := client.Get(session.ProviderUrl)
+			assert.NoError(t, err)
+			resp.Body.Close()
+			assert.NoError(t, service.HandleWebhook(context.Background(), stripeModel.ID, rec.payload, rec.signature))
+			assert.Equal(t, session, fakeSubscriptions.Started[session.ID])
+			assert.Empty(t, fakeEntitlements.Granted[session.ID])
+
+			// the subscription is extended only by the paid invoice of the next period
+			assert.Empty(t, fakeSubscriptions.Renewed[session.ID])
+			events := sim.Events()
+			invoice, err := sim.RenewSubscription(events[len(events)-1].Data.Object.(simulator.Session).Subscription)
+			assert.NoError(t, err)
+			assert.NoError(t, service.HandleWebhook(context.Background(), stripeModel.ID, rec.payload, rec.signature))
+			assert.Equal(t, time.Unix(invoice.Lines.Data[0].Period.End, 0).UTC(), fakeSubscriptions.Renewed[session.ID])
+
+			// the renewal is posted and invoiced by the provider invoice, nothing is posted for the trial
+			assert.Equal(t, tc.trialDays == 0, fakeLedger.Payments[session.ID] != "")
+			assert.Greater(t, invoice.AmountPaid, int64(0))
+			assert.Equal(t, ledger.Movement{Reference: invoice.ID, Provider: stripeModel.Name, Amount: invoice.AmountPaid,
+				Currency: invoice.Currency}, fakeLedger.Renewals[invoice.ID])
+			renewed := fakeInvoices.Renewals[invoice.ID]
+			if assert.NotNil(t, renewed) {
+				assert.Equal(t, session.ID, renewed.ID)
+				assert.Equal(t, money.Money{Amount: invoice.AmountPaid, Currency: invoice.Currency},
+					money.Money{Amount: renewed.Amount, Currency: renewed.Currency})
+			}
+		})
+	}
+
+	t.Run("fail renewal before the subscription starts", func(t *testing.T) {
+		link, err := service.PaymentUrl(context.Background(), stripeModel.ID, Payer{UserID: "user", Authenticated: true}, Purchase{PlanID: "yearly"})
+		assert.NoError(t, err)
+		claims, err := tokens.NewSigner("secret").Verify(tokenFromLink(link))
+		assert.NoError(t, err)
+		resp, err := client.Get(fakeSessionRepo.Sessions[claims.SessionID].ProviderUrl)
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		// the completion event is lost, the provider redelivers the renewal until the subscription is started
+		events := sim.Events()
+		invoice, err := sim.RenewSubscription(events[len(events)-1].Data.Object.(simulator.Session).Subscription)
+		assert.NoError(t, err)
+		err = service.HandleWebhook(context.Background(), stripeModel.ID, rec.payload, rec.signature)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.Empty(t, fakeSubscriptions.Renewed[claims.SessionID])
+		assert.Empty(t, fakeInvoices.Renewals[invoice.ID])
+	})
+
+	t.Run("fail unknown plan", func(t *testing.T) {
+		_, err := service.PaymentUrl(context.Background(), stripeModel.ID, Payer{UserID: "user", Authenticated: true}, Purchase{PlanID: "weekly"})
+		assert.ErrorIs(t, err, ErrPlanNotFound)
+	})
+
+	t.Run("fail anonymous payer", func(t *testing.T) {
+		_, err := service.PaymentUrl(context.Background(), stripeModel.ID, Payer{}, Purchase{PlanID: "monthly"})
+		assert.ErrorIs(t, err, ErrUserRequired)
+	})
+
+	t.Run("fail unauthenticated user", func(t *testing.T) {
+		// userID parameter alone would let anyone take the trial of the user
+		_, err := service.PaymentUrl(context.Background(), stripeModel.ID, Payer{UserID: "user"}, Purchase{PlanID: "monthly"})
+		assert.ErrorIs(t, err, ErrUserRequired)
+	})
+}
+
+func TestPaymentServiceExperiment(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	fakeProviderRepo := FakeProviderRepo{}
+	fakeProviderRepo.Setup()
+	stripeModel := fakeProviderRepo.Providers[3]
+	invalidModel := fakeProviderRepo.Providers[4]
+	sim := simulator.NewServer(mockLogger, simulator.WithApiKey(stripeModel.ApiKey))
+	srv := httptest.NewServer(sim)
+	defer srv.Close()
+
+	paymentProvider := payment.NewPaymentProvider(mockLogger, "../../../assets/providers.json", payment.WithStripe(payment.NewStripe(mockLogger, payment.StripeConfig{
+		BaseUrl:    srv.URL,
+		SuccessUrl: "https://merchant.test/success",
+		Items:      []payment.LineItem{{Name: "Premium", Amount: 1299, Currency: "usd"}},
+	})))
+	fakeSessionRepo := NewFakeSessionRepo()
+	fakeExperiments := &FakeExperiments{
+		Variants: map[string]models.ExperimentVariant{
+			"control":  {Key: "control", Weight: 1},
+			"cheaper":  {Key: "cheaper", Weight: 1, Amount: 999, Currency: "usd"},
+			"provider": {Key: "provider", Weight: 1, ProviderID: stripeModel.ID},
+		},
+		Exposures: map[string]*models.ExperimentExposure{},
+	}
+	service := NewPaymentService(mockLogger, paymentProvider, nil, &fakeProviderRepo, fakeSessionRepo, tokens.NewSigner("secret"),
+		WithBaseUrl("https://pay.test"),
+		WithPrice(1299, "usd"),
+		WithPricing(&FakePricer{}),
+		WithExperiments(fakeExperiments),
+	)
+
+	type testCase struct {
+		name          string
+		userID        string
+		authenticated bool
+		country       string
+		experiment    string
+		expected      money.Money
+		variant       string
+	}
+	testCases := []testCase{
+		{name: "control variant", userID: "control", authenticated: true, country: "PL", experiment: "paywall", expected: money.Money{Amount: 5199, Currency: "pln"}, variant: "control"},
+		{name: "price variant skips localization", userID: "cheaper", authenticated: true, country: "PL", experiment: "paywall", expected: money.Money{Amount: 999, Currency: "usd"}, variant: "cheaper"},
+		{name: "anonymous payer", country: "US", experiment: "paywall", expected: money.Money{Amount: 1299, Currency: "usd"}},
+		{name: "unauthenticated user gets the default price", userID: "cheaper", country: "US", experiment: "paywall", expected: money.Money{Amount: 1299, Currency: "usd"}},
+		{name: "unknown experiment", userID: "cheaper", authenticated: true, country: "US", experiment: "unknown", expected: money.Money{Amount: 1299, Currency: "usd"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			payer := Payer{UserID: tc.userID, Authenticated: tc.authenticated, Country: tc.country}
+			link, err := service.PaymentUrl(context.Background(), stripeModel.ID, payer, Purchase{Experiment: tc.experiment})
+			assert.NoError(t, err)
+			claims, err := tokens.NewSigner("secret").Verify(tokenFromLink(link))
+			assert.NoError(t, err)
+			session := fakeSessionRepo.Sessions[claims.SessionID]
+			assert.Equal(t, tc.expected, money.Money{Amount: session.Amount, Currency: session.Currency})
+			exposure := fakeExperiments.Exposures[session.ID]
+			if tc.variant == "" {
+				assert.Nil(t, exposure)
+				return
+			}
+			assert.Equal(t, &models.ExperimentExposure{ExperimentID: "experiment", VariantKey: tc.variant, UserID: tc.userID, SessionID: session.ID}, exposure)
+		})
+	}
+
+	t.Run("provider variant", func(t *testing.T) {
+		link, err := service.PaymentUrl(context.Background(), invalidModel.ID, Payer{UserID: "provider", Authenticated: true}, Purchase{Experiment: "paywall"})
+		assert.NoError(t, err)
+		claims, err := tokens.NewSigner("secret").Verify(tokenFromLink(link))
+		assert.NoError(t, err)
+		assert.Equal(t, stripeModel.ID, fakeSessionRepo.Sessions[claims.SessionID].ProviderID)
+	})
+}
+
+func TestPaymentServiceKillSwitch(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	fakeProviderRepo := FakeProviderRepo{}
+	fakeProviderRepo.Setup()
+	stripeModel := fakeProviderRepo.Providers[3]
+	applePayModel := fakeProviderRepo.Providers[0]
+	sim := simulator.NewServer(mockLogger, simulator.WithApiKey(stripeModel.ApiKey))
+	srv := httptest.NewServer(sim)
+	defer srv.Close()
+
+	paymentProvider := payment.NewPaymentProvider(mockLogger, "../../../assets/providers.json", payment.WithStripe(payment.NewStripe(mockLogger, payment.StripeConfig{
+		BaseUrl:    srv.URL,
+		SuccessUrl: "https://merchant.test/success",
+		Items:      []payment.LineItem{{Name: "Premium", Amount: 1299, Currency: "usd"}},
+	})))
+	fakeSessionRepo := NewFakeSessionRepo()
+	fakeFlags := &FakeFlags{Countries: map[string][]string{
+		flags.ProviderKillSwitch(stripeModel.Name):   {"PL"},
+		flags.ProviderKillSwitch(applePayModel.Name): {},
+	}}
+	service := NewPaymentService(mockLogger, paymentProvider, nil, &fakeProviderRepo, fakeSessionRepo, tokens.NewSigner("secret"),
+		WithBaseUrl("https://pay.test"),
+		WithFlags(fakeFlags),
+	)
+
+	type testCase struct {
+		name       string
+		providerID string
+		country    string
+		err        error
+	}
+	testCases := []testCase{
+		{name: "disabled provider", providerID: applePayModel.ID, country: "US", err: ErrProvider},
+		{name: "disabled in the country", providerID: stripeModel.ID, country: "PL", err: ErrProvider},
+		{name: "enabled in other countries", providerID: stripeModel.ID, country: "US"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sessions := len(fakeSessionRepo.Sessions)
+			link, err := service.PaymentUrl(context.Background(), tc.providerID, Payer{Country: tc.country}, Purchase{})
+			assert.ErrorIs(t, err, tc.err)
+			if tc.err != nil {
+				assert.Empty(t, link)
+				assert.Len(t, fakeSessionRepo.Sessions, sessions)
+				return
+			}
+			assert.NotEmpty(t, link)
+		})
+	}
+}
+
+func TestPaymentServiceTax(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	fakeProviderRepo := FakeProviderRepo{}
+	fakeProviderRepo.Setup()
+	stripeModel := fakeProviderRepo.Providers[3]
+	sim := simulator.NewServer(mockLogger, simulator.WithApiKey(stripeModel.ApiKey))
+	srv := httptest.NewServer(sim)
+	defer srv.Close()
+
+	paymentProvider := payment.NewPaymentProvider(mockLogger, "../../../assets/providers.json", payment.WithStripe(payment.NewStripe(mockLogger, payment.StripeConfig{
+		BaseUrl:    srv.URL,
+		SuccessUrl: "https://merchant.test/success",
+		Items:      []payment.LineItem{{Name: "Premium", Amount: 1200, Currency: "usd"}},
+	})))
+	fakeSessionRepo := NewFakeSessionRepo()
+	service := NewPaymentService(mockLogger, paymentProvider, nil, &fakeProviderRepo, fakeSessionRepo, tokens.NewSigner("secret"),
+		WithBaseUrl("https://pay.test"),
+		WithPrice(1200, "usd"),
+		WithSubscriptions(&FakeSubscriptions{Started: map[string]*models.PaymentSession{}}),
+		WithTax(&FakeTax{}),
+	)
+
+	type testCase struct {
+		name      string
+		userID    string
+		country   string
+		ipCountry string
+		region    string
+		planID    string
+		due       money.Money
+		taxAmount int64
+		taxed     bool
+	}
+	testCases := []testCase{
+		{name: "exclusive tax", country: "CA", due: money.Money{Amount: 1320, Currency: "usd"}, taxAmount: 120, taxed: true},
+		{name: "inclusive tax", country: "DE", due: money.Money{Amount: 1200, Currency: "usd"}, taxAmount: 200, taxed: true},
+		{name: "untaxed country", country: "US", due: money.Money{Amount: 1200, Currency: "usd"}, taxed: true},
+		{name: "intro price", userID: "user", country: "CA", planID: "yearly", due: money.Money{Amount: 6598, Currency: "usd"}, taxAmount: 599, taxed: true},
+		{name: "trial", userID: "user", country: "CA", planID: "monthly", due: money.Money{Amount: 0, Currency: "usd"}},
+		{name: "detected country", ipCountry: "DE", due: money.Money{Amount: 1200, Currency: "usd"}, taxAmount: 200, taxed: true},
+		{name: "detected country wins over the parameter", country: "US", ipCountry: "CA", due: money.Money{Amount: 1320, Currency: "usd"}, taxAmount: 120, taxed: true},
+		{name: "region of another country", country: "CA", region: "QC", ipCountry: "US", due: money.Money{Amount: 1200, Currency: "usd"}, taxed: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			link, err := service.PaymentUrl(context.Background(), stripeModel.ID, Payer{UserID: tc.userID, Authenticated: true, Country: tc.country, Region: tc.region, IPCountry: tc.ipCountry}, Purchase{PlanID: tc.planID})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.due, link.Amount)
+			claims, err := tokens.NewSigner("secret").Verify(tokenFromLink(link))
+			assert.NoError(t, err)
+			session := fakeSessionRepo.Sessions[claims.SessionID]
+			if tc.ipCountry != "" {
+				assert.Equal(t, tc.ipCountry, session.Country)
+			}
+			assert.Equal(t, tc.due, money.Money{Amount: session.Amount, Currency: session.Currency})
+			assert.Equal(t, tc.taxAmount, session.TaxAmount)
+			if !tc.taxed {
+				assert.Nil(t, link.Tax)
+				assert.Empty(t, session.Taxes)
+				return
+			}
+			assert.Equal(t, tc.due, link.Tax.Total)
+			assert.Equal(t, tc.taxAmount, link.Tax.Amount())
+			assert.Equal(t, link.Tax.Lines, session.Taxes)
+		})
+	}
+
+	// intro discount is taken off the taxed price, so the intro period is charged with its own tax
+	assert.Len(t, sim.Coupons(), 1)
+	assert.Equal(t, int64(9898-6598), sim.Coupons()[0].AmountOff)
+
+	t.Run("fail region without country", func(t *testing.T) {
+		_, err := service.PaymentUrl(context.Background(), stripeModel.ID, Payer{Region: "QC"}, Purchase{})
+		assert.ErrorIs(t, err, ErrCustomerInvalid)
+	})
+}
+
+func TestPaymentServiceRisk(t *testing.T) {
+	mockLogger := zap.NewNop().Sugar()
+	fakeProviderRepo := FakeProviderRepo{}
+	fakeProviderRepo.Setup()
+	stripeModel := fakeProviderRepo.Providers[3]
+	sim := simulator.NewServer(mockLogger, simulator.WithApiKey(stripeModel.ApiKey))
+	srv := httptest.NewServer(sim)
+	defer srv.Close()
+
+	paymentProvider := payment.NewPaymentProvider(mockLogger, "../../../assets/providers.json", payment.WithStripe(payment.NewStripe(mockLogger, payment.StripeConfig{
+		BaseUrl:    srv.URL,
+		SuccessUrl: "https://merchant.test/success",
+		Items:      []payment.LineItem{{Name: "Premium", Amount: 1299, Currency: "usd"}},
+	})))
+	fakeSessionRepo := NewFakeSessionRepo()
+	fakeRisk := &FakeRisk{}
+	service := NewPaymentService(mockLogger, paymentProvider, nil, &fakeProviderRepo, fakeSessionRepo, tokens.NewSigner("secret"),
+		WithBaseUrl("https://pay.test"),
+		WithRisk(fakeRisk),
+	)
+	client := http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	type testCase struct {
+		name      string
+		email     string
+		challenge bool
+		err       error
+	}
+	testCases := []testCase{
+		{name: "allowed", email: "allow@headway.test"},
+		{name: "challenged with 3-D Secure", email: "challenge@headway.test", challenge: true},
+		{name: "screening failure issues the link", email: "error@headway.test"},
+		{name: "denied", email: "deny@headway.test", err: ErrPaymentDenied},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			payer := Payer{Email: tc.email, Country: "PL", IP: "203.0.113.7", DeviceID: "device", IPCountry: "DE"}
+			link, err := service.PaymentUrl(context.Background(), stripeModel.ID, payer, Purchase{})
+			attempt := fakeRisk.Attempts[len(fakeRisk.Attempts)-1]
+			assert.Equal(t, risk.Attempt{SessionID: attempt.SessionID, Email: tc.email, IP: "203.0.113.7", DeviceID: "device", Country: "PL", IPCountry: "DE"}, attempt)
+			assert.ErrorIs(t, err, tc.err)
+			if tc.err != nil {
+				assert.Nil(t, link)
+				assert.NotContains(t, fakeSessionRepo.Sessions, attempt.SessionID)
+				return
+			}
+			session := fakeSessionRepo.Sessions[attempt.SessionID]
+			resp, err := client.Get(session.ProviderUrl)
+			assert.NoError(t, err)
+			resp.Body.Close()
+			events := sim.Events()
+			created := events[len(events)-1].Data.Object.(simulator.Session)
+			assert.Equal(t, tc.challenge, created.PaymentMethodOptions != nil)
+		})
+	}
+
+	t.Run("fail device id too long", func(t *testing.T) {
+		attempts := len(fakeRisk.Attempts)
+		_, err := service.PaymentUrl(context.Background(), stripeModel.ID, Payer{DeviceID: strings.Repeat("d", 129)}, Purchase{})
+		assert.ErrorIs(t, err, ErrCustomerInvalid)
+		assert.Len(t, fakeRisk.Attempts, attempts)
+	})
 }
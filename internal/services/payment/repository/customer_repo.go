@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+type CustomerRepo struct {
+	log  *zap.SugaredLogger
+	conn *sql.DB
+}
+
+func NewCustomerRepo(log *zap.SugaredLogger, conn *sql.DB) *CustomerRepo {
+	return &CustomerRepo{log: log, conn: conn}
+}
+
+// Upsert stores the customer of the user or updates the existing one, the stored email is never
+// replaced and empty country doesn't override the stored one. Provider ids of the customer are loaded
+func (r *CustomerRepo) Upsert(ctx context.Context, c *models.Customer) error {
+	stmnt := `INSERT INTO customers (id, user_id, email, country)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''))
+		ON CONFLICT (user_id) DO UPDATE SET
+			email = COALESCE(customers.email, EXCLUDED.email),
+			country = COALESCE(EXCLUDED.country, customers.country),
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING id, COALESCE(email, ''), COALESCE(country, ''), created_at, updated_at`
+	err := r.conn.QueryRowContext(ctx, stmnt, c.ID, c.UserID, c.Email, c.Country).
+		Scan(&c.ID, &c.Email, &c.Country, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		r.log.Errorw("failed to upsert customer",
+			"userID", c.UserID,
+			"error", err)
+		return err
+	}
+
+	rows, err := r.conn.QueryContext(ctx, "SELECT provider_name, external_id FROM customer_provider_ids WHERE customer_id = $1", c.ID)
+	if err != nil {
+		r.log.Errorw("failed to fetch provider ids of customer",
+			"id", c.ID,
+			"error", err)
+		return err
+	}
+	defer rows.Close()
+	c.ProviderIDs = map[string]string{}
+	for rows.Next() {
+		var name, externalID string
+		if err := rows.Scan(&name, &externalID); err != nil {
+			return err
+		}
+		c.ProviderIDs[name] = externalID
+	}
+	return rows.Err()
+}
+
+// SaveProviderID stores id of the customer on the provider side, replacing the previous one
+func (r *CustomerRepo) SaveProviderID(ctx context.Context, customerID, providerName, externalID string) error {
+	stmnt := `INSERT INTO customer_provider_ids (customer_id, provider_name, external_id) VALUES ($1, $2, $3)
+		ON CONFLICT (customer_id, provider_name) DO UPDATE SET external_id = EXCLUDED.external_id`
+	if _, err := r.conn.ExecContext(ctx, stmnt, customerID, providerName, externalID); err != nil {
+		r.log.Errorw("failed to save provider id of customer",
+			"id", customerID,
+			"provider", providerName,
+			"error", err)
+		return err
+	}
+	return nil
+}
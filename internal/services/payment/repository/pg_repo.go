@@ -6,6 +6,7 @@ import (
 	"payment-api/internal/models"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
 )
 
@@ -42,3 +43,37 @@ func (r *Providerrepo) FetchByID(id string) (*models.Provider, error) {
 	}
 	return &provider, nil
 }
+
+// FetchByIDs fetches providers records by ids with a single query, unknown and malformed ids are skipped
+func (r *Providerrepo) FetchByIDs(ids []string) ([]models.Provider, error) {
+	valid := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, err := uuid.Parse(id); err == nil {
+			valid = append(valid, id)
+		}
+	}
+	providers := []models.Provider{}
+	if len(valid) == 0 {
+		return providers, nil
+	}
+
+	stmnt := "SELECT id, name, api_key, secret FROM providers WHERE id = ANY($1::uuid[])"
+	rows, err := r.conn.Query(stmnt, pq.Array(valid))
+	if err != nil {
+		r.log.Errorw("failed to fetch providers by IDs",
+			"ids", valid,
+			"error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		provider := models.Provider{}
+		if err := rows.Scan(&provider.ID, &provider.Name, &provider.ApiKey, &provider.Secret); err != nil {
+			r.log.Errorf("failed to scan provider, error: %v", err)
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+	return providers, rows.Err()
+}
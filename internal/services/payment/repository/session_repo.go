@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+type SessionRepo struct {
+	log  *zap.SugaredLogger
+	conn *sql.DB
+}
+
+func NewSessionRepo(log *zap.SugaredLogger, conn *sql.DB) *SessionRepo {
+	return &SessionRepo{log: log, conn: conn}
+}
+
+// Create stores a new payment session
+func (r *SessionRepo) Create(ctx context.Context, s *models.PaymentSession) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		r.log.Errorf("failed to begin payment session transaction, error: %v", err)
+		return err
+	}
+	// rollback is no-op after commit
+	defer func() { _ = tx.Rollback() }()
+
+	stmnt := `INSERT INTO payment_sessions (id, provider_id, provider_url, provider_session_id, user_id, email, country,
+		amount, currency, tax_amount, plan_id, trial_days, expires_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), $8, $9, $10, NULLIF($11, ''), $12, $13)`
+	if _, err := tx.ExecContext(ctx, stmnt, s.ID, s.ProviderID, s.ProviderUrl, s.ProviderSessionID, s.UserID, s.Email, s.Country,
+		s.Amount, s.Currency, s.TaxAmount, s.PlanID, s.TrialDays, s.ExpiresAt); err != nil {
+		r.log.Errorw("failed to create payment session",
+			"id", s.ID,
+			"error", err)
+		return err
+	}
+	stmnt = `INSERT INTO payment_session_taxes (session_id, position, name, rate, inclusive, amount, currency)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	for i, t := range s.Taxes {
+		if _, err := tx.ExecContext(ctx, stmnt, s.ID, i, t.Name, t.Rate, t.Inclusive, t.Amount, t.Currency); err != nil {
+			r.log.Errorw("failed to create payment session tax",
+				"id", s.ID,
+				"tax", t.Name,
+				"error", err)
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		r.log.Errorf("failed to commit payment session %v, error: %v", s.ID, err)
+		return err
+	}
+	return nil
+}
+
+// FetchByID fetches single payment session by id
+func (r *SessionRepo) FetchByID(ctx context.Context, id string) (*models.PaymentSession, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrUuidInvalidFormat
+	}
+
+	stmnt := `SELECT id, provider_id, provider_url, COALESCE(provider_session_id, ''), COALESCE(user_id, ''),
+		COALESCE(email, ''), COALESCE(country, ''), amount, currency, tax_amount, COALESCE(plan_id, ''), trial_days,
+		expires_at, revoked_at, paid_at, visits, created_at
+		FROM payment_sessions WHERE id = $1`
+	row := r.conn.QueryRowContext(ctx, stmnt, id)
+
+	s := models.PaymentSession{}
+	if err := row.Scan(&s.ID, &s.ProviderID, &s.ProviderUrl, &s.ProviderSessionID, &s.UserID, &s.Email, &s.Country,
+		&s.Amount, &s.Currency, &s.TaxAmount, &s.PlanID, &s.TrialDays, &s.ExpiresAt, &s.RevokedAt, &s.PaidAt, &s.Visits, &s.CreatedAt); err != nil {
+		r.log.Errorw("failed to fetch payment session by ID",
+			"id", id,
+			"error", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if s.TaxAmount == 0 {
+		return &s, nil
+	}
+
+	stmnt = `SELECT name, rate, inclusive, amount, currency FROM payment_session_taxes
+		WHERE session_id = $1 ORDER BY position`
+	rows, err := r.conn.QueryContext(ctx, stmnt, id)
+	if err != nil {
+		r.log.Errorw("failed to fetch payment session taxes",
+			"id", id,
+			"error", err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t := models.TaxLine{}
+		if err := rows.Scan(&t.Name, &t.Rate, &t.Inclusive, &t.Amount, &t.Currency); err != nil {
+			r.log.Errorw("failed to scan payment session tax",
+				"id", id,
+				"error", err)
+			return nil, err
+		}
+		s.Taxes = append(s.Taxes, t)
+	}
+	if err := rows.Err(); err != nil {
+		r.log.Errorw("failed to fetch payment session taxes",
+			"id", id,
+			"error", err)
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Revoke marks session as revoked, so its url can't be used anymore
+func (r *SessionRepo) Revoke(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrUuidInvalidFormat
+	}
+	stmnt := "UPDATE payment_sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL"
+	res, err := r.conn.ExecContext(ctx, stmnt, id)
+	if err != nil {
+		r.log.Errorw("failed to revoke payment session",
+			"id", id,
+			"error", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// MarkPaid records the time the payment of the session is captured together with id of the payment
+// on the provider side, empty paymentID is not recorded. Both are kept on repeated calls
+func (r *SessionRepo) MarkPaid(ctx context.Context, id, paymentID string) (time.Time, error) {
+	stmnt := `UPDATE payment_sessions SET paid_at = COALESCE(paid_at, CURRENT_TIMESTAMP),
+		provider_payment_id = COALESCE(provider_payment_id, NULLIF($2, '')) WHERE id = $1 RETURNING paid_at`
+	var paidAt time.Time
+	if err := r.conn.QueryRowContext(ctx, stmnt, id, paymentID).Scan(&paidAt); err != nil {
+		r.log.Errorw("failed to mark payment session paid",
+			"id", id,
+			"error", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, ErrNotFound
+		}
+		return time.Time{}, err
+	}
+	return paidAt, nil
+}
+
+// TrackVisit increments the number of times session url was opened
+func (r *SessionRepo) TrackVisit(ctx context.Context, id string) error {
+	stmnt := "UPDATE payment_sessions SET visits = visits + 1 WHERE id = $1"
+	if _, err := r.conn.ExecContext(ctx, stmnt, id); err != nil {
+		r.log.Errorw("failed to track payment session visit",
+			"id", id,
+			"error", err)
+		return err
+	}
+	return nil
+}
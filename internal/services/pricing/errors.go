@@ -0,0 +1,9 @@
+package pricing
+
+import "errors"
+
+var (
+	ErrCurrencyInvalid  = errors.New("currency is invalid")
+	ErrRateInvalid      = errors.New("rate is invalid")
+	ErrUnexpectedResult = errors.New("unexpected error")
+)
@@ -0,0 +1,125 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/locale"
+	"payment-api/internal/models"
+	"payment-api/internal/services/pricing"
+)
+
+type Pricing interface {
+	Quote(ctx context.Context, country string) (*pricing.Quote, error)
+	SetRates(ctx context.Context, base string, rates map[string]string) error
+	Rates(ctx context.Context) ([]models.FxRate, error)
+}
+
+type Handler struct {
+	log        *zap.SugaredLogger
+	pricingSvc Pricing
+	// countryHeader is set by CDN with the country of the client
+	countryHeader string
+}
+
+func NewHandler(log *zap.SugaredLogger, pricingSvc Pricing, countryHeader string) *Handler {
+	return &Handler{log: log, pricingSvc: pricingSvc, countryHeader: countryHeader}
+}
+
+// Price endpoint returns the price localized for the client, the country detected by the CDN is charged,
+// so optional `country` is used only when the country is not detected
+func (h *Handler) Price() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+			return
+		}
+		country := locale.Detected(r, h.countryHeader)
+		if country == "" {
+			country = r.URL.Query().Get("country")
+		}
+		if country == "" {
+			country = locale.FromRequest(r, h.countryHeader).Region
+		}
+
+		quote, err := h.pricingSvc.Quote(r.Context(), country)
+		if err != nil {
+			h.log.Errorf("failed to quote price")
+			writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": map[string]any{
+			"amount":        quote.Price.Amount,
+			"currency":      quote.Price.Currency,
+			"formatted":     quote.Price.String(),
+			"base_amount":   quote.Base.Amount,
+			"base_currency": quote.Base.Currency,
+			"rate":          quote.Rate,
+		}})
+	}
+}
+
+// Rates endpoint lists exchange rates on GET and replaces rates of the base currency on PUT
+// with {"base": "usd", "rates": {"eur": "0.92"}} body
+func (h *Handler) Rates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			rates, err := h.pricingSvc.Rates(r.Context())
+			if err != nil {
+				h.log.Errorf("failed to list fx rates")
+				writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+				return
+			}
+			items := make([]map[string]any, 0, len(rates))
+			for _, rate := range rates {
+				items = append(items, map[string]any{
+					"base":       rate.Base,
+					"quote":      rate.Quote,
+					"rate":       rate.Rate,
+					"updated_at": rate.UpdatedAt,
+				})
+			}
+			writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": items})
+		case http.MethodPut:
+			var body pricing.RatesFile
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Rates) == 0 {
+				writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Provided parameter has bad format"})
+				return
+			}
+			if err := h.pricingSvc.SetRates(r.Context(), body.Base, body.Rates); err != nil {
+				h.log.Errorf("failed to set fx rates")
+				switch {
+				case errors.Is(err, pricing.ErrCurrencyInvalid), errors.Is(err, pricing.ErrRateInvalid):
+					writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Provided parameter has bad format"})
+				default:
+					writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+				}
+				return
+			}
+			writeJson(w, http.StatusNoContent, nil)
+		default:
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+		}
+	}
+}
+
+// writeJson peforms write to the response writer and header as json content-type
+func writeJson(w http.ResponseWriter, status int, data map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if data == nil {
+		return
+	}
+
+	enc, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	_, _ = w.Write(enc)
+}
@@ -0,0 +1,198 @@
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/money"
+	"payment-api/internal/services/pricing/repository"
+)
+
+// Repository for exchange rates
+type FxRateRepo interface {
+	Upsert(ctx context.Context, rates []models.FxRate) error
+	Fetch(ctx context.Context, base, quote string) (*models.FxRate, error)
+	List(ctx context.Context) ([]models.FxRate, error)
+}
+
+// currencyPrice is the price in a single currency, amount fixes the price, otherwise it is converted from the base one
+type currencyPrice struct {
+	Amount   int64          `json:"amount"`
+	Rounding money.Rounding `json:"rounding"`
+}
+
+// priceList lists currencies the product is sold in besides the base one
+type priceList struct {
+	// Rounding of converted prices, rounding of the currency takes precedence
+	Rounding   money.Rounding           `json:"rounding"`
+	Currencies map[string]currencyPrice `json:"currencies"`
+}
+
+// Quote is the price presented and charged to the customer
+type Quote struct {
+	Price money.Money
+	Base  money.Money
+	// Rate the base price was converted with, empty for fixed prices
+	Rate string
+}
+
+type PricingService struct {
+	log        *zap.SugaredLogger
+	fxRateRepo FxRateRepo
+	base       money.Money
+	filePath   string
+}
+
+func NewPricingService(log *zap.SugaredLogger, fxRateRepo FxRateRepo, base money.Money, filePath string) *PricingService {
+	return &PricingService{log: log, fxRateRepo: fxRateRepo, base: base, filePath: filePath}
+}
+
+// Quote returns the price in the currency of the country, the base price is quoted
+// for unknown countries and currencies missing in the price list or the rate table
+func (s *PricingService) Quote(ctx context.Context, country string) (*Quote, error) {
+	quote := &Quote{Price: s.base, Base: s.base}
+	currency, ok := money.CurrencyOf(country)
+	if !ok || currency == s.base.Currency {
+		return quote, nil
+	}
+	// broken price list must not stop the sales, the base price is charged instead
+	list, err := s.load()
+	if err != nil {
+		s.log.Errorf("failed to load price list %v, error: %v", s.filePath, err)
+		return quote, nil
+	}
+	price, ok := list.Currencies[currency]
+	if !ok {
+		return quote, nil
+	}
+	if price.Amount > 0 {
+		quote.Price = money.Money{Amount: price.Amount, Currency: currency}
+		return quote, nil
+	}
+
+	rate, err := s.fxRateRepo.Fetch(ctx, s.base.Currency, currency)
+	if errors.Is(err, repository.ErrNotFound) {
+		s.log.Errorf("fx rate of %v to %v is missing, base price is quoted", s.base.Currency, currency)
+		return quote, nil
+	}
+	if err != nil {
+		s.log.Errorf("failed to fetch fx rate of %v to %v, error: %v", s.base.Currency, currency, err)
+		return nil, ErrUnexpectedResult
+	}
+	r, ok := new(big.Rat).SetString(rate.Rate)
+	if !ok {
+		s.log.Errorf("fx rate of %v to %v is malformed: %v", s.base.Currency, currency, rate.Rate)
+		return nil, ErrUnexpectedResult
+	}
+	rounding := price.Rounding
+	if rounding == "" {
+		rounding = list.Rounding
+	}
+	if rounding == "" {
+		rounding = money.RoundHalfUp
+	}
+	converted, err := money.Convert(s.base, currency, r, rounding)
+	if err != nil {
+		s.log.Errorf("failed to convert %v to %v, error: %v", s.base, currency, err)
+		return nil, ErrUnexpectedResult
+	}
+	quote.Price = converted
+	quote.Rate = rate.Rate
+	return quote, nil
+}
+
+// Price returns the price charged in the country
+func (s *PricingService) Price(ctx context.Context, country string) (money.Money, error) {
+	quote, err := s.Quote(ctx, country)
+	if err != nil {
+		return money.Money{}, err
+	}
+	return quote.Price, nil
+}
+
+// SetRates replaces rates of the base currency to the quote ones, the rates are decimal numbers
+// of quote units per base unit and either all of them are stored or none
+func (s *PricingService) SetRates(ctx context.Context, base string, rates map[string]string) error {
+	base = strings.ToLower(base)
+	if !money.Known(base) {
+		return ErrCurrencyInvalid
+	}
+	fxRates := make([]models.FxRate, 0, len(rates))
+	for quote, rate := range rates {
+		quote = strings.ToLower(quote)
+		if !money.Known(quote) || quote == base {
+			return ErrCurrencyInvalid
+		}
+		r, ok := new(big.Rat).SetString(rate)
+		if !ok || r.Sign() <= 0 {
+			return ErrRateInvalid
+		}
+		fxRates = append(fxRates, models.FxRate{Base: base, Quote: quote, Rate: rate})
+	}
+	if err := s.fxRateRepo.Upsert(ctx, fxRates); err != nil {
+		s.log.Errorf("failed to store fx rates of %v, error: %v", base, err)
+		return ErrUnexpectedResult
+	}
+	return nil
+}
+
+// Rates returns every stored rate
+func (s *PricingService) Rates(ctx context.Context) ([]models.FxRate, error) {
+	rates, err := s.fxRateRepo.List(ctx)
+	if err != nil {
+		s.log.Errorf("failed to list fx rates, error: %v", err)
+		return nil, ErrUnexpectedResult
+	}
+	return rates, nil
+}
+
+// RatesFile is the format of the rates file and the rates API body
+type RatesFile struct {
+	Base  string            `json:"base"`
+	Rates map[string]string `json:"rates"`
+}
+
+// LoadRates stores rates of the file
+func (s *PricingService) LoadRates(ctx context.Context, path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		s.log.Errorf("failed to read fx rates file %v, error: %v", path, err)
+		return err
+	}
+	var f RatesFile
+	if err := json.Unmarshal(raw, &f); err != nil {
+		s.log.Errorf("failed to decode fx rates file %v, error: %v", path, err)
+		return ErrRateInvalid
+	}
+	return s.SetRates(ctx, f.Base, f.Rates)
+}
+
+func (s *PricingService) load() (*priceList, error) {
+	raw, err := os.ReadFile(s.filePath)
+	if err != nil {
+		return nil, err
+	}
+	var list priceList
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, err
+	}
+	if list.Rounding != "" && !list.Rounding.Valid() {
+		return nil, money.ErrUnknownRounding
+	}
+	currencies := make(map[string]currencyPrice, len(list.Currencies))
+	for currency, price := range list.Currencies {
+		if price.Rounding != "" && !price.Rounding.Valid() {
+			return nil, money.ErrUnknownRounding
+		}
+		currencies[strings.ToLower(currency)] = price
+	}
+	list.Currencies = currencies
+	return &list, nil
+}
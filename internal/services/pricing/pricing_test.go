@@ -0,0 +1,149 @@
+package pricing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/money"
+	"payment-api/internal/services/pricing/repository"
+)
+
+// FakeFxRateRepo keeps rates in memory by currency pair
+type FakeFxRateRepo struct {
+	Rates map[string]models.FxRate
+	Err   error
+}
+
+func (m *FakeFxRateRepo) Upsert(ctx context.Context, rates []models.FxRate) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	for _, rate := range rates {
+		m.Rates[rate.Base+rate.Quote] = rate
+	}
+	return nil
+}
+
+func (m *FakeFxRateRepo) Fetch(ctx context.Context, base, quote string) (*models.FxRate, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	rate, ok := m.Rates[base+quote]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &rate, nil
+}
+
+func (m *FakeFxRateRepo) List(ctx context.Context) ([]models.FxRate, error) {
+	rates := []models.FxRate{}
+	for _, rate := range m.Rates {
+		rates = append(rates, rate)
+	}
+	return rates, m.Err
+}
+
+func writePriceList(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "prices.json")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestPricingServiceQuote(t *testing.T) {
+	path := writePriceList(t, `{
+		"rounding": "charm",
+		"currencies": {
+			"eur": {"amount": 1199},
+			"pln": {},
+			"jpy": {"rounding": "whole"},
+			"gbp": {}
+		}
+	}`)
+	base := money.Money{Amount: 1299, Currency: "usd"}
+	repo := &FakeFxRateRepo{Rates: map[string]models.FxRate{
+		"usdpln": {Base: "usd", Quote: "pln", Rate: "3.9871"},
+		"usdjpy": {Base: "usd", Quote: "jpy", Rate: "149.32"},
+	}}
+	service := NewPricingService(zap.NewNop().Sugar(), repo, base, path)
+
+	type testCase struct {
+		name     string
+		country  string
+		expected money.Money
+		rate     string
+	}
+	testCases := []testCase{
+		{name: "fixed price", country: "DE", expected: money.Money{Amount: 1199, Currency: "eur"}},
+		{name: "converted with default rounding", country: "PL", expected: money.Money{Amount: 5199, Currency: "pln"}, rate: "3.9871"},
+		{name: "converted with currency rounding", country: "JP", expected: money.Money{Amount: 1940, Currency: "jpy"}, rate: "149.32"},
+		{name: "missing rate", country: "GB", expected: base},
+		{name: "currency out of price list", country: "UA", expected: base},
+		{name: "base currency", country: "US", expected: base},
+		{name: "unknown country", country: "", expected: base},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			quote, err := service.Quote(context.Background(), tc.country)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, quote.Price)
+			assert.Equal(t, base, quote.Base)
+			assert.Equal(t, tc.rate, quote.Rate)
+		})
+	}
+
+	t.Run("broken price list quotes base price", func(t *testing.T) {
+		service := NewPricingService(zap.NewNop().Sugar(), repo, base, writePriceList(t, `{"rounding": "floor"}`))
+		price, err := service.Price(context.Background(), "PL")
+		assert.NoError(t, err)
+		assert.Equal(t, base, price)
+	})
+
+	t.Run("fail rates unavailable", func(t *testing.T) {
+		service := NewPricingService(zap.NewNop().Sugar(), &FakeFxRateRepo{Err: errors.New("db is down")}, base, path)
+		_, err := service.Price(context.Background(), "PL")
+		assert.ErrorIs(t, err, ErrUnexpectedResult)
+	})
+}
+
+func TestPricingServiceSetRates(t *testing.T) {
+	repo := &FakeFxRateRepo{Rates: map[string]models.FxRate{}}
+	service := NewPricingService(zap.NewNop().Sugar(), repo, money.Money{Amount: 1299, Currency: "usd"}, "")
+
+	assert.NoError(t, service.SetRates(context.Background(), "USD", map[string]string{"EUR": "0.92", "pln": "3.9871"}))
+	assert.Equal(t, models.FxRate{Base: "usd", Quote: "eur", Rate: "0.92"}, repo.Rates["usdeur"])
+	assert.Len(t, repo.Rates, 2)
+
+	type testCase struct {
+		name  string
+		base  string
+		rates map[string]string
+		err   error
+	}
+	testCases := []testCase{
+		{name: "unknown base", base: "xxx", rates: map[string]string{"eur": "0.92"}, err: ErrCurrencyInvalid},
+		{name: "unknown quote", base: "usd", rates: map[string]string{"xxx": "0.92"}, err: ErrCurrencyInvalid},
+		{name: "same currency", base: "usd", rates: map[string]string{"usd": "1"}, err: ErrCurrencyInvalid},
+		{name: "negative rate", base: "usd", rates: map[string]string{"eur": "-0.92"}, err: ErrRateInvalid},
+		{name: "not a number", base: "usd", rates: map[string]string{"eur": "ninety"}, err: ErrRateInvalid},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.ErrorIs(t, service.SetRates(context.Background(), tc.base, tc.rates), tc.err)
+			assert.Len(t, repo.Rates, 2)
+		})
+	}
+
+	t.Run("success rates file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "rates.json")
+		assert.NoError(t, os.WriteFile(path, []byte(`{"base": "usd", "rates": {"gbp": "0.79"}}`), 0o600))
+		assert.NoError(t, service.LoadRates(context.Background(), path))
+		assert.Equal(t, "0.79", repo.Rates["usdgbp"].Rate)
+	})
+}
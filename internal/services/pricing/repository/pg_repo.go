@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+type FxRateRepo struct {
+	log  *zap.SugaredLogger
+	conn *sql.DB
+}
+
+func NewFxRateRepo(log *zap.SugaredLogger, conn *sql.DB) *FxRateRepo {
+	return &FxRateRepo{log: log, conn: conn}
+}
+
+// Upsert stores the rates in a single transaction, existing rates of the same pairs are replaced
+func (r *FxRateRepo) Upsert(ctx context.Context, rates []models.FxRate) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		r.log.Errorf("failed to begin fx rates transaction, error: %v", err)
+		return err
+	}
+	// rollback is no-op after commit
+	defer func() { _ = tx.Rollback() }()
+
+	stmnt := `INSERT INTO fx_rates (base, quote, rate) VALUES ($1, $2, $3)
+		ON CONFLICT (base, quote) DO UPDATE SET rate = EXCLUDED.rate, updated_at = CURRENT_TIMESTAMP`
+	for _, rate := range rates {
+		if _, err := tx.ExecContext(ctx, stmnt, rate.Base, rate.Quote, rate.Rate); err != nil {
+			r.log.Errorw("failed to upsert fx rate",
+				"base", rate.Base,
+				"quote", rate.Quote,
+				"error", err)
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		r.log.Errorf("failed to commit fx rates, error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Fetch fetches the rate of the currency pair
+func (r *FxRateRepo) Fetch(ctx context.Context, base, quote string) (*models.FxRate, error) {
+	stmnt := "SELECT base, quote, rate::text, updated_at FROM fx_rates WHERE base = $1 AND quote = $2"
+	rate := models.FxRate{}
+	if err := r.conn.QueryRowContext(ctx, stmnt, base, quote).Scan(&rate.Base, &rate.Quote, &rate.Rate, &rate.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		r.log.Errorw("failed to fetch fx rate",
+			"base", base,
+			"quote", quote,
+			"error", err)
+		return nil, err
+	}
+	return &rate, nil
+}
+
+// List fetches every rate
+func (r *FxRateRepo) List(ctx context.Context) ([]models.FxRate, error) {
+	stmnt := "SELECT base, quote, rate::text, updated_at FROM fx_rates ORDER BY base, quote"
+	rows, err := r.conn.QueryContext(ctx, stmnt)
+	if err != nil {
+		r.log.Errorf("failed to list fx rates, error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []models.FxRate{}
+	for rows.Next() {
+		rate := models.FxRate{}
+		if err := rows.Scan(&rate.Base, &rate.Quote, &rate.Rate, &rate.UpdatedAt); err != nil {
+			r.log.Errorf("failed to scan fx rate, error: %v", err)
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+	return rates, rows.Err()
+}
@@ -0,0 +1,11 @@
+package promotions
+
+import "errors"
+
+var (
+	// ErrNotApplicable is wrapped with the reason the code can't be applied to the checkout
+	ErrNotApplicable    = errors.New("promo code is not applicable")
+	ErrInvalid          = errors.New("promotion is invalid")
+	ErrDuplicate        = errors.New("promotion code is taken")
+	ErrUnexpectedResult = errors.New("unexpected error")
+)
@@ -0,0 +1,131 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/services/promotions"
+)
+
+type Promotions interface {
+	Create(ctx context.Context, p *models.Promotion) error
+	Promotions(ctx context.Context) ([]models.Promotion, error)
+}
+
+type Handler struct {
+	log           *zap.SugaredLogger
+	promotionsSvc Promotions
+}
+
+func NewHandler(log *zap.SugaredLogger, promotionsSvc Promotions) *Handler {
+	return &Handler{log: log, promotionsSvc: promotionsSvc}
+}
+
+// promotionBody is the promotion as it is created and listed
+type promotionBody struct {
+	Code             string     `json:"code"`
+	Kind             string     `json:"kind"`
+	PercentOff       int        `json:"percent_off,omitempty"`
+	AmountOff        int64      `json:"amount_off,omitempty"`
+	Currency         string     `json:"currency,omitempty"`
+	ProductIDs       []string   `json:"product_ids"`
+	StartsAt         *time.Time `json:"starts_at"`
+	EndsAt           *time.Time `json:"ends_at"`
+	MaxRedemptions   int        `json:"max_redemptions"`
+	PerCustomerLimit int        `json:"per_customer_limit"`
+	Redemptions      int        `json:"redemptions"`
+}
+
+// Promotions endpoint lists promotions on GET and creates a new one on POST
+func (h *Handler) Promotions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			list, err := h.promotionsSvc.Promotions(r.Context())
+			if err != nil {
+				h.log.Errorf("failed to list promotions")
+				writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+				return
+			}
+			items := make([]promotionBody, 0, len(list))
+			for i := range list {
+				items = append(items, toBody(&list[i]))
+			}
+			writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": items})
+		case http.MethodPost:
+			var body promotionBody
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Provided parameter has bad format"})
+				return
+			}
+			p := &models.Promotion{
+				Code:             body.Code,
+				Kind:             body.Kind,
+				PercentOff:       body.PercentOff,
+				AmountOff:        body.AmountOff,
+				Currency:         body.Currency,
+				ProductIDs:       body.ProductIDs,
+				StartsAt:         body.StartsAt,
+				EndsAt:           body.EndsAt,
+				MaxRedemptions:   body.MaxRedemptions,
+				PerCustomerLimit: body.PerCustomerLimit,
+			}
+			if p.ProductIDs == nil {
+				p.ProductIDs = []string{}
+			}
+			if err := h.promotionsSvc.Create(r.Context(), p); err != nil {
+				h.log.Errorf("failed to create promotion")
+				switch {
+				case errors.Is(err, promotions.ErrInvalid):
+					writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": err.Error()})
+				case errors.Is(err, promotions.ErrDuplicate):
+					writeJson(w, http.StatusConflict, map[string]any{"code": http.StatusConflict, "message": "Promotion code is taken"})
+				default:
+					writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+				}
+				return
+			}
+			writeJson(w, http.StatusCreated, map[string]any{"code": http.StatusCreated, "data": toBody(p)})
+		default:
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+		}
+	}
+}
+
+func toBody(p *models.Promotion) promotionBody {
+	return promotionBody{
+		Code:             p.Code,
+		Kind:             p.Kind,
+		PercentOff:       p.PercentOff,
+		AmountOff:        p.AmountOff,
+		Currency:         p.Currency,
+		ProductIDs:       p.ProductIDs,
+		StartsAt:         p.StartsAt,
+		EndsAt:           p.EndsAt,
+		MaxRedemptions:   p.MaxRedemptions,
+		PerCustomerLimit: p.PerCustomerLimit,
+		Redemptions:      p.Redemptions,
+	}
+}
+
+// writeJson peforms write to the response writer and header as json content-type
+func writeJson(w http.ResponseWriter, status int, data map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if data == nil {
+		return
+	}
+
+	enc, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	_, _ = w.Write(enc)
+}
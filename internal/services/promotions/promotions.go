@@ -0,0 +1,218 @@
+package promotions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/money"
+	"payment-api/internal/services/promotions/repository"
+)
+
+var codePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,64}$`)
+
+// checkoutLifetime is how long checkouts of the providers stay payable, a link opened right before it expired
+// may still be paid on the provider side, so its redemption is kept that long after the link expires
+const checkoutLifetime = 24 * time.Hour
+
+// Repository for promotions and their redemptions
+type PromotionRepo interface {
+	Create(ctx context.Context, p *models.Promotion) error
+	FetchByCode(ctx context.Context, code string) (*models.Promotion, error)
+	List(ctx context.Context) ([]models.Promotion, error)
+	Redeem(ctx context.Context, redemption *models.PromoRedemption) error
+	Release(ctx context.Context, sessionID string) error
+	ReleaseExpired(ctx context.Context, before time.Time) (int, error)
+}
+
+type PromotionsService struct {
+	log           *zap.SugaredLogger
+	promotionRepo PromotionRepo
+	now           func() time.Time
+}
+
+func NewPromotionsService(log *zap.SugaredLogger, promotionRepo PromotionRepo) *PromotionsService {
+	return &PromotionsService{log: log, promotionRepo: promotionRepo, now: time.Now}
+}
+
+// Create validates and stores a new promotion
+func (s *PromotionsService) Create(ctx context.Context, p *models.Promotion) error {
+	p.Code = NormalizeCode(p.Code)
+	p.Currency = strings.ToLower(p.Currency)
+	if err := validate(p); err != nil {
+		return err
+	}
+	p.ID = uuid.NewString()
+	p.Redemptions = 0
+	if err := s.promotionRepo.Create(ctx, p); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			return ErrDuplicate
+		}
+		s.log.Errorf("failed to create promotion %v, error: %v", p.Code, err)
+		return ErrUnexpectedResult
+	}
+	return nil
+}
+
+// Promotions returns every promotion
+func (s *PromotionsService) Promotions(ctx context.Context) ([]models.Promotion, error) {
+	promotions, err := s.promotionRepo.List(ctx)
+	if err != nil {
+		s.log.Errorf("failed to list promotions, error: %v", err)
+		return nil, ErrUnexpectedResult
+	}
+	return promotions, nil
+}
+
+// Redeem applies the code to the price of the product bought with the session and counts the redemption,
+// the discounted price is returned. The code can't be applied when it is unknown, out of its validity window,
+// not eligible for the product or its limits are used up
+func (s *PromotionsService) Redeem(ctx context.Context, code, sessionID, userID, productID string, price money.Money) (money.Money, error) {
+	p, err := s.promotionRepo.FetchByCode(ctx, NormalizeCode(code))
+	if errors.Is(err, repository.ErrNotFound) {
+		return money.Money{}, fmt.Errorf("%w: unknown code", ErrNotApplicable)
+	}
+	if err != nil {
+		s.log.Errorf("failed to fetch promotion %v, error: %v", code, err)
+		return money.Money{}, ErrUnexpectedResult
+	}
+
+	now := s.now()
+	switch {
+	case p.StartsAt != nil && now.Before(*p.StartsAt):
+		return money.Money{}, fmt.Errorf("%w: not started yet", ErrNotApplicable)
+	case p.EndsAt != nil && !now.Before(*p.EndsAt):
+		return money.Money{}, fmt.Errorf("%w: expired", ErrNotApplicable)
+	case !eligible(p, productID):
+		return money.Money{}, fmt.Errorf("%w: product is not eligible", ErrNotApplicable)
+	// usage of anonymous payers can't be limited
+	case p.PerCustomerLimit > 0 && userID == "":
+		return money.Money{}, fmt.Errorf("%w: customer is required", ErrNotApplicable)
+	}
+	discount, err := Discount(p, price)
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	redemption := &models.PromoRedemption{
+		SessionID:   sessionID,
+		PromotionID: p.ID,
+		UserID:      userID,
+		Discount:    discount.Amount,
+		Currency:    discount.Currency,
+	}
+	if err := s.promotionRepo.Redeem(ctx, redemption); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrExhausted), errors.Is(err, repository.ErrLimitReached):
+			return money.Money{}, fmt.Errorf("%w: %v", ErrNotApplicable, err)
+		default:
+			s.log.Errorf("failed to redeem promotion %v for session %v, error: %v", p.Code, sessionID, err)
+			return money.Money{}, ErrUnexpectedResult
+		}
+	}
+	return price.Sub(discount)
+}
+
+// Release takes back the redemption of the session, e.g. when its checkout could not be created
+func (s *PromotionsService) Release(ctx context.Context, sessionID string) error {
+	if err := s.promotionRepo.Release(ctx, sessionID); err != nil {
+		s.log.Errorf("failed to release redemption of session %v, error: %v", sessionID, err)
+		return ErrUnexpectedResult
+	}
+	return nil
+}
+
+// ReleaseExpired takes back redemptions of the sessions which were not paid before their links expired
+// or were revoked, once their checkouts can't be paid anymore. The number of released redemptions is returned
+func (s *PromotionsService) ReleaseExpired(ctx context.Context) (int, error) {
+	released, err := s.promotionRepo.ReleaseExpired(ctx, s.now().Add(-checkoutLifetime))
+	if err != nil {
+		s.log.Errorf("failed to release expired redemptions, error: %v", err)
+		return 0, ErrUnexpectedResult
+	}
+	return released, nil
+}
+
+// Run releases expired redemptions on start and then every interval until the context is done
+func (s *PromotionsService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, _ = s.ReleaseExpired(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Discount returns the amount the promotion takes off the price, percent discounts are rounded half up
+// to minor units. Fixed discounts apply to prices of their currency only and none of them makes the price free
+func Discount(p *models.Promotion, price money.Money) (money.Money, error) {
+	var amount int64
+	switch p.Kind {
+	case models.PromotionPercent:
+		amount = (price.Amount*int64(p.PercentOff) + 50) / 100
+	case models.PromotionFixed:
+		if p.Currency != price.Currency {
+			return money.Money{}, fmt.Errorf("%w: price is in another currency", ErrNotApplicable)
+		}
+		amount = p.AmountOff
+	default:
+		return money.Money{}, fmt.Errorf("%w: unknown kind %v", ErrNotApplicable, p.Kind)
+	}
+	if amount >= price.Amount {
+		return money.Money{}, fmt.Errorf("%w: discount exceeds the price", ErrNotApplicable)
+	}
+	return money.Money{Amount: amount, Currency: price.Currency}, nil
+}
+
+// NormalizeCode makes the code case-insensitive
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func eligible(p *models.Promotion, productID string) bool {
+	if len(p.ProductIDs) == 0 {
+		return true
+	}
+	for _, id := range p.ProductIDs {
+		if id == productID {
+			return true
+		}
+	}
+	return false
+}
+
+func validate(p *models.Promotion) error {
+	if !codePattern.MatchString(p.Code) {
+		return fmt.Errorf("%w: code must be 3 to 64 letters, digits, dashes or underscores", ErrInvalid)
+	}
+	switch p.Kind {
+	case models.PromotionPercent:
+		if p.PercentOff < 1 || p.PercentOff > 99 || p.AmountOff != 0 {
+			return fmt.Errorf("%w: percent_off must be from 1 to 99", ErrInvalid)
+		}
+	case models.PromotionFixed:
+		if p.AmountOff <= 0 || !money.Known(p.Currency) || p.PercentOff != 0 {
+			return fmt.Errorf("%w: amount_off and currency are required", ErrInvalid)
+		}
+	default:
+		return fmt.Errorf("%w: kind must be percent or fixed", ErrInvalid)
+	}
+	if p.StartsAt != nil && p.EndsAt != nil && !p.StartsAt.Before(*p.EndsAt) {
+		return fmt.Errorf("%w: starts_at must be before ends_at", ErrInvalid)
+	}
+	if p.MaxRedemptions < 0 || p.PerCustomerLimit < 0 {
+		return fmt.Errorf("%w: limits can't be negative", ErrInvalid)
+	}
+	return nil
+}
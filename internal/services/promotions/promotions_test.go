@@ -0,0 +1,232 @@
+package promotions
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/money"
+	"payment-api/internal/services/promotions/repository"
+)
+
+// FakePromotionRepo keeps promotions in memory by code and redemptions by session,
+// Unpaid holds expiry of the unpaid sessions
+type FakePromotionRepo struct {
+	Promotions  map[string]*models.Promotion
+	Redemptions map[string]*models.PromoRedemption
+	Unpaid      map[string]time.Time
+}
+
+func NewFakePromotionRepo() *FakePromotionRepo {
+	return &FakePromotionRepo{Promotions: map[string]*models.Promotion{}, Redemptions: map[string]*models.PromoRedemption{}, Unpaid: map[string]time.Time{}}
+}
+
+func (m *FakePromotionRepo) Create(ctx context.Context, p *models.Promotion) error {
+	if _, ok := m.Promotions[p.Code]; ok {
+		return repository.ErrDuplicate
+	}
+	m.Promotions[p.Code] = p
+	return nil
+}
+
+func (m *FakePromotionRepo) FetchByCode(ctx context.Context, code string) (*models.Promotion, error) {
+	p, ok := m.Promotions[code]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return p, nil
+}
+
+func (m *FakePromotionRepo) List(ctx context.Context) ([]models.Promotion, error) {
+	list := []models.Promotion{}
+	for _, p := range m.Promotions {
+		list = append(list, *p)
+	}
+	return list, nil
+}
+
+func (m *FakePromotionRepo) Redeem(ctx context.Context, r *models.PromoRedemption) error {
+	var p *models.Promotion
+	for _, promotion := range m.Promotions {
+		if promotion.ID == r.PromotionID {
+			p = promotion
+		}
+	}
+	if p.MaxRedemptions > 0 && p.Redemptions >= p.MaxRedemptions {
+		return repository.ErrExhausted
+	}
+	used := 0
+	for _, redemption := range m.Redemptions {
+		if redemption.PromotionID == p.ID && redemption.UserID == r.UserID {
+			used++
+		}
+	}
+	if p.PerCustomerLimit > 0 && used >= p.PerCustomerLimit {
+		return repository.ErrLimitReached
+	}
+	p.Redemptions++
+	m.Redemptions[r.SessionID] = r
+	return nil
+}
+
+func (m *FakePromotionRepo) Release(ctx context.Context, sessionID string) error {
+	r, ok := m.Redemptions[sessionID]
+	if !ok {
+		return nil
+	}
+	delete(m.Redemptions, sessionID)
+	for _, p := range m.Promotions {
+		if p.ID == r.PromotionID {
+			p.Redemptions--
+		}
+	}
+	return nil
+}
+
+func (m *FakePromotionRepo) ReleaseExpired(ctx context.Context, before time.Time) (int, error) {
+	released := 0
+	for sessionID, expiresAt := range m.Unpaid {
+		if _, ok := m.Redemptions[sessionID]; ok && expiresAt.Before(before) {
+			_ = m.Release(ctx, sessionID)
+			released++
+		}
+	}
+	return released, nil
+}
+
+func TestPromotionsServiceCreate(t *testing.T) {
+	service := NewPromotionsService(zap.NewNop().Sugar(), NewFakePromotionRepo())
+	now := time.Now()
+	later := now.Add(time.Hour)
+
+	type testCase struct {
+		name      string
+		promotion models.Promotion
+		expected  error
+	}
+	testCases := []testCase{
+		{name: "percent", promotion: models.Promotion{Code: "spring-20", Kind: models.PromotionPercent, PercentOff: 20}},
+		{name: "fixed", promotion: models.Promotion{Code: "MINUS5", Kind: models.PromotionFixed, AmountOff: 500, Currency: "USD"}},
+		{name: "duplicate", promotion: models.Promotion{Code: "Spring-20", Kind: models.PromotionPercent, PercentOff: 10}, expected: ErrDuplicate},
+		{name: "short code", promotion: models.Promotion{Code: "AB", Kind: models.PromotionPercent, PercentOff: 10}, expected: ErrInvalid},
+		{name: "code with spaces", promotion: models.Promotion{Code: "SPRING SALE", Kind: models.PromotionPercent, PercentOff: 10}, expected: ErrInvalid},
+		{name: "full discount", promotion: models.Promotion{Code: "FREE", Kind: models.PromotionPercent, PercentOff: 100}, expected: ErrInvalid},
+		{name: "fixed without currency", promotion: models.Promotion{Code: "MINUS1", Kind: models.PromotionFixed, AmountOff: 100}, expected: ErrInvalid},
+		{name: "unknown kind", promotion: models.Promotion{Code: "GIFT", Kind: "gift"}, expected: ErrInvalid},
+		{name: "inverted window", promotion: models.Promotion{Code: "LATE", Kind: models.PromotionPercent, PercentOff: 10, StartsAt: &later, EndsAt: &now}, expected: ErrInvalid},
+		{name: "negative limit", promotion: models.Promotion{Code: "LIMIT", Kind: models.PromotionPercent, PercentOff: 10, MaxRedemptions: -1}, expected: ErrInvalid},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := tc.promotion
+			err := service.Create(context.Background(), &p)
+			assert.ErrorIs(t, err, tc.expected)
+			if tc.expected == nil {
+				assert.NotEmpty(t, p.ID)
+				assert.Equal(t, NormalizeCode(tc.promotion.Code), p.Code)
+			}
+		})
+	}
+}
+
+func TestPromotionsServiceRedeem(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	yesterday := now.Add(-24 * time.Hour)
+	tomorrow := now.Add(24 * time.Hour)
+	repo := NewFakePromotionRepo()
+	repo.Promotions = map[string]*models.Promotion{
+		"SAVE20":   {ID: "1", Code: "SAVE20", Kind: models.PromotionPercent, PercentOff: 20},
+		"MINUS5":   {ID: "2", Code: "MINUS5", Kind: models.PromotionFixed, AmountOff: 500, Currency: "usd"},
+		"SOON":     {ID: "3", Code: "SOON", Kind: models.PromotionPercent, PercentOff: 10, StartsAt: &tomorrow},
+		"OVER":     {ID: "4", Code: "OVER", Kind: models.PromotionPercent, PercentOff: 10, EndsAt: &yesterday},
+		"BOOKS":    {ID: "5", Code: "BOOKS", Kind: models.PromotionPercent, PercentOff: 10, ProductIDs: []string{"books"}},
+		"ONCE":     {ID: "6", Code: "ONCE", Kind: models.PromotionPercent, PercentOff: 10, PerCustomerLimit: 1},
+		"FIRST":    {ID: "7", Code: "FIRST", Kind: models.PromotionPercent, PercentOff: 10, MaxRedemptions: 1},
+		"MINUS20":  {ID: "8", Code: "MINUS20", Kind: models.PromotionFixed, AmountOff: 2000, Currency: "usd"},
+		"PREMIUM5": {ID: "9", Code: "PREMIUM5", Kind: models.PromotionPercent, PercentOff: 5, ProductIDs: []string{"premium"}, StartsAt: &yesterday, EndsAt: &tomorrow},
+	}
+	service := NewPromotionsService(zap.NewNop().Sugar(), repo)
+	service.now = func() time.Time { return now }
+	usd := money.Money{Amount: 1299, Currency: "usd"}
+
+	type testCase struct {
+		name     string
+		code     string
+		userID   string
+		price    money.Money
+		expected money.Money
+		err      error
+	}
+	testCases := []testCase{
+		{name: "percent rounded half up", code: "save20", userID: "user", price: usd, expected: money.Money{Amount: 1039, Currency: "usd"}},
+		{name: "fixed", code: "MINUS5", price: usd, expected: money.Money{Amount: 799, Currency: "usd"}},
+		{name: "fixed in another currency", code: "MINUS5", price: money.Money{Amount: 5199, Currency: "pln"}, err: ErrNotApplicable},
+		{name: "discount exceeds the price", code: "MINUS20", price: usd, err: ErrNotApplicable},
+		{name: "unknown code", code: "NOPE", price: usd, err: ErrNotApplicable},
+		{name: "not started", code: "SOON", price: usd, err: ErrNotApplicable},
+		{name: "expired", code: "OVER", price: usd, err: ErrNotApplicable},
+		{name: "product is not eligible", code: "BOOKS", price: usd, err: ErrNotApplicable},
+		{name: "eligible product within window", code: "PREMIUM5", price: usd, expected: money.Money{Amount: 1234, Currency: "usd"}},
+		{name: "anonymous payer of limited code", code: "ONCE", price: usd, err: ErrNotApplicable},
+		{name: "first use per customer", code: "ONCE", userID: "user", price: usd, expected: money.Money{Amount: 1169, Currency: "usd"}},
+		{name: "second use per customer", code: "ONCE", userID: "user", price: usd, err: ErrNotApplicable},
+		{name: "other customer", code: "ONCE", userID: "other", price: usd, expected: money.Money{Amount: 1169, Currency: "usd"}},
+		{name: "first redemption", code: "FIRST", price: usd, expected: money.Money{Amount: 1169, Currency: "usd"}},
+		{name: "redemptions exhausted", code: "FIRST", price: usd, err: ErrNotApplicable},
+	}
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			price, err := service.Redeem(context.Background(), tc.code, string(rune('a'+i)), tc.userID, "premium", tc.price)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.expected, price)
+		})
+	}
+}
+
+func TestPromotionsServiceRelease(t *testing.T) {
+	repo := NewFakePromotionRepo()
+	repo.Promotions["FIRST"] = &models.Promotion{ID: "1", Code: "FIRST", Kind: models.PromotionPercent, PercentOff: 10, MaxRedemptions: 1}
+	service := NewPromotionsService(zap.NewNop().Sugar(), repo)
+	usd := money.Money{Amount: 1000, Currency: "usd"}
+
+	_, err := service.Redeem(context.Background(), "FIRST", "session-1", "", "premium", usd)
+	assert.NoError(t, err)
+	_, err = service.Redeem(context.Background(), "FIRST", "session-2", "", "premium", usd)
+	assert.ErrorIs(t, err, ErrNotApplicable)
+
+	assert.NoError(t, service.Release(context.Background(), "session-1"))
+	price, err := service.Redeem(context.Background(), "FIRST", "session-2", "", "premium", usd)
+	assert.NoError(t, err)
+	assert.Equal(t, money.Money{Amount: 900, Currency: "usd"}, price)
+}
+
+func TestPromotionsServiceReleaseExpired(t *testing.T) {
+	repo := NewFakePromotionRepo()
+	repo.Promotions["FIRST"] = &models.Promotion{ID: "1", Code: "FIRST", Kind: models.PromotionPercent, PercentOff: 10, MaxRedemptions: 2}
+	service := NewPromotionsService(zap.NewNop().Sugar(), repo)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+	usd := money.Money{Amount: 1000, Currency: "usd"}
+
+	_, err := service.Redeem(context.Background(), "FIRST", "abandoned", "", "premium", usd)
+	assert.NoError(t, err)
+	_, err = service.Redeem(context.Background(), "FIRST", "recent", "", "premium", usd)
+	assert.NoError(t, err)
+	repo.Unpaid["abandoned"] = now.Add(-checkoutLifetime - time.Minute)
+	// its checkout may still be paid
+	repo.Unpaid["recent"] = now.Add(-time.Minute)
+
+	released, err := service.ReleaseExpired(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, released)
+	assert.NotContains(t, repo.Redemptions, "abandoned")
+	assert.Contains(t, repo.Redemptions, "recent")
+	assert.Equal(t, 1, repo.Promotions["FIRST"].Redemptions)
+
+	_, err = service.Redeem(context.Background(), "FIRST", "next", "", "premium", usd)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,10 @@
+package repository
+
+import "errors"
+
+var (
+	ErrNotFound     = errors.New("record is not found")
+	ErrDuplicate    = errors.New("promotion code is taken")
+	ErrExhausted    = errors.New("promotion has no redemptions left")
+	ErrLimitReached = errors.New("customer has used up the promotion")
+)
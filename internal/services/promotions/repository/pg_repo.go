@@ -0,0 +1,214 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+const promotionColumns = `id, code, kind, percent_off, amount_off, currency, product_ids, starts_at, ends_at,
+	max_redemptions, per_customer_limit, redemptions, created_at`
+
+type PromotionRepo struct {
+	log  *zap.SugaredLogger
+	conn *sql.DB
+}
+
+func NewPromotionRepo(log *zap.SugaredLogger, conn *sql.DB) *PromotionRepo {
+	return &PromotionRepo{log: log, conn: conn}
+}
+
+// Create stores a new promotion, ErrDuplicate is returned when the code is taken
+func (r *PromotionRepo) Create(ctx context.Context, p *models.Promotion) error {
+	stmnt := `INSERT INTO promotions (id, code, kind, percent_off, amount_off, currency, product_ids, starts_at, ends_at,
+		max_redemptions, per_customer_limit) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (code) DO NOTHING RETURNING created_at`
+	if err := r.conn.QueryRowContext(ctx, stmnt, p.ID, p.Code, p.Kind, p.PercentOff, p.AmountOff, p.Currency,
+		pq.Array(p.ProductIDs), p.StartsAt, p.EndsAt, p.MaxRedemptions, p.PerCustomerLimit).Scan(&p.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDuplicate
+		}
+		r.log.Errorw("failed to create promotion",
+			"code", p.Code,
+			"error", err)
+		return err
+	}
+	return nil
+}
+
+// FetchByCode fetches single promotion by its uppercase code
+func (r *PromotionRepo) FetchByCode(ctx context.Context, code string) (*models.Promotion, error) {
+	stmnt := "SELECT " + promotionColumns + " FROM promotions WHERE code = $1"
+	p, err := scanPromotion(r.conn.QueryRowContext(ctx, stmnt, code))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		r.log.Errorw("failed to fetch promotion by code",
+			"code", code,
+			"error", err)
+		return nil, err
+	}
+	return p, nil
+}
+
+// List fetches every promotion, the latest first
+func (r *PromotionRepo) List(ctx context.Context) ([]models.Promotion, error) {
+	stmnt := "SELECT " + promotionColumns + " FROM promotions ORDER BY created_at DESC"
+	rows, err := r.conn.QueryContext(ctx, stmnt)
+	if err != nil {
+		r.log.Errorf("failed to list promotions, error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	promotions := []models.Promotion{}
+	for rows.Next() {
+		p, err := scanPromotion(rows)
+		if err != nil {
+			r.log.Errorf("failed to scan promotion, error: %v", err)
+			return nil, err
+		}
+		promotions = append(promotions, *p)
+	}
+	return promotions, rows.Err()
+}
+
+// Redeem counts the redemption unless it exceeds limits of the promotion. The promotion row stays locked
+// until commit, so concurrent redemptions of the same promotion are counted one by one
+func (r *PromotionRepo) Redeem(ctx context.Context, redemption *models.PromoRedemption) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		r.log.Errorf("failed to begin redemption transaction, error: %v", err)
+		return err
+	}
+	// rollback is no-op after commit
+	defer func() { _ = tx.Rollback() }()
+
+	var perCustomerLimit int
+	stmnt := `UPDATE promotions SET redemptions = redemptions + 1
+		WHERE id = $1 AND (max_redemptions = 0 OR redemptions < max_redemptions) RETURNING per_customer_limit`
+	if err := tx.QueryRowContext(ctx, stmnt, redemption.PromotionID).Scan(&perCustomerLimit); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrExhausted
+		}
+		r.log.Errorw("failed to count promotion redemption",
+			"promotionID", redemption.PromotionID,
+			"error", err)
+		return err
+	}
+	if perCustomerLimit > 0 && redemption.UserID != "" {
+		var used int
+		stmnt := "SELECT COUNT(*) FROM promo_redemptions WHERE promotion_id = $1 AND user_id = $2"
+		if err := tx.QueryRowContext(ctx, stmnt, redemption.PromotionID, redemption.UserID).Scan(&used); err != nil {
+			r.log.Errorw("failed to count customer redemptions",
+				"promotionID", redemption.PromotionID,
+				"userID", redemption.UserID,
+				"error", err)
+			return err
+		}
+		if used >= perCustomerLimit {
+			return ErrLimitReached
+		}
+	}
+	stmnt = `INSERT INTO promo_redemptions (session_id, promotion_id, user_id, discount, currency)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5) RETURNING created_at`
+	if err := tx.QueryRowContext(ctx, stmnt, redemption.SessionID, redemption.PromotionID, redemption.UserID,
+		redemption.Discount, redemption.Currency).Scan(&redemption.CreatedAt); err != nil {
+		r.log.Errorw("failed to insert promotion redemption",
+			"promotionID", redemption.PromotionID,
+			"sessionID", redemption.SessionID,
+			"error", err)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		r.log.Errorf("failed to commit redemption of session %v, error: %v", redemption.SessionID, err)
+		return err
+	}
+	return nil
+}
+
+// Release takes back redemption of the session, so it is not counted against the limits
+func (r *PromotionRepo) Release(ctx context.Context, sessionID string) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		r.log.Errorf("failed to begin release transaction, error: %v", err)
+		return err
+	}
+	// rollback is no-op after commit
+	defer func() { _ = tx.Rollback() }()
+
+	var promotionID string
+	stmnt := "DELETE FROM promo_redemptions WHERE session_id = $1 RETURNING promotion_id"
+	if err := tx.QueryRowContext(ctx, stmnt, sessionID).Scan(&promotionID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		r.log.Errorw("failed to delete promotion redemption",
+			"sessionID", sessionID,
+			"error", err)
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE promotions SET redemptions = redemptions - 1 WHERE id = $1", promotionID); err != nil {
+		r.log.Errorw("failed to uncount promotion redemption",
+			"promotionID", promotionID,
+			"error", err)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		r.log.Errorf("failed to commit release of session %v, error: %v", sessionID, err)
+		return err
+	}
+	return nil
+}
+
+// ReleaseExpired takes back redemptions of unpaid payment sessions which expired or were revoked before the time,
+// the number of released redemptions is returned
+func (r *PromotionRepo) ReleaseExpired(ctx context.Context, before time.Time) (int, error) {
+	stmnt := `WITH released AS (
+			DELETE FROM promo_redemptions r USING payment_sessions s
+			WHERE s.id = r.session_id AND s.paid_at IS NULL AND LEAST(s.expires_at, s.revoked_at) < $1
+			RETURNING r.promotion_id
+		), counted AS (
+			SELECT promotion_id, COUNT(*) AS released FROM released GROUP BY promotion_id
+		)
+		UPDATE promotions p SET redemptions = p.redemptions - c.released
+		FROM counted c WHERE p.id = c.promotion_id RETURNING c.released`
+	rows, err := r.conn.QueryContext(ctx, stmnt, before)
+	if err != nil {
+		r.log.Errorf("failed to release expired redemptions, error: %v", err)
+		return 0, err
+	}
+	defer rows.Close()
+
+	total := 0
+	for rows.Next() {
+		var released int
+		if err := rows.Scan(&released); err != nil {
+			r.log.Errorf("failed to scan released redemptions, error: %v", err)
+			return 0, err
+		}
+		total += released
+	}
+	return total, rows.Err()
+}
+
+// scanner is satisfied by both sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPromotion(row scanner) (*models.Promotion, error) {
+	p := models.Promotion{}
+	if err := row.Scan(&p.ID, &p.Code, &p.Kind, &p.PercentOff, &p.AmountOff, &p.Currency, pq.Array(&p.ProductIDs),
+		&p.StartsAt, &p.EndsAt, &p.MaxRedemptions, &p.PerCustomerLimit, &p.Redemptions, &p.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
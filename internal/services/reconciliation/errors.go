@@ -0,0 +1,8 @@
+package reconciliation
+
+import "errors"
+
+var (
+	ErrNotFound         = errors.New("report is not found")
+	ErrUnexpectedResult = errors.New("unexpected error")
+)
@@ -0,0 +1,152 @@
+package v1
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/services/reconciliation"
+)
+
+type Reconciliation interface {
+	Reports(ctx context.Context) ([]models.ReconciliationReport, error)
+	Report(ctx context.Context, id string) (*models.ReconciliationReport, error)
+}
+
+type Handler struct {
+	log               *zap.SugaredLogger
+	reconciliationSvc Reconciliation
+}
+
+func NewHandler(log *zap.SugaredLogger, reconciliationSvc Reconciliation) *Handler {
+	return &Handler{log: log, reconciliationSvc: reconciliationSvc}
+}
+
+// Reports endpoint lists the latest reconciliation reports
+func (h *Handler) Reports() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+			return
+		}
+
+		reports, err := h.reconciliationSvc.Reports(r.Context())
+		if err != nil {
+			h.log.Errorf("failed to list reconciliation reports")
+			writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+			return
+		}
+		items := make([]map[string]any, 0, len(reports))
+		for i := range reports {
+			items = append(items, reportJson(&reports[i]))
+		}
+		writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": items})
+	}
+}
+
+// Report endpoint returns the report with its discrepancies at /api/v1/reconciliation/reports/{id},
+// /api/v1/reconciliation/reports/{id}/download serves the discrepancies as CSV file
+func (h *Handler) Report() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+			return
+		}
+		rest, _ := strings.CutPrefix(r.URL.Path, "/api/v1/reconciliation/reports/")
+		id, download := strings.CutSuffix(rest, "/download")
+		if id == "" || strings.Contains(id, "/") {
+			writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Not found"})
+			return
+		}
+
+		report, err := h.reconciliationSvc.Report(r.Context(), id)
+		if err != nil {
+			h.log.Errorf("failed to fetch reconciliation report")
+			switch {
+			case errors.Is(err, reconciliation.ErrNotFound):
+				writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Not found"})
+			default:
+				writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+			}
+			return
+		}
+
+		if download {
+			writeCsv(w, report)
+			return
+		}
+		data := reportJson(report)
+		discrepancies := make([]map[string]any, 0, len(report.Discrepancies))
+		for _, d := range report.Discrepancies {
+			discrepancies = append(discrepancies, map[string]any{
+				"kind":               d.Kind,
+				"payment_session_id": d.PaymentSessionID,
+				"transaction_id":     d.TransactionID,
+				"expected_amount":    d.ExpectedAmount,
+				"expected_currency":  d.ExpectedCurrency,
+				"settled_amount":     d.SettledAmount,
+				"settled_currency":   d.SettledCurrency,
+			})
+		}
+		data["discrepancies"] = discrepancies
+		writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": data})
+	}
+}
+
+func reportJson(report *models.ReconciliationReport) map[string]any {
+	return map[string]any{
+		"id":            report.ID,
+		"file_name":     report.FileName,
+		"provider":      report.Provider,
+		"period_start":  report.PeriodStart,
+		"period_end":    report.PeriodEnd,
+		"settled_count": report.SettledCount,
+		"matched_count": report.MatchedCount,
+		"created_at":    report.CreatedAt,
+	}
+}
+
+// writeCsv writes discrepancies of the report as CSV attachment
+func writeCsv(w http.ResponseWriter, report *models.ReconciliationReport) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="reconciliation-`+report.ID+`.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"kind", "payment_session_id", "transaction_id", "expected_amount", "expected_currency", "settled_amount", "settled_currency"})
+	for _, d := range report.Discrepancies {
+		_ = cw.Write([]string{
+			d.Kind,
+			d.PaymentSessionID,
+			d.TransactionID,
+			strconv.FormatInt(d.ExpectedAmount, 10),
+			d.ExpectedCurrency,
+			strconv.FormatInt(d.SettledAmount, 10),
+			d.SettledCurrency,
+		})
+	}
+	cw.Flush()
+}
+
+// writeJson peforms write to the response writer and header as json content-type
+func writeJson(w http.ResponseWriter, status int, data map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if data == nil {
+		return
+	}
+
+	enc, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	_, _ = w.Write(enc)
+}
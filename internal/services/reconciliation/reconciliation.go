@@ -0,0 +1,240 @@
+package reconciliation
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"payment-api/internal/integrations/settlement"
+	"payment-api/internal/models"
+	"payment-api/internal/services/reconciliation/repository"
+)
+
+const reportsLimit = 100
+
+// Repository for reconciliation reports
+type ReportRepo interface {
+	Exists(ctx context.Context, fileName string) (bool, error)
+	Create(ctx context.Context, report *models.ReconciliationReport) error
+	List(ctx context.Context, limit int) ([]models.ReconciliationReport, error)
+	FetchByID(ctx context.Context, id string) (*models.ReconciliationReport, error)
+}
+
+// Repository for captured payments
+type PaymentRepo interface {
+	PaidBetween(ctx context.Context, provider string, from, to time.Time) ([]models.PaymentSession, error)
+	FetchPaid(ctx context.Context, ids []string) ([]models.PaymentSession, error)
+	FetchPaidByPaymentIDs(ctx context.Context, provider string, ids []string) ([]models.PaymentSession, error)
+}
+
+type ReconciliationService struct {
+	log         *zap.SugaredLogger
+	reportRepo  ReportRepo
+	paymentRepo PaymentRepo
+	// dir is scanned for settlement files, empty dir disables the import
+	dir string
+}
+
+func NewReconciliationService(log *zap.SugaredLogger, reportRepo ReportRepo, paymentRepo PaymentRepo, dir string) *ReconciliationService {
+	return &ReconciliationService{log: log, reportRepo: reportRepo, paymentRepo: paymentRepo, dir: dir}
+}
+
+// Reconcile matches settled payments of the file against our captured ones and compares their amounts,
+// then stores the report. Rows are matched by id of the payment on the provider side, e.g. Stripe payment intent,
+// the payment session reference is the fallback for rows and payments without it.
+// Payments captured within the period of the file are expected to be settled in it
+func (s *ReconciliationService) Reconcile(ctx context.Context, fileName string, settled *settlement.Report) (*models.ReconciliationReport, error) {
+	expected, err := s.paymentRepo.PaidBetween(ctx, settled.Provider, settled.PeriodStart, settled.PeriodEnd)
+	if err != nil {
+		s.log.Errorf("failed to fetch %v payments to reconcile %v, error: %v", settled.Provider, fileName, err)
+		return nil, ErrUnexpectedResult
+	}
+	// payments captured right before the period may be settled in it as well
+	ids := make([]string, 0, len(settled.Rows))
+	paymentIDs := make([]string, 0, len(settled.Rows))
+	for _, row := range settled.Rows {
+		if row.ReferenceID != "" {
+			ids = append(ids, row.ReferenceID)
+		}
+		if row.PaymentID != "" {
+			paymentIDs = append(paymentIDs, row.PaymentID)
+		}
+	}
+	referenced, err := s.paymentRepo.FetchPaid(ctx, ids)
+	if err != nil {
+		s.log.Errorf("failed to fetch payments referenced by %v, error: %v", fileName, err)
+		return nil, ErrUnexpectedResult
+	}
+	byPaymentID, err := s.paymentRepo.FetchPaidByPaymentIDs(ctx, settled.Provider, paymentIDs)
+	if err != nil {
+		s.log.Errorf("failed to fetch payments settled by %v, error: %v", fileName, err)
+		return nil, ErrUnexpectedResult
+	}
+	payments := make(map[string]models.PaymentSession, len(expected)+len(referenced)+len(byPaymentID))
+	providerPayments := make(map[string]models.PaymentSession, len(expected)+len(byPaymentID))
+	for _, p := range append(append(expected, referenced...), byPaymentID...) {
+		payments[p.ID] = p
+		if p.ProviderPaymentID != "" {
+			providerPayments[p.ProviderPaymentID] = p
+		}
+	}
+
+	report := &models.ReconciliationReport{
+		ID:            uuid.NewString(),
+		FileName:      fileName,
+		Provider:      settled.Provider,
+		PeriodStart:   settled.PeriodStart,
+		PeriodEnd:     settled.PeriodEnd,
+		SettledCount:  len(settled.Rows),
+		Discrepancies: []models.ReconciliationDiscrepancy{},
+	}
+	seen := make(map[string]bool, len(settled.Rows))
+	for _, row := range settled.Rows {
+		d := models.ReconciliationDiscrepancy{
+			PaymentSessionID: row.ReferenceID,
+			TransactionID:    row.TransactionID,
+			SettledAmount:    row.Amount,
+			SettledCurrency:  row.Currency,
+		}
+		p, ok := providerPayments[row.PaymentID]
+		if row.PaymentID == "" || !ok {
+			p, ok = payments[row.ReferenceID]
+		}
+		if ok {
+			d.PaymentSessionID = p.ID
+		}
+		// payment settled twice is extra as well
+		if !ok || seen[p.ID] {
+			d.Kind = models.DiscrepancyExtra
+			report.Discrepancies = append(report.Discrepancies, d)
+			continue
+		}
+		seen[p.ID] = true
+		if p.Amount != row.Amount || p.Currency != row.Currency {
+			d.Kind = models.DiscrepancyAmountMismatch
+			d.ExpectedAmount = p.Amount
+			d.ExpectedCurrency = p.Currency
+			report.Discrepancies = append(report.Discrepancies, d)
+			continue
+		}
+		report.MatchedCount++
+	}
+	for _, p := range expected {
+		if seen[p.ID] {
+			continue
+		}
+		report.Discrepancies = append(report.Discrepancies, models.ReconciliationDiscrepancy{
+			Kind:             models.DiscrepancyMissing,
+			PaymentSessionID: p.ID,
+			ExpectedAmount:   p.Amount,
+			ExpectedCurrency: p.Currency,
+		})
+	}
+
+	if err := s.reportRepo.Create(ctx, report); err != nil {
+		s.log.Errorf("failed to store reconciliation report of %v, error: %v", fileName, err)
+		return nil, ErrUnexpectedResult
+	}
+	return report, nil
+}
+
+// ImportDir reconciles settlement files of the directory which are not reconciled yet,
+// a broken file is logged and skipped, so it doesn't block the rest
+func (s *ReconciliationService) ImportDir(ctx context.Context) ([]*models.ReconciliationReport, error) {
+	if s.dir == "" {
+		return nil, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.csv"))
+	if err != nil {
+		s.log.Errorf("failed to list settlement files of %v, error: %v", s.dir, err)
+		return nil, ErrUnexpectedResult
+	}
+	sort.Strings(paths)
+
+	reports := []*models.ReconciliationReport{}
+	for _, path := range paths {
+		fileName := filepath.Base(path)
+		exists, err := s.reportRepo.Exists(ctx, fileName)
+		if err != nil {
+			s.log.Errorf("failed to check settlement file %v, error: %v", fileName, err)
+			return reports, ErrUnexpectedResult
+		}
+		if exists {
+			continue
+		}
+		settled, err := parseFile(path)
+		if err != nil {
+			s.log.Errorf("failed to parse settlement file %v, error: %v", fileName, err)
+			continue
+		}
+		report, err := s.Reconcile(ctx, fileName, settled)
+		if err != nil {
+			continue
+		}
+		if len(report.Discrepancies) > 0 {
+			s.log.Errorw("settlement file has discrepancies",
+				"alert", "reconciliation_discrepancy",
+				"fileName", fileName,
+				"reportID", report.ID,
+				"discrepancies", len(report.Discrepancies))
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// Run imports the directory on start and then every interval until the context is done
+func (s *ReconciliationService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, _ = s.ImportDir(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reports returns the latest reports without their discrepancies
+func (s *ReconciliationService) Reports(ctx context.Context) ([]models.ReconciliationReport, error) {
+	reports, err := s.reportRepo.List(ctx, reportsLimit)
+	if err != nil {
+		s.log.Errorf("failed to list reconciliation reports, error: %v", err)
+		return nil, ErrUnexpectedResult
+	}
+	return reports, nil
+}
+
+// Report returns the report together with its discrepancies
+func (s *ReconciliationService) Report(ctx context.Context, id string) (*models.ReconciliationReport, error) {
+	report, err := s.reportRepo.FetchByID(ctx, id)
+	if err != nil {
+		s.log.Errorw("failed to fetch reconciliation report",
+			"ID", id,
+			"error", err)
+		switch {
+		case errors.Is(err, repository.ErrNotFound), errors.Is(err, repository.ErrUuidInvalidFormat):
+			return nil, ErrNotFound
+		default:
+			return nil, ErrUnexpectedResult
+		}
+	}
+	return report, nil
+}
+
+func parseFile(path string) (*settlement.Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return settlement.Parse(f)
+}
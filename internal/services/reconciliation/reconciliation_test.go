@@ -0,0 +1,191 @@
+package reconciliation
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"payment-api/internal/integrations/settlement"
+	"payment-api/internal/models"
+	"payment-api/internal/services/reconciliation/repository"
+)
+
+// FakeReportRepo keeps reports in memory by file name
+type FakeReportRepo struct {
+	Reports map[string]*models.ReconciliationReport
+}
+
+func (m *FakeReportRepo) Exists(ctx context.Context, fileName string) (bool, error) {
+	_, ok := m.Reports[fileName]
+	return ok, nil
+}
+
+func (m *FakeReportRepo) Create(ctx context.Context, report *models.ReconciliationReport) error {
+	if _, ok := m.Reports[report.FileName]; ok {
+		return repository.ErrDuplicate
+	}
+	m.Reports[report.FileName] = report
+	return nil
+}
+
+func (m *FakeReportRepo) List(ctx context.Context, limit int) ([]models.ReconciliationReport, error) {
+	reports := []models.ReconciliationReport{}
+	for _, r := range m.Reports {
+		reports = append(reports, *r)
+	}
+	return reports, nil
+}
+
+func (m *FakeReportRepo) FetchByID(ctx context.Context, id string) (*models.ReconciliationReport, error) {
+	for _, r := range m.Reports {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+// FakePaymentRepo serves captured sessions of a single provider
+type FakePaymentRepo struct {
+	Sessions []models.PaymentSession
+	Err      error
+}
+
+func (m *FakePaymentRepo) PaidBetween(ctx context.Context, provider string, from, to time.Time) ([]models.PaymentSession, error) {
+	sessions := []models.PaymentSession{}
+	for _, s := range m.Sessions {
+		if !s.PaidAt.Before(from) && !s.PaidAt.After(to) {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, m.Err
+}
+
+func (m *FakePaymentRepo) FetchPaid(ctx context.Context, ids []string) ([]models.PaymentSession, error) {
+	sessions := []models.PaymentSession{}
+	for _, s := range m.Sessions {
+		for _, id := range ids {
+			if s.ID == id {
+				sessions = append(sessions, s)
+			}
+		}
+	}
+	return sessions, m.Err
+}
+
+func (m *FakePaymentRepo) FetchPaidByPaymentIDs(ctx context.Context, provider string, ids []string) ([]models.PaymentSession, error) {
+	sessions := []models.PaymentSession{}
+	for _, s := range m.Sessions {
+		for _, id := range ids {
+			if s.ProviderPaymentID != "" && s.ProviderPaymentID == id {
+				sessions = append(sessions, s)
+			}
+		}
+	}
+	return sessions, m.Err
+}
+
+func TestReconciliationServiceReconcile(t *testing.T) {
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	paid := func(id string, at time.Time, amount int64) models.PaymentSession {
+		return models.PaymentSession{ID: id, Amount: amount, Currency: "usd", PaidAt: &at}
+	}
+	paymentRepo := &FakePaymentRepo{Sessions: []models.PaymentSession{
+		paid("matched", day.Add(time.Hour), 1299),
+		paid("mismatched", day.Add(2*time.Hour), 1299),
+		paid("missing", day.Add(3*time.Hour), 1299),
+		// captured the day before, settled within the period
+		paid("previous", day.Add(-time.Hour), 1299),
+		// captured after the period, expected in the next file
+		paid("next", day.Add(48*time.Hour), 1299),
+		{ID: "intent", ProviderPaymentID: "pi_1", Amount: 1299, Currency: "usd", PaidAt: &day},
+	}}
+	reportRepo := &FakeReportRepo{Reports: map[string]*models.ReconciliationReport{}}
+	service := NewReconciliationService(zap.NewNop().Sugar(), reportRepo, paymentRepo, "")
+
+	settled := &settlement.Report{
+		Provider: settlement.ProviderStripe,
+		Rows: []settlement.Row{
+			{TransactionID: "txn_1", ReferenceID: "matched", Amount: 1299, Currency: "usd", CreatedAt: day.Add(time.Hour)},
+			{TransactionID: "txn_2", ReferenceID: "mismatched", Amount: 999, Currency: "usd", CreatedAt: day.Add(2 * time.Hour)},
+			{TransactionID: "txn_3", ReferenceID: "previous", Amount: 1299, Currency: "usd", CreatedAt: day.Add(4 * time.Hour)},
+			{TransactionID: "txn_4", Amount: 500, Currency: "usd", CreatedAt: day.Add(5 * time.Hour)},
+			{TransactionID: "txn_5", ReferenceID: "matched", Amount: 1299, Currency: "usd", CreatedAt: day.Add(6 * time.Hour)},
+			// payment intent wins over the reference
+			{TransactionID: "txn_6", PaymentID: "pi_1", ReferenceID: "matched", Amount: 1299, Currency: "usd", CreatedAt: day.Add(6 * time.Hour)},
+		},
+		PeriodStart: day,
+		PeriodEnd:   day.Add(6 * time.Hour),
+	}
+	report, err := service.Reconcile(context.Background(), "stripe-2024-01-02.csv", settled)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, report.SettledCount)
+	assert.Equal(t, 3, report.MatchedCount)
+	assert.Equal(t, []models.ReconciliationDiscrepancy{
+		{Kind: models.DiscrepancyAmountMismatch, PaymentSessionID: "mismatched", TransactionID: "txn_2",
+			ExpectedAmount: 1299, ExpectedCurrency: "usd", SettledAmount: 999, SettledCurrency: "usd"},
+		{Kind: models.DiscrepancyExtra, TransactionID: "txn_4", SettledAmount: 500, SettledCurrency: "usd"},
+		{Kind: models.DiscrepancyExtra, PaymentSessionID: "matched", TransactionID: "txn_5", SettledAmount: 1299, SettledCurrency: "usd"},
+		{Kind: models.DiscrepancyMissing, PaymentSessionID: "missing", ExpectedAmount: 1299, ExpectedCurrency: "usd"},
+	}, report.Discrepancies)
+	assert.Same(t, report, reportRepo.Reports["stripe-2024-01-02.csv"])
+
+	fetched, err := service.Report(context.Background(), report.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, report, fetched)
+	_, err = service.Report(context.Background(), "unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// file is reconciled once
+	_, err = service.Reconcile(context.Background(), "stripe-2024-01-02.csv", settled)
+	assert.ErrorIs(t, err, ErrUnexpectedResult)
+
+	paymentRepo.Err = errors.New("db is down")
+	_, err = service.Reconcile(context.Background(), "stripe-2024-01-03.csv", settled)
+	assert.ErrorIs(t, err, ErrUnexpectedResult)
+}
+
+func TestReconciliationServiceImportDir(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	write("stripe.csv", "balance_transaction_id,created_utc,currency,gross,reporting_category,payment_metadata[payment_session_id]\n"+
+		"txn_1,2024-01-02 10:00:00,usd,12.99,charge,session\n")
+	// default columns of the report carry no metadata, only the payment intent
+	write("stripe-default.csv", "balance_transaction_id,created_utc,currency,gross,reporting_category,payment_intent_id\n"+
+		"txn_2,2024-01-02 11:00:00,usd,12.99,charge,pi_1\n")
+	write("unknown.csv", "id,amount\n1,100\n")
+	write("notes.txt", "not a settlement file")
+
+	paidAt := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	intentPaidAt := paidAt.Add(time.Hour)
+	paymentRepo := &FakePaymentRepo{Sessions: []models.PaymentSession{
+		{ID: "session", Amount: 1299, Currency: "usd", PaidAt: &paidAt},
+		{ID: "intent", ProviderPaymentID: "pi_1", Amount: 1299, Currency: "usd", PaidAt: &intentPaidAt},
+	}}
+	reportRepo := &FakeReportRepo{Reports: map[string]*models.ReconciliationReport{}}
+	service := NewReconciliationService(zap.NewNop().Sugar(), reportRepo, paymentRepo, dir)
+
+	reports, err := service.ImportDir(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, reports, 2)
+	assert.Equal(t, "stripe-default.csv", reports[0].FileName)
+	assert.Equal(t, 1, reports[0].MatchedCount)
+	assert.Empty(t, reports[0].Discrepancies)
+	assert.Equal(t, "stripe.csv", reports[1].FileName)
+	assert.Equal(t, 1, reports[1].MatchedCount)
+	assert.Empty(t, reports[1].Discrepancies)
+
+	// reconciled files are skipped on the next run
+	reports, err = service.ImportDir(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, reports)
+	assert.Len(t, reportRepo.Reports, 2)
+}
@@ -0,0 +1,9 @@
+package repository
+
+import "errors"
+
+var (
+	ErrUuidInvalidFormat = errors.New("uuid has invalid format")
+	ErrNotFound          = errors.New("record is not found")
+	ErrDuplicate         = errors.New("file is already reconciled")
+)
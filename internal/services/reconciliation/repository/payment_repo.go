@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+// PaymentRepo reads captured payment sessions the settlements are matched against
+type PaymentRepo struct {
+	log  *zap.SugaredLogger
+	conn *sql.DB
+}
+
+func NewPaymentRepo(log *zap.SugaredLogger, conn *sql.DB) *PaymentRepo {
+	return &PaymentRepo{log: log, conn: conn}
+}
+
+// PaidBetween fetches sessions of the provider captured within the period, bounds included.
+// Sessions which charged nothing, e.g. trials, are not settled, thus they are skipped
+func (r *PaymentRepo) PaidBetween(ctx context.Context, provider string, from, to time.Time) ([]models.PaymentSession, error) {
+	stmnt := `SELECT s.id, s.provider_id, COALESCE(s.provider_session_id, ''), COALESCE(s.provider_payment_id, ''),
+		s.amount, s.currency, s.paid_at
+		FROM payment_sessions s JOIN providers p ON p.id = s.provider_id
+		WHERE LOWER(p.name) = LOWER($1) AND s.paid_at BETWEEN $2 AND $3 AND s.amount > 0 ORDER BY s.paid_at`
+	return r.fetch(ctx, stmnt, provider, from, to)
+}
+
+// FetchPaid fetches captured sessions by their ids, unknown and invalid ids are skipped
+func (r *PaymentRepo) FetchPaid(ctx context.Context, ids []string) ([]models.PaymentSession, error) {
+	valid := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, err := uuid.Parse(id); err == nil {
+			valid = append(valid, id)
+		}
+	}
+	if len(valid) == 0 {
+		return []models.PaymentSession{}, nil
+	}
+	stmnt := `SELECT id, provider_id, COALESCE(provider_session_id, ''), COALESCE(provider_payment_id, ''),
+		amount, currency, paid_at
+		FROM payment_sessions WHERE id = ANY($1::uuid[]) AND paid_at IS NOT NULL`
+	return r.fetch(ctx, stmnt, pq.Array(valid))
+}
+
+// FetchPaidByPaymentIDs fetches captured sessions of the provider by ids of their payments on the provider side
+func (r *PaymentRepo) FetchPaidByPaymentIDs(ctx context.Context, provider string, ids []string) ([]models.PaymentSession, error) {
+	if len(ids) == 0 {
+		return []models.PaymentSession{}, nil
+	}
+	stmnt := `SELECT s.id, s.provider_id, COALESCE(s.provider_session_id, ''), s.provider_payment_id,
+		s.amount, s.currency, s.paid_at
+		FROM payment_sessions s JOIN providers p ON p.id = s.provider_id
+		WHERE LOWER(p.name) = LOWER($1) AND s.provider_payment_id = ANY($2) AND s.paid_at IS NOT NULL`
+	return r.fetch(ctx, stmnt, provider, pq.Array(ids))
+}
+
+func (r *PaymentRepo) fetch(ctx context.Context, stmnt string, args ...any) ([]models.PaymentSession, error) {
+	rows, err := r.conn.QueryContext(ctx, stmnt, args...)
+	if err != nil {
+		r.log.Errorf("failed to fetch paid sessions, error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.PaymentSession{}
+	for rows.Next() {
+		s := models.PaymentSession{}
+		if err := rows.Scan(&s.ID, &s.ProviderID, &s.ProviderSessionID, &s.ProviderPaymentID, &s.Amount, &s.Currency, &s.PaidAt); err != nil {
+			r.log.Errorf("failed to scan paid session, error: %v", err)
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+type ReportRepo struct {
+	log  *zap.SugaredLogger
+	conn *sql.DB
+}
+
+func NewReportRepo(log *zap.SugaredLogger, conn *sql.DB) *ReportRepo {
+	return &ReportRepo{log: log, conn: conn}
+}
+
+// Exists tells whether the file was already reconciled
+func (r *ReportRepo) Exists(ctx context.Context, fileName string) (bool, error) {
+	var exists bool
+	stmnt := "SELECT EXISTS(SELECT 1 FROM reconciliation_reports WHERE file_name = $1)"
+	if err := r.conn.QueryRowContext(ctx, stmnt, fileName).Scan(&exists); err != nil {
+		r.log.Errorw("failed to check reconciliation report",
+			"fileName", fileName,
+			"error", err)
+		return false, err
+	}
+	return exists, nil
+}
+
+// Create stores the report with its discrepancies in a single transaction,
+// ErrDuplicate is returned when the file is already reconciled
+func (r *ReportRepo) Create(ctx context.Context, report *models.ReconciliationReport) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		r.log.Errorf("failed to begin reconciliation transaction, error: %v", err)
+		return err
+	}
+	// rollback is no-op after commit
+	defer func() { _ = tx.Rollback() }()
+
+	stmnt := `INSERT INTO reconciliation_reports (id, file_name, provider, period_start, period_end, settled_count, matched_count)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT (file_name) DO NOTHING RETURNING created_at`
+	if err := tx.QueryRowContext(ctx, stmnt, report.ID, report.FileName, report.Provider, report.PeriodStart, report.PeriodEnd,
+		report.SettledCount, report.MatchedCount).Scan(&report.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDuplicate
+		}
+		r.log.Errorw("failed to insert reconciliation report",
+			"fileName", report.FileName,
+			"error", err)
+		return err
+	}
+	stmnt = `INSERT INTO reconciliation_discrepancies (report_id, kind, payment_session_id, transaction_id,
+		expected_amount, expected_currency, settled_amount, settled_currency)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6, $7, $8)`
+	for _, d := range report.Discrepancies {
+		if _, err := tx.ExecContext(ctx, stmnt, report.ID, d.Kind, d.PaymentSessionID, d.TransactionID,
+			d.ExpectedAmount, d.ExpectedCurrency, d.SettledAmount, d.SettledCurrency); err != nil {
+			r.log.Errorw("failed to insert reconciliation discrepancy",
+				"reportID", report.ID,
+				"error", err)
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		r.log.Errorf("failed to commit reconciliation report %v, error: %v", report.ID, err)
+		return err
+	}
+	return nil
+}
+
+// List fetches the latest reports without their discrepancies
+func (r *ReportRepo) List(ctx context.Context, limit int) ([]models.ReconciliationReport, error) {
+	stmnt := `SELECT id, file_name, provider, period_start, period_end, settled_count, matched_count, created_at
+		FROM reconciliation_reports ORDER BY created_at DESC LIMIT $1`
+	rows, err := r.conn.QueryContext(ctx, stmnt, limit)
+	if err != nil {
+		r.log.Errorf("failed to list reconciliation reports, error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []models.ReconciliationReport{}
+	for rows.Next() {
+		report := models.ReconciliationReport{}
+		if err := rows.Scan(&report.ID, &report.FileName, &report.Provider, &report.PeriodStart, &report.PeriodEnd,
+			&report.SettledCount, &report.MatchedCount, &report.CreatedAt); err != nil {
+			r.log.Errorf("failed to scan reconciliation report, error: %v", err)
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+// FetchByID fetches single report together with its discrepancies
+func (r *ReportRepo) FetchByID(ctx context.Context, id string) (*models.ReconciliationReport, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrUuidInvalidFormat
+	}
+
+	stmnt := `SELECT id, file_name, provider, period_start, period_end, settled_count, matched_count, created_at
+		FROM reconciliation_reports WHERE id = $1`
+	report := models.ReconciliationReport{}
+	if err := r.conn.QueryRowContext(ctx, stmnt, id).Scan(&report.ID, &report.FileName, &report.Provider,
+		&report.PeriodStart, &report.PeriodEnd, &report.SettledCount, &report.MatchedCount, &report.CreatedAt); err != nil {
+		r.log.Errorw("failed to fetch reconciliation report by ID",
+			"id", id,
+			"error", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	stmnt = `SELECT kind, COALESCE(payment_session_id, ''), COALESCE(transaction_id, ''),
+		expected_amount, expected_currency, settled_amount, settled_currency
+		FROM reconciliation_discrepancies WHERE report_id = $1 ORDER BY id`
+	rows, err := r.conn.QueryContext(ctx, stmnt, id)
+	if err != nil {
+		r.log.Errorw("failed to fetch reconciliation discrepancies",
+			"id", id,
+			"error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	report.Discrepancies = []models.ReconciliationDiscrepancy{}
+	for rows.Next() {
+		d := models.ReconciliationDiscrepancy{}
+		if err := rows.Scan(&d.Kind, &d.PaymentSessionID, &d.TransactionID,
+			&d.ExpectedAmount, &d.ExpectedCurrency, &d.SettledAmount, &d.SettledCurrency); err != nil {
+			r.log.Errorf("failed to scan reconciliation discrepancy, error: %v", err)
+			return nil, err
+		}
+		report.Discrepancies = append(report.Discrepancies, d)
+	}
+	return &report, rows.Err()
+}
@@ -0,0 +1,10 @@
+package risk
+
+import "errors"
+
+var (
+	ErrNotFound         = errors.New("risk decision is not found")
+	ErrRulesInvalid     = errors.New("risk rules are invalid")
+	ErrReviewInvalid    = errors.New("review of the risk decision is invalid")
+	ErrUnexpectedResult = errors.New("unexpected error")
+)
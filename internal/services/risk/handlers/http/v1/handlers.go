@@ -0,0 +1,175 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/services/risk"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+type Risk interface {
+	Decisions(ctx context.Context, action string, limit int) ([]models.RiskDecision, error)
+	Decision(ctx context.Context, id string) (*models.RiskDecision, error)
+	Review(ctx context.Context, id, review string) (*models.RiskDecision, error)
+}
+
+type Handler struct {
+	log     *zap.SugaredLogger
+	riskSvc Risk
+}
+
+func NewHandler(log *zap.SugaredLogger, riskSvc Risk) *Handler {
+	return &Handler{log: log, riskSvc: riskSvc}
+}
+
+type decisionBody struct {
+	ID         string     `json:"id"`
+	SessionID  string     `json:"session_id,omitempty"`
+	UserID     string     `json:"user_id,omitempty"`
+	Email      string     `json:"email,omitempty"`
+	IP         string     `json:"ip,omitempty"`
+	DeviceID   string     `json:"device_id,omitempty"`
+	Country    string     `json:"country,omitempty"`
+	IPCountry  string     `json:"ip_country,omitempty"`
+	Score      int        `json:"score"`
+	Action     string     `json:"action"`
+	Reasons    []string   `json:"reasons"`
+	Review     string     `json:"review,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type reviewRequest struct {
+	Review string `json:"review"`
+}
+
+// Decisions endpoint lists the latest risk decisions on GET, optionally of the action
+func (h *Handler) Decisions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+			return
+		}
+		limit := defaultListLimit
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 || n > maxListLimit {
+				writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Provided parameter has bad format"})
+				return
+			}
+			limit = n
+		}
+		list, err := h.riskSvc.Decisions(r.Context(), r.URL.Query().Get("action"), limit)
+		if err != nil {
+			h.log.Errorf("failed to list risk decisions")
+			writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+			return
+		}
+		items := make([]decisionBody, 0, len(list))
+		for i := range list {
+			items = append(items, toBody(&list[i]))
+		}
+		writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": items})
+	}
+}
+
+// Decision endpoint returns the decision on GET /api/v1/risk/decisions/{id}
+// and records the verdict of the reviewer on POST /api/v1/risk/decisions/{id}/review
+func (h *Handler) Decision() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rest, _ := strings.CutPrefix(r.URL.Path, "/api/v1/risk/decisions/")
+		parts := strings.Split(rest, "/")
+		switch {
+		case len(parts) == 1 && parts[0] != "":
+			if r.Method != http.MethodGet {
+				writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+				return
+			}
+			d, err := h.riskSvc.Decision(r.Context(), parts[0])
+			if err != nil {
+				h.log.Errorf("failed to fetch risk decision")
+				writeError(w, err)
+				return
+			}
+			writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": toBody(d)})
+		case len(parts) == 2 && parts[1] == "review":
+			if r.Method != http.MethodPost {
+				writeJson(w, http.StatusMethodNotAllowed, map[string]any{"code": http.StatusMethodNotAllowed, "message": "Method is not allowed"})
+				return
+			}
+			var req reviewRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Provided parameter has bad format"})
+				return
+			}
+			d, err := h.riskSvc.Review(r.Context(), parts[0], req.Review)
+			if err != nil {
+				h.log.Errorf("failed to review risk decision")
+				writeError(w, err)
+				return
+			}
+			writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": toBody(d)})
+		default:
+			writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Not found"})
+		}
+	}
+}
+
+func toBody(d *models.RiskDecision) decisionBody {
+	return decisionBody{
+		ID:         d.ID,
+		SessionID:  d.SessionID,
+		UserID:     d.UserID,
+		Email:      d.Email,
+		IP:         d.IP,
+		DeviceID:   d.DeviceID,
+		Country:    d.Country,
+		IPCountry:  d.IPCountry,
+		Score:      d.Score,
+		Action:     d.Action,
+		Reasons:    d.Reasons,
+		Review:     d.Review,
+		ReviewedAt: d.ReviewedAt,
+		CreatedAt:  d.CreatedAt,
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, risk.ErrNotFound):
+		writeJson(w, http.StatusNotFound, map[string]any{"code": http.StatusNotFound, "message": "Not found"})
+	case errors.Is(err, risk.ErrReviewInvalid):
+		writeJson(w, http.StatusBadRequest, map[string]any{"code": http.StatusBadRequest, "message": "Review must be fraud or legit"})
+	default:
+		writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+	}
+}
+
+// writeJson peforms write to the response writer and header as json content-type
+func writeJson(w http.ResponseWriter, status int, data map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if data == nil {
+		return
+	}
+
+	enc, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	_, _ = w.Write(enc)
+}
@@ -0,0 +1,8 @@
+package repository
+
+import "errors"
+
+var (
+	ErrNotFound   = errors.New("record is not found")
+	ErrKeyInvalid = errors.New("velocity key is not supported")
+)
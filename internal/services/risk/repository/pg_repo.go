@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+const decisionColumns = `id, COALESCE(session_id::text, ''), user_id, email, ip, device_id, country, ip_country, score, action,
+	reasons, review, reviewed_at, created_at`
+
+// keyColumns are columns attempts are counted by, keys are not interpolated into queries as is
+var keyColumns = map[string]string{
+	"ip":     "ip",
+	"user":   "user_id",
+	"email":  "email",
+	"device": "device_id",
+}
+
+type DecisionRepo struct {
+	log  *zap.SugaredLogger
+	conn *sql.DB
+}
+
+func NewDecisionRepo(log *zap.SugaredLogger, conn *sql.DB) *DecisionRepo {
+	return &DecisionRepo{log: log, conn: conn}
+}
+
+// Window is the window attempts of the key value are counted in, it starts at Since
+type Window struct {
+	Key   string
+	Value string
+	Since time.Time
+}
+
+// Create counts attempts of every window, lets decide make the decision with the counts and stores it.
+// Key values of the windows stay locked until commit, so concurrent attempts of the same value are counted one by one
+func (r *DecisionRepo) Create(ctx context.Context, d *models.RiskDecision, windows []Window, decide func(counts []int)) error {
+	for _, w := range windows {
+		if _, ok := keyColumns[w.Key]; !ok {
+			return ErrKeyInvalid
+		}
+	}
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		r.log.Errorf("failed to begin risk decision transaction, error: %v", err)
+		return err
+	}
+	// rollback is no-op after commit
+	defer func() { _ = tx.Rollback() }()
+
+	// locks are taken in the same order by every transaction, so they don't deadlock
+	locks := make([]string, 0, len(windows))
+	for _, w := range windows {
+		locks = append(locks, w.Key+":"+w.Value)
+	}
+	sort.Strings(locks)
+	for i, lock := range locks {
+		if i > 0 && lock == locks[i-1] {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('risk:' || $1))", lock); err != nil {
+			r.log.Errorf("failed to lock attempts of %v, error: %v", lock, err)
+			return err
+		}
+	}
+	counts := make([]int, len(windows))
+	for i, w := range windows {
+		stmnt := "SELECT COUNT(*) FROM risk_decisions WHERE " + keyColumns[w.Key] + " = $1 AND created_at > $2"
+		if err := tx.QueryRowContext(ctx, stmnt, w.Value, w.Since).Scan(&counts[i]); err != nil {
+			r.log.Errorw("failed to count attempts",
+				"key", w.Key,
+				"error", err)
+			return err
+		}
+	}
+	decide(counts)
+
+	stmnt := `INSERT INTO risk_decisions (id, session_id, user_id, email, ip, device_id, country, ip_country, score,
+		action, reasons, created_at) VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+	if _, err := tx.ExecContext(ctx, stmnt, d.ID, d.SessionID, d.UserID, d.Email, d.IP, d.DeviceID, d.Country,
+		d.IPCountry, d.Score, d.Action, pq.Array(d.Reasons), d.CreatedAt); err != nil {
+		r.log.Errorw("failed to create risk decision",
+			"sessionID", d.SessionID,
+			"error", err)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		r.log.Errorf("failed to commit risk decision %v, error: %v", d.ID, err)
+		return err
+	}
+	return nil
+}
+
+// FetchByID fetches single decision by id
+func (r *DecisionRepo) FetchByID(ctx context.Context, id string) (*models.RiskDecision, error) {
+	stmnt := "SELECT " + decisionColumns + " FROM risk_decisions WHERE id = $1"
+	d, err := scanDecision(r.conn.QueryRowContext(ctx, stmnt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		r.log.Errorw("failed to fetch risk decision by ID",
+			"id", id,
+			"error", err)
+		return nil, err
+	}
+	return d, nil
+}
+
+// List fetches the latest decisions with the action, empty action stands for every decision
+func (r *DecisionRepo) List(ctx context.Context, action string, limit int) ([]models.RiskDecision, error) {
+	stmnt := "SELECT " + decisionColumns + ` FROM risk_decisions WHERE $1 = '' OR action = $1
+		ORDER BY created_at DESC LIMIT $2`
+	rows, err := r.conn.QueryContext(ctx, stmnt, action, limit)
+	if err != nil {
+		r.log.Errorf("failed to list risk decisions, error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	decisions := []models.RiskDecision{}
+	for rows.Next() {
+		d, err := scanDecision(rows)
+		if err != nil {
+			r.log.Errorf("failed to scan risk decision, error: %v", err)
+			return nil, err
+		}
+		decisions = append(decisions, *d)
+	}
+	return decisions, rows.Err()
+}
+
+// Review records the verdict of the reviewer on the decision
+func (r *DecisionRepo) Review(ctx context.Context, id, review string, at time.Time) error {
+	stmnt := "UPDATE risk_decisions SET review = $2, reviewed_at = $3 WHERE id = $1"
+	res, err := r.conn.ExecContext(ctx, stmnt, id, review, at)
+	if err != nil {
+		r.log.Errorw("failed to review risk decision",
+			"id", id,
+			"error", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// scanner is satisfied by both sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanDecision(row scanner) (*models.RiskDecision, error) {
+	d := models.RiskDecision{}
+	if err := row.Scan(&d.ID, &d.SessionID, &d.UserID, &d.Email, &d.IP, &d.DeviceID, &d.Country, &d.IPCountry, &d.Score,
+		&d.Action, pq.Array(&d.Reasons), &d.Review, &d.ReviewedAt, &d.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
@@ -0,0 +1,269 @@
+package risk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/services/risk/repository"
+)
+
+// Keys attempts are counted by in the velocity rules
+const (
+	KeyIP     = "ip"
+	KeyUser   = "user"
+	KeyEmail  = "email"
+	KeyDevice = "device"
+)
+
+var velocityKeys = map[string]bool{KeyIP: true, KeyUser: true, KeyEmail: true, KeyDevice: true}
+
+// Repository for risk decisions
+type DecisionRepo interface {
+	// Create counts attempts of the windows, lets decide make the decision with the counts and stores it,
+	// concurrent attempts of the same key value are counted one by one
+	Create(ctx context.Context, d *models.RiskDecision, windows []repository.Window, decide func(counts []int)) error
+	FetchByID(ctx context.Context, id string) (*models.RiskDecision, error)
+	List(ctx context.Context, action string, limit int) ([]models.RiskDecision, error)
+	Review(ctx context.Context, id, review string, at time.Time) error
+}
+
+// velocityRule triggers once the key value was screened limit times within the window
+type velocityRule struct {
+	Key    string `json:"key"`
+	Window string `json:"window"`
+	Limit  int    `json:"limit"`
+	Weight int    `json:"weight"`
+	window time.Duration
+}
+
+func (r velocityRule) name() string {
+	return "velocity_" + r.Key + "_" + r.Window
+}
+
+type ruleTable struct {
+	Thresholds struct {
+		Challenge int `json:"challenge"`
+		Deny      int `json:"deny"`
+	} `json:"thresholds"`
+	Velocity []velocityRule `json:"velocity"`
+	// CountryMismatch triggers when the country claimed by the payer is not the country of the ip
+	CountryMismatch struct {
+		Weight int `json:"weight"`
+	} `json:"country_mismatch"`
+	Blocklist struct {
+		Weight  int      `json:"weight"`
+		Emails  []string `json:"emails"`
+		IPs     []string `json:"ips"`
+		Devices []string `json:"devices"`
+	} `json:"blocklist"`
+}
+
+// Attempt is the attempt to get the payment link, every field besides SessionID is optional
+type Attempt struct {
+	SessionID string
+	UserID    string
+	Email     string
+	IP        string
+	DeviceID  string
+	// Country is claimed by the payer and IPCountry is detected by the CDN
+	Country   string
+	IPCountry string
+}
+
+type RiskService struct {
+	log          *zap.SugaredLogger
+	decisionRepo DecisionRepo
+	filePath     string
+	now          func() time.Time
+}
+
+func NewRiskService(log *zap.SugaredLogger, decisionRepo DecisionRepo, filePath string) *RiskService {
+	return &RiskService{log: log, decisionRepo: decisionRepo, filePath: filePath, now: time.Now}
+}
+
+// Screen scores the attempt with the rules and decides whether the payment link is issued. Every attempt
+// is stored with its decision, denied ones as well, so they are counted by the velocity rules and reviewed
+func (s *RiskService) Screen(ctx context.Context, a Attempt) (*models.RiskDecision, error) {
+	table, err := s.load()
+	if err != nil {
+		s.log.Errorf("failed to load risk rules %v, error: %v", s.filePath, err)
+		return nil, ErrUnexpectedResult
+	}
+	now := s.now().UTC()
+	a.Email = strings.ToLower(a.Email)
+	d := &models.RiskDecision{
+		ID:        uuid.NewString(),
+		SessionID: a.SessionID,
+		UserID:    a.UserID,
+		Email:     a.Email,
+		IP:        a.IP,
+		DeviceID:  a.DeviceID,
+		Country:   strings.ToUpper(a.Country),
+		IPCountry: strings.ToUpper(a.IPCountry),
+		Reasons:   []string{},
+		CreatedAt: now,
+	}
+	trigger := func(reason string, weight int) {
+		d.Score += weight
+		d.Reasons = append(d.Reasons, reason)
+	}
+
+	// velocity rules are applied to the counts taken together with storing the decision,
+	// so a burst of attempts can't be screened before any of them is counted
+	rules := make([]velocityRule, 0, len(table.Velocity))
+	windows := make([]repository.Window, 0, len(table.Velocity))
+	for _, r := range table.Velocity {
+		value := attemptKey(a, r.Key)
+		if value == "" {
+			continue
+		}
+		rules = append(rules, r)
+		windows = append(windows, repository.Window{Key: r.Key, Value: value, Since: now.Add(-r.window)})
+	}
+	decide := func(counts []int) {
+		for i, r := range rules {
+			if counts[i] >= r.Limit {
+				trigger(r.name(), r.Weight)
+			}
+		}
+		if d.Country != "" && d.IPCountry != "" && d.Country != d.IPCountry {
+			trigger("country_mismatch", table.CountryMismatch.Weight)
+		}
+		if listed(table.Blocklist.Emails, a.Email) {
+			trigger("blocklist_email", table.Blocklist.Weight)
+		}
+		if listed(table.Blocklist.IPs, a.IP) {
+			trigger("blocklist_ip", table.Blocklist.Weight)
+		}
+		if listed(table.Blocklist.Devices, a.DeviceID) {
+			trigger("blocklist_device", table.Blocklist.Weight)
+		}
+
+		switch {
+		case d.Score >= table.Thresholds.Deny:
+			d.Action = models.RiskDeny
+			// denied attempt gets no session
+			d.SessionID = ""
+		case d.Score >= table.Thresholds.Challenge:
+			d.Action = models.RiskChallenge
+		default:
+			d.Action = models.RiskAllow
+		}
+	}
+	if err := s.decisionRepo.Create(ctx, d, windows, decide); err != nil {
+		s.log.Errorw("failed to store risk decision",
+			"sessionID", a.SessionID,
+			"error", err)
+		return nil, ErrUnexpectedResult
+	}
+	return d, nil
+}
+
+// Decisions returns the latest decisions with the action, empty action stands for every decision
+func (s *RiskService) Decisions(ctx context.Context, action string, limit int) ([]models.RiskDecision, error) {
+	decisions, err := s.decisionRepo.List(ctx, action, limit)
+	if err != nil {
+		s.log.Errorf("failed to list risk decisions, error: %v", err)
+		return nil, ErrUnexpectedResult
+	}
+	return decisions, nil
+}
+
+// Decision returns single decision by id
+func (s *RiskService) Decision(ctx context.Context, id string) (*models.RiskDecision, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	d, err := s.decisionRepo.FetchByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		s.log.Errorw("failed to fetch risk decision",
+			"id", id,
+			"error", err)
+		return nil, ErrUnexpectedResult
+	}
+	return d, nil
+}
+
+// Review records the verdict of the reviewer on the decision, it may be changed later
+func (s *RiskService) Review(ctx context.Context, id, review string) (*models.RiskDecision, error) {
+	if review != models.RiskReviewFraud && review != models.RiskReviewLegit {
+		return nil, ErrReviewInvalid
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	if err := s.decisionRepo.Review(ctx, id, review, s.now().UTC()); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		s.log.Errorw("failed to review risk decision",
+			"id", id,
+			"error", err)
+		return nil, ErrUnexpectedResult
+	}
+	return s.Decision(ctx, id)
+}
+
+func attemptKey(a Attempt, key string) string {
+	switch key {
+	case KeyIP:
+		return a.IP
+	case KeyUser:
+		return a.UserID
+	case KeyEmail:
+		return a.Email
+	case KeyDevice:
+		return a.DeviceID
+	}
+	return ""
+}
+
+func listed(list []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, v := range list {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// load reads risk rules on every call, so weights and thresholds can be tuned without restart
+func (s *RiskService) load() (*ruleTable, error) {
+	raw, err := os.ReadFile(s.filePath)
+	if err != nil {
+		return nil, err
+	}
+	var table ruleTable
+	if err := json.Unmarshal(raw, &table); err != nil {
+		return nil, err
+	}
+	if table.Thresholds.Challenge <= 0 || table.Thresholds.Deny < table.Thresholds.Challenge {
+		return nil, fmt.Errorf("%w: thresholds must be positive and challenge must not exceed deny", ErrRulesInvalid)
+	}
+	for i, r := range table.Velocity {
+		if !velocityKeys[r.Key] {
+			return nil, fmt.Errorf("%w: velocity key %v is unknown", ErrRulesInvalid, r.Key)
+		}
+		window, err := time.ParseDuration(r.Window)
+		if err != nil || window <= 0 || r.Limit <= 0 {
+			return nil, fmt.Errorf("%w: velocity rule %v has invalid window or limit", ErrRulesInvalid, r.name())
+		}
+		table.Velocity[i].window = window
+	}
+	return &table, nil
+}
@@ -0,0 +1,208 @@
+package risk
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/services/risk/repository"
+)
+
+func writeRules(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "risk_rules.json")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// FakeDecisionRepo keeps decisions in memory in the order they are made, decisions are made one by one
+// as with the locked key values
+type FakeDecisionRepo struct {
+	mu        sync.Mutex
+	Decisions []models.RiskDecision
+}
+
+func (m *FakeDecisionRepo) Create(ctx context.Context, d *models.RiskDecision, windows []repository.Window, decide func(counts []int)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := make([]int, len(windows))
+	for i, w := range windows {
+		for _, stored := range m.Decisions {
+			attempt := Attempt{IP: stored.IP, UserID: stored.UserID, Email: stored.Email, DeviceID: stored.DeviceID}
+			if stored.CreatedAt.After(w.Since) && attemptKey(attempt, w.Key) == w.Value {
+				counts[i]++
+			}
+		}
+	}
+	decide(counts)
+	m.Decisions = append(m.Decisions, *d)
+	return nil
+}
+
+func (m *FakeDecisionRepo) FetchByID(ctx context.Context, id string) (*models.RiskDecision, error) {
+	for _, d := range m.Decisions {
+		if d.ID == id {
+			return &d, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (m *FakeDecisionRepo) List(ctx context.Context, action string, limit int) ([]models.RiskDecision, error) {
+	list := []models.RiskDecision{}
+	for i := len(m.Decisions) - 1; i >= 0 && len(list) < limit; i-- {
+		if action == "" || m.Decisions[i].Action == action {
+			list = append(list, m.Decisions[i])
+		}
+	}
+	return list, nil
+}
+
+func (m *FakeDecisionRepo) Review(ctx context.Context, id, review string, at time.Time) error {
+	for i := range m.Decisions {
+		if m.Decisions[i].ID == id {
+			m.Decisions[i].Review = review
+			m.Decisions[i].ReviewedAt = &at
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+func TestRiskServiceScreen(t *testing.T) {
+	path := writeRules(t, `{
+		"thresholds": {"challenge": 40, "deny": 80},
+		"velocity": [
+			{"key": "ip", "window": "10m", "limit": 2, "weight": 40},
+			{"key": "device", "window": "1h", "limit": 3, "weight": 40}
+		],
+		"country_mismatch": {"weight": 40},
+		"blocklist": {"weight": 100, "emails": ["Fraud@Headway.test"], "ips": ["198.51.100.1"], "devices": ["emulator"]}
+	}`)
+	fakeRepo := &FakeDecisionRepo{}
+	service := NewRiskService(zap.NewNop().Sugar(), fakeRepo, path)
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+
+	type testCase struct {
+		name    string
+		attempt Attempt
+		elapsed time.Duration
+		action  string
+		score   int
+		reasons []string
+	}
+	testCases := []testCase{
+		{name: "allow first attempt", attempt: Attempt{IP: "203.0.113.7", DeviceID: "phone", Country: "pl", IPCountry: "PL"}, action: models.RiskAllow, reasons: []string{}},
+		{name: "allow within the limit", attempt: Attempt{IP: "203.0.113.7", DeviceID: "phone"}, action: models.RiskAllow, reasons: []string{}},
+		{name: "challenge ip velocity", attempt: Attempt{IP: "203.0.113.7", DeviceID: "phone"}, action: models.RiskChallenge, score: 40, reasons: []string{"velocity_ip_10m"}},
+		{name: "deny ip and device velocity", attempt: Attempt{IP: "203.0.113.7", DeviceID: "phone"}, action: models.RiskDeny, score: 80, reasons: []string{"velocity_ip_10m", "velocity_device_1h"}},
+		{name: "challenge device velocity once ip window slides", attempt: Attempt{IP: "203.0.113.7", DeviceID: "phone"}, elapsed: 11 * time.Minute, action: models.RiskChallenge, score: 40, reasons: []string{"velocity_device_1h"}},
+		{name: "challenge country mismatch", attempt: Attempt{Country: "US", IPCountry: "ng"}, action: models.RiskChallenge, score: 40, reasons: []string{"country_mismatch"}},
+		{name: "deny blocked email", attempt: Attempt{Email: "fraud@headway.test"}, action: models.RiskDeny, score: 100, reasons: []string{"blocklist_email"}},
+		{name: "deny blocked ip", attempt: Attempt{IP: "198.51.100.1"}, action: models.RiskDeny, score: 100, reasons: []string{"blocklist_ip"}},
+		{name: "deny blocked device", attempt: Attempt{DeviceID: "emulator"}, action: models.RiskDeny, score: 100, reasons: []string{"blocklist_device"}},
+		{name: "allow anonymous attempt", attempt: Attempt{}, action: models.RiskAllow, reasons: []string{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			now = now.Add(tc.elapsed)
+			tc.attempt.SessionID = uuid.NewString()
+			d, err := service.Screen(context.Background(), tc.attempt)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.action, d.Action)
+			assert.Equal(t, tc.score, d.Score)
+			assert.Equal(t, tc.reasons, d.Reasons)
+			assert.Equal(t, *d, fakeRepo.Decisions[len(fakeRepo.Decisions)-1])
+			if tc.action == models.RiskDeny {
+				assert.Empty(t, d.SessionID)
+				return
+			}
+			assert.Equal(t, tc.attempt.SessionID, d.SessionID)
+		})
+	}
+
+	t.Run("burst of attempts is counted one by one", func(t *testing.T) {
+		var wg sync.WaitGroup
+		actions := make(chan string, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d, err := service.Screen(context.Background(), Attempt{SessionID: uuid.NewString(), IP: "192.0.2.10"})
+				assert.NoError(t, err)
+				actions <- d.Action
+			}()
+		}
+		wg.Wait()
+		close(actions)
+		allowed := 0
+		for action := range actions {
+			if action == models.RiskAllow {
+				allowed++
+			}
+		}
+		assert.Equal(t, 2, allowed)
+	})
+
+	t.Run("fail invalid rules", func(t *testing.T) {
+		for _, rules := range []string{
+			`{"thresholds": {"challenge": 0, "deny": 80}}`,
+			`{"thresholds": {"challenge": 90, "deny": 80}}`,
+			`{"thresholds": {"challenge": 40, "deny": 80}, "velocity": [{"key": "card", "window": "1h", "limit": 1}]}`,
+			`{"thresholds": {"challenge": 40, "deny": 80}, "velocity": [{"key": "ip", "window": "hour", "limit": 1}]}`,
+			`{"thresholds": {"challenge": 40, "deny": 80}, "velocity": [{"key": "ip", "window": "1h", "limit": 0}]}`,
+			`{`,
+		} {
+			invalid := NewRiskService(zap.NewNop().Sugar(), &FakeDecisionRepo{}, writeRules(t, rules))
+			_, err := invalid.Screen(context.Background(), Attempt{SessionID: uuid.NewString()})
+			assert.ErrorIs(t, err, ErrUnexpectedResult, rules)
+		}
+	})
+}
+
+func TestRiskServiceReview(t *testing.T) {
+	fakeRepo := &FakeDecisionRepo{}
+	service := NewRiskService(zap.NewNop().Sugar(), fakeRepo, writeRules(t, `{"thresholds": {"challenge": 40, "deny": 80}}`))
+	d, err := service.Screen(context.Background(), Attempt{SessionID: uuid.NewString()})
+	assert.NoError(t, err)
+
+	type testCase struct {
+		name   string
+		id     string
+		review string
+		err    error
+	}
+	testCases := []testCase{
+		{name: "success fraud", id: d.ID, review: models.RiskReviewFraud},
+		{name: "success verdict is changed", id: d.ID, review: models.RiskReviewLegit},
+		{name: "fail unknown review", id: d.ID, review: "maybe", err: ErrReviewInvalid},
+		{name: "fail unknown decision", id: uuid.NewString(), review: models.RiskReviewFraud, err: ErrNotFound},
+		{name: "fail malformed id", id: "decision", review: models.RiskReviewFraud, err: ErrNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reviewed, err := service.Review(context.Background(), tc.id, tc.review)
+			assert.ErrorIs(t, err, tc.err)
+			if tc.err != nil {
+				return
+			}
+			assert.Equal(t, tc.review, reviewed.Review)
+			assert.NotNil(t, reviewed.ReviewedAt)
+		})
+	}
+
+	list, err := service.Decisions(context.Background(), models.RiskAllow, 10)
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+	list, err = service.Decisions(context.Background(), models.RiskDeny, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+}
@@ -0,0 +1,13 @@
+package subscriptions
+
+import "errors"
+
+var (
+	ErrPlanNotFound = errors.New("plan is not found")
+	ErrPlanInvalid  = errors.New("plan is invalid")
+	// ErrSubscriptionNotFound is returned when the session has started no subscription
+	ErrSubscriptionNotFound = errors.New("subscription is not found")
+	// ErrSubscriptionCanceled is returned on renewal of the canceled subscription
+	ErrSubscriptionCanceled = errors.New("subscription is canceled")
+	ErrUnexpectedResult     = errors.New("unexpected error")
+)
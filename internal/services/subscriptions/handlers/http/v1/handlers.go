@@ -0,0 +1,70 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/services/subscriptions"
+)
+
+type Subscriptions interface {
+	Offers(ctx context.Context, userID string) ([]subscriptions.Offer, error)
+}
+
+type Handler struct {
+	log              *zap.SugaredLogger
+	subscriptionsSvc Subscriptions
+}
+
+func NewHandler(log *zap.SugaredLogger, subscriptionsSvc Subscriptions) *Handler {
+	return &Handler{log: log, subscriptionsSvc: subscriptionsSvc}
+}
+
+// Plans endpoint lists plans with the terms offered to the user, the trial is offered
+// only when userID is provided and the user has not had one
+func (h *Handler) Plans() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		offers, err := h.subscriptionsSvc.Offers(r.Context(), r.URL.Query().Get("userID"))
+		if err != nil {
+			h.log.Errorf("failed to list plans")
+			writeJson(w, http.StatusInternalServerError, map[string]any{"code": http.StatusInternalServerError, "message": "Oops, something went wrong"})
+			return
+		}
+		plans := make([]map[string]any, 0, len(offers))
+		for _, o := range offers {
+			plan := map[string]any{
+				"id":         o.Plan.ID,
+				"name":       o.Plan.Name,
+				"amount":     o.Plan.Amount,
+				"currency":   o.Plan.Currency,
+				"interval":   o.Plan.Interval,
+				"trial_days": o.TrialDays,
+			}
+			if o.Plan.IntroAmount > 0 {
+				plan["intro_amount"] = o.Plan.IntroAmount
+				plan["intro_periods"] = o.Plan.IntroPeriods
+			}
+			plans = append(plans, plan)
+		}
+		writeJson(w, http.StatusOK, map[string]any{"code": http.StatusOK, "data": plans})
+	}
+}
+
+// writeJson peforms write to the response writer and header as json content-type
+func writeJson(w http.ResponseWriter, status int, data map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if data == nil {
+		return
+	}
+
+	enc, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	_, _ = w.Write(enc)
+}
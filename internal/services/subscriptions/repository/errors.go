@@ -0,0 +1,8 @@
+package repository
+
+import "errors"
+
+var (
+	ErrNotFound  = errors.New("record is not found")
+	ErrDuplicate = errors.New("subscription already exists")
+)
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+)
+
+const subscriptionColumns = `id, user_id, plan_id, session_id, status, trial_ends_at, current_period_end, created_at, updated_at`
+
+type SubscriptionRepo struct {
+	log  *zap.SugaredLogger
+	conn *sql.DB
+}
+
+func NewSubscriptionRepo(log *zap.SugaredLogger, conn *sql.DB) *SubscriptionRepo {
+	return &SubscriptionRepo{log: log, conn: conn}
+}
+
+// Create stores a new subscription, ErrDuplicate is returned when the session has started one already
+// or when the trial of the user is taken
+func (r *SubscriptionRepo) Create(ctx context.Context, s *models.Subscription) error {
+	stmnt := `INSERT INTO subscriptions (id, user_id, plan_id, session_id, status, trial_ends_at, current_period_end)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT DO NOTHING RETURNING created_at, updated_at`
+	if err := r.conn.QueryRowContext(ctx, stmnt, s.ID, s.UserID, s.PlanID, s.SessionID, s.Status, s.TrialEndsAt,
+		s.CurrentPeriodEnd).Scan(&s.CreatedAt, &s.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDuplicate
+		}
+		r.log.Errorw("failed to create subscription",
+			"sessionID", s.SessionID,
+			"error", err)
+		return err
+	}
+	return nil
+}
+
+// FetchBySessionID fetches the subscription started by the payment session
+func (r *SubscriptionRepo) FetchBySessionID(ctx context.Context, sessionID string) (*models.Subscription, error) {
+	stmnt := "SELECT " + subscriptionColumns + " FROM subscriptions WHERE session_id = $1"
+	s, err := scanSubscription(r.conn.QueryRowContext(ctx, stmnt, sessionID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		r.log.Errorw("failed to fetch subscription by session",
+			"sessionID", sessionID,
+			"error", err)
+		return nil, err
+	}
+	return s, nil
+}
+
+// HasTrial tells whether the user has started a subscription with a trial
+func (r *SubscriptionRepo) HasTrial(ctx context.Context, userID string) (bool, error) {
+	stmnt := "SELECT EXISTS (SELECT 1 FROM subscriptions WHERE user_id = $1 AND trial_ends_at IS NOT NULL)"
+	var exists bool
+	if err := r.conn.QueryRowContext(ctx, stmnt, userID).Scan(&exists); err != nil {
+		r.log.Errorw("failed to check trial of the user",
+			"userID", userID,
+			"error", err)
+		return false, err
+	}
+	return exists, nil
+}
+
+// Renew activates the subscription for the period ending at the provided time and returns it,
+// the period is never shortened, so renewals delivered out of order or twice keep the latest one.
+// ErrNotFound is returned when the subscription is canceled
+func (r *SubscriptionRepo) Renew(ctx context.Context, id string, periodEnd time.Time) (*models.Subscription, error) {
+	stmnt := `UPDATE subscriptions SET status = $1, current_period_end = GREATEST(current_period_end, $2),
+		updated_at = CURRENT_TIMESTAMP WHERE id = $3 AND status <> $4 RETURNING ` + subscriptionColumns
+	s, err := scanSubscription(r.conn.QueryRowContext(ctx, stmnt, models.SubscriptionActive, periodEnd, id, models.SubscriptionCanceled))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		r.log.Errorw("failed to renew subscription",
+			"id", id,
+			"error", err)
+		return nil, err
+	}
+	return s, nil
+}
+
+// Cancel marks the subscription canceled, canceling it again is a no-op
+func (r *SubscriptionRepo) Cancel(ctx context.Context, id string) error {
+	stmnt := "UPDATE subscriptions SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2"
+	if _, err := r.conn.ExecContext(ctx, stmnt, models.SubscriptionCanceled, id); err != nil {
+		r.log.Errorw("failed to cancel subscription",
+			"id", id,
+			"error", err)
+		return err
+	}
+	return nil
+}
+
+// scanner is satisfied by both sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSubscription(row scanner) (*models.Subscription, error) {
+	s := models.Subscription{}
+	if err := row.Scan(&s.ID, &s.UserID, &s.PlanID, &s.SessionID, &s.Status, &s.TrialEndsAt, &s.CurrentPeriodEnd,
+		&s.CreatedAt, &s.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
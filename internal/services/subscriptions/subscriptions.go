@@ -0,0 +1,284 @@
+package subscriptions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/money"
+	"payment-api/internal/services/subscriptions/repository"
+)
+
+// Repository for subscriptions
+type SubscriptionRepo interface {
+	Create(ctx context.Context, s *models.Subscription) error
+	FetchBySessionID(ctx context.Context, sessionID string) (*models.Subscription, error)
+	HasTrial(ctx context.Context, userID string) (bool, error)
+	Renew(ctx context.Context, id string, periodEnd time.Time) (*models.Subscription, error)
+	Cancel(ctx context.Context, id string) error
+}
+
+// Entitlements grants access for the periods of subscriptions
+type Entitlements interface {
+	GrantSubscription(ctx context.Context, sub *models.Subscription, periodStart time.Time) error
+}
+
+// plansFile lists plans sold on the web
+type plansFile struct {
+	Plans []models.Plan `json:"plans"`
+}
+
+// Offer is what the customer gets on the checkout of the plan
+type Offer struct {
+	Plan models.Plan
+	// TrialDays is zero when the plan has no trial or the customer has used theirs
+	TrialDays int
+}
+
+type SubscriptionsService struct {
+	log              *zap.SugaredLogger
+	subscriptionRepo SubscriptionRepo
+	entitlements     Entitlements
+	filePath         string
+}
+
+func NewSubscriptionsService(log *zap.SugaredLogger, subscriptionRepo SubscriptionRepo, entitlements Entitlements, filePath string) *SubscriptionsService {
+	return &SubscriptionsService{
+		log:              log,
+		subscriptionRepo: subscriptionRepo,
+		entitlements:     entitlements,
+		filePath:         filePath,
+	}
+}
+
+// Plans returns every plan of the plans file, the file is read on every call,
+// so plans can be changed without restart
+func (s *SubscriptionsService) Plans(ctx context.Context) ([]models.Plan, error) {
+	plans, err := s.load()
+	if err != nil {
+		s.log.Errorf("failed to load plans from %v, error: %v", s.filePath, err)
+		return nil, ErrUnexpectedResult
+	}
+	return plans, nil
+}
+
+// Offers returns terms the user gets with every plan, see Offer
+func (s *SubscriptionsService) Offers(ctx context.Context, userID string) ([]Offer, error) {
+	plans, err := s.Plans(ctx)
+	if err != nil {
+		return nil, err
+	}
+	eligible, err := s.trialEligible(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	offers := make([]Offer, 0, len(plans))
+	for _, plan := range plans {
+		offer := Offer{Plan: plan}
+		if eligible {
+			offer.TrialDays = plan.TrialDays
+		}
+		offers = append(offers, offer)
+	}
+	return offers, nil
+}
+
+// Offer returns terms the user gets with the plan. A user gets a single trial whatever plan it is taken with,
+// anonymous users get no trial since it can't be told whether they had one
+func (s *SubscriptionsService) Offer(ctx context.Context, planID, userID string) (*Offer, error) {
+	plan, err := s.plan(ctx, planID)
+	if err != nil {
+		return nil, err
+	}
+	offer := &Offer{Plan: *plan}
+	if plan.TrialDays == 0 {
+		return offer, nil
+	}
+	eligible, err := s.trialEligible(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if eligible {
+		offer.TrialDays = plan.TrialDays
+	}
+	return offer, nil
+}
+
+func (s *SubscriptionsService) trialEligible(ctx context.Context, userID string) (bool, error) {
+	if userID == "" {
+		return false, nil
+	}
+	used, err := s.subscriptionRepo.HasTrial(ctx, userID)
+	if err != nil {
+		s.log.Errorf("failed to check trial of %v, error: %v", userID, err)
+		return false, ErrUnexpectedResult
+	}
+	return !used, nil
+}
+
+// Start creates the subscription bought with the paid session and grants access for its first period,
+// which is the trial when the session has one. Repeated calls for the same session keep a single subscription
+func (s *SubscriptionsService) Start(ctx context.Context, session *models.PaymentSession, paidAt time.Time) error {
+	sub, err := s.subscriptionRepo.FetchBySessionID(ctx, session.ID)
+	switch {
+	case err == nil && sub.Status == models.SubscriptionCanceled:
+		return nil
+	case err == nil:
+		return s.grant(ctx, sub, paidAt)
+	case !errors.Is(err, repository.ErrNotFound):
+		s.log.Errorf("failed to fetch subscription of session %v, error: %v", session.ID, err)
+		return ErrUnexpectedResult
+	}
+	plan, err := s.plan(ctx, session.PlanID)
+	if err != nil {
+		return err
+	}
+
+	sub = &models.Subscription{
+		ID:               uuid.NewString(),
+		UserID:           session.UserID,
+		PlanID:           plan.ID,
+		SessionID:        session.ID,
+		Status:           models.SubscriptionActive,
+		CurrentPeriodEnd: plan.PeriodEnd(paidAt),
+	}
+	if session.TrialDays > 0 {
+		trialEnd := paidAt.AddDate(0, 0, session.TrialDays)
+		sub.Status = models.SubscriptionTrialing
+		sub.TrialEndsAt = &trialEnd
+		sub.CurrentPeriodEnd = trialEnd
+	}
+	err = s.subscriptionRepo.Create(ctx, sub)
+	if errors.Is(err, repository.ErrDuplicate) {
+		// the session is started concurrently
+		if stored, err := s.subscriptionRepo.FetchBySessionID(ctx, session.ID); err == nil {
+			return s.grant(ctx, stored, paidAt)
+		}
+		// otherwise the user has taken a trial with another session meanwhile, the provider gives
+		// the trial anyway, so access lasts as long, but the trial is not counted twice
+		s.log.Errorw("trial is taken twice",
+			"alert", "trial_reused",
+			"userID", session.UserID,
+			"sessionID", session.ID)
+		sub.Status = models.SubscriptionActive
+		sub.TrialEndsAt = nil
+		err = s.subscriptionRepo.Create(ctx, sub)
+	}
+	if err != nil {
+		s.log.Errorf("failed to create subscription of session %v, error: %v", session.ID, err)
+		return ErrUnexpectedResult
+	}
+	return s.grant(ctx, sub, paidAt)
+}
+
+// Renew extends the subscription started with the session for the period paid on the provider side,
+// the first renewal of a trialing subscription converts it. Access is extended only once the provider confirms
+// the charge, so a trial whose charge fails ends without access. Repeated renewals for the same period are no-ops,
+// canceled subscriptions are not renewed and ErrSubscriptionCanceled is returned
+func (s *SubscriptionsService) Renew(ctx context.Context, sessionID string, periodStart, periodEnd time.Time) error {
+	sub, err := s.subscriptionRepo.FetchBySessionID(ctx, sessionID)
+	if err != nil {
+		s.log.Errorf("failed to fetch subscription of session %v, error: %v", sessionID, err)
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrSubscriptionNotFound
+		}
+		return ErrUnexpectedResult
+	}
+	if sub.Status == models.SubscriptionCanceled {
+		return ErrSubscriptionCanceled
+	}
+	sub, err = s.subscriptionRepo.Renew(ctx, sub.ID, periodEnd)
+	if errors.Is(err, repository.ErrNotFound) {
+		// canceled meanwhile
+		return ErrSubscriptionCanceled
+	}
+	if err != nil {
+		s.log.Errorf("failed to renew subscription of session %v, error: %v", sessionID, err)
+		return ErrUnexpectedResult
+	}
+	return s.grant(ctx, sub, periodStart)
+}
+
+// Cancel cancels the subscription started with the session, so it is not renewed anymore and the repeated
+// completion of the session doesn't grant access again. Sessions without a subscription have nothing to cancel
+func (s *SubscriptionsService) Cancel(ctx context.Context, sessionID string) error {
+	sub, err := s.subscriptionRepo.FetchBySessionID(ctx, sessionID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		s.log.Errorf("failed to fetch subscription of session %v, error: %v", sessionID, err)
+		return ErrUnexpectedResult
+	}
+	if err := s.subscriptionRepo.Cancel(ctx, sub.ID); err != nil {
+		s.log.Errorf("failed to cancel subscription %v, error: %v", sub.ID, err)
+		return ErrUnexpectedResult
+	}
+	return nil
+}
+
+func (s *SubscriptionsService) grant(ctx context.Context, sub *models.Subscription, periodStart time.Time) error {
+	if s.entitlements == nil {
+		return nil
+	}
+	if err := s.entitlements.GrantSubscription(ctx, sub, periodStart); err != nil {
+		s.log.Errorf("failed to grant access of subscription %v, error: %v", sub.ID, err)
+		return ErrUnexpectedResult
+	}
+	return nil
+}
+
+func (s *SubscriptionsService) plan(ctx context.Context, id string) (*models.Plan, error) {
+	plans, err := s.Plans(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range plans {
+		if plans[i].ID == id {
+			return &plans[i], nil
+		}
+	}
+	return nil, ErrPlanNotFound
+}
+
+func (s *SubscriptionsService) load() ([]models.Plan, error) {
+	raw, err := os.ReadFile(s.filePath)
+	if err != nil {
+		return nil, err
+	}
+	var f plansFile
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, err
+	}
+	for i := range f.Plans {
+		f.Plans[i].Currency = strings.ToLower(f.Plans[i].Currency)
+		if err := validate(&f.Plans[i]); err != nil {
+			return nil, err
+		}
+	}
+	return f.Plans, nil
+}
+
+func validate(p *models.Plan) error {
+	switch {
+	case p.ID == "":
+		return fmt.Errorf("%w: id is required", ErrPlanInvalid)
+	case p.Amount <= 0 || !money.Known(p.Currency):
+		return fmt.Errorf("%w: %v has no amount or currency", ErrPlanInvalid, p.ID)
+	case p.Interval != models.IntervalMonth && p.Interval != models.IntervalYear:
+		return fmt.Errorf("%w: %v interval must be month or year", ErrPlanInvalid, p.ID)
+	case p.TrialDays < 0:
+		return fmt.Errorf("%w: %v trial can't be negative", ErrPlanInvalid, p.ID)
+	case p.IntroAmount < 0 || p.IntroAmount >= p.Amount || (p.IntroAmount > 0) != (p.IntroPeriods > 0):
+		return fmt.Errorf("%w: %v intro price must be lower than the price and last at least a period", ErrPlanInvalid, p.ID)
+	}
+	return nil
+}
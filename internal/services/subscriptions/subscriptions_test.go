@@ -0,0 +1,228 @@
+package subscriptions
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/services/subscriptions/repository"
+)
+
+// FakeSubscriptionRepo keeps subscriptions in memory by session, a user takes a single trial as with the unique index
+type FakeSubscriptionRepo struct {
+	Subscriptions map[string]*models.Subscription
+}
+
+func NewFakeSubscriptionRepo() *FakeSubscriptionRepo {
+	return &FakeSubscriptionRepo{Subscriptions: map[string]*models.Subscription{}}
+}
+
+func (m *FakeSubscriptionRepo) Create(ctx context.Context, s *models.Subscription) error {
+	if _, ok := m.Subscriptions[s.SessionID]; ok {
+		return repository.ErrDuplicate
+	}
+	if used, _ := m.HasTrial(ctx, s.UserID); used && s.TrialEndsAt != nil {
+		return repository.ErrDuplicate
+	}
+	stored := *s
+	m.Subscriptions[s.SessionID] = &stored
+	return nil
+}
+
+func (m *FakeSubscriptionRepo) FetchBySessionID(ctx context.Context, sessionID string) (*models.Subscription, error) {
+	s, ok := m.Subscriptions[sessionID]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	stored := *s
+	return &stored, nil
+}
+
+func (m *FakeSubscriptionRepo) HasTrial(ctx context.Context, userID string) (bool, error) {
+	for _, s := range m.Subscriptions {
+		if s.UserID == userID && s.TrialEndsAt != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *FakeSubscriptionRepo) Renew(ctx context.Context, id string, periodEnd time.Time) (*models.Subscription, error) {
+	for _, s := range m.Subscriptions {
+		if s.ID == id && s.Status != models.SubscriptionCanceled {
+			s.Status = models.SubscriptionActive
+			if periodEnd.After(s.CurrentPeriodEnd) {
+				s.CurrentPeriodEnd = periodEnd
+			}
+			stored := *s
+			return &stored, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (m *FakeSubscriptionRepo) Cancel(ctx context.Context, id string) error {
+	for _, s := range m.Subscriptions {
+		if s.ID == id {
+			s.Status = models.SubscriptionCanceled
+		}
+	}
+	return nil
+}
+
+// FakeEntitlements remembers when access of every subscription expires
+type FakeEntitlements struct {
+	ExpiresAt map[string]time.Time
+}
+
+func (m *FakeEntitlements) GrantSubscription(ctx context.Context, sub *models.Subscription, periodStart time.Time) error {
+	m.ExpiresAt[sub.ID] = sub.CurrentPeriodEnd
+	return nil
+}
+
+func writePlans(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "plans.json")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+const plans = `{"plans": [
+	{"id": "monthly", "name": "Monthly", "amount": 1299, "currency": "USD", "interval": "month", "trial_days": 7},
+	{"id": "yearly", "name": "Yearly", "amount": 8999, "currency": "usd", "interval": "year", "intro_amount": 5999, "intro_periods": 1}
+]}`
+
+func TestSubscriptionsServiceOffer(t *testing.T) {
+	repo := NewFakeSubscriptionRepo()
+	trialEnd := time.Now()
+	repo.Subscriptions["session"] = &models.Subscription{ID: "sub", UserID: "trialed", SessionID: "session", TrialEndsAt: &trialEnd}
+	service := NewSubscriptionsService(zap.NewNop().Sugar(), repo, nil, writePlans(t, plans))
+
+	type testCase struct {
+		name      string
+		planID    string
+		userID    string
+		trialDays int
+		err       error
+	}
+	testCases := []testCase{
+		{name: "trial for a new user", planID: "monthly", userID: "user", trialDays: 7},
+		{name: "no second trial", planID: "monthly", userID: "trialed"},
+		{name: "no trial for anonymous user", planID: "monthly"},
+		{name: "plan without trial", planID: "yearly", userID: "user"},
+		{name: "unknown plan", planID: "weekly", userID: "user", err: ErrPlanNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			offer, err := service.Offer(context.Background(), tc.planID, tc.userID)
+			assert.ErrorIs(t, err, tc.err)
+			if tc.err == nil {
+				assert.Equal(t, tc.planID, offer.Plan.ID)
+				assert.Equal(t, "usd", offer.Plan.Currency)
+				assert.Equal(t, tc.trialDays, offer.TrialDays)
+			}
+		})
+	}
+
+	t.Run("offers of every plan", func(t *testing.T) {
+		offers, err := service.Offers(context.Background(), "trialed")
+		assert.NoError(t, err)
+		assert.Len(t, offers, 2)
+		assert.Equal(t, 0, offers[0].TrialDays)
+	})
+}
+
+func TestSubscriptionsServicePlans(t *testing.T) {
+	testCases := map[string]string{
+		"unknown interval":       `{"plans": [{"id": "weekly", "amount": 299, "currency": "usd", "interval": "week"}]}`,
+		"unknown currency":       `{"plans": [{"id": "monthly", "amount": 299, "currency": "xxx", "interval": "month"}]}`,
+		"intro above the price":  `{"plans": [{"id": "monthly", "amount": 299, "currency": "usd", "interval": "month", "intro_amount": 399, "intro_periods": 1}]}`,
+		"intro without a period": `{"plans": [{"id": "monthly", "amount": 299, "currency": "usd", "interval": "month", "intro_amount": 99}]}`,
+		"malformed":              `{"plans": [`,
+	}
+	for name, content := range testCases {
+		t.Run(name, func(t *testing.T) {
+			service := NewSubscriptionsService(zap.NewNop().Sugar(), NewFakeSubscriptionRepo(), nil, writePlans(t, content))
+			_, err := service.Plans(context.Background())
+			assert.ErrorIs(t, err, ErrUnexpectedResult)
+		})
+	}
+}
+
+func TestSubscriptionsServiceStartAndRenew(t *testing.T) {
+	repo := NewFakeSubscriptionRepo()
+	entitlements := &FakeEntitlements{ExpiresAt: map[string]time.Time{}}
+	service := NewSubscriptionsService(zap.NewNop().Sugar(), repo, entitlements, writePlans(t, plans))
+	paidAt := time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)
+	trialEnd := paidAt.AddDate(0, 0, 7)
+
+	t.Run("trial", func(t *testing.T) {
+		session := &models.PaymentSession{ID: "trial", UserID: "user", PlanID: "monthly", TrialDays: 7}
+		assert.NoError(t, service.Start(context.Background(), session, paidAt))
+		// repeated capture keeps a single subscription
+		assert.NoError(t, service.Start(context.Background(), session, paidAt))
+		assert.Len(t, repo.Subscriptions, 1)
+		sub := repo.Subscriptions["trial"]
+		assert.Equal(t, models.SubscriptionTrialing, sub.Status)
+		assert.Equal(t, trialEnd, *sub.TrialEndsAt)
+		assert.Equal(t, trialEnd, entitlements.ExpiresAt[sub.ID])
+	})
+
+	t.Run("no trial", func(t *testing.T) {
+		session := &models.PaymentSession{ID: "paid", UserID: "user", PlanID: "yearly"}
+		assert.NoError(t, service.Start(context.Background(), session, paidAt))
+		sub := repo.Subscriptions["paid"]
+		assert.Equal(t, models.SubscriptionActive, sub.Status)
+		assert.Nil(t, sub.TrialEndsAt)
+		assert.Equal(t, paidAt.AddDate(1, 0, 0), entitlements.ExpiresAt[sub.ID])
+	})
+
+	t.Run("trial taken twice", func(t *testing.T) {
+		session := &models.PaymentSession{ID: "second-trial", UserID: "user", PlanID: "monthly", TrialDays: 7}
+		assert.NoError(t, service.Start(context.Background(), session, paidAt))
+		sub := repo.Subscriptions["second-trial"]
+		assert.Equal(t, models.SubscriptionActive, sub.Status)
+		assert.Nil(t, sub.TrialEndsAt)
+		assert.Equal(t, trialEnd, entitlements.ExpiresAt[sub.ID])
+	})
+
+	t.Run("renewal", func(t *testing.T) {
+		periodEnd := trialEnd.AddDate(0, 1, 0)
+		assert.NoError(t, service.Renew(context.Background(), "trial", trialEnd, periodEnd))
+		sub := repo.Subscriptions["trial"]
+		assert.Equal(t, models.SubscriptionActive, sub.Status)
+		assert.Equal(t, periodEnd, sub.CurrentPeriodEnd)
+		assert.Equal(t, periodEnd, entitlements.ExpiresAt[sub.ID])
+
+		// renewal redelivered out of order keeps the latest period
+		assert.NoError(t, service.Renew(context.Background(), "trial", paidAt, trialEnd))
+		assert.Equal(t, periodEnd, repo.Subscriptions["trial"].CurrentPeriodEnd)
+		assert.Equal(t, periodEnd, entitlements.ExpiresAt[sub.ID])
+	})
+
+	t.Run("canceled subscription is not renewed", func(t *testing.T) {
+		assert.NoError(t, service.Cancel(context.Background(), "paid"))
+		// sessions without a subscription have nothing to cancel
+		assert.NoError(t, service.Cancel(context.Background(), "unknown"))
+		sub := repo.Subscriptions["paid"]
+		assert.Equal(t, models.SubscriptionCanceled, sub.Status)
+		expiresAt := entitlements.ExpiresAt[sub.ID]
+
+		err := service.Renew(context.Background(), "paid", expiresAt, expiresAt.AddDate(1, 0, 0))
+		assert.ErrorIs(t, err, ErrSubscriptionCanceled)
+		// repeated completion of the session doesn't grant access again
+		assert.NoError(t, service.Start(context.Background(), &models.PaymentSession{ID: "paid", UserID: "user", PlanID: "yearly"}, expiresAt))
+		assert.Equal(t, models.SubscriptionCanceled, repo.Subscriptions["paid"].Status)
+		assert.Equal(t, expiresAt, entitlements.ExpiresAt[sub.ID])
+	})
+
+	t.Run("fail renewal of unknown session", func(t *testing.T) {
+		err := service.Renew(context.Background(), "unknown", trialEnd, trialEnd.AddDate(0, 1, 0))
+		assert.ErrorIs(t, err, ErrSubscriptionNotFound)
+	})
+}
@@ -0,0 +1,8 @@
+package tax
+
+import "errors"
+
+var (
+	ErrRulesInvalid     = errors.New("tax rules are invalid")
+	ErrUnexpectedResult = errors.New("unexpected error")
+)
@@ -0,0 +1,167 @@
+package tax
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/money"
+)
+
+// rule taxes sales to the country, or to a region of the country when the region is set,
+// rules of the country and of the region are charged together, e.g. GST and QST in Quebec
+type rule struct {
+	Country string `json:"country"`
+	// Region is ISO 3166-2 subdivision code without the country prefix, e.g. "QC"
+	Region string `json:"region"`
+	Name   string `json:"name"`
+	// Rate is percent as a decimal number
+	Rate string `json:"rate"`
+	// DigitalRate replaces the rate for digital goods, "0" exempts them
+	DigitalRate string `json:"digital_rate"`
+	Inclusive   bool   `json:"inclusive"`
+}
+
+type ruleTable struct {
+	Rules []rule `json:"rules"`
+}
+
+// Breakdown splits the price into the net amount and the taxes
+type Breakdown struct {
+	Net   money.Money
+	Lines []models.TaxLine
+	// Total is charged from the customer, it exceeds the price by exclusive taxes
+	Total money.Money
+}
+
+// Amount returns the sum of the taxes
+func (b *Breakdown) Amount() int64 {
+	var amount int64
+	for _, l := range b.Lines {
+		amount += l.Amount
+	}
+	return amount
+}
+
+type TaxService struct {
+	log      *zap.SugaredLogger
+	filePath string
+}
+
+func NewTaxService(log *zap.SugaredLogger, filePath string) *TaxService {
+	return &TaxService{log: log, filePath: filePath}
+}
+
+// Calculate returns taxes of the digital goods sold for the price to the customer from the country and region.
+// Inclusive taxes are carved out of the price and exclusive ones are added on top of the net amount, every line
+// is rounded half up to minor units. Countries without rules are not taxed
+func (s *TaxService) Calculate(ctx context.Context, price money.Money, country, region string) (*Breakdown, error) {
+	table, err := s.load()
+	if err != nil {
+		s.log.Errorf("failed to load tax rules %v, error: %v", s.filePath, err)
+		return nil, ErrUnexpectedResult
+	}
+	breakdown, err := calculate(table.Rules, price, strings.ToUpper(country), strings.ToUpper(region))
+	if err != nil {
+		s.log.Errorw("failed to calculate tax",
+			"country", country,
+			"region", region,
+			"price", price,
+			"error", err)
+		return nil, ErrUnexpectedResult
+	}
+	return breakdown, nil
+}
+
+func calculate(rules []rule, price money.Money, country, region string) (*Breakdown, error) {
+	type rated struct {
+		rule
+		rate *big.Rat
+	}
+	var matched []rated
+	inclusive := new(big.Rat)
+	for _, r := range rules {
+		if r.Country != country || (r.Region != "" && r.Region != region) {
+			continue
+		}
+		value := r.Rate
+		if r.DigitalRate != "" {
+			value = r.DigitalRate
+		}
+		rate, ok := new(big.Rat).SetString(value)
+		if !ok || rate.Sign() < 0 {
+			return nil, fmt.Errorf("%w: rate of %v is malformed", ErrRulesInvalid, r.Name)
+		}
+		if rate.Sign() == 0 {
+			continue
+		}
+		r.Rate = value
+		matched = append(matched, rated{rule: r, rate: rate})
+		if r.Inclusive {
+			inclusive.Add(inclusive, rate)
+		}
+	}
+
+	breakdown := &Breakdown{Net: price, Lines: []models.TaxLine{}, Total: price}
+	hundred := big.NewRat(100, 1)
+	// inclusive tax is its share of the price with every inclusive tax, e.g. 20 / 120 of the price
+	grossPercent := new(big.Rat).Add(hundred, inclusive)
+	for _, m := range matched {
+		if !m.Inclusive {
+			continue
+		}
+		share := new(big.Rat).Quo(m.rate, grossPercent)
+		amount, err := money.Convert(price, price.Currency, share, money.RoundHalfUp)
+		if err != nil {
+			return nil, err
+		}
+		breakdown.Lines = append(breakdown.Lines, line(m.rule, amount))
+		breakdown.Net.Amount -= amount.Amount
+	}
+	for _, m := range matched {
+		if m.Inclusive {
+			continue
+		}
+		share := new(big.Rat).Quo(m.rate, hundred)
+		amount, err := money.Convert(breakdown.Net, price.Currency, share, money.RoundHalfUp)
+		if err != nil {
+			return nil, err
+		}
+		breakdown.Lines = append(breakdown.Lines, line(m.rule, amount))
+		breakdown.Total.Amount += amount.Amount
+	}
+	return breakdown, nil
+}
+
+func line(r rule, amount money.Money) models.TaxLine {
+	return models.TaxLine{
+		Name:      r.Name,
+		Rate:      r.Rate,
+		Inclusive: r.Inclusive,
+		Amount:    amount.Amount,
+		Currency:  amount.Currency,
+	}
+}
+
+// load reads tax rules on every call, so they can be updated without restart
+func (s *TaxService) load() (*ruleTable, error) {
+	raw, err := os.ReadFile(s.filePath)
+	if err != nil {
+		return nil, err
+	}
+	var table ruleTable
+	if err := json.Unmarshal(raw, &table); err != nil {
+		return nil, err
+	}
+	for i := range table.Rules {
+		table.Rules[i].Country = strings.ToUpper(table.Rules[i].Country)
+		table.Rules[i].Region = strings.ToUpper(table.Rules[i].Region)
+	}
+	return &table, nil
+}
@@ -0,0 +1,114 @@
+package tax
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"payment-api/internal/models"
+	"payment-api/internal/money"
+)
+
+func writeRules(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "tax_rules.json")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestTaxServiceCalculate(t *testing.T) {
+	path := writeRules(t, `{
+		"rules": [
+			{"country": "de", "name": "VAT", "rate": "19", "inclusive": true},
+			{"country": "JP", "name": "JCT", "rate": "10", "inclusive": true},
+			{"country": "CA", "name": "GST", "rate": "5"},
+			{"country": "CA", "region": "qc", "name": "QST", "rate": "9.975"},
+			{"country": "US", "region": "WA", "name": "Sales tax", "rate": "6.5"},
+			{"country": "US", "region": "CA", "name": "Sales tax", "rate": "7.25", "digital_rate": "0"}
+		]
+	}`)
+	service := NewTaxService(zap.NewNop().Sugar(), path)
+
+	type testCase struct {
+		name     string
+		price    money.Money
+		country  string
+		region   string
+		expected Breakdown
+	}
+	testCases := []testCase{
+		{name: "inclusive vat", price: money.Money{Amount: 1199, Currency: "eur"}, country: "DE", expected: Breakdown{
+			Net:   money.Money{Amount: 1008, Currency: "eur"},
+			Lines: []models.TaxLine{{Name: "VAT", Rate: "19", Inclusive: true, Amount: 191, Currency: "eur"}},
+			Total: money.Money{Amount: 1199, Currency: "eur"},
+		}},
+		{name: "currency without minor units", price: money.Money{Amount: 1940, Currency: "jpy"}, country: "jp", expected: Breakdown{
+			Net:   money.Money{Amount: 1764, Currency: "jpy"},
+			Lines: []models.TaxLine{{Name: "JCT", Rate: "10", Inclusive: true, Amount: 176, Currency: "jpy"}},
+			Total: money.Money{Amount: 1940, Currency: "jpy"},
+		}},
+		{name: "exclusive gst", price: money.Money{Amount: 1299, Currency: "cad"}, country: "CA", region: "ON", expected: Breakdown{
+			Net:   money.Money{Amount: 1299, Currency: "cad"},
+			Lines: []models.TaxLine{{Name: "GST", Rate: "5", Amount: 65, Currency: "cad"}},
+			Total: money.Money{Amount: 1364, Currency: "cad"},
+		}},
+		{name: "country and region taxes", price: money.Money{Amount: 1000, Currency: "cad"}, country: "CA", region: "qc", expected: Breakdown{
+			Net: money.Money{Amount: 1000, Currency: "cad"},
+			Lines: []models.TaxLine{
+				{Name: "GST", Rate: "5", Amount: 50, Currency: "cad"},
+				{Name: "QST", Rate: "9.975", Amount: 100, Currency: "cad"},
+			},
+			Total: money.Money{Amount: 1150, Currency: "cad"},
+		}},
+		{name: "region tax", price: money.Money{Amount: 1299, Currency: "usd"}, country: "US", region: "WA", expected: Breakdown{
+			Net:   money.Money{Amount: 1299, Currency: "usd"},
+			Lines: []models.TaxLine{{Name: "Sales tax", Rate: "6.5", Amount: 84, Currency: "usd"}},
+			Total: money.Money{Amount: 1383, Currency: "usd"},
+		}},
+		{name: "digital goods exempt", price: money.Money{Amount: 1299, Currency: "usd"}, country: "US", region: "CA", expected: Breakdown{
+			Net:   money.Money{Amount: 1299, Currency: "usd"},
+			Lines: []models.TaxLine{},
+			Total: money.Money{Amount: 1299, Currency: "usd"},
+		}},
+		{name: "country without rules", price: money.Money{Amount: 1299, Currency: "usd"}, country: "BR", expected: Breakdown{
+			Net:   money.Money{Amount: 1299, Currency: "usd"},
+			Lines: []models.TaxLine{},
+			Total: money.Money{Amount: 1299, Currency: "usd"},
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			breakdown, err := service.Calculate(context.Background(), tc.price, tc.country, tc.region)
+			assert.NoError(t, err)
+			assert.Equal(t, &tc.expected, breakdown)
+		})
+	}
+
+	t.Run("inclusive taxes add up to the price", func(t *testing.T) {
+		service := NewTaxService(zap.NewNop().Sugar(), writeRules(t, `{"rules": [
+			{"country": "CA", "name": "GST", "rate": "5", "inclusive": true},
+			{"country": "CA", "name": "PST", "rate": "7", "inclusive": true}
+		]}`))
+		for amount := int64(1); amount < 2000; amount++ {
+			breakdown, err := service.Calculate(context.Background(), money.Money{Amount: amount, Currency: "cad"}, "CA", "")
+			assert.NoError(t, err)
+			assert.Equal(t, amount, breakdown.Net.Amount+breakdown.Amount())
+			assert.Equal(t, amount, breakdown.Total.Amount)
+		}
+	})
+
+	t.Run("fail malformed rate", func(t *testing.T) {
+		service := NewTaxService(zap.NewNop().Sugar(), writeRules(t, `{"rules": [{"country": "DE", "name": "VAT", "rate": "19%"}]}`))
+		_, err := service.Calculate(context.Background(), money.Money{Amount: 1199, Currency: "eur"}, "DE", "")
+		assert.ErrorIs(t, err, ErrUnexpectedResult)
+	})
+
+	t.Run("fail missing rules", func(t *testing.T) {
+		service := NewTaxService(zap.NewNop().Sugar(), filepath.Join(t.TempDir(), "missing.json"))
+		_, err := service.Calculate(context.Background(), money.Money{Amount: 1199, Currency: "eur"}, "DE", "")
+		assert.ErrorIs(t, err, ErrUnexpectedResult)
+	})
+}
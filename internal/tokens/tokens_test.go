@@ -0,0 +1,53 @@
+package tokens
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignerSignVerify(t *testing.T) {
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	signer := NewSigner("secret")
+	signer.now = func() time.Time { return now }
+
+	token, err := signer.Sign(Claims{SessionID: "session", ExpiresAt: now.Add(time.Minute).Unix()})
+	assert.NoError(t, err)
+
+	type testCase struct {
+		name        string
+		token       string
+		signer      *Signer
+		expectedErr error
+	}
+	// Signer with another secret
+	foreign := NewSigner("another")
+	foreign.now = signer.now
+	// Signer which clock is already past expiration
+	late := NewSigner("secret")
+	late.now = func() time.Time { return now.Add(time.Hour) }
+
+	payload, _, _ := strings.Cut(token, ".")
+	testCases := []testCase{
+		{"success", token, signer, nil},
+		{"fail expired", token, late, ErrExpired},
+		{"fail another secret", token, foreign, ErrSignature},
+		{"fail tampered payload", "e30." + token[len(payload)+1:], signer, ErrSignature},
+		{"fail no signature", payload, signer, ErrMalformed},
+		{"fail empty", "", signer, ErrMalformed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims, err := tc.signer.Verify(tc.token)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "session", claims.SessionID)
+		})
+	}
+}